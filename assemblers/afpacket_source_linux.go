@@ -0,0 +1,118 @@
+//go:build linux
+
+package assemblers
+
+import (
+	"os"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/afpacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcap"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/bpf"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+// newAfpacketPacketSource creates a packet source that reads packets from an AF_PACKET socket
+// using TPACKET_V3 memory-mapped ring buffers.
+func newAfpacketPacketSource(config config.Config) (*gopacket.PacketSource, error) {
+	log.Debug().
+		Str("interface", config.Interface).
+		Int("block_size", config.AfpacketBlockSize).
+		Int("num_blocks", config.AfpacketNumBlocks).
+		Int("fanout_group", config.AfpacketFanoutGroup).
+		Str("bpf_filter", config.BpfFilter).
+		Msg("Configuring afpacket packet source")
+
+	// an empty interface name listens on all interfaces, the same as pcap's "any"
+	iface := config.Interface
+	if iface == "any" {
+		iface = ""
+	}
+
+	handle, err := afpacket.NewTPacket(
+		afpacket.OptInterface(iface),
+		afpacket.OptFrameSize(os.Getpagesize()),
+		afpacket.OptBlockSize(config.AfpacketBlockSize),
+		afpacket.OptNumBlocks(config.AfpacketNumBlocks),
+		afpacket.TPacketVersion3,
+	)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Failed to open an afpacket handle")
+		return nil, err
+	}
+
+	if config.BpfFilter != "" {
+		filter, err := compileAfpacketBpfFilter(config.Snaplen, config.BpfFilter)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Msg("Error compiling BPF filter")
+			return nil, err
+		}
+		if err = handle.SetBPF(filter); err != nil {
+			log.Error().
+				Err(err).
+				Msg("Error setting BPF filter")
+			return nil, err
+		}
+	}
+
+	if config.AfpacketFanoutGroup >= 0 {
+		// hash fanout keeps both directions of a TCP stream on the same socket
+		if err = handle.SetFanout(afpacket.FanoutHashWithDefrag, uint16(config.AfpacketFanoutGroup)); err != nil {
+			log.Error().
+				Err(err).
+				Int("fanout_group", config.AfpacketFanoutGroup).
+				Msg("Error joining afpacket fanout group")
+			return nil, err
+		}
+	}
+
+	go logAfpacketHandleStats(handle)
+	return gopacket.NewPacketSource(
+		handle,
+		layers.LinkTypeEthernet,
+	), nil
+}
+
+// compileAfpacketBpfFilter uses libpcap to compile a BPF filter expression into
+// the raw BPF instructions that can be attached to an AF_PACKET socket.
+func compileAfpacketBpfFilter(snaplen int, filter string) ([]bpf.RawInstruction, error) {
+	pcapBPF, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet, snaplen, filter)
+	if err != nil {
+		return nil, err
+	}
+	bpfInstructions := make([]bpf.RawInstruction, len(pcapBPF))
+	for i, instruction := range pcapBPF {
+		bpfInstructions[i] = bpf.RawInstruction{
+			Op: instruction.Code,
+			Jt: instruction.Jt,
+			Jf: instruction.Jf,
+			K:  instruction.K,
+		}
+	}
+	return bpfInstructions, nil
+}
+
+func logAfpacketHandleStats(handle *afpacket.TPacket) {
+	// TODO make ticker configurable
+	ticker := time.NewTicker(time.Second * 10)
+	for {
+		<-ticker.C
+		// socket stats are accumulated by the handle, so these are running totals
+		_, socketStats, err := handle.SocketStats()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get afpacket handle stats")
+			continue
+		}
+		stats.source_received.Store(uint64(socketStats.Packets()))
+		stats.source_dropped.Store(uint64(socketStats.Drops()))
+		stats.source_queue_freezes.Store(uint64(socketStats.QueueFreezes()))
+	}
+}
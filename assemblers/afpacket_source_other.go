@@ -0,0 +1,16 @@
+//go:build !linux
+
+package assemblers
+
+import (
+	"errors"
+
+	"github.com/gopacket/gopacket"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+// newAfpacketPacketSource is only supported on linux
+func newAfpacketPacketSource(config config.Config) (*gopacket.PacketSource, error) {
+	return nil, errors.New("afpacket packet source is only supported on linux")
+}
@@ -0,0 +1,123 @@
+package assemblers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/reassembly"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+// shardPacketBufferSize is the number of packets that can be queued for a shard
+// before the capture loop blocks waiting for the shard to catch up
+const shardPacketBufferSize = 1000
+
+// shardPacket is a TCP packet queued for reassembly by a shard
+type shardPacket struct {
+	netFlow gopacket.Flow
+	tcp     *layers.TCP
+	context *Context
+}
+
+// assemblerShard reassembles the TCP streams for a subset of connections.
+//
+// Each shard has its own stream pool and reassembly.Assembler, so shards can reassemble
+// packets concurrently as long as both directions of a connection are sent to the same shard.
+type assemblerShard struct {
+	id            int
+	config        config.Config
+	streamFactory *tcpStreamFactory
+	streamPool    *reassembly.StreamPool
+	assembler     *reassembly.Assembler
+	packets       chan shardPacket
+}
+
+func newAssemblerShard(id int, config config.Config, maxBufferedPagesTotal int, eventsChan chan Event) *assemblerShard {
+	streamFactory := NewTcpStreamFactory(config, eventsChan)
+	streamPool := reassembly.NewStreamPool(&streamFactory)
+	assembler := reassembly.NewAssembler(streamPool)
+
+	// Set total max pages and per-connection max pages -- this is very important to limit memory usage
+	assembler.AssemblerOptions.MaxBufferedPagesTotal = maxBufferedPagesTotal
+	assembler.AssemblerOptions.MaxBufferedPagesPerConnection = config.MaxBufferedPagesPerConnection
+
+	return &assemblerShard{
+		id:            id,
+		config:        config,
+		streamFactory: &streamFactory,
+		streamPool:    streamPool,
+		assembler:     assembler,
+		packets:       make(chan shardPacket, shardPacketBufferSize),
+	}
+}
+
+// shardForFlow returns the index of the shard that handles the given connection.
+//
+// Flow hashes are symmetric, so both directions of a connection are sent to the same shard.
+func shardForFlow(netFlow gopacket.Flow, transportFlow gopacket.Flow, shardCount int) int {
+	return int((netFlow.FastHash() ^ transportFlow.FastHash()) % uint64(shardCount))
+}
+
+// assemble passes a TCP packet to the shard's assembler
+func (shard *assemblerShard) assemble(packet shardPacket) {
+	shard.assembler.AssembleWithContext(packet.netFlow, packet.tcp, packet.context)
+}
+
+// flush flushes and closes streams that have been idle longer than the configured timeouts,
+// then evicts messages that have waited too long for their counterpart from the remaining streams
+func (shard *assemblerShard) flush(now time.Time) {
+	flushed, closed := shard.assembler.FlushWithOptions(
+		reassembly.FlushOptions{
+			T:  now.Add(-shard.config.StreamFlushTimeout),
+			TC: now.Add(-shard.config.StreamCloseTimeout),
+		},
+	)
+	shard.streamFactory.evict(now)
+	log.Debug().
+		Int("shard", shard.id).
+		Int("flushed", flushed).
+		Int("closed", closed).
+		Msg("Flushing old streams")
+}
+
+// run reassembles packets sent to the shard until its packets channel is closed.
+// Idle streams are flushed using the given clock.
+func (shard *assemblerShard) run(wg *sync.WaitGroup, now func() time.Time) {
+	defer wg.Done()
+
+	// Tick even more frequently than the flush interval (4 is somewhat arbitrary)
+	flushCloseTicker := time.NewTicker(shard.config.StreamFlushTimeout / 4)
+	defer flushCloseTicker.Stop()
+
+	for {
+		select {
+		case <-flushCloseTicker.C:
+			shard.flush(now())
+		case packet, ok := <-shard.packets:
+			if !ok {
+				return
+			}
+			shard.assemble(packet)
+		}
+	}
+}
+
+// stop closes all remaining streams in the shard, returning the number of closed streams
+func (shard *assemblerShard) stop() int {
+	closed := shard.assembler.FlushAll()
+	if zerolog.GlobalLevel() >= zerolog.DebugLevel {
+		// this uses stdlib's log, but oh well
+		shard.streamPool.Dump()
+	}
+	log.Debug().
+		Int("shard", shard.id).
+		Int("closed", closed).
+		Str("assembler_page_usage", shard.assembler.Dump()).
+		Msg("Stopping TCP assembler shard")
+	return closed
+}
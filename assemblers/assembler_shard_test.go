@@ -0,0 +1,58 @@
+package assemblers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardForFlowIsSymmetric(t *testing.T) {
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	transportFlow := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0xd4, 0x31}, []byte{0x1f, 0x90})
+
+	for shardCount := 1; shardCount <= 16; shardCount++ {
+		shard := shardForFlow(netFlow, transportFlow, shardCount)
+		assert.Less(t, shard, shardCount)
+		assert.Equal(t, shard, shardForFlow(netFlow.Reverse(), transportFlow.Reverse(), shardCount),
+			"both directions of a connection must use the same shard")
+	}
+}
+
+func TestShardedAssemblerEmitsEventsAndStops(t *testing.T) {
+	captureStart := time.Date(2023, 11, 1, 12, 0, 0, 0, time.UTC)
+	capture := writeTestCapture(t, captureStart, testHttpExchange())
+
+	reader, err := pcapgo.NewReader(capture)
+	require.NoError(t, err)
+	packetSource := gopacket.NewPacketSource(reader, reader.LinkType())
+
+	config := newTestAssemblerConfig()
+	config.AssemblerShards = 4
+	eventsChan := make(chan Event, 10)
+	assembler := newTcpAssembler(config, packetSource, eventsChan)
+	require.Len(t, assembler.shards, 4)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go assembler.Start(context.Background(), &wg)
+
+	select {
+	case <-assembler.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("assembler did not stop at the end of the capture file")
+	}
+	wg.Wait()
+
+	require.Len(t, eventsChan, 1)
+	event := (<-eventsChan).(*HttpEvent)
+	assert.Equal(t, "GET", event.Request().Method)
+	assert.Equal(t, 418, event.Response().StatusCode)
+	assert.Equal(t, 0, assembler.shardQueueLength())
+}
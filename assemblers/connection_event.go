@@ -0,0 +1,106 @@
+package assemblers
+
+import (
+	"time"
+)
+
+// ConnectionEvent represents a TCP connection, sent when the connection closes.
+//
+// The request timestamp and packet count are the connection's first packet and the packets sent by the client,
+// the response timestamp and packet count are its last packet and the packets sent by the server.
+type ConnectionEvent struct {
+	eventBase
+	synToSynAck  time.Duration
+	synAckToAck  time.Duration
+	clientBytes  int64
+	serverBytes  int64
+	endReason    string
+	requestCount int
+}
+
+// Make sure ConnectionEvent implements Event interface
+var _ Event = (*ConnectionEvent)(nil)
+
+func NewConnectionEvent(
+	streamIdent string,
+	requestId int64,
+	requestTimestamp time.Time,
+	responseTimestamp time.Time,
+	requestPacketCount int,
+	responsePacketCount int,
+	srcIp string,
+	dstIp string,
+	synToSynAck time.Duration,
+	synAckToAck time.Duration,
+	clientBytes int64,
+	serverBytes int64,
+	endReason string,
+	requestCount int,
+	tcpStats *TcpStats) *ConnectionEvent {
+	return &ConnectionEvent{
+		eventBase: eventBase{
+			streamIdent:         streamIdent,
+			requestId:           requestId,
+			requestTimestamp:    requestTimestamp,
+			responseTimestamp:   responseTimestamp,
+			requestPacketCount:  requestPacketCount,
+			responsePacketCount: responsePacketCount,
+			srcIp:               srcIp,
+			dstIp:               dstIp,
+			tcpStats:            tcpStats,
+		},
+		synToSynAck:  synToSynAck,
+		synAckToAck:  synAckToAck,
+		clientBytes:  clientBytes,
+		serverBytes:  serverBytes,
+		endReason:    endReason,
+		requestCount: requestCount,
+	}
+}
+
+// SynToSynAck returns the time from the client's SYN to the server's SYN-ACK,
+// or -1 if we didn't see both packets
+func (event *ConnectionEvent) SynToSynAck() time.Duration {
+	return event.synToSynAck
+}
+
+// SynAckToAck returns the time from the server's SYN-ACK to the client's ACK,
+// or -1 if we didn't see both packets
+func (event *ConnectionEvent) SynAckToAck() time.Duration {
+	return event.synAckToAck
+}
+
+// HandshakeRtt returns the round trip time of the three-way handshake from SYN to ACK,
+// or -1 if we didn't see the whole handshake, eg for connections opened before the agent started
+func (event *ConnectionEvent) HandshakeRtt() time.Duration {
+	if event.synToSynAck < 0 || event.synAckToAck < 0 {
+		return -1
+	}
+	return event.synToSynAck + event.synAckToAck
+}
+
+// Duration returns the time from the connection's first packet to its last
+func (event *ConnectionEvent) Duration() time.Duration {
+	return event.responseTimestamp.Sub(event.requestTimestamp)
+}
+
+// ClientBytes returns the number of TCP payload bytes sent by the client
+func (event *ConnectionEvent) ClientBytes() int64 {
+	return event.clientBytes
+}
+
+// ServerBytes returns the number of TCP payload bytes sent by the server
+func (event *ConnectionEvent) ServerBytes() int64 {
+	return event.serverBytes
+}
+
+// EndReason returns why the connection ended, "fin" when it was closed, "rst" when it was reset,
+// or "timeout" when it was flushed after being idle
+func (event *ConnectionEvent) EndReason() string {
+	return event.endReason
+}
+
+// RequestCount returns the number of request events sent for the connection
+func (event *ConnectionEvent) RequestCount() int {
+	return event.requestCount
+}
@@ -0,0 +1,65 @@
+package assemblers
+
+import (
+	"time"
+)
+
+// ConnectionFailureEvent represents a call that failed at the TCP level, because the server refused the connection,
+// never answered the client's SYN, or the connection was reset while the client waited for a response.
+//
+// The request timestamp is the client's first SYN, or when it started sending the request that was reset.
+// The response timestamp is when the connection was reset, and zero when the SYN was never answered.
+type ConnectionFailureEvent struct {
+	eventBase
+	serverPort int
+	reason     string
+	resetBy    string
+}
+
+// Make sure ConnectionFailureEvent implements Event interface
+var _ Event = (*ConnectionFailureEvent)(nil)
+
+func NewConnectionFailureEvent(
+	streamIdent string,
+	requestId int64,
+	requestTimestamp time.Time,
+	responseTimestamp time.Time,
+	requestPacketCount int,
+	responsePacketCount int,
+	srcIp string,
+	dstIp string,
+	serverPort int,
+	reason string,
+	resetBy string) *ConnectionFailureEvent {
+	return &ConnectionFailureEvent{
+		eventBase: eventBase{
+			streamIdent:         streamIdent,
+			requestId:           requestId,
+			requestTimestamp:    requestTimestamp,
+			responseTimestamp:   responseTimestamp,
+			requestPacketCount:  requestPacketCount,
+			responsePacketCount: responsePacketCount,
+			srcIp:               srcIp,
+			dstIp:               dstIp,
+		},
+		serverPort: serverPort,
+		reason:     reason,
+		resetBy:    resetBy,
+	}
+}
+
+// ServerPort returns the port the client connected to
+func (event *ConnectionFailureEvent) ServerPort() int {
+	return event.serverPort
+}
+
+// Reason returns why the call failed, "connection_refused" when the server answered the SYN with a reset,
+// "syn_timeout" when the SYN was never answered, or "reset" when the connection was reset mid-request
+func (event *ConnectionFailureEvent) Reason() string {
+	return event.reason
+}
+
+// ResetBy returns which side reset the connection, "client" or "server", or "" if it wasn't reset
+func (event *ConnectionFailureEvent) ResetBy() string {
+	return event.resetBy
+}
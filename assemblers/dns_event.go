@@ -0,0 +1,76 @@
+package assemblers
+
+import (
+	"time"
+)
+
+// DnsEvent represents a DNS query sent over UDP and the server's response
+type DnsEvent struct {
+	eventBase
+	queryName    string
+	queryType    string
+	responseCode string
+	answerCount  int
+	timedOut     bool
+}
+
+// Make sure DnsEvent implements Event interface
+var _ Event = (*DnsEvent)(nil)
+
+func NewDnsEvent(
+	streamIdent string,
+	requestId int64,
+	requestTimestamp time.Time,
+	responseTimestamp time.Time,
+	requestPacketCount int,
+	responsePacketCount int,
+	srcIp string,
+	dstIp string,
+	queryName string,
+	queryType string,
+	responseCode string,
+	answerCount int,
+	timedOut bool) *DnsEvent {
+	return &DnsEvent{
+		eventBase: eventBase{
+			streamIdent:         streamIdent,
+			requestId:           requestId,
+			requestTimestamp:    requestTimestamp,
+			responseTimestamp:   responseTimestamp,
+			requestPacketCount:  requestPacketCount,
+			responsePacketCount: responsePacketCount,
+			srcIp:               srcIp,
+			dstIp:               dstIp,
+		},
+		queryName:    queryName,
+		queryType:    queryType,
+		responseCode: responseCode,
+		answerCount:  answerCount,
+		timedOut:     timedOut,
+	}
+}
+
+// QueryName returns the name being resolved, eg "example.com"
+func (event *DnsEvent) QueryName() string {
+	return event.queryName
+}
+
+// QueryType returns the type of record being queried, eg "A"
+func (event *DnsEvent) QueryType() string {
+	return event.queryType
+}
+
+// ResponseCode returns the response code sent by the server, eg "NXDOMAIN", or "" if the query timed out
+func (event *DnsEvent) ResponseCode() string {
+	return event.responseCode
+}
+
+// AnswerCount returns the number of answers in the response
+func (event *DnsEvent) AnswerCount() int {
+	return event.answerCount
+}
+
+// TimedOut returns true if we didn't see a response to the query before the timeout
+func (event *DnsEvent) TimedOut() bool {
+	return event.timedOut
+}
@@ -0,0 +1,150 @@
+package assemblers
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+const (
+	// dnsQueryTimeout is how long we wait for a response before sending an event for a timed out query
+	dnsQueryTimeout = 5 * time.Second
+	// dnsMaxPendingQueries limits how many queries we hold on to while waiting for their responses
+	dnsMaxPendingQueries = 10000
+)
+
+// dnsResponseCodes are the names of the common DNS response codes
+var dnsResponseCodes = map[layers.DNSResponseCode]string{
+	layers.DNSResponseCodeNoErr:    "NOERROR",
+	layers.DNSResponseCodeFormErr:  "FORMERR",
+	layers.DNSResponseCodeServFail: "SERVFAIL",
+	layers.DNSResponseCodeNXDomain: "NXDOMAIN",
+	layers.DNSResponseCodeNotImp:   "NOTIMP",
+	layers.DNSResponseCodeRefused:  "REFUSED",
+}
+
+func dnsResponseCodeName(code layers.DNSResponseCode) string {
+	if name, ok := dnsResponseCodes[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", code)
+}
+
+// dnsQueryKey identifies a query by its client and server addresses and transaction ID
+type dnsQueryKey struct {
+	netFlow       gopacket.Flow
+	transportFlow gopacket.Flow
+	id            uint16
+}
+
+// dnsQuery is a query waiting for its response
+type dnsQuery struct {
+	name      string
+	queryType string
+	timestamp time.Time
+}
+
+// dnsTracker matches DNS queries sent over UDP to their responses, sending a DnsEvent for each query.
+//
+// UDP doesn't have connections to reassemble, so each packet is decoded as it arrives.
+// Queries that don't get a response within dnsQueryTimeout, or before the assembler stops,
+// are sent as timed out events.
+type dnsTracker struct {
+	ports      []string
+	pending    map[dnsQueryKey]*dnsQuery
+	eventsChan chan Event
+}
+
+func newDnsTracker(ports []string, eventsChan chan Event) *dnsTracker {
+	return &dnsTracker{
+		ports:      ports,
+		pending:    make(map[dnsQueryKey]*dnsQuery),
+		eventsChan: eventsChan,
+	}
+}
+
+// handlePacket decodes a UDP packet sent to or from a DNS port, storing queries and matching responses
+func (tracker *dnsTracker) handlePacket(netFlow gopacket.Flow, udp *layers.UDP, timestamp time.Time) {
+	toServer := slices.Contains(tracker.ports, strconv.Itoa(int(udp.DstPort)))
+	fromServer := slices.Contains(tracker.ports, strconv.Itoa(int(udp.SrcPort)))
+	if !toServer && !fromServer {
+		return
+	}
+	dns := &layers.DNS{}
+	if err := dns.DecodeFromBytes(udp.Payload, gopacket.NilDecodeFeedback); err != nil || len(dns.Questions) == 0 {
+		return
+	}
+
+	if !dns.QR {
+		if !toServer || len(tracker.pending) >= dnsMaxPendingQueries {
+			return
+		}
+		key := dnsQueryKey{netFlow, udp.TransportFlow(), dns.ID}
+		// clients retry queries with the same ID, we measure from the first attempt
+		if _, ok := tracker.pending[key]; !ok {
+			tracker.pending[key] = &dnsQuery{
+				name:      string(dns.Questions[0].Name),
+				queryType: dns.Questions[0].Type.String(),
+				timestamp: timestamp,
+			}
+		}
+		return
+	}
+
+	if !fromServer {
+		return
+	}
+	key := dnsQueryKey{netFlow.Reverse(), udp.TransportFlow().Reverse(), dns.ID}
+	query, ok := tracker.pending[key]
+	if !ok {
+		return
+	}
+	delete(tracker.pending, key)
+	tracker.sendEvent(key, query, timestamp, dnsResponseCodeName(dns.ResponseCode), int(dns.ANCount), false)
+}
+
+// expire sends timed out events for queries that haven't had a response since before the timeout
+func (tracker *dnsTracker) expire(now time.Time) {
+	for key, query := range tracker.pending {
+		if now.Sub(query.timestamp) > dnsQueryTimeout {
+			delete(tracker.pending, key)
+			tracker.sendEvent(key, query, time.Time{}, "", 0, true)
+		}
+	}
+}
+
+// flush sends timed out events for every query still waiting for a response,
+// used when the assembler stops and no more responses will be seen
+func (tracker *dnsTracker) flush() {
+	for key, query := range tracker.pending {
+		delete(tracker.pending, key)
+		tracker.sendEvent(key, query, time.Time{}, "", 0, true)
+	}
+}
+
+func (tracker *dnsTracker) sendEvent(key dnsQueryKey, query *dnsQuery, timestamp time.Time, responseCode string, answerCount int, timedOut bool) {
+	src, dst := key.netFlow.Endpoints()
+	responsePacketCount := 1
+	if timedOut {
+		responsePacketCount = 0
+	}
+	tracker.eventsChan <- NewDnsEvent(
+		fmt.Sprintf("%s:%s", key.netFlow, key.transportFlow),
+		int64(key.id),
+		query.timestamp,
+		timestamp,
+		1,
+		responsePacketCount,
+		src.String(),
+		dst.String(),
+		query.name,
+		query.queryType,
+		responseCode,
+		answerCount,
+		timedOut,
+	)
+}
@@ -0,0 +1,131 @@
+package assemblers
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handleTestDnsPacket serializes the DNS message into a UDP packet between a client and server and passes it to the tracker
+func handleTestDnsPacket(t *testing.T, tracker *dnsTracker, dns *layers.DNS, clientPort uint16, timestamp time.Time) {
+	clientIP, serverIP := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 53}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: clientIP, DstIP: serverIP}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(clientPort), DstPort: 53}
+	if dns.QR {
+		ip.SrcIP, ip.DstIP = serverIP, clientIP
+		udp.SrcPort, udp.DstPort = 53, layers.UDPPort(clientPort)
+	}
+	require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+	data := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(data, opts, ip, udp, dns))
+
+	packet := gopacket.NewPacket(data.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	udpLayer := packet.Layer(layers.LayerTypeUDP)
+	require.NotNil(t, udpLayer)
+	tracker.handlePacket(packet.NetworkLayer().NetworkFlow(), udpLayer.(*layers.UDP), timestamp)
+}
+
+func newTestDnsQuery(id uint16, name string, queryType layers.DNSType) *layers.DNS {
+	return &layers.DNS{
+		ID:        id,
+		RD:        true,
+		Questions: []layers.DNSQuestion{{Name: []byte(name), Type: queryType, Class: layers.DNSClassIN}},
+	}
+}
+
+func newTestDnsResponse(query *layers.DNS, responseCode layers.DNSResponseCode, answers ...net.IP) *layers.DNS {
+	response := *query
+	response.QR = true
+	response.RA = true
+	response.ResponseCode = responseCode
+	for _, ip := range answers {
+		response.Answers = append(response.Answers, layers.DNSResourceRecord{
+			Name: query.Questions[0].Name, Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 30, IP: ip,
+		})
+	}
+	return &response
+}
+
+func TestDnsTrackerMatchesResponses(t *testing.T) {
+	eventsChan := make(chan Event, 10)
+	tracker := newDnsTracker([]string{"53"}, eventsChan)
+	queryTime := time.Now()
+	responseTime := queryTime.Add(3 * time.Millisecond)
+
+	found := newTestDnsQuery(1234, "api.example.com", layers.DNSTypeA)
+	missing := newTestDnsQuery(1234, "missing.example.com", layers.DNSTypeAAAA)
+	handleTestDnsPacket(t, tracker, found, 40000, queryTime)
+	// same transaction ID from a different client port
+	handleTestDnsPacket(t, tracker, missing, 40001, queryTime)
+	// a retry doesn't reset the query time
+	handleTestDnsPacket(t, tracker, found, 40000, queryTime.Add(time.Millisecond))
+	require.Len(t, tracker.pending, 2)
+
+	handleTestDnsPacket(t, tracker, newTestDnsResponse(missing, layers.DNSResponseCodeNXDomain), 40001, responseTime)
+	handleTestDnsPacket(t, tracker, newTestDnsResponse(found, layers.DNSResponseCodeNoErr, net.IP{10, 1, 0, 1}, net.IP{10, 1, 0, 2}), 40000, responseTime)
+	// a response we didn't see the query for
+	handleTestDnsPacket(t, tracker, newTestDnsResponse(newTestDnsQuery(99, "other.example.com", layers.DNSTypeA), layers.DNSResponseCodeNoErr), 40002, responseTime)
+
+	require.Len(t, eventsChan, 2)
+	event := (<-eventsChan).(*DnsEvent)
+	assert.Equal(t, "missing.example.com", event.QueryName())
+	assert.Equal(t, "AAAA", event.QueryType())
+	assert.Equal(t, "NXDOMAIN", event.ResponseCode())
+	assert.Equal(t, 0, event.AnswerCount())
+
+	event = (<-eventsChan).(*DnsEvent)
+	assert.Equal(t, int64(1234), event.RequestId())
+	assert.Equal(t, "api.example.com", event.QueryName())
+	assert.Equal(t, "A", event.QueryType())
+	assert.Equal(t, "NOERROR", event.ResponseCode())
+	assert.Equal(t, 2, event.AnswerCount())
+	assert.False(t, event.TimedOut())
+	assert.Equal(t, queryTime, event.RequestTimestamp())
+	assert.Equal(t, responseTime, event.ResponseTimestamp())
+	assert.Equal(t, "10.0.0.1", event.SrcIp())
+	assert.Equal(t, "10.0.0.53", event.DstIp())
+	assert.Empty(t, tracker.pending)
+}
+
+func TestDnsTrackerExpiresQueries(t *testing.T) {
+	eventsChan := make(chan Event, 10)
+	tracker := newDnsTracker([]string{"53"}, eventsChan)
+	queryTime := time.Now()
+
+	handleTestDnsPacket(t, tracker, newTestDnsQuery(1, "slow.example.com", layers.DNSTypeA), 40000, queryTime)
+	handleTestDnsPacket(t, tracker, newTestDnsQuery(2, "recent.example.com", layers.DNSTypeA), 40000, queryTime.Add(4*time.Second))
+
+	tracker.expire(queryTime.Add(dnsQueryTimeout + time.Second))
+
+	require.Len(t, eventsChan, 1)
+	event := (<-eventsChan).(*DnsEvent)
+	assert.Equal(t, "slow.example.com", event.QueryName())
+	assert.True(t, event.TimedOut())
+	assert.Equal(t, "", event.ResponseCode())
+	assert.True(t, event.ResponseTimestamp().IsZero())
+	assert.Len(t, tracker.pending, 1)
+}
+
+func TestDnsTrackerFlushesQueries(t *testing.T) {
+	eventsChan := make(chan Event, 10)
+	tracker := newDnsTracker([]string{"53"}, eventsChan)
+	queryTime := time.Now()
+
+	handleTestDnsPacket(t, tracker, newTestDnsQuery(1, "recent.example.com", layers.DNSTypeA), 40000, queryTime)
+
+	// queries are flushed when the assembler stops, however recently they were sent
+	tracker.flush()
+
+	require.Len(t, eventsChan, 1)
+	event := (<-eventsChan).(*DnsEvent)
+	assert.Equal(t, "recent.example.com", event.QueryName())
+	assert.True(t, event.TimedOut())
+	assert.True(t, event.ResponseTimestamp().IsZero())
+	assert.Empty(t, tracker.pending)
+}
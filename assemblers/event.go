@@ -11,18 +11,30 @@ type Event interface {
 	// RequestId returns a unique identifier for the request/response cycle
 	RequestId() int64
 
-	// RequestTimestamp returns the timestamp of the request
+	// RequestTimestamp returns the timestamp of the request's first byte
 	RequestTimestamp() time.Time
 
-	// ResponseTimestamp returns the timestamp of the response
+	// ResponseTimestamp returns the timestamp of the response's first byte
 	ResponseTimestamp() time.Time
 
+	// RequestLastByteTimestamp returns the timestamp of the request's last byte
+	RequestLastByteTimestamp() time.Time
+
+	// ResponseLastByteTimestamp returns the timestamp of the response's last byte
+	ResponseLastByteTimestamp() time.Time
+
 	// RequestPacketCount returns the number of packets in the request
 	RequestPacketCount() int
 
 	// ResponsePacketCount returns the number of packets in the response
 	ResponsePacketCount() int
 
+	// RequestPartial returns true if the end of the request wasn't captured, so its last byte timestamp isn't known
+	RequestPartial() bool
+
+	// ResponsePartial returns true if the end of the response wasn't captured, so its last byte timestamp isn't known
+	ResponsePartial() bool
+
 	// SrcIp returns the source IP address
 	SrcIp() string
 
@@ -30,6 +42,16 @@ type Event interface {
 	DstIp() string
 }
 
+// Releaser is implemented by events that hold memory shared by all streams, eg captured HTTP bodies.
+// The memory stays counted against its limit while the event waits to be handled,
+// and is given back once every handler the event was sent to has released it.
+type Releaser interface {
+	// Retain adds n more handlers that must release the event, for events sent to several handlers
+	Retain(n int)
+	// Release is called by a handler once it has finished with the event
+	Release()
+}
+
 type eventBase struct {
 	streamIdent         string
 	requestId           int64
@@ -39,6 +61,48 @@ type eventBase struct {
 	responsePacketCount int
 	srcIp               string
 	dstIp               string
+
+	// only set by parsers that follow messages spread across segments,
+	// otherwise the last byte is assumed to arrive with the first
+	requestLastByteTimestamp  time.Time
+	responseLastByteTimestamp time.Time
+	requestPartial            bool
+	responsePartial           bool
+
+	// only set for events sent by TCP streams whose every packet is captured
+	tcpStats *TcpStats
+}
+
+// MessageInfo describes how a request or response was captured
+type MessageInfo struct {
+	// Timestamp is when the message's first byte was captured, zero if the message wasn't seen
+	Timestamp time.Time
+	// LastByteTimestamp is when the message's last byte was captured, zero if it was captured with the first byte
+	LastByteTimestamp time.Time
+	// PacketCount is the number of packets the message was captured from
+	PacketCount int
+	// Partial is set when the end of the message wasn't captured, eg it was in packets the BPF filter doesn't match,
+	// so LastByteTimestamp is only when the last captured byte arrived
+	Partial bool
+}
+
+// newEventBase returns an eventBase for a request and response captured on the stream
+func newEventBase(streamIdent string, requestId int64, srcIp string, dstIp string, request MessageInfo, response MessageInfo) eventBase {
+	return eventBase{
+		streamIdent:         streamIdent,
+		requestId:           requestId,
+		requestTimestamp:    request.Timestamp,
+		responseTimestamp:   response.Timestamp,
+		requestPacketCount:  request.PacketCount,
+		responsePacketCount: response.PacketCount,
+		srcIp:               srcIp,
+		dstIp:               dstIp,
+
+		requestLastByteTimestamp:  request.LastByteTimestamp,
+		responseLastByteTimestamp: response.LastByteTimestamp,
+		requestPartial:            request.Partial,
+		responsePartial:           response.Partial,
+	}
 }
 
 func (event *eventBase) StreamIdent() string {
@@ -57,6 +121,20 @@ func (event *eventBase) ResponseTimestamp() time.Time {
 	return event.responseTimestamp
 }
 
+func (event *eventBase) RequestLastByteTimestamp() time.Time {
+	if event.requestLastByteTimestamp.IsZero() {
+		return event.requestTimestamp
+	}
+	return event.requestLastByteTimestamp
+}
+
+func (event *eventBase) ResponseLastByteTimestamp() time.Time {
+	if event.responseLastByteTimestamp.IsZero() {
+		return event.responseTimestamp
+	}
+	return event.responseLastByteTimestamp
+}
+
 func (event *eventBase) RequestPacketCount() int {
 	return event.requestPacketCount
 }
@@ -65,6 +143,14 @@ func (event *eventBase) ResponsePacketCount() int {
 	return event.responsePacketCount
 }
 
+func (event *eventBase) RequestPartial() bool {
+	return event.requestPartial
+}
+
+func (event *eventBase) ResponsePartial() bool {
+	return event.responsePartial
+}
+
 func (event *eventBase) SrcIp() string {
 	return event.srcIp
 }
@@ -72,3 +158,22 @@ func (event *eventBase) SrcIp() string {
 func (event *eventBase) DstIp() string {
 	return event.dstIp
 }
+
+// TcpStats returns the network problems seen on the event's TCP connection while its request was made,
+// or over the whole connection for ConnectionEvents.
+// Returns false if they weren't tracked, as not every packet on the connection was captured.
+func (event *eventBase) TcpStats() (TcpStats, bool) {
+	if event.tcpStats == nil {
+		return TcpStats{}, false
+	}
+	return *event.tcpStats, true
+}
+
+// tcpStatsSetter is implemented by events that carry TcpStats
+type tcpStatsSetter interface {
+	setTcpStats(stats TcpStats)
+}
+
+func (event *eventBase) setTcpStats(stats TcpStats) {
+	event.tcpStats = &stats
+}
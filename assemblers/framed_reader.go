@@ -0,0 +1,80 @@
+package assemblers
+
+import (
+	"errors"
+)
+
+var errInvalidFrame = errors.New("invalid message frame")
+
+// framedReader incrementally reads length-prefixed messages from one direction of a connection,
+// as used by most binary database and messaging protocols.
+//
+// Messages can be split across reassembled segments in any way. Only the first maxCapture bytes
+// of each message body are kept, so large messages (eg result rows) don't need to be buffered.
+type framedReader struct {
+	headerLength int
+	// parseHeader returns the length of the message body that follows the header,
+	// or false if the header isn't valid
+	parseHeader func(header []byte) (int, bool)
+	maxCapture  int
+
+	header    []byte
+	body      []byte
+	length    int
+	remaining int
+	inBody    bool
+	desynced  bool
+}
+
+func newFramedReader(headerLength int, maxCapture int, parseHeader func(header []byte) (int, bool)) *framedReader {
+	return &framedReader{
+		headerLength: headerLength,
+		parseHeader:  parseHeader,
+		maxCapture:   maxCapture,
+	}
+}
+
+// read reads the data and calls emit for each complete message.
+// The body passed to emit is truncated if the message is larger than maxCapture,
+// and is only valid until emit returns.
+func (reader *framedReader) read(data []byte, emit func(header []byte, body []byte, truncated bool)) error {
+	if reader.desynced {
+		return errInvalidFrame
+	}
+	for len(data) > 0 {
+		if !reader.inBody {
+			n := min(reader.headerLength-len(reader.header), len(data))
+			reader.header = append(reader.header, data[:n]...)
+			data = data[n:]
+			if len(reader.header) < reader.headerLength {
+				return nil
+			}
+			length, ok := reader.parseHeader(reader.header)
+			if !ok || length < 0 {
+				reader.desynced = true
+				reader.header = nil
+				return errInvalidFrame
+			}
+			reader.inBody = true
+			reader.length = length
+			reader.remaining = length
+		}
+
+		n := min(reader.remaining, len(data))
+		if capture := min(n, reader.maxCapture-len(reader.body)); capture > 0 {
+			reader.body = append(reader.body, data[:capture]...)
+		}
+		reader.remaining -= n
+		data = data[n:]
+		if reader.remaining > 0 {
+			return nil
+		}
+
+		// reset before calling emit, which can change the header length for the next message
+		header, body := reader.header, reader.body
+		truncated := reader.length > len(body)
+		reader.header, reader.body, reader.inBody = header[:0], body[:0], false
+		emit(header, body, truncated)
+	}
+	return nil
+}
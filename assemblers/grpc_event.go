@@ -0,0 +1,114 @@
+package assemblers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// GrpcMessageStats summarises the gRPC messages sent in one direction of a gRPC call
+type GrpcMessageStats struct {
+	// Count is the number of messages sent, which is more than one for streaming RPCs
+	Count int
+	// CompressedSize is the total size in bytes of the messages as sent on the wire
+	CompressedSize int64
+	// UncompressedSize is the total size in bytes of the messages after decompression,
+	// or -1 if a compressed message could not be decompressed
+	UncompressedSize int64
+}
+
+// GrpcCall describes a gRPC call's request and response
+type GrpcCall struct {
+	// Path is the HTTP/2 path of the call, eg "/package.Service/Method"
+	Path string
+	// RequestHeader and ResponseHeader are the extracted headers
+	RequestHeader  http.Header
+	ResponseHeader http.Header
+	// StatusCode is the grpc-status sent by the server, or -1 if no status was captured
+	StatusCode int
+	// StatusMessage is the grpc-message sent by the server
+	StatusMessage string
+	// RequestMessages and ResponseMessages summarise the messages sent by the client and server
+	RequestMessages  GrpcMessageStats
+	ResponseMessages GrpcMessageStats
+}
+
+// GrpcEvent represents a gRPC call made over a HTTP/2 stream
+type GrpcEvent struct {
+	eventBase
+	call GrpcCall
+}
+
+// Make sure GrpcEvent implements Event interface
+var _ Event = (*GrpcEvent)(nil)
+
+// NewGrpcEvent returns a GrpcEvent for a gRPC call captured on the stream.
+// The response info is empty if the server didn't respond.
+func NewGrpcEvent(
+	streamIdent string,
+	requestId int64,
+	srcIp string,
+	dstIp string,
+	requestInfo MessageInfo,
+	responseInfo MessageInfo,
+	call GrpcCall) *GrpcEvent {
+	return &GrpcEvent{
+		eventBase: newEventBase(streamIdent, requestId, srcIp, dstIp, requestInfo, responseInfo),
+		call:      call,
+	}
+}
+
+// Path returns the HTTP/2 path of the call, eg "/package.Service/Method"
+func (event *GrpcEvent) Path() string {
+	return event.call.Path
+}
+
+// Service returns the fully-qualified name of the called service, eg "package.Service"
+func (event *GrpcEvent) Service() string {
+	service, _ := splitGrpcPath(event.call.Path)
+	return service
+}
+
+// Method returns the name of the called method, eg "Method"
+func (event *GrpcEvent) Method() string {
+	_, method := splitGrpcPath(event.call.Path)
+	return method
+}
+
+// RequestHeader returns the extracted request headers
+func (event *GrpcEvent) RequestHeader() http.Header {
+	return event.call.RequestHeader
+}
+
+// ResponseHeader returns the extracted response headers
+func (event *GrpcEvent) ResponseHeader() http.Header {
+	return event.call.ResponseHeader
+}
+
+// StatusCode returns the grpc-status sent by the server, or -1 if no status was captured
+func (event *GrpcEvent) StatusCode() int {
+	return event.call.StatusCode
+}
+
+// StatusMessage returns the grpc-message sent by the server
+func (event *GrpcEvent) StatusMessage() string {
+	return event.call.StatusMessage
+}
+
+// RequestMessages returns a summary of the messages sent by the client
+func (event *GrpcEvent) RequestMessages() GrpcMessageStats {
+	return event.call.RequestMessages
+}
+
+// ResponseMessages returns a summary of the messages sent by the server
+func (event *GrpcEvent) ResponseMessages() GrpcMessageStats {
+	return event.call.ResponseMessages
+}
+
+// splitGrpcPath splits a gRPC path of the form "/package.Service/Method" into its service and method
+func splitGrpcPath(path string) (service string, method string) {
+	path = strings.TrimPrefix(path, "/")
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
@@ -0,0 +1,174 @@
+package assemblers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// grpcMessagePrefixLength is the size of the compressed flag and message length that precede every gRPC message
+	grpcMessagePrefixLength = 5
+	// grpcMaxBufferedMessageSize is the largest compressed message we hold on to so it can be decompressed,
+	// matching gRPC's default maximum receive message size
+	grpcMaxBufferedMessageSize = 4 * 1024 * 1024
+	// grpcMaxUncompressedMessageSize limits how much we decompress when measuring a single message
+	grpcMaxUncompressedMessageSize = 64 * 1024 * 1024
+	// grpcMaxBufferedMemory limits the memory held by compressed messages across all streams,
+	// messages that don't fit aren't decompressed so their uncompressed size isn't known
+	grpcMaxBufferedMemory = 64 * 1024 * 1024
+)
+
+// isGrpcContentType returns true if the content type is used by gRPC requests and responses,
+// eg "application/grpc" or "application/grpc+proto"
+func isGrpcContentType(contentType string) bool {
+	return contentType == "application/grpc" ||
+		strings.HasPrefix(contentType, "application/grpc+") ||
+		strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// parseGrpcStatus returns the grpc-status and decoded grpc-message from response headers or trailers.
+// The status code is -1 if the headers don't include a grpc-status.
+func parseGrpcStatus(header http.Header) (int, string) {
+	statusCode := -1
+	if status := header.Get("Grpc-Status"); status != "" {
+		if code, err := strconv.Atoi(status); err == nil {
+			statusCode = code
+		}
+	}
+	// grpc-message is percent-encoded
+	message := header.Get("Grpc-Message")
+	if decoded, err := url.PathUnescape(message); err == nil {
+		message = decoded
+	}
+	return statusCode, message
+}
+
+// grpcMessageReader counts and measures the length-prefixed gRPC messages sent in one direction of a call.
+// Messages can be split across any number of HTTP/2 DATA frames.
+type grpcMessageReader struct {
+	// grpc-encoding used to compress messages, eg "gzip"
+	encoding string
+	stats    GrpcMessageStats
+	// the memory that can be held by buffered messages across all streams
+	maxMemory int64
+
+	prefix       [grpcMessagePrefixLength]byte
+	prefixLength int
+	inMessage    bool
+	compressed   bool
+	length       uint32
+	remaining    uint32
+	// set while the current message is buffered, after reserving its length from the memory shared by all streams
+	buffering bool
+	// compressed message bytes, kept until the message is complete so it can be decompressed
+	message []byte
+}
+
+func newGrpcMessageReader(encoding string, maxMemory int64) *grpcMessageReader {
+	return &grpcMessageReader{encoding: encoding, maxMemory: maxMemory}
+}
+
+// read reads the next chunk of message data from a DATA frame
+func (reader *grpcMessageReader) read(data []byte) {
+	for len(data) > 0 {
+		if !reader.inMessage {
+			n := copy(reader.prefix[reader.prefixLength:], data)
+			reader.prefixLength += n
+			data = data[n:]
+			if reader.prefixLength < grpcMessagePrefixLength {
+				return
+			}
+			reader.startMessage()
+			continue
+		}
+		n := min(int(reader.remaining), len(data))
+		if reader.buffering {
+			reader.message = append(reader.message, data[:n]...)
+		}
+		reader.remaining -= uint32(n)
+		data = data[n:]
+		if reader.remaining == 0 {
+			reader.finishMessage()
+		}
+	}
+	// messages with no content don't have any data after the prefix
+	if reader.inMessage && reader.remaining == 0 {
+		reader.finishMessage()
+	}
+}
+
+func (reader *grpcMessageReader) startMessage() {
+	reader.inMessage = true
+	reader.prefixLength = 0
+	reader.compressed = reader.prefix[0]&1 == 1
+	reader.length = binary.BigEndian.Uint32(reader.prefix[1:])
+	reader.remaining = reader.length
+	reader.stats.Count++
+	reader.stats.CompressedSize += int64(reader.length)
+	// we can only decompress gzip, so other messages aren't kept
+	reader.buffering = reader.compressed && reader.encoding == "gzip" && reader.length <= grpcMaxBufferedMessageSize &&
+		reader.reserve(int64(reader.length))
+}
+
+func (reader *grpcMessageReader) finishMessage() {
+	reader.inMessage = false
+	uncompressedSize := int64(reader.length)
+	if reader.compressed {
+		uncompressedSize = reader.decompressedSize()
+	}
+	reader.release()
+
+	if uncompressedSize < 0 || reader.stats.UncompressedSize < 0 {
+		reader.stats.UncompressedSize = -1
+		return
+	}
+	reader.stats.UncompressedSize += uncompressedSize
+}
+
+// reserve reserves n bytes of the memory shared by all streams' buffered messages, returning false if there isn't room
+func (reader *grpcMessageReader) reserve(n int64) bool {
+	for {
+		used := stats.grpc_message_buffer_bytes.Load()
+		if used+n > reader.maxMemory {
+			stats.grpc_message_buffer_exhausted.Add(1)
+			return false
+		}
+		if stats.grpc_message_buffer_bytes.CompareAndSwap(used, used+n) {
+			return true
+		}
+	}
+}
+
+// release gives back the memory reserved for the current message, eg once it's complete or its stream has ended
+func (reader *grpcMessageReader) release() {
+	if reader == nil || !reader.buffering {
+		return
+	}
+	reader.buffering = false
+	reader.message = nil
+	stats.grpc_message_buffer_bytes.Add(-int64(reader.length))
+}
+
+// decompressedSize returns the size of the current message after decompression,
+// or -1 if it wasn't buffered or can't be decompressed
+func (reader *grpcMessageReader) decompressedSize() int64 {
+	if !reader.buffering || len(reader.message) != int(reader.length) {
+		return -1
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(reader.message))
+	if err != nil {
+		return -1
+	}
+	defer gzipReader.Close()
+	size, err := io.Copy(io.Discard, io.LimitReader(gzipReader, grpcMaxUncompressedMessageSize+1))
+	if err != nil || size > grpcMaxUncompressedMessageSize {
+		return -1
+	}
+	return size
+}
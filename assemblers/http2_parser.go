@@ -0,0 +1,495 @@
+package assemblers
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// http2ConnectionPreface is sent by the client at the start of every HTTP/2 connection,
+// including after a HTTP/1.1 "Upgrade: h2c" exchange.
+const http2ConnectionPreface = http2.ClientPreface
+
+const (
+	// http2FrameHeaderLength is the size of the fixed header that starts every HTTP/2 frame
+	http2FrameHeaderLength = 9
+	// http2DefaultMaxFrameSize is the largest frame a peer can send until SETTINGS_MAX_FRAME_SIZE says otherwise
+	http2DefaultMaxFrameSize = 16384
+	// http2DefaultHeaderTableSize is the HPACK dynamic table size until SETTINGS_HEADER_TABLE_SIZE says otherwise
+	http2DefaultHeaderTableSize = 4096
+)
+
+// http2Parser parses HTTP/2 frames sent over cleartext connections (h2c),
+// either using prior knowledge or after a HTTP/1.1 upgrade.
+//
+// Unlike HTTP/1.x, requests and responses are multiplexed over a connection and
+// are matched using their HTTP/2 stream ID instead of TCP seq/ack numbers.
+// A HttpEvent, or a GrpcEvent for gRPC calls, is emitted for each HTTP/2 stream once the response has ended.
+// Streams that are reset, haven't seen a frame within the TTL, have to make room for newer ones,
+// or are still open when the connection closes are sent with the ends that weren't seen marked partial.
+type http2Parser struct {
+	headersToExtract []string
+	// set once the connection has been identified as HTTP/2
+	active  bool
+	client  *http2Direction
+	server  *http2Direction
+	streams map[uint32]*http2Stream
+	// how long a stream waits for its next frame before it's evicted
+	ttl time.Duration
+	// the most streams tracked at once, the least recently active are evicted to make room
+	maxStreams int
+}
+
+// http2Direction holds the state for frames sent in one direction of a HTTP/2 connection
+type http2Direction struct {
+	// bytes of a partial frame carried over between reassembled segments
+	pending []byte
+	reader  *bytes.Reader
+	framer  *http2.Framer
+	// each direction has its own HPACK compression context
+	decoder *hpack.Decoder
+	// largest frame the receiving peer has said it accepts
+	maxFrameSize uint32
+	// set once data has been read as HTTP/2 frames
+	started bool
+	// set when frame boundaries can no longer be found, eg after missing packets
+	desynced bool
+	// header block for a HEADERS frame waiting on CONTINUATION frames
+	headerBlock     []byte
+	headerStreamId  uint32
+	headerEndStream bool
+}
+
+// http2Stream holds a HTTP/2 request and response until the stream is complete
+type http2Stream struct {
+	id               uint32
+	request          *http.Request
+	requestInfo      HttpMessageInfo
+	requestBodySize  int64
+	response         *http.Response
+	responseInfo     HttpMessageInfo
+	responseBodySize int64
+	// set once the client or server has ended its side of the stream
+	requestEnded  bool
+	responseEnded bool
+	// when the last frame for the stream was seen, used to evict streams whose end we'll never see
+	lastFrameTimestamp time.Time
+
+	// set when the request is a gRPC call, which is sent as a GrpcEvent instead of a HttpEvent
+	grpc                 bool
+	grpcRequestMessages  *grpcMessageReader
+	grpcResponseMessages *grpcMessageReader
+	grpcStatusCode       int
+	grpcStatusMessage    string
+}
+
+func newHttp2Parser(headersToExtract []string, ttl time.Duration, maxStreams int) *http2Parser {
+	return &http2Parser{
+		headersToExtract: headersToExtract,
+		client:           newHttp2Direction(),
+		server:           newHttp2Direction(),
+		streams:          make(map[uint32]*http2Stream),
+		ttl:              ttl,
+		maxStreams:       maxStreams,
+	}
+}
+
+func newHttp2Direction() *http2Direction {
+	reader := bytes.NewReader(nil)
+	framer := http2.NewFramer(nil, reader)
+	// we only observe the connection, so accept whatever the peers send each other
+	framer.AllowIllegalReads = true
+	framer.SetMaxReadFrameSize(1<<24 - 1)
+	return &http2Direction{
+		reader:       reader,
+		framer:       framer,
+		decoder:      hpack.NewDecoder(http2DefaultHeaderTableSize, nil),
+		maxFrameSize: http2DefaultMaxFrameSize,
+	}
+}
+
+// parse reads HTTP/2 frames from the buffer once the connection has been identified as HTTP/2,
+// either by the client connection preface or a HTTP/1.1 upgrade to h2c.
+//
+// Returns (false, nil) without consuming the buffer when the connection isn't HTTP/2,
+// so other parsers can try to parse it.
+func (parser *http2Parser) parse(stream *tcpStream, requestId int64, timestamp time.Time, isClient bool, buffer *bufio.Reader, packetCount int) (bool, error) {
+	if !parser.active {
+		if !isClient {
+			return false, nil
+		}
+		preface, err := buffer.Peek(len(http2ConnectionPreface))
+		if err != nil || string(preface) != http2ConnectionPreface {
+			return false, nil
+		}
+		parser.active = true
+	}
+	// the client can send its preface before we see the server accept the upgrade,
+	// so leave the HTTP/1.1 101 response for the HTTP/1.x parser
+	if !isClient && !parser.server.started {
+		if start, err := buffer.Peek(len("HTTP/")); err == nil && string(start) == "HTTP/" {
+			return false, nil
+		}
+	}
+
+	data, err := io.ReadAll(buffer)
+	if err != nil {
+		return false, err
+	}
+	// the client preface is sent once, at the start of the connection
+	if isClient && bytes.HasPrefix(data, []byte(http2ConnectionPreface)) {
+		data = data[len(http2ConnectionPreface):]
+	}
+
+	direction := parser.server
+	if isClient {
+		direction = parser.client
+	}
+	parser.readFrames(stream, direction, isClient, data, timestamp, packetCount)
+	return true, nil
+}
+
+// upgrade switches the connection to HTTP/2 after a HTTP/1.1 "Upgrade: h2c" request was accepted.
+// The upgraded request becomes HTTP/2 stream 1, which the server responds to using HTTP/2 frames.
+func (parser *http2Parser) upgrade(request *http.Request, requestInfo HttpMessageInfo) {
+	parser.active = true
+	if request == nil {
+		return
+	}
+	request.Proto, request.ProtoMajor, request.ProtoMinor = "HTTP/2.0", 2, 0
+	parser.streams[1] = &http2Stream{
+		id:                 1,
+		request:            request,
+		requestInfo:        requestInfo,
+		requestBodySize:    request.ContentLength,
+		requestEnded:       true,
+		lastFrameTimestamp: requestInfo.Timestamp,
+	}
+}
+
+// readFrames reads all complete frames from the data, keeping any partial frame until more data arrives
+func (parser *http2Parser) readFrames(stream *tcpStream, direction *http2Direction, isClient bool, data []byte, timestamp time.Time, packetCount int) {
+	direction.started = true
+	if direction.desynced {
+		return
+	}
+	pending := append(direction.pending, data...)
+	for len(pending) >= http2FrameHeaderLength {
+		length := uint32(pending[0])<<16 | uint32(pending[1])<<8 | uint32(pending[2])
+		if length > direction.maxFrameSize {
+			// we've lost track of where frames start, most likely due to missing packets
+			log.Debug().
+				Str("stream_ident", stream.ident).
+				Uint32("frame_length", length).
+				Msg("Invalid HTTP/2 frame length, ignoring the rest of this direction of the connection")
+			direction.desynced = true
+			direction.pending = nil
+			return
+		}
+		frameLength := http2FrameHeaderLength + int(length)
+		if len(pending) < frameLength {
+			break
+		}
+		direction.reader.Reset(pending[:frameLength])
+		pending = pending[frameLength:]
+		frame, err := direction.framer.ReadFrame()
+		if err != nil {
+			log.Debug().
+				Err(err).
+				Str("stream_ident", stream.ident).
+				Msg("Error reading HTTP/2 frame")
+			continue
+		}
+		parser.handleFrame(stream, direction, isClient, frame, timestamp, packetCount)
+	}
+	// copy the leftover bytes so we don't hold on to the segment's buffer
+	direction.pending = append([]byte(nil), pending...)
+}
+
+func (parser *http2Parser) handleFrame(stream *tcpStream, direction *http2Direction, isClient bool, frame http2.Frame, timestamp time.Time, packetCount int) {
+	switch frame := frame.(type) {
+	case *http2.SettingsFrame:
+		if frame.IsAck() {
+			return
+		}
+		// settings describe what the sender accepts, so apply them to the opposite direction
+		opposite := parser.client
+		if isClient {
+			opposite = parser.server
+		}
+		if size, ok := frame.Value(http2.SettingHeaderTableSize); ok {
+			opposite.decoder.SetAllowedMaxDynamicTableSize(size)
+		}
+		if size, ok := frame.Value(http2.SettingMaxFrameSize); ok {
+			opposite.maxFrameSize = size
+		}
+	case *http2.HeadersFrame:
+		direction.headerStreamId = frame.StreamID
+		direction.headerEndStream = frame.StreamEnded()
+		direction.headerBlock = append(direction.headerBlock[:0], frame.HeaderBlockFragment()...)
+		if frame.HeadersEnded() {
+			parser.handleHeaderBlock(stream, direction, isClient, timestamp, packetCount)
+		}
+	case *http2.ContinuationFrame:
+		if frame.StreamID != direction.headerStreamId {
+			return
+		}
+		direction.headerBlock = append(direction.headerBlock, frame.HeaderBlockFragment()...)
+		if frame.HeadersEnded() {
+			parser.handleHeaderBlock(stream, direction, isClient, timestamp, packetCount)
+		}
+	case *http2.DataFrame:
+		h2Stream, ok := parser.streams[frame.StreamID]
+		if !ok {
+			return
+		}
+		h2Stream.lastFrameTimestamp = timestamp
+		if isClient {
+			h2Stream.requestEnded = h2Stream.requestEnded || frame.StreamEnded()
+			h2Stream.requestInfo.LastByteTimestamp = stream.lastByteTimestamp(timestamp)
+			h2Stream.requestBodySize += int64(len(frame.Data()))
+			if h2Stream.grpcRequestMessages != nil {
+				h2Stream.grpcRequestMessages.read(frame.Data())
+			}
+		} else {
+			h2Stream.responseInfo.LastByteTimestamp = stream.lastByteTimestamp(timestamp)
+			h2Stream.responseBodySize += int64(len(frame.Data()))
+			if h2Stream.grpcResponseMessages != nil {
+				h2Stream.grpcResponseMessages.read(frame.Data())
+			}
+		}
+		if frame.StreamEnded() && !isClient {
+			h2Stream.responseEnded = true
+			parser.completeStream(stream, h2Stream, "")
+		}
+	case *http2.RSTStreamFrame:
+		if h2Stream, ok := parser.streams[frame.StreamID]; ok {
+			parser.completeStream(stream, h2Stream, unmatchedStreamReset)
+		}
+	}
+}
+
+// handleHeaderBlock decodes a complete header block and records it as a request or response
+func (parser *http2Parser) handleHeaderBlock(stream *tcpStream, direction *http2Direction, isClient bool, timestamp time.Time, packetCount int) {
+	streamId := direction.headerStreamId
+	endStream := direction.headerEndStream
+	// the HPACK decoder must see every header block, even the ones we don't use, to keep its table in sync
+	fields, err := direction.decoder.DecodeFull(direction.headerBlock)
+	direction.headerBlock = direction.headerBlock[:0]
+	if err != nil {
+		log.Debug().
+			Err(err).
+			Str("stream_ident", stream.ident).
+			Uint32("http2_stream_id", streamId).
+			Msg("Error decoding HTTP/2 headers")
+		return
+	}
+
+	pseudoHeaders := map[string]string{}
+	header := http.Header{}
+	for _, field := range fields {
+		if strings.HasPrefix(field.Name, ":") {
+			pseudoHeaders[field.Name] = field.Value
+		} else {
+			header.Add(field.Name, field.Value)
+		}
+	}
+
+	h2Stream, ok := parser.streams[streamId]
+	if !ok {
+		if parser.maxStreams > 0 && len(parser.streams) >= parser.maxStreams {
+			parser.evictLeastRecentlyActive(stream)
+		}
+		h2Stream = &http2Stream{id: streamId}
+		parser.streams[streamId] = h2Stream
+	}
+	h2Stream.lastFrameTimestamp = timestamp
+
+	if isClient {
+		h2Stream.requestEnded = h2Stream.requestEnded || endStream
+		h2Stream.requestInfo.LastByteTimestamp = stream.lastByteTimestamp(timestamp)
+		// a second header block from the client holds request trailers, which we don't need
+		if h2Stream.request == nil {
+			h2Stream.request = &http.Request{
+				Method:        pseudoHeaders[":method"],
+				RequestURI:    pseudoHeaders[":path"],
+				Host:          pseudoHeaders[":authority"],
+				Proto:         "HTTP/2.0",
+				ProtoMajor:    2,
+				ContentLength: -1,
+				Header:        extractHeaders(header, parser.headersToExtract),
+			}
+			h2Stream.requestInfo.Timestamp = timestamp
+			h2Stream.requestInfo.PacketCount = packetCount
+			h2Stream.requestInfo.ContentEncoding = header.Get("Content-Encoding")
+			if isGrpcContentType(header.Get("Content-Type")) {
+				h2Stream.grpc = true
+				h2Stream.grpcRequestMessages = newGrpcMessageReader(header.Get("Grpc-Encoding"), grpcMaxBufferedMemory)
+				h2Stream.grpcStatusCode = -1
+			}
+		}
+		return
+	}
+
+	h2Stream.responseInfo.LastByteTimestamp = stream.lastByteTimestamp(timestamp)
+	if h2Stream.response == nil {
+		status, err := strconv.Atoi(pseudoHeaders[":status"])
+		if err != nil {
+			return
+		}
+		// informational responses (eg 100 Continue) are followed by the final response
+		if status >= 100 && status < 200 {
+			return
+		}
+		h2Stream.response = &http.Response{
+			Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+			StatusCode:    status,
+			Proto:         "HTTP/2.0",
+			ProtoMajor:    2,
+			ContentLength: -1,
+			Header:        extractHeaders(header, parser.headersToExtract),
+		}
+		h2Stream.responseInfo.Timestamp = timestamp
+		h2Stream.responseInfo.PacketCount = packetCount
+		h2Stream.responseInfo.ContentEncoding = header.Get("Content-Encoding")
+		if h2Stream.grpc {
+			h2Stream.grpcResponseMessages = newGrpcMessageReader(header.Get("Grpc-Encoding"), grpcMaxBufferedMemory)
+		}
+	}
+	// gRPC sends its status in trailers, or in the response headers when there's no response body
+	if h2Stream.grpc {
+		if statusCode, statusMessage := parseGrpcStatus(header); statusCode >= 0 {
+			h2Stream.grpcStatusCode = statusCode
+			h2Stream.grpcStatusMessage = statusMessage
+		}
+	}
+	if endStream {
+		h2Stream.responseEnded = true
+		parser.completeStream(stream, h2Stream, "")
+	}
+}
+
+// completeStream sends a HttpEvent or GrpcEvent for the HTTP/2 stream and stops tracking it.
+// Ends of the stream that weren't seen are marked partial, and an unmatched request or response
+// is sent with the reason the stream ended without its counterpart.
+func (parser *http2Parser) completeStream(stream *tcpStream, h2Stream *http2Stream, unmatchedReason string) {
+	delete(parser.streams, h2Stream.id)
+	h2Stream.grpcRequestMessages.release()
+	h2Stream.grpcResponseMessages.release()
+	h2Stream.requestInfo.Partial = h2Stream.request != nil && !h2Stream.requestEnded
+	h2Stream.responseInfo.Partial = h2Stream.response != nil && !h2Stream.responseEnded
+	if h2Stream.grpc {
+		parser.completeGrpcStream(stream, h2Stream)
+		return
+	}
+	if h2Stream.request == nil && h2Stream.response == nil {
+		return
+	}
+	if h2Stream.request != nil {
+		h2Stream.request.ContentLength = h2Stream.requestBodySize
+	}
+	if h2Stream.response != nil {
+		h2Stream.response.ContentLength = h2Stream.responseBodySize
+	}
+	event := NewHttpEvent(
+		stream.ident,
+		int64(h2Stream.id),
+		stream.srcIP,
+		stream.dstIP,
+		h2Stream.request,
+		h2Stream.requestInfo,
+		h2Stream.response,
+		h2Stream.responseInfo,
+	)
+	if h2Stream.request == nil || h2Stream.response == nil {
+		event.unmatchedReason = unmatchedReason
+	}
+	stream.sendEvent(event)
+}
+
+// evict sends events for streams that haven't seen a frame within the TTL,
+// most likely because their end was in packets we missed
+func (parser *http2Parser) evict(stream *tcpStream, now time.Time) {
+	var expired []*http2Stream
+	for _, h2Stream := range parser.streams {
+		if now.Sub(h2Stream.lastFrameTimestamp) > parser.ttl {
+			expired = append(expired, h2Stream)
+		}
+	}
+	stats.http_unmatched_timeout.Add(uint64(len(expired)))
+	for _, h2Stream := range sortHttp2Streams(expired) {
+		parser.completeStream(stream, h2Stream, unmatchedTimeout)
+	}
+}
+
+// evictLeastRecentlyActive sends an event for the stream that has gone longest without a frame,
+// making room for a new stream
+func (parser *http2Parser) evictLeastRecentlyActive(stream *tcpStream) {
+	var oldest *http2Stream
+	for _, h2Stream := range parser.streams {
+		if oldest == nil || h2Stream.lastFrameTimestamp.Before(oldest.lastFrameTimestamp) {
+			oldest = h2Stream
+		}
+	}
+	stats.http_unmatched_max_entries.Add(1)
+	parser.completeStream(stream, oldest, unmatchedMaxEntries)
+}
+
+// close sends events for streams that were still open when the connection closed
+func (parser *http2Parser) close(stream *tcpStream) {
+	open := make([]*http2Stream, 0, len(parser.streams))
+	for _, h2Stream := range parser.streams {
+		open = append(open, h2Stream)
+	}
+	stats.http_unmatched_stream_closed.Add(uint64(len(open)))
+	for _, h2Stream := range sortHttp2Streams(open) {
+		parser.completeStream(stream, h2Stream, unmatchedStreamClosed)
+	}
+}
+
+// sortHttp2Streams sorts streams by ID, which is the order they were opened in
+func sortHttp2Streams(h2Streams []*http2Stream) []*http2Stream {
+	slices.SortFunc(h2Streams, func(a, b *http2Stream) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	return h2Streams
+}
+
+// completeGrpcStream sends a GrpcEvent for a HTTP/2 stream that carried a gRPC call
+func (parser *http2Parser) completeGrpcStream(stream *tcpStream, h2Stream *http2Stream) {
+	var responseHeader http.Header
+	if h2Stream.response != nil {
+		responseHeader = h2Stream.response.Header
+	}
+	var responseMessages GrpcMessageStats
+	if h2Stream.grpcResponseMessages != nil {
+		responseMessages = h2Stream.grpcResponseMessages.stats
+	}
+	stream.sendEvent(NewGrpcEvent(
+		stream.ident,
+		int64(h2Stream.id),
+		stream.srcIP,
+		stream.dstIP,
+		h2Stream.requestInfo.MessageInfo,
+		h2Stream.responseInfo.MessageInfo,
+		GrpcCall{
+			Path:             h2Stream.request.RequestURI,
+			RequestHeader:    h2Stream.request.Header,
+			ResponseHeader:   responseHeader,
+			StatusCode:       h2Stream.grpcStatusCode,
+			StatusMessage:    h2Stream.grpcStatusMessage,
+			RequestMessages:  h2Stream.grpcRequestMessages.stats,
+			ResponseMessages: responseMessages,
+		},
+	))
+}
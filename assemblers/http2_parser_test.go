@@ -0,0 +1,393 @@
+package assemblers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+// http2TestConnection writes the frames sent in one direction of a HTTP/2 connection
+type http2TestConnection struct {
+	buf     *bytes.Buffer
+	framer  *http2.Framer
+	encoder *hpack.Encoder
+	headers *bytes.Buffer
+}
+
+func newHttp2TestConnection() *http2TestConnection {
+	buf := &bytes.Buffer{}
+	headers := &bytes.Buffer{}
+	return &http2TestConnection{
+		buf:     buf,
+		framer:  http2.NewFramer(buf, nil),
+		encoder: hpack.NewEncoder(headers),
+		headers: headers,
+	}
+}
+
+// writeHeaders writes a HEADERS frame with the given fields, split into a CONTINUATION frame if split is set
+func (conn *http2TestConnection) writeHeaders(t *testing.T, streamId uint32, endStream bool, split bool, fields ...string) {
+	conn.headers.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		require.NoError(t, conn.encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]}))
+	}
+	block := conn.headers.Bytes()
+	if !split {
+		require.NoError(t, conn.framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      streamId,
+			BlockFragment: block,
+			EndStream:     endStream,
+			EndHeaders:    true,
+		}))
+		return
+	}
+	half := len(block) / 2
+	require.NoError(t, conn.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamId,
+		BlockFragment: block[:half],
+		EndStream:     endStream,
+	}))
+	require.NoError(t, conn.framer.WriteContinuation(streamId, true, block[half:]))
+}
+
+// takeBytes returns the frames written so far
+func (conn *http2TestConnection) takeBytes() []byte {
+	data := append([]byte(nil), conn.buf.Bytes()...)
+	conn.buf.Reset()
+	return data
+}
+
+func newHttp2TestStream() *tcpStream {
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	transportFlow := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0xd4, 0x31}, []byte{0x1f, 0x90})
+	return NewTcpStream(netFlow, transportFlow, config.Config{
+		HTTPHeadersToExtract:  []string{"User-Agent"},
+		HTTPMatcherTTL:        time.Minute,
+		HTTPMatcherMaxEntries: 1000,
+	}, make(chan Event, 10))
+}
+
+func TestHttp2PriorKnowledgeEmitsEventPerStream(t *testing.T) {
+	stream := newHttp2TestStream()
+	client := newHttp2TestConnection()
+	server := newHttp2TestConnection()
+	requestTime := time.Now()
+	responseTime := requestTime.Add(10 * time.Millisecond)
+
+	client.buf.WriteString(http2.ClientPreface)
+	require.NoError(t, client.framer.WriteSettings())
+	client.writeHeaders(t, 1, true, false, ":method", "GET", ":path", "/one", ":scheme", "http", ":authority", "example.com", "user-agent", "h2-test/1.0", "cookie", "secret")
+	client.writeHeaders(t, 3, false, true, ":method", "POST", ":path", "/two", ":scheme", "http", ":authority", "example.com")
+	require.NoError(t, client.framer.WriteData(3, true, []byte("hello")))
+	data := client.takeBytes()
+	// split the client data so a frame is spread across two segments
+	stream.parse(data[:40], 0, requestTime, true, 1)
+	stream.parse(data[40:], 0, requestTime, true, 1)
+
+	require.NoError(t, server.framer.WriteSettings(http2.Setting{ID: http2.SettingHeaderTableSize, Val: 4096}))
+	// the second stream is answered first, and sends an informational response before the final one
+	server.writeHeaders(t, 3, false, false, ":status", "100")
+	server.writeHeaders(t, 3, false, false, ":status", "201")
+	require.NoError(t, server.framer.WriteData(3, true, []byte("created")))
+	server.writeHeaders(t, 1, true, false, ":status", "404", "user-agent", "ignored-but-extracted")
+	stream.parse(server.takeBytes(), 0, responseTime, false, 2)
+
+	require.Len(t, stream.eventsChan, 2)
+
+	first := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, int64(3), first.RequestId())
+	assert.Equal(t, "POST", first.Request().Method)
+	assert.Equal(t, "/two", first.Request().RequestURI)
+	assert.Equal(t, "example.com", first.Request().Host)
+	assert.Equal(t, 2, first.Request().ProtoMajor)
+	assert.Equal(t, int64(5), first.Request().ContentLength)
+	assert.Equal(t, 201, first.Response().StatusCode)
+	assert.Equal(t, int64(7), first.Response().ContentLength)
+	assert.Equal(t, requestTime, first.RequestTimestamp())
+	assert.Equal(t, responseTime, first.ResponseTimestamp())
+
+	second := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, int64(1), second.RequestId())
+	assert.Equal(t, "GET", second.Request().Method)
+	assert.Equal(t, "/one", second.Request().RequestURI)
+	assert.Equal(t, "h2-test/1.0", second.Request().Header.Get("User-Agent"))
+	assert.Empty(t, second.Request().Header.Get("Cookie"))
+	assert.Equal(t, 404, second.Response().StatusCode)
+	assert.Equal(t, "10.0.0.1", second.SrcIp())
+	assert.Equal(t, "10.0.0.2", second.DstIp())
+}
+
+func TestHttp2UpgradeFromHttp1(t *testing.T) {
+	stream := newHttp2TestStream()
+	client := newHttp2TestConnection()
+	server := newHttp2TestConnection()
+	requestTime := time.Now()
+	responseTime := requestTime.Add(10 * time.Millisecond)
+
+	upgradeRequest := "GET /upgrade HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"User-Agent: h2c-test/1.0\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\n" +
+		"HTTP2-Settings: AAMAAABkAAQAAP__\r\n\r\n"
+	stream.parse([]byte(upgradeRequest), 100, requestTime, true, 1)
+
+	upgradeResponse := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Upgrade: h2c\r\n\r\n"
+	server.buf.WriteString(upgradeResponse)
+	require.NoError(t, server.framer.WriteSettings())
+	server.writeHeaders(t, 1, false, false, ":status", "200")
+	require.NoError(t, server.framer.WriteData(1, true, []byte("upgraded")))
+	stream.parse(server.takeBytes(), 100, responseTime, false, 2)
+
+	require.Len(t, stream.eventsChan, 1)
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, int64(1), event.RequestId())
+	assert.Equal(t, "GET", event.Request().Method)
+	assert.Equal(t, "/upgrade", event.Request().RequestURI)
+	assert.Equal(t, "h2c-test/1.0", event.Request().Header.Get("User-Agent"))
+	assert.Equal(t, 2, event.Request().ProtoMajor)
+	assert.Equal(t, 200, event.Response().StatusCode)
+	assert.Equal(t, int64(8), event.Response().ContentLength)
+	assert.Equal(t, requestTime, event.RequestTimestamp())
+	assert.Equal(t, responseTime, event.ResponseTimestamp())
+
+	// the client then sends the preface and more requests over HTTP/2
+	client.buf.WriteString(http2.ClientPreface)
+	require.NoError(t, client.framer.WriteSettings())
+	client.writeHeaders(t, 3, true, false, ":method", "GET", ":path", "/next", ":scheme", "http", ":authority", "example.com")
+	stream.parse(client.takeBytes(), 0, requestTime, true, 1)
+	server.writeHeaders(t, 3, true, false, ":status", "204")
+	stream.parse(server.takeBytes(), 0, responseTime, false, 1)
+
+	require.Len(t, stream.eventsChan, 1)
+	event = (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, int64(3), event.RequestId())
+	assert.Equal(t, "/next", event.Request().RequestURI)
+	assert.Equal(t, 204, event.Response().StatusCode)
+}
+
+func TestHttp2UpgradeWithoutCapturedRequest(t *testing.T) {
+	stream := newHttp2TestStream()
+	server := newHttp2TestConnection()
+
+	// the capture started after the client asked to upgrade
+	server.buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+	require.NoError(t, server.framer.WriteSettings())
+	server.writeHeaders(t, 1, true, false, ":status", "200")
+	stream.parse(server.takeBytes(), 100, time.Now(), false, 1)
+
+	// only the HTTP/2 response is sent, nothing is left waiting for a request that was never captured
+	require.Len(t, stream.eventsChan, 1)
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Nil(t, event.Request())
+	assert.Equal(t, 200, event.Response().StatusCode)
+	assert.Empty(t, stream.parsers[1].(*httpParser).matcher.messages)
+}
+
+func TestHttp2ParserIgnoresHttp1Connections(t *testing.T) {
+	stream := newHttp2TestStream()
+
+	stream.parse([]byte("GET /check HTTP/1.1\r\nHost: example.com\r\n\r\n"), 1, time.Now(), true, 1)
+	stream.parse([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"), 1, time.Now(), false, 1)
+
+	require.Len(t, stream.eventsChan, 1)
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/check", event.Request().RequestURI)
+	assert.Equal(t, 1, event.Request().ProtoMajor)
+	assert.Equal(t, 200, event.Response().StatusCode)
+}
+
+// grpcMessage returns a length-prefixed gRPC message, gzipped if compress is set
+func grpcMessage(t *testing.T, payload []byte, compress bool) []byte {
+	flag := byte(0)
+	if compress {
+		flag = 1
+		buf := &bytes.Buffer{}
+		writer := gzip.NewWriter(buf)
+		_, err := writer.Write(payload)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		payload = buf.Bytes()
+	}
+	message := []byte{flag, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(message[1:], uint32(len(payload)))
+	return append(message, payload...)
+}
+
+func TestHttp2GrpcCallEmitsGrpcEvent(t *testing.T) {
+	stream := newHttp2TestStream()
+	client := newHttp2TestConnection()
+	server := newHttp2TestConnection()
+	requestTime := time.Now()
+	responseTime := requestTime.Add(10 * time.Millisecond)
+
+	client.buf.WriteString(http2.ClientPreface)
+	client.writeHeaders(t, 1, false, false, ":method", "POST", ":path", "/teapot.v1.TeapotService/Brew", ":scheme", "http", ":authority", "teapot:50051",
+		"content-type", "application/grpc+proto", "grpc-encoding", "gzip", "user-agent", "grpc-go/1.60.0")
+	request := append(grpcMessage(t, bytes.Repeat([]byte("a"), 100), true), grpcMessage(t, []byte("plain"), false)...)
+	// split a message across DATA frames
+	require.NoError(t, client.framer.WriteData(1, false, request[:3]))
+	require.NoError(t, client.framer.WriteData(1, true, request[3:]))
+	stream.parse(client.takeBytes(), 0, requestTime, true, 1)
+
+	server.writeHeaders(t, 1, false, false, ":status", "200", "content-type", "application/grpc")
+	response := append(grpcMessage(t, []byte("one"), false), grpcMessage(t, []byte{}, false)...)
+	response = append(response, grpcMessage(t, []byte("three"), false)...)
+	require.NoError(t, server.framer.WriteData(1, false, response))
+	server.writeHeaders(t, 1, true, false, "grpc-status", "12", "grpc-message", "I%27m a teapot")
+	stream.parse(server.takeBytes(), 0, responseTime, false, 2)
+
+	require.Len(t, stream.eventsChan, 1)
+	event := (<-stream.eventsChan).(*GrpcEvent)
+	assert.Equal(t, int64(1), event.RequestId())
+	assert.Equal(t, "/teapot.v1.TeapotService/Brew", event.Path())
+	assert.Equal(t, "teapot.v1.TeapotService", event.Service())
+	assert.Equal(t, "Brew", event.Method())
+	assert.Equal(t, "grpc-go/1.60.0", event.RequestHeader().Get("User-Agent"))
+	assert.Equal(t, 12, event.StatusCode())
+	assert.Equal(t, "I'm a teapot", event.StatusMessage())
+	assert.Equal(t, 2, event.RequestMessages().Count)
+	assert.Equal(t, int64(len(request)-10), event.RequestMessages().CompressedSize)
+	assert.Equal(t, int64(105), event.RequestMessages().UncompressedSize)
+	assert.Equal(t, GrpcMessageStats{Count: 3, CompressedSize: 8, UncompressedSize: 8}, event.ResponseMessages())
+	assert.Equal(t, requestTime, event.RequestTimestamp())
+	assert.Equal(t, responseTime, event.ResponseTimestamp())
+}
+
+func TestHttp2GrpcTrailersOnlyResponse(t *testing.T) {
+	stream := newHttp2TestStream()
+	client := newHttp2TestConnection()
+	server := newHttp2TestConnection()
+
+	client.buf.WriteString(http2.ClientPreface)
+	client.writeHeaders(t, 1, false, false, ":method", "POST", ":path", "/teapot.v1.TeapotService/Pour", "content-type", "application/grpc")
+	require.NoError(t, client.framer.WriteData(1, true, grpcMessage(t, []byte("cup"), false)))
+	stream.parse(client.takeBytes(), 0, time.Now(), true, 1)
+
+	server.writeHeaders(t, 1, true, false, ":status", "200", "content-type", "application/grpc", "grpc-status", "5", "grpc-message", "not found")
+	stream.parse(server.takeBytes(), 0, time.Now(), false, 1)
+
+	require.Len(t, stream.eventsChan, 1)
+	event := (<-stream.eventsChan).(*GrpcEvent)
+	assert.Equal(t, "Pour", event.Method())
+	assert.Equal(t, 5, event.StatusCode())
+	assert.Equal(t, "not found", event.StatusMessage())
+	assert.Equal(t, 1, event.RequestMessages().Count)
+	assert.Equal(t, 0, event.ResponseMessages().Count)
+}
+
+func TestHttp2ResetStreamIsSentPartial(t *testing.T) {
+	stream := newHttp2TestStream()
+	client := newHttp2TestConnection()
+	server := newHttp2TestConnection()
+
+	client.buf.WriteString(http2.ClientPreface)
+	client.writeHeaders(t, 1, false, false, ":method", "POST", ":path", "/upload", ":authority", "example.com")
+	require.NoError(t, client.framer.WriteData(1, false, []byte("part")))
+	client.writeHeaders(t, 3, true, false, ":method", "GET", ":path", "/download", ":authority", "example.com")
+	stream.parse(client.takeBytes(), 0, time.Now(), true, 1)
+
+	// the client cancels the upload before the server responds
+	require.NoError(t, client.framer.WriteRSTStream(1, http2.ErrCodeCancel))
+	stream.parse(client.takeBytes(), 0, time.Now(), true, 2)
+	// the server starts a response, then resets the stream
+	server.writeHeaders(t, 3, false, false, ":status", "200")
+	require.NoError(t, server.framer.WriteData(3, false, []byte("par")))
+	require.NoError(t, server.framer.WriteRSTStream(3, http2.ErrCodeInternal))
+	stream.parse(server.takeBytes(), 0, time.Now(), false, 3)
+
+	require.Len(t, stream.eventsChan, 2)
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/upload", event.Request().RequestURI)
+	assert.True(t, event.RequestPartial())
+	assert.Nil(t, event.Response())
+	assert.Equal(t, unmatchedStreamReset, event.UnmatchedReason())
+
+	event = (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/download", event.Request().RequestURI)
+	assert.False(t, event.RequestPartial())
+	assert.Equal(t, 200, event.Response().StatusCode)
+	assert.True(t, event.ResponsePartial())
+	assert.Equal(t, "", event.UnmatchedReason())
+	assert.Empty(t, stream.parsers[0].(*http2Parser).streams)
+}
+
+func TestHttp2StreamsWhoseEndIsNeverSeenAreEvicted(t *testing.T) {
+	stream := newHttp2TestStream()
+	parser := stream.parsers[0].(*http2Parser)
+	parser.maxStreams = 2
+	client := newHttp2TestConnection()
+	server := newHttp2TestConnection()
+	requestTime := time.Now()
+
+	client.buf.WriteString(http2.ClientPreface)
+	client.writeHeaders(t, 1, true, false, ":method", "GET", ":path", "/one", ":authority", "example.com")
+	client.writeHeaders(t, 3, false, false, ":method", "POST", ":path", "/two", ":authority", "example.com",
+		"content-type", "application/grpc")
+	stream.parse(client.takeBytes(), 0, requestTime, true, 1)
+	// the server's response to the first stream starts, but its end is never seen
+	server.writeHeaders(t, 1, false, false, ":status", "200")
+	stream.parse(server.takeBytes(), 0, requestTime.Add(time.Second), false, 2)
+
+	// the least recently active stream makes room for a new one
+	client.writeHeaders(t, 5, true, false, ":method", "GET", ":path", "/three", ":authority", "example.com")
+	stream.parse(client.takeBytes(), 0, requestTime.Add(2*time.Second), true, 3)
+	require.Len(t, stream.eventsChan, 1)
+	grpcEvent := (<-stream.eventsChan).(*GrpcEvent)
+	assert.Equal(t, "/two", grpcEvent.Path())
+	assert.True(t, grpcEvent.RequestPartial())
+	assert.Equal(t, -1, grpcEvent.StatusCode())
+
+	// streams without a frame within the TTL are evicted
+	stream.evict(requestTime.Add(time.Second + time.Minute + time.Millisecond))
+	require.Len(t, stream.eventsChan, 1)
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/one", event.Request().RequestURI)
+	assert.False(t, event.RequestPartial())
+	assert.Equal(t, 200, event.Response().StatusCode)
+	assert.True(t, event.ResponsePartial())
+
+	// and streams still open when the connection closes are sent
+	parser.close(stream)
+	require.Len(t, stream.eventsChan, 1)
+	event = (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/three", event.Request().RequestURI)
+	assert.Nil(t, event.Response())
+	assert.Equal(t, unmatchedStreamClosed, event.UnmatchedReason())
+	assert.Empty(t, parser.streams)
+}
+
+func TestGrpcMessageReaderSkipsDecompressionWhenBufferMemoryIsExhausted(t *testing.T) {
+	usedBefore := stats.grpc_message_buffer_bytes.Load()
+	exhaustedBefore := stats.grpc_message_buffer_exhausted.Load()
+	message := grpcMessage(t, bytes.Repeat([]byte("a"), 100), true)
+	length := int64(len(message) - grpcMessagePrefixLength)
+	maxMemory := usedBefore + length
+
+	// the first message arrives in two frames, holding its memory in between
+	first := newGrpcMessageReader("gzip", maxMemory)
+	first.read(message[:10])
+	assert.Equal(t, usedBefore+length, stats.grpc_message_buffer_bytes.Load())
+
+	second := newGrpcMessageReader("gzip", maxMemory)
+	second.read(message)
+	assert.Equal(t, GrpcMessageStats{Count: 1, CompressedSize: length, UncompressedSize: -1}, second.stats)
+	assert.Equal(t, exhaustedBefore+1, stats.grpc_message_buffer_exhausted.Load())
+
+	first.read(message[10:])
+	assert.Equal(t, GrpcMessageStats{Count: 1, CompressedSize: length, UncompressedSize: 100}, first.stats)
+	assert.Equal(t, usedBefore, stats.grpc_message_buffer_bytes.Load())
+}
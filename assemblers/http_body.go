@@ -0,0 +1,211 @@
+package assemblers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// httpMaxChunkLineLength limits how long a chunk size or trailer line can be,
+// so we stop following a connection that isn't sending a valid chunked body
+const httpMaxChunkLineLength = 4096
+
+var errInvalidChunkedBody = errors.New("invalid chunked body")
+
+type httpBodyFraming int
+
+const (
+	httpBodyNone httpBodyFraming = iota
+	// the body is Content-Length bytes long
+	httpBodyLength
+	// the body is sent in chunks, each prefixed with its size, ending with a zero sized chunk and optional trailers
+	httpBodyChunked
+	// the body continues until the connection closes, used by responses without a Content-Length
+	httpBodyUntilClose
+)
+
+type httpChunkState int
+
+const (
+	httpChunkSize httpChunkState = iota
+	httpChunkData
+	httpChunkDataEnd
+	httpChunkTrailer
+)
+
+// httpBody reads past the body of a request or response as it arrives,
+// which can be spread across any number of reassembled segments, counting its size.
+//
+// The size is the number of payload bytes, which doesn't include the chunk framing
+// of chunked bodies, and is the compressed size for bodies with a content encoding.
+type httpBody struct {
+	framing    httpBodyFraming
+	remaining  int64
+	chunkState httpChunkState
+	line       []byte
+	size       int64
+	// keeps the start of the body when body capture is enabled, nil otherwise
+	capture *httpBodyCapture
+}
+
+// newHttpBody returns the body that follows a request or response's headers
+func newHttpBody(contentLength int64, transferEncoding []string, body io.ReadCloser) *httpBody {
+	switch {
+	// responses to HEAD requests and some status codes don't have a body, whatever their Content-Length says
+	case body == nil || body == http.NoBody:
+		return &httpBody{framing: httpBodyNone}
+	case slices.Contains(transferEncoding, "chunked"):
+		return &httpBody{framing: httpBodyChunked}
+	case contentLength > 0:
+		return &httpBody{framing: httpBodyLength, remaining: contentLength}
+	case contentLength < 0:
+		return &httpBody{framing: httpBodyUntilClose}
+	}
+	return &httpBody{framing: httpBodyNone}
+}
+
+// complete returns true once the whole body has been read
+func (body *httpBody) complete() bool {
+	return body.framing == httpBodyNone || body.framing == httpBodyLength && body.remaining == 0
+}
+
+// read reads as much of the body as the buffer holds, returning true when the body is complete.
+// Returns an error if a chunked body isn't valid.
+func (body *httpBody) read(buffer *bufio.Reader) (bool, error) {
+	switch body.framing {
+	case httpBodyLength:
+		body.remaining -= body.discard(buffer, body.remaining)
+	case httpBodyUntilClose:
+		body.discard(buffer, math.MaxInt64)
+	case httpBodyChunked:
+		return body.readChunks(buffer)
+	}
+	return body.complete(), nil
+}
+
+// skip accounts for n bytes of the body that weren't captured, eg because packets were lost.
+// Returns false if the body could have ended within them, so the rest of the body can't be followed.
+func (body *httpBody) skip(n int64) bool {
+	switch {
+	case body.framing == httpBodyLength && n <= body.remaining:
+	case body.framing == httpBodyChunked && body.chunkState == httpChunkData && n <= body.remaining:
+	default:
+		return false
+	}
+	body.remaining -= n
+	body.size += n
+	body.capture.skipped()
+	return true
+}
+
+// truncate ends the body at what has been read so far, when the rest of it won't be captured.
+// The size is still known for bodies with a Content-Length, otherwise it's set to -1.
+func (body *httpBody) truncate() {
+	switch body.framing {
+	case httpBodyLength:
+		body.size += body.remaining
+		body.remaining = 0
+	case httpBodyChunked, httpBodyUntilClose:
+		body.size = -1
+	}
+	body.framing = httpBodyNone
+	body.capture.skipped()
+}
+
+// discard skips up to n bytes of the body in the buffer, returning how many were skipped.
+// The skipped bytes are captured first if there's room for them.
+func (body *httpBody) discard(buffer *bufio.Reader, n int64) int64 {
+	captured := body.capture.read(buffer, n)
+	discarded := captured
+	for discarded < n {
+		skipped, err := buffer.Discard(int(min(n-discarded, math.MaxInt32)))
+		discarded += int64(skipped)
+		if err != nil {
+			break
+		}
+	}
+	if discarded > captured {
+		body.capture.skipped()
+	}
+	body.size += discarded
+	return discarded
+}
+
+func (body *httpBody) readChunks(buffer *bufio.Reader) (bool, error) {
+	for {
+		switch body.chunkState {
+		case httpChunkSize:
+			line, ok, err := body.readLine(buffer)
+			if !ok || err != nil {
+				return false, err
+			}
+			// chunk extensions follow the size after a semicolon
+			if i := bytes.IndexByte(line, ';'); i >= 0 {
+				line = line[:i]
+			}
+			size, err := strconv.ParseInt(string(bytes.TrimSpace(line)), 16, 64)
+			if err != nil || size < 0 {
+				return false, errInvalidChunkedBody
+			}
+			if size == 0 {
+				body.chunkState = httpChunkTrailer
+			} else {
+				body.chunkState = httpChunkData
+				body.remaining = size
+			}
+		case httpChunkData:
+			body.remaining -= body.discard(buffer, body.remaining)
+			if body.remaining > 0 {
+				return false, nil
+			}
+			body.chunkState = httpChunkDataEnd
+		case httpChunkDataEnd:
+			line, ok, err := body.readLine(buffer)
+			if !ok || err != nil {
+				return false, err
+			}
+			if len(line) != 0 {
+				return false, errInvalidChunkedBody
+			}
+			body.chunkState = httpChunkSize
+		case httpChunkTrailer:
+			// trailers end with an empty line
+			line, ok, err := body.readLine(buffer)
+			if !ok || err != nil {
+				return false, err
+			}
+			if len(line) == 0 {
+				body.framing = httpBodyNone
+				return true, nil
+			}
+		}
+	}
+}
+
+// readLine reads a CRLF terminated line, which can be split across segments, returning the line without its
+// line ending and true once the whole line has been read
+func (body *httpBody) readLine(buffer *bufio.Reader) ([]byte, bool, error) {
+	for {
+		data, err := buffer.ReadSlice('\n')
+		body.line = append(body.line, data...)
+		if len(body.line) > httpMaxChunkLineLength {
+			return nil, false, errInvalidChunkedBody
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			// the rest of the line is in the next segment
+			return nil, false, nil
+		}
+		break
+	}
+	line := bytes.TrimSuffix(bytes.TrimSuffix(body.line, []byte("\n")), []byte("\r"))
+	body.line = body.line[:0]
+	return line, true, nil
+}
@@ -0,0 +1,250 @@
+package assemblers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+// httpBodyCaptureOptions decides which HTTP bodies are captured and how much of each is kept
+type httpBodyCaptureOptions struct {
+	requests     bool
+	responses    bool
+	maxBytes     int
+	maxMemory    int64
+	contentTypes []string
+	statusCodes  []string
+}
+
+// newHttpBodyCaptureOptions returns the body capture options from the config, or nil if bodies aren't captured
+func newHttpBodyCaptureOptions(config config.Config) *httpBodyCaptureOptions {
+	options := &httpBodyCaptureOptions{
+		requests:     slices.Contains(config.HTTPBodyCapture, "request"),
+		responses:    slices.Contains(config.HTTPBodyCapture, "response"),
+		maxBytes:     config.HTTPBodyMaxBytes,
+		maxMemory:    int64(config.HTTPBodyMaxMemory),
+		contentTypes: config.HTTPBodyContentTypes,
+		statusCodes:  config.HTTPBodyStatusCodes,
+	}
+	if !options.requests && !options.responses || options.maxBytes <= 0 || options.maxMemory <= 0 {
+		return nil
+	}
+	return options
+}
+
+// newRequestCapture returns a capture for the body of a request with the given headers,
+// or nil if it shouldn't be captured.
+// The response status isn't known yet, so request bodies are dropped later if it doesn't match.
+func (options *httpBodyCaptureOptions) newRequestCapture(header http.Header) *httpBodyCapture {
+	if options == nil || !options.requests {
+		return nil
+	}
+	return options.newCapture(header)
+}
+
+// newResponseCapture returns a capture for the body of a response with the given status and headers,
+// or nil if it shouldn't be captured
+func (options *httpBodyCaptureOptions) newResponseCapture(statusCode int, header http.Header) *httpBodyCapture {
+	if options == nil || !options.responses || !options.matchesStatus(statusCode) {
+		return nil
+	}
+	return options.newCapture(header)
+}
+
+func (options *httpBodyCaptureOptions) newCapture(header http.Header) *httpBodyCapture {
+	if !options.matchesContentType(header.Get("Content-Type")) {
+		return nil
+	}
+	var compressed bool
+	switch strings.ToLower(header.Get("Content-Encoding")) {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		compressed = true
+	default:
+		// we can't decode the body, so there's nothing readable to capture
+		return nil
+	}
+	return &httpBodyCapture{
+		maxBytes:   options.maxBytes,
+		maxMemory:  options.maxMemory,
+		compressed: compressed,
+	}
+}
+
+// matchesContentType returns true if the media type of the Content-Type header is in the allowlist,
+// which can include wildcards for all subtypes, eg "text/*"
+func (options *httpBodyCaptureOptions) matchesContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range options.contentTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mediaType || allowed == "*/*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasSuffix(prefix, "/") && strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesStatus returns true if the response status code matches one of the configured status codes,
+// which can be single codes ("404"), ranges ("500-599") or classes ("5xx"). All status codes match if none are configured.
+func (options *httpBodyCaptureOptions) matchesStatus(statusCode int) bool {
+	if len(options.statusCodes) == 0 {
+		return true
+	}
+	for _, spec := range options.statusCodes {
+		spec = strings.ToLower(strings.TrimSpace(spec))
+		if class, ok := strings.CutSuffix(spec, "xx"); ok {
+			if digit, err := strconv.Atoi(class); err == nil && len(class) == 1 && statusCode/100 == digit {
+				return true
+			}
+		} else if low, high, ok := strings.Cut(spec, "-"); ok {
+			lowCode, lowErr := strconv.Atoi(low)
+			highCode, highErr := strconv.Atoi(high)
+			if lowErr == nil && highErr == nil && statusCode >= lowCode && statusCode <= highCode {
+				return true
+			}
+		} else if code, err := strconv.Atoi(spec); err == nil && statusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// httpBodyCapture keeps the first bytes of a request or response body as it's read.
+//
+// The memory used by captured bodies is shared by all streams, and is reserved as bytes are captured
+// and released once the body's event has been handled. A body that doesn't fit is truncated.
+type httpBodyCapture struct {
+	maxBytes  int
+	maxMemory int64
+	// the body has a gzip content encoding, and is decompressed once it has been read
+	compressed bool
+	data       []byte
+	// set once bytes of the body have been skipped, after which nothing more is captured
+	truncated bool
+	// the number of bytes of the shared memory held by the capture
+	reserved int64
+}
+
+// read copies up to n bytes of the body from the buffer while there's room for them, returning how many were copied
+func (capture *httpBodyCapture) read(buffer *bufio.Reader, n int64) int64 {
+	if capture == nil || capture.truncated {
+		return 0
+	}
+	want := min(n, int64(capture.maxBytes-len(capture.data)))
+	if want <= 0 {
+		return 0
+	}
+	granted := capture.reserve(want)
+	if granted < want {
+		capture.truncated = true
+		stats.http_body_capture_memory_exhausted.Add(1)
+	}
+	start := len(capture.data)
+	capture.data = append(capture.data, make([]byte, granted)...)
+	read, _ := io.ReadFull(buffer, capture.data[start:])
+	capture.data = capture.data[:start+read]
+	// give back what the buffer didn't hold, the rest of the body is in later segments
+	capture.release(granted - int64(read))
+	return int64(read)
+}
+
+// skipped records that bytes of the body were skipped because there was no room for them
+func (capture *httpBodyCapture) skipped() {
+	if capture != nil {
+		capture.truncated = true
+	}
+}
+
+// finish decompresses the captured body, keeping at most maxBytes, once the whole body has been read
+func (capture *httpBodyCapture) finish() {
+	if capture == nil || !capture.compressed {
+		return
+	}
+	capture.compressed = false
+	reader, err := gzip.NewReader(bytes.NewReader(capture.data))
+	if err != nil {
+		capture.data = nil
+		capture.release(capture.reserved)
+		return
+	}
+	// a truncated body decompresses as far as it goes, so keep what we got before the error
+	decoded, err := io.ReadAll(io.LimitReader(reader, int64(capture.maxBytes)+1))
+	if err != nil && len(decoded) == 0 {
+		capture.data = nil
+		capture.release(capture.reserved)
+		return
+	}
+	if len(decoded) > capture.maxBytes {
+		decoded = decoded[:capture.maxBytes]
+		capture.truncated = true
+	}
+	if extra := int64(len(decoded)) - capture.reserved; extra > 0 {
+		if granted := capture.reserve(extra); granted < extra {
+			decoded = decoded[:capture.reserved]
+			capture.truncated = true
+			stats.http_body_capture_memory_exhausted.Add(1)
+		}
+	} else {
+		capture.release(-extra)
+	}
+	capture.data = decoded
+}
+
+// reserve reserves up to n bytes of the memory shared by all captured bodies, returning how many were reserved
+func (capture *httpBodyCapture) reserve(n int64) int64 {
+	for {
+		used := stats.http_body_capture_bytes.Load()
+		granted := min(n, capture.maxMemory-used)
+		if granted <= 0 {
+			return 0
+		}
+		if stats.http_body_capture_bytes.CompareAndSwap(used, used+granted) {
+			capture.reserved += granted
+			return granted
+		}
+	}
+}
+
+// release gives back n bytes of the capture's reserved memory
+func (capture *httpBodyCapture) release(n int64) {
+	if capture == nil || n <= 0 {
+		return
+	}
+	n = min(n, capture.reserved)
+	capture.reserved -= n
+	stats.http_body_capture_bytes.Add(-n)
+}
+
+// releaseAll gives back all of the capture's reserved memory
+func (capture *httpBodyCapture) releaseAll() {
+	if capture != nil {
+		capture.release(capture.reserved)
+	}
+}
+
+// body returns the captured body, or "" if nothing was captured
+func (capture *httpBodyCapture) body() string {
+	if capture == nil {
+		return ""
+	}
+	return string(capture.data)
+}
+
+// isTruncated returns true if only the start of the body was captured
+func (capture *httpBodyCapture) isTruncated() bool {
+	return capture != nil && capture.truncated
+}
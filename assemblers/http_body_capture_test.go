@@ -0,0 +1,163 @@
+package assemblers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+func newHttpBodyCaptureTestStream(maxBytes int, maxMemory int, statusCodes []string) *tcpStream {
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	transportFlow := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0xd4, 0x31}, []byte{0x1f, 0x90}) // 54321 -> 8080
+	return NewTcpStream(netFlow, transportFlow, config.Config{
+		HTTPPorts:             []string{"8080"},
+		HTTPMatcherTTL:        time.Minute,
+		HTTPMatcherMaxEntries: 1000,
+		HTTPBodyCapture:       []string{"request", "response"},
+		HTTPBodyMaxBytes:      maxBytes,
+		HTTPBodyContentTypes:  []string{"application/json", "text/*"},
+		HTTPBodyStatusCodes:   statusCodes,
+		HTTPBodyMaxMemory:     maxMemory,
+	}, make(chan Event, 10))
+}
+
+func gzipBody(t *testing.T, body string) []byte {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return compressed.Bytes()
+}
+
+func TestHttpParserCapturesBodies(t *testing.T) {
+	stream := newHttpBodyCaptureTestStream(1024, 1<<20, []string{"5xx"})
+	start := time.Now()
+	usedBefore := stats.http_body_capture_bytes.Load()
+
+	// a JSON request split across segments, answered with a gzipped, chunked server error
+	stream.parse([]byte("POST /orders HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/json\r\nContent-Length: 12\r\n\r\n{\"id\":"), 1, start, true, 1)
+	stream.parse([]byte("\"abc\"}"), 2, start.Add(time.Millisecond), true, 1)
+	assert.Equal(t, usedBefore+12, stats.http_body_capture_bytes.Load(), "bodies waiting to be matched hold memory")
+	compressed := gzipBody(t, `{"error":"out of stock"}`)
+	stream.parse([]byte(fmt.Sprintf("HTTP/1.1 500 Internal Server Error\r\nContent-Type: application/json; charset=utf-8\r\nContent-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n\r\n%x\r\n", len(compressed))), 3, start.Add(2*time.Millisecond), false, 1)
+	stream.parse(append(compressed, "\r\n0\r\n\r\n"...), 4, start.Add(3*time.Millisecond), false, 1)
+
+	// the request body is dropped once the response status doesn't match
+	stream.parse([]byte("POST /orders HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/json\r\nContent-Length: 2\r\n\r\n{}"), 5, start.Add(4*time.Millisecond), true, 1)
+	stream.parse([]byte("HTTP/1.1 201 Created\r\nContent-Type: application/json\r\nContent-Length: 2\r\n\r\n{}"), 6, start.Add(5*time.Millisecond), false, 1)
+
+	// bodies with other content types aren't captured
+	stream.parse([]byte("GET /status HTTP/1.1\r\nHost: example.com\r\n\r\n"), 7, start.Add(6*time.Millisecond), true, 1)
+	stream.parse([]byte("HTTP/1.1 503 Service Unavailable\r\nContent-Type: image/png\r\nContent-Length: 4\r\n\r\n\x89PNG"), 8, start.Add(7*time.Millisecond), false, 1)
+	require.Len(t, stream.eventsChan, 3)
+	assert.Equal(t, usedBefore+12+24, stats.http_body_capture_bytes.Load(), "events waiting to be handled hold memory")
+
+	event := (<-stream.eventsChan).(*HttpEvent)
+	event.Release()
+	assert.Equal(t, `{"id":"abc"}`, event.RequestBody())
+	assert.False(t, event.RequestBodyTruncated())
+	assert.Equal(t, `{"error":"out of stock"}`, event.ResponseBody())
+	assert.False(t, event.ResponseBodyTruncated())
+	assert.Equal(t, int64(len(compressed)), event.Response().ContentLength)
+
+	event = (<-stream.eventsChan).(*HttpEvent)
+	event.Release()
+	assert.Equal(t, "", event.RequestBody())
+	assert.Equal(t, "", event.ResponseBody())
+
+	event = (<-stream.eventsChan).(*HttpEvent)
+	event.Release()
+	assert.Equal(t, "", event.ResponseBody())
+
+	assert.Equal(t, usedBefore, stats.http_body_capture_bytes.Load(), "handled events release their memory")
+}
+
+func TestHttpParserTruncatesCapturedBodies(t *testing.T) {
+	testCases := []struct {
+		name              string
+		maxBytes          int
+		maxMemory         int
+		expectedBody      string
+		expectedExhausted uint64
+	}{
+		{
+			name:         "max bytes",
+			maxBytes:     5,
+			maxMemory:    1 << 20,
+			expectedBody: "hello",
+		},
+		{
+			name:              "max memory",
+			maxBytes:          1024,
+			maxMemory:         3,
+			expectedBody:      "hel",
+			expectedExhausted: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stream := newHttpBodyCaptureTestStream(tc.maxBytes, tc.maxMemory, nil)
+			start := time.Now()
+			exhaustedBefore := stats.http_body_capture_memory_exhausted.Load()
+
+			stream.parse([]byte("GET /greeting HTTP/1.1\r\nHost: example.com\r\n\r\n"), 1, start, true, 1)
+			stream.parse([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 11\r\n\r\nhello"), 2, start.Add(time.Millisecond), false, 1)
+			stream.parse([]byte(" world"), 3, start.Add(2*time.Millisecond), false, 1)
+			require.Len(t, stream.eventsChan, 1)
+
+			event := (<-stream.eventsChan).(*HttpEvent)
+			event.Release()
+			assert.Equal(t, tc.expectedBody, event.ResponseBody())
+			assert.True(t, event.ResponseBodyTruncated())
+			assert.Equal(t, int64(11), event.Response().ContentLength)
+			assert.Equal(t, tc.expectedExhausted, stats.http_body_capture_memory_exhausted.Load()-exhaustedBefore)
+		})
+	}
+}
+
+func TestHttpEventReleasesBodiesOnceEveryHandlerHasReleasedIt(t *testing.T) {
+	stream := newHttpBodyCaptureTestStream(1024, 1<<20, nil)
+	start := time.Now()
+	usedBefore := stats.http_body_capture_bytes.Load()
+
+	stream.parse([]byte("GET /greeting HTTP/1.1\r\nHost: example.com\r\n\r\n"), 1, start, true, 1)
+	stream.parse([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nhello"), 2, start.Add(time.Millisecond), false, 1)
+	require.Len(t, stream.eventsChan, 1)
+
+	// the event is sent to two handlers
+	event := (<-stream.eventsChan).(*HttpEvent)
+	event.Retain(1)
+	event.Release()
+	assert.Equal(t, usedBefore+5, stats.http_body_capture_bytes.Load())
+	event.Release()
+	assert.Equal(t, usedBefore, stats.http_body_capture_bytes.Load())
+	assert.Equal(t, "hello", event.ResponseBody())
+}
+
+func TestHttpBodyCaptureMatchesStatusCodes(t *testing.T) {
+	options := &httpBodyCaptureOptions{statusCodes: []string{"5xx", "400-403", " 429 ", "bogus", "4-"}}
+	for statusCode, expected := range map[int]bool{
+		200: false,
+		400: true,
+		403: true,
+		404: false,
+		429: true,
+		500: true,
+		503: true,
+	} {
+		assert.Equal(t, expected, options.matchesStatus(statusCode), statusCode)
+	}
+
+	// all status codes match when none are configured
+	assert.True(t, (&httpBodyCaptureOptions{}).matchesStatus(200))
+}
@@ -0,0 +1,83 @@
+package assemblers
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpBodyReadsAcrossSegments(t *testing.T) {
+	testCases := []struct {
+		name             string
+		contentLength    int64
+		transferEncoding []string
+		segments         []string
+		expectedSize     int64
+		expectedRest     string
+	}{
+		{
+			name:          "content length",
+			contentLength: 10,
+			segments:      []string{"01234", "56789GET /"},
+			expectedSize:  10,
+			expectedRest:  "GET /",
+		},
+		{
+			name:             "chunked",
+			transferEncoding: []string{"chunked"},
+			segments:         []string{"5\r\nhello\r\n6;ext=1\r\n world\r\n0\r\n\r\nGET /"},
+			expectedSize:     11,
+			expectedRest:     "GET /",
+		},
+		{
+			name:             "chunked split inside size lines, data and trailers",
+			transferEncoding: []string{"chunked"},
+			segments:         []string{"5\r", "\nhel", "lo\r", "\n1", "0\r\n0123456789abcdef\r\n0\r\nTrailer: ", "value\r\n", "\r\n"},
+			expectedSize:     21,
+		},
+		{
+			name:          "until close",
+			contentLength: -1,
+			segments:      []string{"some data", " and some more"},
+			expectedSize:  23,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := newHttpBody(tc.contentLength, tc.transferEncoding, io.NopCloser(nil))
+			buffer := bufio.NewReader(nil)
+			var complete bool
+			for _, segment := range tc.segments {
+				assert.False(t, complete, "body completed before the last segment")
+				buffer.Reset(bytes.NewReader([]byte(segment)))
+				var err error
+				complete, err = body.read(buffer)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.contentLength >= 0, complete)
+			assert.Equal(t, tc.expectedSize, body.size)
+			rest, _ := io.ReadAll(buffer)
+			assert.Equal(t, tc.expectedRest, string(rest))
+		})
+	}
+}
+
+func TestHttpBodyWithoutContent(t *testing.T) {
+	body := newHttpBody(10, nil, http.NoBody)
+	complete, err := body.read(bufio.NewReader(bytes.NewReader([]byte("HTTP/1.1 200 OK\r\n"))))
+	require.NoError(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, int64(0), body.size)
+}
+
+func TestHttpBodyInvalidChunkSize(t *testing.T) {
+	body := newHttpBody(-1, []string{"chunked"}, io.NopCloser(nil))
+	_, err := body.read(bufio.NewReader(bytes.NewReader([]byte("zz\r\nhello\r\n"))))
+	assert.ErrorIs(t, err, errInvalidChunkedBody)
+}
@@ -2,7 +2,7 @@ package assemblers
 
 import (
 	"net/http"
-	"time"
+	"sync/atomic"
 )
 
 // HttpEvent represents a HTTP request/response pair
@@ -10,35 +10,54 @@ type HttpEvent struct {
 	eventBase
 	request  *http.Request
 	response *http.Response
+	// the Content-Encoding of the request and response bodies, eg "gzip"
+	requestContentEncoding  string
+	responseContentEncoding string
+	// the TCP sequence numbers the request and response started at
+	requestSeqAck  int64
+	responseSeqAck int64
+	// why the event is missing its request or response, empty for matched events
+	unmatchedReason string
+	// the start of the request and response bodies, only set when body capture is enabled
+	requestBody  *httpBodyCapture
+	responseBody *httpBodyCapture
+	// the number of handlers holding the event besides the first, the last to release it gives back the bodies' memory
+	retained atomic.Int32
 }
 
-// Make sure HttpEvent implements Event interface
+// Make sure HttpEvent implements Event and Releaser interfaces
 var _ Event = (*HttpEvent)(nil)
+var _ Releaser = (*HttpEvent)(nil)
 
+// HttpMessageInfo describes how a HTTP request or response was captured
+type HttpMessageInfo struct {
+	MessageInfo
+	// ContentEncoding is the Content-Encoding of the message's body, eg "gzip", or "" if it isn't encoded
+	ContentEncoding string
+	// SeqAck is the server's TCP sequence number when a HTTP/1.x message started:
+	// the one a request acknowledges, or the sequence number of a response's first byte
+	SeqAck int64
+}
+
+// NewHttpEvent returns a HttpEvent for a request and response captured on the stream.
+// Either can be nil for an unmatched request or response, with empty info.
 func NewHttpEvent(
 	streamIdent string,
 	requestId int64,
-	requestTimestamp time.Time,
-	responseTimestamp time.Time,
-	requestPacketCount int,
-	responsePacketCount int,
 	srcIp string,
 	dstIp string,
 	request *http.Request,
-	response *http.Response) *HttpEvent {
+	requestInfo HttpMessageInfo,
+	response *http.Response,
+	responseInfo HttpMessageInfo) *HttpEvent {
 	return &HttpEvent{
-		eventBase: eventBase{
-			streamIdent:         streamIdent,
-			requestId:           requestId,
-			requestTimestamp:    requestTimestamp,
-			responseTimestamp:   responseTimestamp,
-			requestPacketCount:  requestPacketCount,
-			responsePacketCount: responsePacketCount,
-			srcIp:               srcIp,
-			dstIp:               dstIp,
-		},
-		request:  request,
-		response: response,
+		eventBase:               newEventBase(streamIdent, requestId, srcIp, dstIp, requestInfo.MessageInfo, responseInfo.MessageInfo),
+		request:                 request,
+		response:                response,
+		requestContentEncoding:  requestInfo.ContentEncoding,
+		responseContentEncoding: responseInfo.ContentEncoding,
+		requestSeqAck:           requestInfo.SeqAck,
+		responseSeqAck:          responseInfo.SeqAck,
 	}
 }
 
@@ -51,3 +70,67 @@ func (event *HttpEvent) Request() *http.Request {
 func (event *HttpEvent) Response() *http.Response {
 	return event.response
 }
+
+// RequestContentEncoding returns the content encoding of the request body, eg "gzip", or "" if it isn't encoded
+func (event *HttpEvent) RequestContentEncoding() string {
+	return event.requestContentEncoding
+}
+
+// ResponseContentEncoding returns the content encoding of the response body, eg "gzip", or "" if it isn't encoded
+func (event *HttpEvent) ResponseContentEncoding() string {
+	return event.responseContentEncoding
+}
+
+// SeqAck returns the server's TCP sequence number when the request started, or when the response started
+// for a response without a request. HTTP/2 messages are multiplexed over the connection, so they don't have one.
+func (event *HttpEvent) SeqAck() (int64, bool) {
+	switch {
+	case event.request != nil:
+		return event.requestSeqAck, event.request.ProtoMajor < 2
+	case event.response != nil:
+		return event.responseSeqAck, event.response.ProtoMajor < 2
+	}
+	return 0, false
+}
+
+// UnmatchedReason returns why the event was sent without its request or response,
+// eg "timeout" for a request that never got a response, or "" if the request and response were matched
+func (event *HttpEvent) UnmatchedReason() string {
+	return event.unmatchedReason
+}
+
+// RequestBody returns the start of the request body, decompressed if it was gzipped,
+// or "" if it wasn't captured
+func (event *HttpEvent) RequestBody() string {
+	return event.requestBody.body()
+}
+
+// RequestBodyTruncated returns true if only the start of the request body was captured
+func (event *HttpEvent) RequestBodyTruncated() bool {
+	return event.requestBody.isTruncated()
+}
+
+// ResponseBody returns the start of the response body, decompressed if it was gzipped,
+// or "" if it wasn't captured
+func (event *HttpEvent) ResponseBody() string {
+	return event.responseBody.body()
+}
+
+// ResponseBodyTruncated returns true if only the start of the response body was captured
+func (event *HttpEvent) ResponseBodyTruncated() bool {
+	return event.responseBody.isTruncated()
+}
+
+// Retain adds n more handlers that must release the event before its captured bodies' memory is given back
+func (event *HttpEvent) Retain(n int) {
+	event.retained.Add(int32(n))
+}
+
+// Release gives back the memory held by the event's captured bodies once every handler has released it
+func (event *HttpEvent) Release() {
+	if event.retained.Add(-1) != -1 {
+		return
+	}
+	event.requestBody.releaseAll()
+	event.responseBody.releaseAll()
+}
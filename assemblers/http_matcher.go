@@ -2,83 +2,197 @@ package assemblers
 
 import (
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 )
 
+// Reasons a request or response is sent without its counterpart
+const (
+	// the counterpart wasn't seen within the matcher's TTL, eg the server never responded
+	unmatchedTimeout = "timeout"
+	// the matcher was holding too many entries, so the oldest were evicted
+	unmatchedMaxEntries = "max_entries"
+	// the connection closed before the counterpart was seen
+	unmatchedStreamClosed = "stream_closed"
+	// the counterpart was sent before the capture started, or its packets were missed
+	unmatchedNotCaptured = "not_captured"
+	// the HTTP/2 stream was reset before the counterpart was sent
+	unmatchedStreamReset = "stream_reset"
+)
+
 type httpMatcher struct {
 	messages map[int64]*entry
 	mtx      *sync.Mutex
+	// how long an entry waits for its counterpart before it's evicted
+	ttl time.Duration
+	// the most entries held at once, older entries are evicted to make room
+	maxEntries int
 }
 
 type entry struct {
-	request             *http.Request
-	requestTimestamp    time.Time
-	response            *http.Response
-	responseTimestamp   time.Time
-	requestPacketCount  int
-	responsePacketCount int
+	requestId int64
+	// the request and response, nil until they've been seen
+	request  *httpMessage
+	response *httpMessage
 }
 
-func newRequestResponseMatcher() *httpMatcher {
+func newRequestResponseMatcher(ttl time.Duration, maxEntries int) *httpMatcher {
 	return &httpMatcher{
-		messages: make(map[int64]*entry),
-		mtx:      &sync.Mutex{},
+		messages:   make(map[int64]*entry),
+		mtx:        &sync.Mutex{},
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+// storedAt returns the timestamp of the request or response the entry was stored with
+func (e *entry) storedAt() time.Time {
+	if e.request != nil {
+		return e.request.info.Timestamp
 	}
+	return e.response.info.Timestamp
 }
 
-// GetOrStoreRequest receives a tcpStream ident, a timestamp, a request, and a packet count.
+// GetOrStoreRequest receives a key and a request whose whole body has been read.
 //
 // If the response that matches the stream ident has been seen before,
 // returns a match entry containing both Request and Response and matchFound will be true.
 //
 // If the response hasn't been seen yet,
 // stores the Request for later lookup and returns match as nil and matchFound will be false.
-func (m *httpMatcher) GetOrStoreRequest(key int64, timestamp time.Time, request *http.Request, packetCount int) (match *entry, matchFound bool) {
+func (m *httpMatcher) GetOrStoreRequest(key int64, request *httpMessage) (match *entry, matchFound bool) {
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
 
 	if match, matchFound = m.messages[key]; matchFound {
 		match.request = request
-		match.requestTimestamp = timestamp
-		match.requestPacketCount = packetCount
 		delete(m.messages, key)
 		return match, matchFound
 	}
 
 	m.messages[key] = &entry{
-		request:            request,
-		requestTimestamp:   timestamp,
-		requestPacketCount: packetCount,
+		requestId: key,
+		request:   request,
 	}
 
 	return nil, false
 }
 
-// GetOrStoreResponse receives a tcpStream ident, a timestamp, a response, and a packet count.
+// GetOrStoreResponse receives a key and a response whose whole body has been read.
 //
 // If the request that matches the stream ident has been seen before,
 // returns a match entry containing both Request and Response and matchFound will be true.
 //
 // If the request hasn't been seen yet,
 // stores the Response for later lookup and returns match as nil and matchFound will be false.
-func (m *httpMatcher) GetOrStoreResponse(key int64, timestamp time.Time, response *http.Response, packetCount int) (match *entry, matchFound bool) {
+func (m *httpMatcher) GetOrStoreResponse(key int64, response *httpMessage) (match *entry, matchFound bool) {
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
 
 	if match, matchFound = m.messages[key]; matchFound {
 		match.response = response
-		match.responseTimestamp = timestamp
-		match.responsePacketCount = packetCount
 		delete(m.messages, key)
 		return match, matchFound
 	}
 
 	m.messages[key] = &entry{
-		response:            response,
-		responseTimestamp:   timestamp,
-		responsePacketCount: packetCount,
+		requestId: key,
+		response:  response,
 	}
 
 	return nil, false
 }
+
+// GetRequest returns the stored request for the key without removing it, or nil if it hasn't been seen.
+func (m *httpMatcher) GetRequest(key int64) *http.Request {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if e, ok := m.messages[key]; ok && e.request != nil {
+		return e.request.request
+	}
+	return nil
+}
+
+// Get returns the entry stored for the key without removing it, or nil if there isn't one.
+func (m *httpMatcher) Get(key int64) *entry {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return m.messages[key]
+}
+
+// Evict removes and returns the entry stored for the key, or nil if there isn't one.
+func (m *httpMatcher) Evict(key int64) *entry {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	e := m.messages[key]
+	delete(m.messages, key)
+	return e
+}
+
+// EvictExpired removes and returns the entries stored more than the TTL before now,
+// which aren't going to see their counterpart.
+func (m *httpMatcher) EvictExpired(now time.Time) []*entry {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	var evicted []*entry
+	for key, e := range m.messages {
+		if now.Sub(e.storedAt()) > m.ttl {
+			evicted = append(evicted, e)
+			delete(m.messages, key)
+		}
+	}
+	stats.http_unmatched_timeout.Add(uint64(len(evicted)))
+	return sortEntries(evicted)
+}
+
+// EvictOverflow removes and returns the oldest entries while there are more than the max entries.
+func (m *httpMatcher) EvictOverflow() []*entry {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	overflow := len(m.messages) - m.maxEntries
+	if m.maxEntries <= 0 || overflow <= 0 {
+		return nil
+	}
+	keys := make([]int64, 0, len(m.messages))
+	for key := range m.messages {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b int64) int {
+		return m.messages[a].storedAt().Compare(m.messages[b].storedAt())
+	})
+	evicted := make([]*entry, 0, overflow)
+	for _, key := range keys[:overflow] {
+		evicted = append(evicted, m.messages[key])
+		delete(m.messages, key)
+	}
+	stats.http_unmatched_max_entries.Add(uint64(len(evicted)))
+	return evicted
+}
+
+// EvictAll removes and returns all entries, used when the stream closes.
+func (m *httpMatcher) EvictAll() []*entry {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	evicted := make([]*entry, 0, len(m.messages))
+	for key, e := range m.messages {
+		evicted = append(evicted, e)
+		delete(m.messages, key)
+	}
+	stats.http_unmatched_stream_closed.Add(uint64(len(evicted)))
+	return sortEntries(evicted)
+}
+
+// sortEntries sorts entries by the time they were stored, so evicted entries are sent in order
+func sortEntries(entries []*entry) []*entry {
+	slices.SortFunc(entries, func(a, b *entry) int {
+		return a.storedAt().Compare(b.storedAt())
+	})
+	return entries
+}
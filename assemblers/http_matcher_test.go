@@ -6,78 +6,121 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func newTestRequestMessage(request *http.Request, timestamp time.Time, packetCount int) *httpMessage {
+	return &httpMessage{request: request, info: HttpMessageInfo{MessageInfo: MessageInfo{Timestamp: timestamp, PacketCount: packetCount}}}
+}
+
+func newTestResponseMessage(response *http.Response, timestamp time.Time, packetCount int) *httpMessage {
+	return &httpMessage{response: response, info: HttpMessageInfo{MessageInfo: MessageInfo{Timestamp: timestamp, PacketCount: packetCount}}}
+}
+
 func Test_HttpMatcher_StoringARequest(t *testing.T) {
-	matcher := newRequestResponseMatcher()
+	matcher := newRequestResponseMatcher(time.Minute, 1000)
 
 	requestId := int64(12345)
 	reqTimestamp := time.Now()
 	req := &http.Request{}
 
-	entry, found := matcher.GetOrStoreRequest(requestId, reqTimestamp, req, 0)
+	entry, found := matcher.GetOrStoreRequest(requestId, newTestRequestMessage(req, reqTimestamp, 0))
 
 	assert.False(t, found, "Expect no entry when first storing a request with no response seen yet.")
 	assert.Nil(t, entry)
 }
 
 func Test_HttpMatcher_StoringAResponse(t *testing.T) {
-	matcher := newRequestResponseMatcher()
+	matcher := newRequestResponseMatcher(time.Minute, 1000)
 
 	requestId := int64(12345)
 	resTimestamp := time.Now()
 	res := &http.Response{}
 
-	entry, found := matcher.GetOrStoreResponse(requestId, resTimestamp, res, 0)
+	entry, found := matcher.GetOrStoreResponse(requestId, newTestResponseMessage(res, resTimestamp, 0))
 
 	assert.False(t, found, "Expect no entry found when first storing a response with no request seen yet.")
 	assert.Nil(t, entry)
 }
 
 func Test_HttpMatcher_GetResponseThatMatchesRequest(t *testing.T) {
-	matcher := newRequestResponseMatcher()
+	matcher := newRequestResponseMatcher(time.Minute, 1000)
 	requestId := int64(12345)
 	unmatchRequestId := int64(54321)
 
 	// store a response that won't match
-	_, found := matcher.GetOrStoreResponse(unmatchRequestId, time.Now(), &http.Response{}, 0)
+	_, found := matcher.GetOrStoreResponse(unmatchRequestId, newTestResponseMessage(&http.Response{}, time.Now(), 0))
 	assert.False(t, found, "Expect no matching request when storing a matchless response.")
 
 	// store the response that will match
 	resp := &http.Response{}
 	respPacketCount := 2
-	_, found = matcher.GetOrStoreResponse(requestId, time.Now(), resp, respPacketCount)
+	_, found = matcher.GetOrStoreResponse(requestId, newTestResponseMessage(resp, time.Now(), respPacketCount))
 	assert.False(t, found, "Expect no matching request when storing a response first.")
 
 	// get the response that matches a request's ident
 	req := &http.Request{}
-	foundEntry, found := matcher.GetOrStoreRequest(requestId, time.Now(), req, 0)
+	foundEntry, found := matcher.GetOrStoreRequest(requestId, newTestRequestMessage(req, time.Now(), 0))
 	assert.True(t, found, "Expect the matching response was found.")
-	assert.Equal(t, resp, foundEntry.response)
-	assert.Equal(t, req, foundEntry.request)
-	assert.Equal(t, foundEntry.responsePacketCount, 2)
+	assert.Equal(t, resp, foundEntry.response.response)
+	assert.Equal(t, req, foundEntry.request.request)
+	assert.Equal(t, 2, foundEntry.response.info.PacketCount)
 }
 
 func Test_HttpMatcher_GetRequestThatMatchesResponse(t *testing.T) {
-	matcher := newRequestResponseMatcher()
+	matcher := newRequestResponseMatcher(time.Minute, 1000)
 	requestId := int64(12345)
 	unmatchRequestId := int64(54321)
 
 	// store a request that won't match
-	_, found := matcher.GetOrStoreRequest(unmatchRequestId, time.Now(), &http.Request{}, 0)
+	_, found := matcher.GetOrStoreRequest(unmatchRequestId, newTestRequestMessage(&http.Request{}, time.Now(), 0))
 	assert.False(t, found, "Expect no matching response when storing a matchless request.")
 
 	// store the request that will match
 	req := &http.Request{}
 	reqPacketCount := 2
-	_, found = matcher.GetOrStoreRequest(requestId, time.Now(), req, reqPacketCount)
+	_, found = matcher.GetOrStoreRequest(requestId, newTestRequestMessage(req, time.Now(), reqPacketCount))
 	assert.False(t, found, "Expect no matching response when storing a request first.")
 
 	// get the request that matches a response's ident
 	resp := &http.Response{}
-	foundEntry, found := matcher.GetOrStoreResponse(requestId, time.Now(), resp, 0)
+	foundEntry, found := matcher.GetOrStoreResponse(requestId, newTestResponseMessage(resp, time.Now(), 0))
 	assert.True(t, found, "Expect the matching request was found.")
-	assert.Equal(t, req, foundEntry.request)
-	assert.Equal(t, resp, foundEntry.response)
-	assert.Equal(t, foundEntry.requestPacketCount, 2)
+	assert.Equal(t, req, foundEntry.request.request)
+	assert.Equal(t, resp, foundEntry.response.response)
+	assert.Equal(t, 2, foundEntry.request.info.PacketCount)
+}
+
+func Test_HttpMatcher_EvictExpired(t *testing.T) {
+	matcher := newRequestResponseMatcher(time.Second, 1000)
+	now := time.Now()
+
+	matcher.GetOrStoreRequest(1, newTestRequestMessage(&http.Request{}, now.Add(-2*time.Second), 1))
+	matcher.GetOrStoreResponse(2, newTestResponseMessage(&http.Response{}, now.Add(-3*time.Second), 1))
+	matcher.GetOrStoreRequest(3, newTestRequestMessage(&http.Request{}, now, 1))
+
+	evicted := matcher.EvictExpired(now)
+	require.Len(t, evicted, 2)
+	// oldest first
+	assert.Equal(t, int64(2), evicted[0].requestId)
+	assert.Equal(t, int64(1), evicted[1].requestId)
+	assert.Len(t, matcher.messages, 1)
+}
+
+func Test_HttpMatcher_EvictOverflow(t *testing.T) {
+	matcher := newRequestResponseMatcher(time.Minute, 2)
+	now := time.Now()
+
+	matcher.GetOrStoreRequest(1, newTestRequestMessage(&http.Request{}, now.Add(time.Millisecond), 1))
+	matcher.GetOrStoreRequest(2, newTestRequestMessage(&http.Request{}, now, 1))
+	assert.Empty(t, matcher.EvictOverflow())
+
+	matcher.GetOrStoreRequest(3, newTestRequestMessage(&http.Request{}, now.Add(2*time.Millisecond), 1))
+	evicted := matcher.EvictOverflow()
+	require.Len(t, evicted, 1)
+	assert.Equal(t, int64(2), evicted[0].requestId)
+	assert.Len(t, matcher.messages, 2)
+
+	assert.Len(t, matcher.EvictAll(), 2)
+	assert.Empty(t, matcher.messages)
 }
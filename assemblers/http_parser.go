@@ -2,96 +2,442 @@ package assemblers
 
 import (
 	"bufio"
+	"bytes"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/gopacket/gopacket/reassembly"
 )
 
-// httpParser parses HTTP requests and responses
+// httpParser parses HTTP/1.x requests and responses.
+//
+// Servers respond to requests on a connection in the order they were received, including
+// pipelined requests, so the nth response on a connection is matched to the nth request.
+// Requests and responses are stored in the matcher once their whole body has been read,
+// which can take any number of reassembled segments.
+// That's only possible on connections where every packet is captured (see HTTP_PORTS): elsewhere the BPF filter
+// only captures packets that start with a request or response, so bodies end with the first packet,
+// and their size is only known from their Content-Length.
+//
+// Counting alone can't tell when the capture starts part way through a connection or misses a message,
+// so requests and responses are also checked against where they start in the server's sequence numbers:
+// a response always starts at or after the sequence number its request acknowledges.
 type httpParser struct {
 	matcher          *httpMatcher
 	headersToExtract []string
+	// used to continue parsing the connection as HTTP/2 after an "Upgrade: h2c" request is accepted
+	http2 *http2Parser
+	// decides which bodies are captured, nil when body capture is disabled
+	bodyCapture *httpBodyCaptureOptions
+	// the number of requests and final (non-informational) responses seen, used as the matcher key
+	requestCount  int64
+	responseCount int64
+	// the last request or response, while the rest of its body arrives in later segments
+	pendingRequest  *httpMessage
+	pendingResponse *httpMessage
+	// the start of a request or response whose headers continue in the next segment
+	partialRequest  *httpPartialHeader
+	partialResponse *httpPartialHeader
+	// the server's sequence number after the last response, set once a response has been read
+	responseEnd      int64
+	responseEndKnown bool
 }
 
-func newHttpParser(headersToExtract []string) *httpParser {
+// httpMessage is a request or response whose body is being read
+type httpMessage struct {
+	request  *http.Request
+	response *http.Response
+	info     HttpMessageInfo
+	body     *httpBody
+	// the server's sequence number when the message started: the one a request acknowledges,
+	// or the sequence number of a response's first byte
+	position int64
+}
+
+// httpPartialHeader is the start of a request or response whose headers were cut off at the end of a segment
+type httpPartialHeader struct {
+	data []byte
+	// the request ID the data would have been parsed with had it arrived with the rest of the headers
+	requestId   int64
+	timestamp   time.Time
+	packetCount int
+}
+
+// httpMaxPartialHeaderSize limits how much of a message's headers is kept while waiting for the rest of them
+const httpMaxPartialHeaderSize = 64 * 1024
+
+func newHttpParser(headersToExtract []string, matcher *httpMatcher, http2 *http2Parser, bodyCapture *httpBodyCaptureOptions) *httpParser {
 	return &httpParser{
-		matcher:          newRequestResponseMatcher(),
+		matcher:          matcher,
 		headersToExtract: headersToExtract,
+		http2:            http2,
+		bodyCapture:      bodyCapture,
 	}
 }
 
-// Parse parses a HTTP request or response and stores it in the matcher
+// Parse parses the HTTP requests or responses in the buffer and stores them in the matcher.
 // If a match is found, it sends a HttpEvent to the tcpStream's events channel.
 //
 // Returns (true, nil) for successful parse; (false, Error) when parsing fails
 func (parser *httpParser) parse(stream *tcpStream, requestId int64, timestamp time.Time, isClient bool, buffer *bufio.Reader, packetCount int) (bool, error) {
+	pending, partial := &parser.pendingResponse, &parser.partialResponse
 	if isClient {
-		req, err := http.ReadRequest(buffer)
+		pending, partial = &parser.pendingRequest, &parser.partialRequest
+	}
+	// read headers that started in an earlier segment with the rest of them, unless the data between wasn't captured
+	if header := *partial; header != nil {
+		*partial = nil
+		if stream.skipped == 0 {
+			stream.prepend(header.data)
+			requestId, timestamp, packetCount = header.requestId, header.timestamp, header.packetCount+packetCount
+		}
+	}
+	// continue reading a body that started in an earlier segment
+	if message := *pending; message != nil && stream.skipped > 0 && !message.body.skip(int64(stream.skipped)) {
+		// the body ended in data that wasn't captured, so the data starts a new message
+		*pending = nil
+		message.truncate()
+		parser.complete(stream, message, requestId)
+	} else if message != nil {
+		message.info.PacketCount += packetCount
+		message.info.LastByteTimestamp = stream.lastByteTimestamp(timestamp)
+		complete, err := message.body.read(buffer)
 		if err != nil {
+			message.body.capture.releaseAll()
+			*pending = nil
 			return false, err
 		}
-		// We only care about a few headers, so recreate the header with just the ones we need
-		req.Header = parser.extractHeaders(req.Header)
-		// We don't need the body, so just close it if set
-		if req.Body != nil {
-			req.Body.Close()
-		}
-		if entry, matchFound := parser.matcher.GetOrStoreRequest(requestId, timestamp, req, packetCount); matchFound {
-			// we have a match, process complete request/response pair
-			stream.eventsChan <- NewHttpEvent(
-				stream.ident,
-				requestId,
-				entry.requestTimestamp,
-				entry.responseTimestamp,
-				entry.requestPacketCount,
-				entry.responsePacketCount,
-				stream.srcIP,
-				stream.dstIP,
-				entry.request,
-				entry.response,
-			)
+		if !complete {
+			return true, nil
 		}
-	} else {
-		res, err := http.ReadResponse(buffer, nil)
+		*pending = nil
+		parser.complete(stream, message, requestId)
+		if _, err := buffer.Peek(1); err != nil {
+			parser.evict(stream, timestamp)
+			return true, nil
+		}
+	}
+
+	// a segment can contain several messages, eg pipelined requests or the responses to them
+	for {
+		start := stream.consumed()
+		if parser.keepPartialHeader(stream, partial, start, requestId, timestamp, isClient, packetCount) {
+			break
+		}
+		message := &httpMessage{
+			info: HttpMessageInfo{
+				MessageInfo: MessageInfo{
+					Timestamp:         timestamp,
+					LastByteTimestamp: stream.lastByteTimestamp(timestamp),
+					PacketCount:       packetCount,
+				},
+			},
+		}
+		if isClient {
+			message.position = requestId
+			message.info.SeqAck = message.position
+			req, err := http.ReadRequest(buffer)
+			if err != nil {
+				return false, err
+			}
+			message.request = req
+			message.info.ContentEncoding = req.Header.Get("Content-Encoding")
+			message.body = newHttpBody(req.ContentLength, req.TransferEncoding, req.Body)
+			message.body.capture = parser.bodyCapture.newRequestCapture(req.Header)
+			// We only care about a few headers, so recreate the header with just the ones we need
+			req.Header = parser.extractHeaders(req.Header)
+		} else {
+			message.position = requestId + start
+			message.info.SeqAck = message.position
+			// the request tells us whether the response has a body, eg responses to HEAD requests don't
+			res, err := http.ReadResponse(buffer, parser.matcher.GetRequest(parser.responseCount+1))
+			if err != nil {
+				return false, err
+			}
+			// the server accepted the upgrade, so the response to the request is sent using HTTP/2 frames
+			if parser.http2 != nil && res.StatusCode == http.StatusSwitchingProtocols && isH2cUpgrade(res.Header) {
+				// the 101 response isn't stored, the upgraded request is only sent once its HTTP/2 response ends
+				var request *httpMessage
+				if parser.requestCount > parser.responseCount {
+					parser.responseCount++
+					if entry := parser.matcher.Evict(parser.responseCount); entry != nil {
+						request = entry.request
+					}
+				}
+				if request != nil {
+					// the request is sent with the HTTP/2 response, which doesn't include captured bodies
+					request.capture().releaseAll()
+					parser.http2.upgrade(request.request, request.info)
+				} else {
+					parser.http2.upgrade(nil, HttpMessageInfo{})
+				}
+				return parser.http2.parse(stream, requestId, timestamp, isClient, buffer, packetCount)
+			}
+			message.response = res
+			message.info.ContentEncoding = res.Header.Get("Content-Encoding")
+			message.body = newHttpBody(res.ContentLength, res.TransferEncoding, res.Body)
+			message.body.capture = parser.bodyCapture.newResponseCapture(res.StatusCode, res.Header)
+			// We only care about a few headers, so recreate the header with just the ones we need
+			res.Header = parser.extractHeaders(res.Header)
+		}
+
+		// We only keep the start of the body if it's captured, but have to read past it to get to the next message
+		complete, err := message.body.read(buffer)
 		if err != nil {
+			message.body.capture.releaseAll()
 			return false, err
 		}
-		// We only care about a few headers, so recreate the header with just the ones we need
-		res.Header = parser.extractHeaders(res.Header)
-		// We don't need the body, so just close it if set
-		if res.Body != nil {
-			res.Body.Close()
-		}
-		if entry, matchFound := parser.matcher.GetOrStoreResponse(requestId, timestamp, res, packetCount); matchFound {
-			// we have a match, process complete request/response pair
-			stream.eventsChan <- NewHttpEvent(
-				stream.ident,
-				requestId,
-				entry.requestTimestamp,
-				entry.responseTimestamp,
-				entry.requestPacketCount,
-				entry.responsePacketCount,
-				stream.srcIP,
-				stream.dstIP,
-				entry.request,
-				entry.response,
-			)
+		if !complete && !stream.fullCapture {
+			// the filter only captures packets that start with a request or response, so the rest of the body won't arrive
+			message.truncate()
+			complete = true
+		}
+		if !complete {
+			*pending = message
+			break
+		}
+		parser.complete(stream, message, requestId)
+		if _, err := buffer.Peek(1); err != nil {
+			break
 		}
 	}
+	parser.evict(stream, timestamp)
 	return true, nil
 }
 
+// keepPartialHeader keeps the start of a message whose headers don't end in the data being parsed,
+// so they can be read once the rest of them arrives in the next segment in the same direction.
+// Returns false if the headers are complete, or the data doesn't look like the start of a message.
+func (parser *httpParser) keepPartialHeader(stream *tcpStream, partial **httpPartialHeader, start int64, requestId int64, timestamp time.Time, isClient bool, packetCount int) bool {
+	data := stream.unread(start)
+	if len(data) > httpMaxPartialHeaderSize || bytes.Contains(data, []byte("\r\n\r\n")) || bytes.Contains(data, []byte("\n\n")) {
+		return false
+	}
+	if !looksLikeHttpStart(data, isClient) {
+		return false
+	}
+	// responses are positioned by where they start in the data, requests by the sequence number they acknowledge
+	if !isClient {
+		requestId += start
+	}
+	*partial = &httpPartialHeader{
+		data:        bytes.Clone(data),
+		requestId:   requestId,
+		timestamp:   timestamp,
+		packetCount: packetCount,
+	}
+	return true
+}
+
+// looksLikeHttpStart returns true if the data could be the start of a request line, or of a response's status line
+func looksLikeHttpStart(data []byte, isClient bool) bool {
+	if !isClient {
+		prefix := []byte("HTTP/")
+		return len(data) > 0 && bytes.HasPrefix(prefix, data[:min(len(data), len(prefix))])
+	}
+	method, _, _ := bytes.Cut(data, []byte(" "))
+	if len(method) == 0 || len(method) > 16 {
+		return false
+	}
+	for _, c := range method {
+		if (c < 'A' || c > 'Z') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// complete stores a request or response once its whole body has been read from the segment starting at the sequence number
+func (parser *httpParser) complete(stream *tcpStream, message *httpMessage, sequence int64) {
+	parser.store(stream, message)
+	if message.response != nil {
+		parser.responseEnd = sequence + stream.consumed()
+		parser.responseEndKnown = true
+	}
+}
+
+// store stores a request or response whose whole body has been read in the matcher,
+// sending a HttpEvent if it completes a request/response pair
+func (parser *httpParser) store(stream *tcpStream, message *httpMessage) {
+	capture := message.body.capture
+	capture.finish()
+	if message.request != nil {
+		message.request.ContentLength = message.body.size
+		parser.storeRequest(stream, message)
+		return
+	}
+	// informational responses, eg "100 Continue", are followed by the final response to the request
+	if message.response.StatusCode < http.StatusOK && message.response.StatusCode != http.StatusSwitchingProtocols {
+		capture.releaseAll()
+		return
+	}
+	message.response.ContentLength = message.body.size
+	parser.storeResponse(stream, message)
+}
+
+// storeRequest matches the request to the oldest response waiting for its request, or stores it until its response arrives.
+//
+// Waiting responses that started before the request was sent can't be its response,
+// so they're sent without a request, eg when the capture started part way through the connection.
+func (parser *httpParser) storeRequest(stream *tcpStream, request *httpMessage) {
+	for parser.responseCount > parser.requestCount {
+		waiting := parser.matcher.Get(parser.requestCount + 1)
+		if waiting == nil || waiting.response == nil || !sequenceBefore(waiting.response.position, request.position) {
+			break
+		}
+		parser.requestCount++
+		parser.sendNotCaptured(stream, parser.matcher.Evict(parser.requestCount))
+	}
+	parser.requestCount++
+	if entry, matchFound := parser.matcher.GetOrStoreRequest(parser.requestCount, request); matchFound {
+		parser.sendEvent(stream, entry, "")
+	}
+}
+
+// storeResponse matches the response to the oldest request waiting for its response, or stores it until its request arrives.
+//
+// A response that started before the oldest waiting request was sent is the response to an earlier request that
+// wasn't captured, so it's sent without a request. When the server sent data between the oldest waiting request and
+// the next one, that was the start of the oldest request's response. If the response started after the next request,
+// the oldest request's response wasn't captured, so it's sent without a response.
+func (parser *httpParser) storeResponse(stream *tcpStream, response *httpMessage) {
+	for parser.requestCount > parser.responseCount {
+		waiting := parser.matcher.Get(parser.responseCount + 1)
+		if waiting == nil || waiting.request == nil {
+			break
+		}
+		if sequenceBefore(response.position, waiting.request.position) {
+			// the response isn't stored, so it doesn't have a key
+			parser.sendNotCaptured(stream, &entry{response: response})
+			return
+		}
+		next := parser.matcher.Get(parser.responseCount + 2)
+		if next == nil || next.request == nil || sequenceBefore(response.position, next.request.position) {
+			break
+		}
+		// data the server sent before the oldest request was sent belongs to earlier responses
+		serverPosition := waiting.request.position
+		if parser.responseEndKnown && sequenceBefore(serverPosition, parser.responseEnd) {
+			serverPosition = parser.responseEnd
+		}
+		if !sequenceBefore(serverPosition, next.request.position) {
+			break
+		}
+		parser.responseCount++
+		parser.sendNotCaptured(stream, parser.matcher.Evict(parser.responseCount))
+	}
+	parser.responseCount++
+	if entry, matchFound := parser.matcher.GetOrStoreResponse(parser.responseCount, response); matchFound {
+		parser.sendEvent(stream, entry, "")
+	}
+}
+
+// sendNotCaptured sends a HttpEvent for a request or response whose counterpart wasn't captured
+func (parser *httpParser) sendNotCaptured(stream *tcpStream, entry *entry) {
+	stats.http_unmatched_not_captured.Add(1)
+	parser.sendEvent(stream, entry, unmatchedNotCaptured)
+}
+
+// evict sends events for requests and responses that have waited too long for their counterpart,
+// or that have to make room for newer ones
+func (parser *httpParser) evict(stream *tcpStream, now time.Time) {
+	for _, entry := range parser.matcher.EvictExpired(now) {
+		parser.sendEvent(stream, entry, unmatchedTimeout)
+	}
+	for _, entry := range parser.matcher.EvictOverflow() {
+		parser.sendEvent(stream, entry, unmatchedMaxEntries)
+	}
+}
+
+// close sends events for the requests and responses still waiting for their counterpart when the stream closes
+func (parser *httpParser) close(stream *tcpStream) {
+	// bodies without a Content-Length end when the connection closes, others weren't captured in full
+	for _, message := range []*httpMessage{parser.pendingRequest, parser.pendingResponse} {
+		if message != nil {
+			if message.body.framing != httpBodyUntilClose {
+				message.truncate()
+			}
+			parser.store(stream, message)
+		}
+	}
+	parser.pendingRequest, parser.pendingResponse = nil, nil
+	for _, entry := range parser.matcher.EvictAll() {
+		parser.sendEvent(stream, entry, unmatchedStreamClosed)
+	}
+}
+
+// sendEvent sends a HttpEvent for the entry, with the reason it's missing its request or response if it isn't matched
+func (parser *httpParser) sendEvent(stream *tcpStream, entry *entry, unmatchedReason string) {
+	var request *http.Request
+	var response *http.Response
+	var requestInfo, responseInfo HttpMessageInfo
+	var requestBody, responseBody *httpBodyCapture
+	if entry.request != nil {
+		request, requestInfo, requestBody = entry.request.request, entry.request.info, entry.request.capture()
+	}
+	if entry.response != nil {
+		response, responseInfo, responseBody = entry.response.response, entry.response.info, entry.response.capture()
+	}
+	event := NewHttpEvent(stream.ident, entry.requestId, stream.srcIP, stream.dstIP, request, requestInfo, response, responseInfo)
+	event.unmatchedReason = unmatchedReason
+	// the bodies' memory is held until the event has been handled, so queued events count towards the limit
+	event.responseBody = responseBody
+	// request bodies are captured before the response status is known, so drop them if it doesn't match
+	if requestBody != nil && (response == nil || parser.bodyCapture.matchesStatus(response.StatusCode)) {
+		event.requestBody = requestBody
+	} else {
+		requestBody.releaseAll()
+	}
+	stream.sendEvent(event)
+}
+
+// truncate ends the message at what has been captured, when the rest of its body won't be
+func (message *httpMessage) truncate() {
+	message.body.truncate()
+	message.info.Partial = true
+}
+
+// capture returns the start of the message's body, or nil if it wasn't captured
+func (message *httpMessage) capture() *httpBodyCapture {
+	if message.body == nil {
+		return nil
+	}
+	return message.body.capture
+}
+
+// sequenceBefore returns true if the TCP sequence number a comes before b, allowing for sequence numbers wrapping around
+func sequenceBefore(a int64, b int64) bool {
+	return reassembly.Sequence(uint32(a)).Difference(reassembly.Sequence(uint32(b))) > 0
+}
+
 // extractHeaders returns a new http.Header object with only specified headers from the original.
 // The original request/response header contains a lot of stuff we don't really care about
 // and stays in memory until the request/response pair is processed
 func (parser *httpParser) extractHeaders(header http.Header) http.Header {
+	return extractHeaders(header, parser.headersToExtract)
+}
+
+// extractHeaders returns a new http.Header object with only the given headers from the original
+func extractHeaders(header http.Header, headersToExtract []string) http.Header {
 	cleanHeader := http.Header{}
 	if header == nil {
 		return cleanHeader
 	}
-	for _, headerName := range parser.headersToExtract {
+	for _, headerName := range headersToExtract {
 		if headerValue := header.Get(headerName); headerValue != "" {
 			cleanHeader.Set(headerName, headerValue)
 		}
 	}
 	return cleanHeader
 }
+
+// isH2cUpgrade returns true if the headers ask to upgrade the connection to cleartext HTTP/2
+func isH2cUpgrade(header http.Header) bool {
+	for _, protocol := range strings.Split(header.Get("Upgrade"), ",") {
+		if strings.EqualFold(strings.TrimSpace(protocol), "h2c") {
+			return true
+		}
+	}
+	return false
+}
@@ -2,9 +2,17 @@ package assemblers
 
 import (
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/reassembly"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
 )
 
 func TestExtractHeader(t *testing.T) {
@@ -53,9 +61,448 @@ func TestExtractHeader(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			parser := newHttpParser(tc.headersToExtract)
+			parser := newHttpParser(tc.headersToExtract, newRequestResponseMatcher(time.Minute, 1000), nil, nil)
 			result := parser.extractHeaders(tc.header)
 			assert.Equal(t, tc.expected, result)
 		})
 	}
 }
+
+func TestHttpParserSendsUnmatchedRequests(t *testing.T) {
+	stream := newHttpTestStream()
+	stream.parsers[1].(*httpParser).matcher.ttl = time.Second
+	start := time.Now()
+
+	// a request that never gets a response, evicted once a later message is more than the TTL after it
+	stream.parse([]byte("GET /hung HTTP/1.1\r\nHost: example.com\r\n\r\n"), 1, start, true, 1)
+	stream.parse([]byte("GET /closed HTTP/1.1\r\nHost: example.com\r\n\r\n"), 2, start.Add(2*time.Second), true, 1)
+	require.Len(t, stream.eventsChan, 1)
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, int64(1), event.RequestId())
+	assert.Equal(t, "/hung", event.Request().URL.Path)
+	assert.Nil(t, event.Response())
+	assert.Equal(t, "timeout", event.UnmatchedReason())
+
+	// the other request is sent when the connection closes
+	IncrementActiveStreamCount()
+	stream.ReassemblyComplete(nil)
+	require.Len(t, stream.eventsChan, 1)
+	event = (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, int64(2), event.RequestId())
+	assert.Equal(t, "/closed", event.Request().URL.Path)
+	assert.Equal(t, "stream_closed", event.UnmatchedReason())
+}
+
+func TestHttpParserEvictsUnmatchedRequestsWithoutNewData(t *testing.T) {
+	factory := NewTcpStreamFactory(config.Config{HTTPMatcherTTL: time.Second, HTTPMatcherMaxEntries: 1000}, make(chan Event, 10))
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	transportFlow := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0xd4, 0x31}, []byte{0x1f, 0x90})
+	stream := factory.New(netFlow, transportFlow, nil, nil).(*tcpStream)
+	start := time.Now()
+
+	// the connection goes quiet after a request that never gets a response
+	stream.parse([]byte("GET /hung HTTP/1.1\r\nHost: example.com\r\n\r\n"), 1, start, true, 1)
+	factory.evict(start.Add(500 * time.Millisecond))
+	assert.Empty(t, stream.eventsChan)
+
+	factory.evict(start.Add(2 * time.Second))
+	require.Len(t, stream.eventsChan, 1)
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/hung", event.Request().URL.Path)
+	assert.Equal(t, "timeout", event.UnmatchedReason())
+
+	// closed streams are forgotten
+	stream.ReassemblyComplete(nil)
+	factory.evict(start.Add(3 * time.Second))
+	assert.Empty(t, factory.streams)
+}
+
+// newHttpTestStream returns a stream for a connection from a client to port 8080,
+// which is in HTTP_PORTS so every packet is captured and bodies can continue in later segments
+func newHttpTestStream() *tcpStream {
+	return newHttpTestStreamWithPorts([]string{"8080"})
+}
+
+// newPayloadFilteredHttpTestStream returns a stream for a connection from a client to port 8080,
+// which is only captured from packets that start with a request or response
+func newPayloadFilteredHttpTestStream() *tcpStream {
+	return newHttpTestStreamWithPorts(nil)
+}
+
+func newHttpTestStreamWithPorts(httpPorts []string) *tcpStream {
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	transportFlow := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0xd4, 0x31}, []byte{0x1f, 0x90}) // 54321 -> 8080
+	return NewTcpStream(netFlow, transportFlow, config.Config{
+		HTTPPorts:             httpPorts,
+		HTTPMatcherTTL:        time.Minute,
+		HTTPMatcherMaxEntries: 1000,
+	}, make(chan Event, 10))
+}
+
+func TestHttpParserMatchesPipelinedRequests(t *testing.T) {
+	stream := newHttpTestStream()
+	start := time.Now()
+
+	stream.parse([]byte(
+		"GET /one HTTP/1.1\r\nHost: example.com\r\n\r\n"+
+			"HEAD /two HTTP/1.1\r\nHost: example.com\r\n\r\n"+
+			"POST /three HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello",
+	), 1, start, true, 2)
+	assert.Empty(t, stream.eventsChan)
+
+	// the HEAD response has a Content-Length but no body
+	stream.parse([]byte(
+		"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\none"+
+			"HTTP/1.1 404 Not Found\r\nContent-Length: 10\r\n\r\n"+
+			"HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n",
+	), 2, start.Add(time.Millisecond), false, 1)
+	require.Len(t, stream.eventsChan, 3)
+
+	for _, expected := range []struct {
+		path   string
+		status int
+	}{
+		{"/one", http.StatusOK},
+		{"/two", http.StatusNotFound},
+		{"/three", http.StatusCreated},
+	} {
+		event := (<-stream.eventsChan).(*HttpEvent)
+		require.NotNil(t, event.Request())
+		require.NotNil(t, event.Response())
+		assert.Equal(t, expected.path, event.Request().URL.Path)
+		assert.Equal(t, expected.status, event.Response().StatusCode)
+		assert.Equal(t, "", event.UnmatchedReason())
+	}
+}
+
+func TestHttpParserMatchesPipelinedMessagesWhoseHeadersAreSplitAcrossSegments(t *testing.T) {
+	stream := newHttpTestStream()
+	start := time.Now()
+
+	first := "GET /one HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	stream.parse([]byte(first+"GET /two HTTP/1.1\r\nHo"), 1, start, true, 1)
+	stream.parse([]byte("st: example.com\r\n\r\n"), 1, start.Add(time.Millisecond), true, 1)
+	assert.Empty(t, stream.eventsChan)
+
+	response := "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\none"
+	stream.parse([]byte(response+"HTTP/1.1 404 Not"), 100, start.Add(2*time.Millisecond), false, 1)
+	require.Len(t, stream.eventsChan, 1)
+	stream.parse([]byte(" Found\r\nContent-Length: 0\r\n\r\n"), 100+int64(len(response))+16, start.Add(3*time.Millisecond), false, 1)
+	require.Len(t, stream.eventsChan, 2)
+
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/one", event.Request().URL.Path)
+	assert.Equal(t, http.StatusOK, event.Response().StatusCode)
+
+	event = (<-stream.eventsChan).(*HttpEvent)
+	require.NotNil(t, event.Request())
+	require.NotNil(t, event.Response())
+	assert.Equal(t, "", event.UnmatchedReason())
+	assert.Equal(t, "/two", event.Request().URL.Path)
+	assert.Equal(t, "example.com", event.Request().Host)
+	assert.Equal(t, http.StatusNotFound, event.Response().StatusCode)
+	// the messages start in the first of their segments
+	assert.Equal(t, start, event.RequestTimestamp())
+	assert.Equal(t, 2, event.RequestPacketCount())
+	assert.Equal(t, start.Add(2*time.Millisecond), event.ResponseTimestamp())
+	assert.Equal(t, 2, event.ResponsePacketCount())
+	seqAck, ok := event.SeqAck()
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), seqAck)
+	assert.Equal(t, 100+int64(len(response)), event.responseSeqAck)
+}
+
+func TestHttpParserMatchesRequestsWhenCaptureStartsMidConnection(t *testing.T) {
+	stream := newHttpTestStream()
+	start := time.Now()
+	serverSeq := int64(1000)
+
+	// the response to a request sent before the capture started
+	unavailable := "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n"
+	stream.parse([]byte(unavailable), serverSeq, start, false, 1)
+	serverSeq += int64(len(unavailable))
+	assert.Empty(t, stream.eventsChan)
+
+	ok := "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+	stream.parse([]byte("GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n"), serverSeq, start.Add(time.Millisecond), true, 1)
+	stream.parse([]byte(ok), serverSeq, start.Add(2*time.Millisecond), false, 1)
+	serverSeq += int64(len(ok))
+	stream.parse([]byte("GET /b HTTP/1.1\r\nHost: example.com\r\n\r\n"), serverSeq, start.Add(3*time.Millisecond), true, 1)
+	stream.parse([]byte("HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"), serverSeq, start.Add(4*time.Millisecond), false, 1)
+	require.Len(t, stream.eventsChan, 3)
+
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Nil(t, event.Request())
+	assert.Equal(t, http.StatusServiceUnavailable, event.Response().StatusCode)
+	assert.Equal(t, "not_captured", event.UnmatchedReason())
+	for _, expected := range []struct {
+		path   string
+		status int
+	}{
+		{"/a", http.StatusOK},
+		{"/b", http.StatusNotFound},
+	} {
+		event := (<-stream.eventsChan).(*HttpEvent)
+		require.NotNil(t, event.Request())
+		require.NotNil(t, event.Response())
+		assert.Equal(t, expected.path, event.Request().URL.Path)
+		assert.Equal(t, expected.status, event.Response().StatusCode)
+		assert.Equal(t, "", event.UnmatchedReason())
+	}
+}
+
+func TestHttpParserSendsRequestsWhoseResponseWasMissed(t *testing.T) {
+	stream := newHttpTestStream()
+	start := time.Now()
+
+	stream.parse([]byte("GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n"), 1000, start, true, 1)
+	// the server's 50 byte response to /a isn't captured, so the next request acknowledges it
+	stream.parse([]byte("GET /b HTTP/1.1\r\nHost: example.com\r\n\r\n"), 1050, start.Add(2*time.Millisecond), true, 1)
+	stream.parse([]byte("HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"), 1050, start.Add(3*time.Millisecond), false, 1)
+	require.Len(t, stream.eventsChan, 2)
+
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/a", event.Request().URL.Path)
+	assert.Nil(t, event.Response())
+	assert.Equal(t, "not_captured", event.UnmatchedReason())
+	event = (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/b", event.Request().URL.Path)
+	assert.Equal(t, http.StatusNotFound, event.Response().StatusCode)
+	assert.Equal(t, "", event.UnmatchedReason())
+}
+
+func TestHttpParserMatchesKeepAliveRequests(t *testing.T) {
+	stream := newHttpTestStream()
+	start := time.Now()
+
+	stream.parse([]byte("GET /first HTTP/1.1\r\nHost: example.com\r\n\r\n"), 1, start, true, 1)
+	// the response body continues in the next segment
+	stream.parse([]byte("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n01234"), 2, start.Add(time.Millisecond), false, 1)
+	stream.parse([]byte("56789"), 3, start.Add(2*time.Millisecond), false, 1)
+	stream.parse([]byte("GET /second HTTP/1.1\r\nHost: example.com\r\n\r\n"), 4, start.Add(3*time.Millisecond), true, 1)
+	stream.parse([]byte("HTTP/1.1 500 Internal Server Error\r\nContent-Length: 0\r\n\r\n"), 5, start.Add(4*time.Millisecond), false, 1)
+	require.Len(t, stream.eventsChan, 2)
+
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/first", event.Request().URL.Path)
+	assert.Equal(t, http.StatusOK, event.Response().StatusCode)
+	assert.Equal(t, start, event.RequestTimestamp())
+	assert.Equal(t, start.Add(time.Millisecond), event.ResponseTimestamp())
+
+	event = (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/second", event.Request().URL.Path)
+	assert.Equal(t, http.StatusInternalServerError, event.Response().StatusCode)
+}
+
+func TestHttpParserSkipsContinueResponses(t *testing.T) {
+	stream := newHttpTestStream()
+	start := time.Now()
+
+	stream.parse([]byte("PUT /upload HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue\r\nContent-Length: 11\r\n\r\n"), 1, start, true, 1)
+	stream.parse([]byte("HTTP/1.1 100 Continue\r\n\r\n"), 2, start.Add(time.Millisecond), false, 1)
+	stream.parse([]byte("hello world"), 3, start.Add(2*time.Millisecond), true, 1)
+	stream.parse([]byte("HTTP/1.1 204 No Content\r\n\r\n"), 4, start.Add(3*time.Millisecond), false, 1)
+	stream.parse([]byte("GET /next HTTP/1.1\r\nHost: example.com\r\n\r\n"), 5, start.Add(4*time.Millisecond), true, 1)
+	stream.parse([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"), 6, start.Add(5*time.Millisecond), false, 1)
+	require.Len(t, stream.eventsChan, 2)
+
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/upload", event.Request().URL.Path)
+	assert.Equal(t, http.StatusNoContent, event.Response().StatusCode)
+	assert.Equal(t, start.Add(3*time.Millisecond), event.ResponseTimestamp())
+
+	event = (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/next", event.Request().URL.Path)
+	assert.Equal(t, http.StatusOK, event.Response().StatusCode)
+}
+
+func TestHttpParserReportsBodySizes(t *testing.T) {
+	stream := newHttpTestStream()
+	start := time.Now()
+
+	stream.parse([]byte("POST /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\nContent-Encoding: gzip\r\n\r\n4\r\nabcd\r\n"), 1, start, true, 1)
+	stream.parse([]byte("6\r\nefghij\r\n0\r\n\r\n"), 2, start.Add(time.Millisecond), true, 1)
+	stream.parse([]byte("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"), 3, start.Add(2*time.Millisecond), false, 1)
+	stream.parse([]byte("GET /download HTTP/1.1\r\nHost: example.com\r\n\r\n"), 4, start.Add(3*time.Millisecond), true, 1)
+	// without a Content-Length, the response body continues until the connection closes
+	stream.parse([]byte("HTTP/1.1 200 OK\r\nContent-Encoding: br\r\nConnection: close\r\n\r\nsome "), 5, start.Add(4*time.Millisecond), false, 1)
+	stream.parse([]byte("streamed data"), 6, start.Add(5*time.Millisecond), false, 2)
+	require.Len(t, stream.eventsChan, 1)
+
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/upload", event.Request().URL.Path)
+	assert.Equal(t, int64(10), event.Request().ContentLength)
+	assert.Equal(t, []string{"chunked"}, event.Request().TransferEncoding)
+	assert.Equal(t, "gzip", event.RequestContentEncoding())
+	assert.Equal(t, 2, event.RequestPacketCount())
+	assert.Equal(t, int64(5), event.Response().ContentLength)
+	assert.Equal(t, "", event.ResponseContentEncoding())
+
+	IncrementActiveStreamCount()
+	stream.ReassemblyComplete(nil)
+	require.Len(t, stream.eventsChan, 1)
+	event = (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/download", event.Request().URL.Path)
+	assert.Equal(t, int64(0), event.Request().ContentLength)
+	assert.Equal(t, int64(18), event.Response().ContentLength)
+	assert.Equal(t, "br", event.ResponseContentEncoding())
+	assert.Equal(t, 3, event.ResponsePacketCount())
+	assert.Equal(t, "", event.UnmatchedReason())
+}
+
+// testScatterGather is a reassembly.ScatterGather holding the given packets' data
+type testScatterGather struct {
+	packets    []string
+	timestamps []time.Time
+	direction  reassembly.TCPFlowDirection
+	skip       int
+}
+
+func (sg *testScatterGather) Lengths() (int, int) {
+	return len(strings.Join(sg.packets, "")), 0
+}
+
+func (sg *testScatterGather) Fetch(length int) []byte {
+	return []byte(strings.Join(sg.packets, "")[:length])
+}
+
+func (sg *testScatterGather) KeepFrom(offset int) {}
+
+func (sg *testScatterGather) CaptureInfo(offset int) gopacket.CaptureInfo {
+	for i, packet := range sg.packets {
+		if offset < len(packet) {
+			return gopacket.CaptureInfo{Timestamp: sg.timestamps[i]}
+		}
+		offset -= len(packet)
+	}
+	return gopacket.CaptureInfo{}
+}
+
+func (sg *testScatterGather) Info() (reassembly.TCPFlowDirection, bool, bool, int) {
+	return sg.direction, false, false, sg.skip
+}
+
+func (sg *testScatterGather) Stats() reassembly.TCPAssemblyStats {
+	return reassembly.TCPAssemblyStats{Packets: len(sg.packets)}
+}
+
+func TestHttpParserRecordsFirstAndLastByteTimestamps(t *testing.T) {
+	stream := newHttpTestStream()
+	start := time.Now()
+	ms := func(n int) time.Time { return start.Add(time.Duration(n) * time.Millisecond) }
+
+	stream.ReassembledSG(&testScatterGather{
+		packets:    []string{"POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 10\r\n\r\n01234", "56789"},
+		timestamps: []time.Time{ms(0), ms(5)},
+		direction:  reassembly.TCPDirClientToServer,
+	}, &Context{CaptureInfo: gopacket.CaptureInfo{Timestamp: ms(5)}})
+	// the response body continues in the next segment
+	stream.ReassembledSG(&testScatterGather{
+		packets:    []string{"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n0123"},
+		timestamps: []time.Time{ms(25)},
+		direction:  reassembly.TCPDirServerToClient,
+	}, &Context{CaptureInfo: gopacket.CaptureInfo{Timestamp: ms(25)}})
+	stream.ReassembledSG(&testScatterGather{
+		packets:    []string{"45", "6789"},
+		timestamps: []time.Time{ms(30), ms(40)},
+		direction:  reassembly.TCPDirServerToClient,
+	}, &Context{CaptureInfo: gopacket.CaptureInfo{Timestamp: ms(40)}})
+	require.Len(t, stream.eventsChan, 1)
+
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, ms(0), event.RequestTimestamp())
+	assert.Equal(t, ms(5), event.RequestLastByteTimestamp())
+	assert.Equal(t, ms(25), event.ResponseTimestamp())
+	assert.Equal(t, ms(40), event.ResponseLastByteTimestamp())
+	assert.Equal(t, 2, event.RequestPacketCount())
+	assert.Equal(t, 3, event.ResponsePacketCount())
+}
+
+func TestHttpParserEndsBodiesAtTheFirstPacketWhenPayloadFiltered(t *testing.T) {
+	stream := newPayloadFilteredHttpTestStream()
+	start := time.Now()
+
+	// the rest of each body is in packets the BPF filter doesn't capture
+	stream.parse([]byte("POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5000\r\n\r\n01234"), 1, start, true, 1)
+	stream.parse([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n400\r\nabcd"), 5001, start.Add(time.Millisecond), false, 1)
+	require.Len(t, stream.eventsChan, 1)
+
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/upload", event.Request().URL.Path)
+	assert.Equal(t, int64(5000), event.Request().ContentLength)
+	assert.Equal(t, int64(-1), event.Response().ContentLength)
+	assert.True(t, event.RequestPartial())
+	assert.True(t, event.ResponsePartial())
+	assert.Equal(t, "", event.UnmatchedReason())
+}
+
+func TestHttpParserFollowsBodiesAcrossGaps(t *testing.T) {
+	stream := newHttpTestStream()
+	start := time.Now()
+	ms := func(n int) time.Time { return start.Add(time.Duration(n) * time.Millisecond) }
+
+	stream.ReassembledSG(&testScatterGather{
+		packets:    []string{"GET /download HTTP/1.1\r\nHost: example.com\r\n\r\n"},
+		timestamps: []time.Time{ms(0)},
+		direction:  reassembly.TCPDirClientToServer,
+		skip:       -1,
+	}, &Context{CaptureInfo: gopacket.CaptureInfo{Timestamp: ms(0)}})
+	stream.ReassembledSG(&testScatterGather{
+		packets:    []string{"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n01"},
+		timestamps: []time.Time{ms(10)},
+		direction:  reassembly.TCPDirServerToClient,
+		skip:       -1,
+	}, &Context{CaptureInfo: gopacket.CaptureInfo{Timestamp: ms(10)}})
+	// a lost packet held 3 bytes of the body, which still continues in the next segment
+	stream.ReassembledSG(&testScatterGather{
+		packets:    []string{"56789"},
+		timestamps: []time.Time{ms(20)},
+		direction:  reassembly.TCPDirServerToClient,
+		skip:       3,
+	}, &Context{CaptureInfo: gopacket.CaptureInfo{Timestamp: ms(20)}})
+	require.Len(t, stream.eventsChan, 1)
+
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/download", event.Request().URL.Path)
+	assert.Equal(t, int64(10), event.Response().ContentLength)
+	// the end of the response was captured, so its last byte timestamp is still known
+	assert.False(t, event.ResponsePartial())
+	assert.Equal(t, ms(20), event.ResponseLastByteTimestamp())
+}
+
+func TestHttpParserStartsNewMessageAfterGapPastBody(t *testing.T) {
+	stream := newHttpTestStream()
+	start := time.Now()
+	ms := func(n int) time.Time { return start.Add(time.Duration(n) * time.Millisecond) }
+
+	stream.ReassembledSG(&testScatterGather{
+		packets:    []string{"POST /first HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\n01234\r\n"},
+		timestamps: []time.Time{ms(0)},
+		direction:  reassembly.TCPDirClientToServer,
+		skip:       -1,
+	}, &Context{CaptureInfo: gopacket.CaptureInfo{Timestamp: ms(0)}})
+	// the end of the chunked body was lost, so the next segment is parsed as a new request
+	stream.ReassembledSG(&testScatterGather{
+		packets:    []string{"GET /second HTTP/1.1\r\nHost: example.com\r\n\r\n"},
+		timestamps: []time.Time{ms(10)},
+		direction:  reassembly.TCPDirClientToServer,
+		skip:       100,
+	}, &Context{CaptureInfo: gopacket.CaptureInfo{Timestamp: ms(10)}})
+	stream.ReassembledSG(&testScatterGather{
+		packets:    []string{"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\nHTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"},
+		timestamps: []time.Time{ms(20)},
+		direction:  reassembly.TCPDirServerToClient,
+		skip:       -1,
+	}, &Context{CaptureInfo: gopacket.CaptureInfo{Timestamp: ms(20)}})
+	require.Len(t, stream.eventsChan, 2)
+
+	event := (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/first", event.Request().URL.Path)
+	assert.Equal(t, int64(-1), event.Request().ContentLength)
+	assert.True(t, event.RequestPartial())
+	assert.Equal(t, http.StatusOK, event.Response().StatusCode)
+
+	event = (<-stream.eventsChan).(*HttpEvent)
+	assert.Equal(t, "/second", event.Request().URL.Path)
+	assert.Equal(t, http.StatusNotFound, event.Response().StatusCode)
+}
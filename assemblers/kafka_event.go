@@ -0,0 +1,118 @@
+package assemblers
+
+import (
+	"time"
+)
+
+// KafkaEvent represents a Kafka request sent by a client to a broker and the broker's response
+type KafkaEvent struct {
+	eventBase
+	apiKey        int16
+	apiVersion    int16
+	correlationId int32
+	clientId      string
+	topics        []string
+	topicIds      []string
+	consumerGroup string
+	errorCodes    []int16
+}
+
+// Make sure KafkaEvent implements Event interface
+var _ Event = (*KafkaEvent)(nil)
+
+func NewKafkaEvent(
+	streamIdent string,
+	requestId int64,
+	requestTimestamp time.Time,
+	responseTimestamp time.Time,
+	requestPacketCount int,
+	responsePacketCount int,
+	srcIp string,
+	dstIp string,
+	apiKey int16,
+	apiVersion int16,
+	correlationId int32,
+	clientId string,
+	topics []string,
+	topicIds []string,
+	consumerGroup string,
+	errorCodes []int16) *KafkaEvent {
+	return &KafkaEvent{
+		eventBase: eventBase{
+			streamIdent:         streamIdent,
+			requestId:           requestId,
+			requestTimestamp:    requestTimestamp,
+			responseTimestamp:   responseTimestamp,
+			requestPacketCount:  requestPacketCount,
+			responsePacketCount: responsePacketCount,
+			srcIp:               srcIp,
+			dstIp:               dstIp,
+		},
+		apiKey:        apiKey,
+		apiVersion:    apiVersion,
+		correlationId: correlationId,
+		clientId:      clientId,
+		topics:        topics,
+		topicIds:      topicIds,
+		consumerGroup: consumerGroup,
+		errorCodes:    errorCodes,
+	}
+}
+
+// ApiKey returns the numeric key of the request's API, eg 0 for Produce
+func (event *KafkaEvent) ApiKey() int16 {
+	return event.apiKey
+}
+
+// ApiName returns the name of the request's API, eg "Produce"
+func (event *KafkaEvent) ApiName() string {
+	return kafkaApiName(event.apiKey)
+}
+
+// ApiVersion returns the version of the request's API
+func (event *KafkaEvent) ApiVersion() int16 {
+	return event.apiVersion
+}
+
+// CorrelationId returns the ID the client used to match the response to the request
+func (event *KafkaEvent) CorrelationId() int32 {
+	return event.correlationId
+}
+
+// ClientId returns the client ID sent with the request, which is often the client's application name
+func (event *KafkaEvent) ClientId() string {
+	return event.clientId
+}
+
+// Topics returns the names of the topics in the request, for APIs we decode
+func (event *KafkaEvent) Topics() []string {
+	return event.topics
+}
+
+// TopicIds returns the IDs of topics in the request whose names we don't know,
+// as fetches from version 13 identify topics by ID and we only learn names from Metadata responses
+func (event *KafkaEvent) TopicIds() []string {
+	return event.topicIds
+}
+
+// ConsumerGroup returns the consumer group that committed offsets, for OffsetCommit requests
+func (event *KafkaEvent) ConsumerGroup() string {
+	return event.consumerGroup
+}
+
+// ErrorCodes returns the distinct non-zero error codes in the response, for APIs we decode
+func (event *KafkaEvent) ErrorCodes() []int16 {
+	return event.errorCodes
+}
+
+// Operation returns the messaging operation the request performs,
+// "publish" for Produce and "receive" for Fetch, or "" for other APIs
+func (event *KafkaEvent) Operation() string {
+	switch event.apiKey {
+	case kafkaApiProduce:
+		return "publish"
+	case kafkaApiFetch:
+		return "receive"
+	}
+	return ""
+}
@@ -0,0 +1,528 @@
+package assemblers
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// kafkaMaxCapture is the number of bytes kept from each message, enough to decode
+	// requests and the start of responses without buffering the records they carry
+	kafkaMaxCapture = 64 * 1024
+	// kafkaMaxMessageSize is the largest message we expect, matching the broker's default socket.request.max.bytes
+	kafkaMaxMessageSize = 100 * 1024 * 1024
+	// kafkaMaxPendingRequests limits how many requests we hold on to while waiting for their responses
+	kafkaMaxPendingRequests = 1000
+	// kafkaMaxTopics limits how many topic names are kept for each request
+	kafkaMaxTopics = 100
+	// kafkaMaxTopicIds limits how many topic names we remember by topic ID
+	kafkaMaxTopicIds = 10000
+
+	kafkaApiProduce      = 0
+	kafkaApiFetch        = 1
+	kafkaApiMetadata     = 3
+	kafkaApiOffsetCommit = 8
+)
+
+// kafkaApiNames are the names of the Kafka APIs clients commonly use, by API key
+var kafkaApiNames = map[int16]string{
+	0:  "Produce",
+	1:  "Fetch",
+	2:  "ListOffsets",
+	3:  "Metadata",
+	8:  "OffsetCommit",
+	9:  "OffsetFetch",
+	10: "FindCoordinator",
+	11: "JoinGroup",
+	12: "Heartbeat",
+	13: "LeaveGroup",
+	14: "SyncGroup",
+	15: "DescribeGroups",
+	16: "ListGroups",
+	17: "SaslHandshake",
+	18: "ApiVersions",
+	19: "CreateTopics",
+	20: "DeleteTopics",
+	22: "InitProducerId",
+	24: "AddPartitionsToTxn",
+	25: "AddOffsetsToTxn",
+	26: "EndTxn",
+	28: "TxnOffsetCommit",
+	32: "DescribeConfigs",
+	36: "SaslAuthenticate",
+	37: "CreatePartitions",
+}
+
+func kafkaApiName(apiKey int16) string {
+	if name, ok := kafkaApiNames[apiKey]; ok {
+		return name
+	}
+	return fmt.Sprintf("ApiKey%d", apiKey)
+}
+
+// kafkaDecodedApis are the APIs whose request and response bodies we decode, with the first version
+// that uses flexible encoding and the last version we know how to decode
+var kafkaDecodedApis = map[int16]struct {
+	firstFlexibleVersion int16
+	maxVersion           int16
+}{
+	kafkaApiProduce:      {9, 12},
+	kafkaApiFetch:        {12, 17},
+	kafkaApiMetadata:     {9, 13},
+	kafkaApiOffsetCommit: {8, 9},
+}
+
+// kafkaTopicNames are the names of topics by topic ID, learned from Metadata responses.
+// Clients often fetch metadata on a different connection to the one they fetch records on,
+// so the names are shared by every connection.
+var kafkaTopicNames = struct {
+	sync.Mutex
+	names map[[16]byte]string
+}{names: make(map[[16]byte]string)}
+
+func storeKafkaTopicName(topicId [16]byte, name string) {
+	kafkaTopicNames.Lock()
+	defer kafkaTopicNames.Unlock()
+	if len(kafkaTopicNames.names) >= kafkaMaxTopicIds {
+		clear(kafkaTopicNames.names)
+	}
+	kafkaTopicNames.names[topicId] = name
+}
+
+func loadKafkaTopicName(topicId [16]byte) (string, bool) {
+	kafkaTopicNames.Lock()
+	defer kafkaTopicNames.Unlock()
+	name, ok := kafkaTopicNames.names[topicId]
+	return name, ok
+}
+
+// kafkaParser parses Kafka requests sent by clients and responses sent by brokers.
+//
+// Brokers respond to requests in the order they were received, with the correlation ID
+// the client sent, so responses are matched to the oldest request with the same correlation ID.
+// If too many requests wait for their responses, we've lost track of the connection, so we stop following it.
+type kafkaParser struct {
+	requests  *framedReader
+	responses *framedReader
+	pending   []*kafkaRequest
+}
+
+// kafkaRequest is a request waiting for its response
+type kafkaRequest struct {
+	apiKey        int16
+	apiVersion    int16
+	correlationId int32
+	clientId      string
+	topics        []string
+	topicIds      []string
+	consumerGroup string
+	acks          int16
+	timestamp     time.Time
+	packetCount   int
+}
+
+func newKafkaParser() *kafkaParser {
+	return &kafkaParser{
+		requests:  newFramedReader(4, kafkaMaxCapture, parseKafkaHeader),
+		responses: newFramedReader(4, kafkaMaxCapture, parseKafkaHeader),
+	}
+}
+
+// parseKafkaHeader parses the size that every request and response starts with
+func parseKafkaHeader(header []byte) (int, bool) {
+	size := int32(binary.BigEndian.Uint32(header))
+	if size < 4 || size > kafkaMaxMessageSize {
+		return 0, false
+	}
+	return int(size), true
+}
+
+// parse reads Kafka requests sent by the client and responses sent by the broker,
+// sending a KafkaEvent for each response that matches a request.
+func (parser *kafkaParser) parse(stream *tcpStream, requestId int64, timestamp time.Time, isClient bool, buffer *bufio.Reader, packetCount int) (bool, error) {
+	reader := parser.responses
+	if isClient {
+		reader = parser.requests
+	}
+	// we've already reported we can't follow this direction of the connection
+	if reader.desynced {
+		return true, nil
+	}
+
+	data, err := io.ReadAll(buffer)
+	if err != nil {
+		return false, err
+	}
+	if isClient {
+		err = reader.read(data, func(header []byte, body []byte, truncated bool) {
+			parser.storeRequest(stream, body, timestamp, packetCount)
+		})
+	} else {
+		err = reader.read(data, func(header []byte, body []byte, truncated bool) {
+			parser.matchResponse(stream, body, timestamp, packetCount)
+		})
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (parser *kafkaParser) storeRequest(stream *tcpStream, body []byte, timestamp time.Time, packetCount int) {
+	if parser.requests.desynced {
+		return
+	}
+	reader := &kafkaReader{data: body}
+	request := &kafkaRequest{
+		apiKey:        reader.int16(),
+		apiVersion:    reader.int16(),
+		correlationId: reader.int32(),
+		// the client ID is never a compact string, even in flexible versions
+		clientId:    reader.string(),
+		acks:        -1,
+		timestamp:   timestamp,
+		packetCount: packetCount,
+	}
+	if !reader.ok() || request.apiKey < 0 || request.apiVersion < 0 {
+		return
+	}
+
+	if api, ok := kafkaDecodedApis[request.apiKey]; ok && request.apiVersion <= api.maxVersion {
+		reader.flexible = request.apiVersion >= api.firstFlexibleVersion
+		reader.skipTaggedFields()
+		decodeKafkaRequest(reader, request)
+	}
+
+	// producers that don't wait for acknowledgement don't get a response
+	if request.apiKey == kafkaApiProduce && request.acks == 0 {
+		parser.sendEvent(stream, request, time.Time{}, 0, nil)
+		return
+	}
+	if len(parser.pending) >= kafkaMaxPendingRequests {
+		parser.desync(stream)
+		return
+	}
+	parser.pending = append(parser.pending, request)
+}
+
+func (parser *kafkaParser) matchResponse(stream *tcpStream, body []byte, timestamp time.Time, packetCount int) {
+	if parser.responses.desynced {
+		return
+	}
+	reader := &kafkaReader{data: body}
+	correlationId := reader.int32()
+	index := slices.IndexFunc(parser.pending, func(request *kafkaRequest) bool {
+		return request.correlationId == correlationId
+	})
+	if !reader.ok() || index < 0 {
+		return
+	}
+	// any earlier requests aren't going to get a response
+	request := parser.pending[index]
+	parser.pending = parser.pending[index+1:]
+
+	var errorCodes []int16
+	if api, ok := kafkaDecodedApis[request.apiKey]; ok && request.apiVersion <= api.maxVersion {
+		reader.flexible = request.apiVersion >= api.firstFlexibleVersion
+		reader.skipTaggedFields()
+		errorCodes = decodeKafkaResponse(reader, request)
+	}
+	parser.sendEvent(stream, request, timestamp, packetCount, errorCodes)
+}
+
+// desync stops following the connection once too many requests are waiting for responses,
+// sending the waiting requests without their responses
+func (parser *kafkaParser) desync(stream *tcpStream) {
+	stats.desynced_max_pending.Add(1)
+	parser.requests.desynced = true
+	parser.responses.desynced = true
+	for _, request := range parser.pending {
+		parser.sendEvent(stream, request, time.Time{}, 0, nil)
+	}
+	parser.pending = nil
+}
+
+func (parser *kafkaParser) sendEvent(stream *tcpStream, request *kafkaRequest, timestamp time.Time, packetCount int, errorCodes []int16) {
+	stream.sendEvent(NewKafkaEvent(
+		stream.ident,
+		int64(request.correlationId),
+		request.timestamp,
+		timestamp,
+		request.packetCount,
+		packetCount,
+		stream.srcIP,
+		stream.dstIP,
+		request.apiKey,
+		request.apiVersion,
+		request.correlationId,
+		request.clientId,
+		request.topics,
+		request.topicIds,
+		request.consumerGroup,
+		errorCodes,
+	))
+}
+
+// decodeKafkaRequest decodes the topics (and other fields we report) from the body of a request
+func decodeKafkaRequest(reader *kafkaReader, request *kafkaRequest) {
+	version := request.apiVersion
+	addTopic := func(topic string) {
+		if reader.ok() && topic != "" && len(request.topics) < kafkaMaxTopics && !slices.Contains(request.topics, topic) {
+			request.topics = append(request.topics, topic)
+		}
+	}
+	// topics identified by ID are reported by name if we've seen it in a Metadata response
+	addTopicId := func(topicId [16]byte) {
+		if !reader.ok() || topicId == [16]byte{} {
+			return
+		}
+		if name, ok := loadKafkaTopicName(topicId); ok {
+			addTopic(name)
+		} else if id := base64.RawURLEncoding.EncodeToString(topicId[:]); len(request.topicIds) < kafkaMaxTopics && !slices.Contains(request.topicIds, id) {
+			request.topicIds = append(request.topicIds, id)
+		}
+	}
+
+	switch request.apiKey {
+	case kafkaApiProduce:
+		if version >= 3 {
+			reader.string() // transactional ID
+		}
+		if acks := reader.int16(); reader.ok() {
+			request.acks = acks
+		}
+		reader.int32() // timeout
+		for topics := reader.arrayLength(); topics > 0 && reader.ok(); topics-- {
+			addTopic(reader.string())
+			for partitions := reader.arrayLength(); partitions > 0 && reader.ok(); partitions-- {
+				reader.int32() // index
+				reader.skipBytes()
+				reader.skipTaggedFields()
+			}
+			reader.skipTaggedFields()
+		}
+	case kafkaApiFetch:
+		if version <= 14 {
+			reader.int32() // replica ID
+		}
+		// max wait, min bytes
+		reader.skip(8)
+		if version >= 3 {
+			reader.int32() // max bytes
+		}
+		if version >= 4 {
+			reader.int8() // isolation level
+		}
+		if version >= 7 {
+			reader.skip(8) // session ID and epoch
+		}
+		for topics := reader.arrayLength(); topics > 0 && reader.ok(); topics-- {
+			// topics are identified by ID rather than name from version 13
+			if version >= 13 {
+				addTopicId(reader.uuid())
+			} else {
+				addTopic(reader.string())
+			}
+			for partitions := reader.arrayLength(); partitions > 0 && reader.ok(); partitions-- {
+				reader.int32() // partition
+				if version >= 9 {
+					reader.int32() // current leader epoch
+				}
+				reader.skip(8) // fetch offset
+				if version >= 12 {
+					reader.int32() // last fetched epoch
+				}
+				if version >= 5 {
+					reader.skip(8) // log start offset
+				}
+				reader.int32() // partition max bytes
+				reader.skipTaggedFields()
+			}
+			reader.skipTaggedFields()
+		}
+	case kafkaApiMetadata:
+		// a null array requests all topics
+		for topics := reader.arrayLength(); topics > 0 && reader.ok(); topics-- {
+			if version >= 10 {
+				reader.skip(16) // topic ID
+			}
+			addTopic(reader.string())
+			reader.skipTaggedFields()
+		}
+	case kafkaApiOffsetCommit:
+		request.consumerGroup = reader.string()
+		if version >= 1 {
+			reader.int32()  // generation ID
+			reader.string() // member ID
+		}
+		if version >= 7 {
+			reader.string() // group instance ID
+		}
+		if version >= 2 && version <= 4 {
+			reader.skip(8) // retention time
+		}
+		for topics := reader.arrayLength(); topics > 0 && reader.ok(); topics-- {
+			addTopic(reader.string())
+			for partitions := reader.arrayLength(); partitions > 0 && reader.ok(); partitions-- {
+				reader.int32() // partition index
+				reader.skip(8) // committed offset
+				if version >= 6 {
+					reader.int32() // committed leader epoch
+				}
+				if version == 1 {
+					reader.skip(8) // commit timestamp
+				}
+				reader.string() // committed metadata
+				reader.skipTaggedFields()
+			}
+			reader.skipTaggedFields()
+		}
+	}
+}
+
+// decodeKafkaResponse returns the distinct non-zero error codes in the body of a response.
+// Responses can be truncated, in which case the error codes found before the end of the captured data are returned.
+func decodeKafkaResponse(reader *kafkaReader, request *kafkaRequest) []int16 {
+	version := request.apiVersion
+	var errorCodes []int16
+	addErrorCode := func(errorCode int16) {
+		if reader.ok() && errorCode != 0 && !slices.Contains(errorCodes, errorCode) {
+			errorCodes = append(errorCodes, errorCode)
+		}
+	}
+
+	switch request.apiKey {
+	case kafkaApiProduce:
+		for topics := reader.arrayLength(); topics > 0 && reader.ok(); topics-- {
+			reader.string() // name
+			for partitions := reader.arrayLength(); partitions > 0 && reader.ok(); partitions-- {
+				reader.int32() // index
+				addErrorCode(reader.int16())
+				reader.skip(8) // base offset
+				if version >= 2 {
+					reader.skip(8) // log append time
+				}
+				if version >= 5 {
+					reader.skip(8) // log start offset
+				}
+				if version >= 8 {
+					for recordErrors := reader.arrayLength(); recordErrors > 0 && reader.ok(); recordErrors-- {
+						reader.int32()  // batch index
+						reader.string() // batch index error message
+						reader.skipTaggedFields()
+					}
+					reader.string() // error message
+				}
+				reader.skipTaggedFields()
+			}
+			reader.skipTaggedFields()
+		}
+	case kafkaApiFetch:
+		if version >= 1 {
+			reader.int32() // throttle time
+		}
+		if version >= 7 {
+			addErrorCode(reader.int16())
+			reader.int32() // session ID
+		}
+		for topics := reader.arrayLength(); topics > 0 && reader.ok(); topics-- {
+			if version >= 13 {
+				reader.skip(16) // topic ID
+			} else {
+				reader.string() // topic
+			}
+			for partitions := reader.arrayLength(); partitions > 0 && reader.ok(); partitions-- {
+				reader.int32() // partition index
+				addErrorCode(reader.int16())
+				reader.skip(8) // high watermark
+				if version >= 4 {
+					reader.skip(8) // last stable offset
+				}
+				if version >= 5 {
+					reader.skip(8) // log start offset
+				}
+				if version >= 4 {
+					for aborted := reader.arrayLength(); aborted > 0 && reader.ok(); aborted-- {
+						reader.skip(16) // producer ID and first offset
+						reader.skipTaggedFields()
+					}
+				}
+				if version >= 11 {
+					reader.int32() // preferred read replica
+				}
+				reader.skipBytes() // records
+				reader.skipTaggedFields()
+			}
+			reader.skipTaggedFields()
+		}
+	case kafkaApiMetadata:
+		if version >= 3 {
+			reader.int32() // throttle time
+		}
+		for brokers := reader.arrayLength(); brokers > 0 && reader.ok(); brokers-- {
+			reader.int32()  // node ID
+			reader.string() // host
+			reader.int32()  // port
+			if version >= 1 {
+				reader.string() // rack
+			}
+			reader.skipTaggedFields()
+		}
+		if version >= 2 {
+			reader.string() // cluster ID
+		}
+		if version >= 1 {
+			reader.int32() // controller ID
+		}
+		for topics := reader.arrayLength(); topics > 0 && reader.ok(); topics-- {
+			addErrorCode(reader.int16())
+			name := reader.string()
+			if version >= 10 {
+				// remember the topic's name so fetches by topic ID can be reported by name
+				if topicId := reader.uuid(); reader.ok() && name != "" && topicId != [16]byte{} {
+					storeKafkaTopicName(topicId, name)
+				}
+			}
+			if version >= 1 {
+				reader.int8() // is internal
+			}
+			for partitions := reader.arrayLength(); partitions > 0 && reader.ok(); partitions-- {
+				addErrorCode(reader.int16())
+				reader.skip(8) // partition index and leader ID
+				if version >= 7 {
+					reader.int32() // leader epoch
+				}
+				reader.skipInt32Array() // replica nodes
+				reader.skipInt32Array() // ISR nodes
+				if version >= 5 {
+					reader.skipInt32Array() // offline replicas
+				}
+				reader.skipTaggedFields()
+			}
+			if version >= 8 {
+				reader.int32() // topic authorized operations
+			}
+			reader.skipTaggedFields()
+		}
+	case kafkaApiOffsetCommit:
+		if version >= 3 {
+			reader.int32() // throttle time
+		}
+		for topics := reader.arrayLength(); topics > 0 && reader.ok(); topics-- {
+			reader.string() // name
+			for partitions := reader.arrayLength(); partitions > 0 && reader.ok(); partitions-- {
+				reader.int32() // partition index
+				addErrorCode(reader.int16())
+				reader.skipTaggedFields()
+			}
+			reader.skipTaggedFields()
+		}
+	}
+	return errorCodes
+}
@@ -0,0 +1,301 @@
+package assemblers
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+func newKafkaTestStream() *tcpStream {
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	transportFlow := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0xd4, 0x31}, []byte{0x23, 0x84}) // 54321 -> 9092
+	return NewTcpStream(netFlow, transportFlow, config.Config{
+		KafkaPorts: []string{"9092"},
+	}, make(chan Event, 10))
+}
+
+// kafkaTestMessage builds a Kafka message, using compact strings and arrays when flexible is true
+type kafkaTestMessage struct {
+	data     []byte
+	flexible bool
+}
+
+func (message *kafkaTestMessage) int8(value int8) *kafkaTestMessage {
+	message.data = append(message.data, byte(value))
+	return message
+}
+
+func (message *kafkaTestMessage) int16(value int16) *kafkaTestMessage {
+	message.data = binary.BigEndian.AppendUint16(message.data, uint16(value))
+	return message
+}
+
+func (message *kafkaTestMessage) int32(value int32) *kafkaTestMessage {
+	message.data = binary.BigEndian.AppendUint32(message.data, uint32(value))
+	return message
+}
+
+func (message *kafkaTestMessage) int64(value int64) *kafkaTestMessage {
+	message.data = binary.BigEndian.AppendUint64(message.data, uint64(value))
+	return message
+}
+
+func (message *kafkaTestMessage) length(length int, fixedSize int) *kafkaTestMessage {
+	switch {
+	case message.flexible:
+		message.data = binary.AppendUvarint(message.data, uint64(length+1))
+	case fixedSize == 2:
+		message.int16(int16(length))
+	default:
+		message.int32(int32(length))
+	}
+	return message
+}
+
+func (message *kafkaTestMessage) string(value string) *kafkaTestMessage {
+	message.length(len(value), 2)
+	message.data = append(message.data, value...)
+	return message
+}
+
+func (message *kafkaTestMessage) bytes(value []byte) *kafkaTestMessage {
+	message.length(len(value), 4)
+	message.data = append(message.data, value...)
+	return message
+}
+
+func (message *kafkaTestMessage) array(length int) *kafkaTestMessage {
+	return message.length(length, 4)
+}
+
+func (message *kafkaTestMessage) taggedFields() *kafkaTestMessage {
+	if message.flexible {
+		message.data = append(message.data, 0)
+	}
+	return message
+}
+
+// requestHeader writes a request header, whose client ID is never a compact string
+func (message *kafkaTestMessage) requestHeader(apiKey int16, apiVersion int16, correlationId int32, clientId string) *kafkaTestMessage {
+	message.int16(apiKey).int16(apiVersion).int32(correlationId)
+	message.int16(int16(len(clientId)))
+	message.data = append(message.data, clientId...)
+	return message.taggedFields()
+}
+
+// bytesWithSize returns the message prefixed with its size
+func (message *kafkaTestMessage) bytesWithSize() []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(message.data))), message.data...)
+}
+
+func TestKafkaParserProduceAndFetch(t *testing.T) {
+	stream := newKafkaTestStream()
+	require.IsType(t, &kafkaParser{}, stream.parsers[0])
+	requestTime := time.Now()
+	responseTime := requestTime.Add(time.Millisecond)
+
+	// Produce v3 (non-flexible) to two topics
+	produce := (&kafkaTestMessage{}).requestHeader(0, 3, 41, "orders-service").
+		string("").int16(-1).int32(30000).array(2).
+		string("orders").array(1).int32(0).bytes(make([]byte, 100)).
+		string("audit").array(1).int32(2).bytes(make([]byte, 10)).
+		bytesWithSize()
+	// Fetch v12 (flexible) from one topic
+	fetch := &kafkaTestMessage{flexible: true}
+	fetch.requestHeader(1, 12, 42, "orders-consumer").
+		int32(-1).int32(500).int32(1).int32(52428800).int8(0).int32(0).int32(-1).
+		array(1).string("orders").array(1).
+		int32(0).int32(-1).int64(100).int32(-1).int64(-1).int32(1048576).taggedFields().
+		taggedFields().
+		array(0).string("").taggedFields()
+	// split the requests across segments
+	requests := append(produce, fetch.bytesWithSize()...)
+	stream.parse(requests[:20], 0, requestTime, true, 1)
+	stream.parse(requests[20:], 0, requestTime, true, 2)
+
+	produceResponse := (&kafkaTestMessage{}).int32(41).
+		array(2).
+		string("orders").array(1).int32(0).int16(0).int64(100).int64(-1).
+		string("audit").array(1).int32(2).int16(3).int64(-1).int64(-1).
+		int32(0).
+		bytesWithSize()
+	fetchResponse := &kafkaTestMessage{flexible: true}
+	fetchResponse.int32(42).taggedFields().
+		int32(0).int16(0).int32(0).
+		array(1).string("orders").array(1).
+		int32(0).int16(1).int64(200).int64(200).int64(0).array(0).int32(-1).bytes(make([]byte, 1000)).taggedFields().
+		taggedFields().
+		taggedFields()
+	stream.parse(append(produceResponse, fetchResponse.bytesWithSize()...), 0, responseTime, false, 3)
+
+	require.Len(t, stream.eventsChan, 2)
+	event := (<-stream.eventsChan).(*KafkaEvent)
+	assert.Equal(t, int64(41), event.RequestId())
+	assert.Equal(t, "Produce", event.ApiName())
+	assert.Equal(t, int16(3), event.ApiVersion())
+	assert.Equal(t, int32(41), event.CorrelationId())
+	assert.Equal(t, "orders-service", event.ClientId())
+	assert.Equal(t, []string{"orders", "audit"}, event.Topics())
+	assert.Equal(t, []int16{3}, event.ErrorCodes())
+	assert.Equal(t, "publish", event.Operation())
+	assert.Equal(t, requestTime, event.RequestTimestamp())
+	assert.Equal(t, responseTime, event.ResponseTimestamp())
+	assert.Equal(t, "10.0.0.1", event.SrcIp())
+	assert.Equal(t, "10.0.0.2", event.DstIp())
+
+	event = (<-stream.eventsChan).(*KafkaEvent)
+	assert.Equal(t, "Fetch", event.ApiName())
+	assert.Equal(t, int16(12), event.ApiVersion())
+	assert.Equal(t, "orders-consumer", event.ClientId())
+	assert.Equal(t, []string{"orders"}, event.Topics())
+	assert.Equal(t, []int16{1}, event.ErrorCodes())
+	assert.Equal(t, "receive", event.Operation())
+}
+
+func TestKafkaParserMetadataAndOffsetCommit(t *testing.T) {
+	stream := newKafkaTestStream()
+
+	stream.parse(concatBytes(
+		(&kafkaTestMessage{}).requestHeader(3, 1, 1, "app").
+			array(1).string("orders").
+			bytesWithSize(),
+		(&kafkaTestMessage{}).requestHeader(8, 2, 2, "app").
+			string("orders-group").int32(5).string("member-1").int64(-1).
+			array(1).string("orders").array(1).int32(0).int64(200).string("").
+			bytesWithSize(),
+		// an API we don't decode
+		(&kafkaTestMessage{}).requestHeader(12, 4, 3, "app").
+			bytesWithSize(),
+		// a request that never gets a response
+		(&kafkaTestMessage{}).requestHeader(18, 3, 4, "app").
+			bytesWithSize(),
+		// a produce request that doesn't wait for acknowledgement
+		(&kafkaTestMessage{}).requestHeader(0, 2, 5, "app").
+			int16(0).int32(30000).array(1).string("logs").array(0).
+			bytesWithSize(),
+	), 0, time.Now(), true, 1)
+
+	stream.parse(concatBytes(
+		(&kafkaTestMessage{}).int32(1).
+			array(1).int32(1).string("broker-1").int32(9092).string("").
+			int32(1).
+			array(1).int16(3).string("orders").int8(0).array(0).
+			bytesWithSize(),
+		(&kafkaTestMessage{}).int32(2).
+			array(1).string("orders").array(1).int32(0).int16(25).
+			bytesWithSize(),
+		(&kafkaTestMessage{}).int32(3).int16(0).
+			bytesWithSize(),
+	), 0, time.Now(), false, 2)
+
+	expected := []struct {
+		apiName       string
+		topics        []string
+		consumerGroup string
+		errorCodes    []int16
+		response      bool
+	}{
+		{"Produce", []string{"logs"}, "", nil, false},
+		{"Metadata", []string{"orders"}, "", []int16{3}, true},
+		{"OffsetCommit", []string{"orders"}, "orders-group", []int16{25}, true},
+		{"Heartbeat", nil, "", nil, true},
+	}
+	require.Len(t, stream.eventsChan, len(expected))
+	for _, want := range expected {
+		event := (<-stream.eventsChan).(*KafkaEvent)
+		assert.Equal(t, want.apiName, event.ApiName())
+		assert.Equal(t, want.topics, event.Topics())
+		assert.Equal(t, want.consumerGroup, event.ConsumerGroup())
+		assert.Equal(t, want.errorCodes, event.ErrorCodes())
+		assert.Equal(t, want.response, !event.ResponseTimestamp().IsZero())
+	}
+	assert.Len(t, stream.parsers[0].(*kafkaParser).pending, 1)
+}
+
+func TestKafkaParserDesyncsWhenTooManyRequestsArePending(t *testing.T) {
+	stream := newKafkaTestStream()
+	stream.eventsChan = make(chan Event, kafkaMaxPendingRequests)
+	requestTime := time.Now()
+
+	var requests []byte
+	for i := 0; i <= kafkaMaxPendingRequests; i++ {
+		requests = append(requests, (&kafkaTestMessage{}).requestHeader(12, 4, int32(i), "app").bytesWithSize()...)
+	}
+	stream.parse(requests, 0, requestTime, true, 1)
+
+	// the waiting requests are sent without responses
+	require.Len(t, stream.eventsChan, kafkaMaxPendingRequests)
+	for i := 0; i < kafkaMaxPendingRequests; i++ {
+		event := (<-stream.eventsChan).(*KafkaEvent)
+		assert.Equal(t, int32(i), event.CorrelationId())
+		assert.Equal(t, "Heartbeat", event.ApiName())
+		assert.Equal(t, requestTime, event.RequestTimestamp())
+		assert.True(t, event.ResponseTimestamp().IsZero())
+	}
+
+	// and we no longer follow the connection
+	stream.parse((&kafkaTestMessage{}).int32(0).int16(0).bytesWithSize(), 0, time.Now(), false, 2)
+	stream.parse((&kafkaTestMessage{}).requestHeader(12, 4, 0, "app").bytesWithSize(), 0, time.Now(), true, 3)
+	stream.parse((&kafkaTestMessage{}).int32(0).int16(0).bytesWithSize(), 0, time.Now(), false, 4)
+	assert.Empty(t, stream.eventsChan)
+}
+
+func TestKafkaParserFetchByTopicId(t *testing.T) {
+	ordersId := [16]byte{0x6b, 0x1f, 15: 1}
+	unknownId := [16]byte{0x6b, 0x1f, 15: 2}
+	fetch := func(stream *tcpStream, apiVersion int16, correlationId int32) {
+		request := &kafkaTestMessage{flexible: true}
+		request.requestHeader(1, apiVersion, correlationId, "orders-consumer")
+		if apiVersion <= 14 {
+			request.int32(-1) // replica ID
+		}
+		request.int32(500).int32(1).int32(52428800).int8(0).int32(0).int32(-1).array(2)
+		for _, topicId := range [][16]byte{ordersId, unknownId} {
+			request.data = append(request.data, topicId[:]...)
+			request.array(1).
+				int32(0).int32(-1).int64(100).int32(-1).int64(-1).int32(1048576).taggedFields().
+				taggedFields()
+		}
+		request.array(0).string("").taggedFields()
+		stream.parse(request.bytesWithSize(), 0, time.Now(), true, 1)
+	}
+
+	// Metadata v12 on one connection tells us the orders topic's ID
+	metadataStream := newKafkaTestStream()
+	metadataRequest := &kafkaTestMessage{flexible: true}
+	metadataRequest.requestHeader(3, 12, 1, "orders-consumer").array(1)
+	metadataRequest.data = append(metadataRequest.data, ordersId[:]...)
+	metadataRequest.int8(0).taggedFields() // null name
+	metadataRequest.int8(0).int8(0).taggedFields()
+	metadataStream.parse(metadataRequest.bytesWithSize(), 0, time.Now(), true, 1)
+	metadataResponse := &kafkaTestMessage{flexible: true}
+	metadataResponse.int32(1).taggedFields().
+		int32(0).array(0).string("cluster").int32(1).
+		array(1).int16(0).string("orders")
+	metadataResponse.data = append(metadataResponse.data, ordersId[:]...)
+	metadataResponse.int8(0).array(0).int32(0).taggedFields().
+		taggedFields()
+	metadataStream.parse(metadataResponse.bytesWithSize(), 0, time.Now(), false, 2)
+	require.Len(t, metadataStream.eventsChan, 1)
+
+	for _, apiVersion := range []int16{13, 15} {
+		stream := newKafkaTestStream()
+		fetch(stream, apiVersion, 2)
+		stream.parse((&kafkaTestMessage{flexible: true}).int32(2).taggedFields().
+			int32(0).int16(0).int32(0).array(0).taggedFields().
+			bytesWithSize(), 0, time.Now(), false, 2)
+
+		require.Len(t, stream.eventsChan, 1)
+		event := (<-stream.eventsChan).(*KafkaEvent)
+		assert.Equal(t, apiVersion, event.ApiVersion())
+		assert.Equal(t, []string{"orders"}, event.Topics())
+		assert.Equal(t, []string{"ax8AAAAAAAAAAAAAAAAAAg"}, event.TopicIds())
+	}
+}
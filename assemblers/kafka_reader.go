@@ -0,0 +1,136 @@
+package assemblers
+
+import (
+	"encoding/binary"
+)
+
+// kafkaReader decodes the primitive types used by the Kafka protocol from a message.
+//
+// Flexible message versions use compact (varint length prefixed) strings, arrays and bytes,
+// and add tagged fields to every struct. Reads past the end of the message return zero values
+// and mark the reader as failed, so decoding can stop at the first truncated or invalid field.
+type kafkaReader struct {
+	data     []byte
+	flexible bool
+	failed   bool
+}
+
+func (reader *kafkaReader) ok() bool {
+	return !reader.failed
+}
+
+func (reader *kafkaReader) take(n int) []byte {
+	if reader.failed || n < 0 || n > len(reader.data) {
+		reader.failed = true
+		return nil
+	}
+	data := reader.data[:n]
+	reader.data = reader.data[n:]
+	return data
+}
+
+func (reader *kafkaReader) skip(n int) {
+	reader.take(n)
+}
+
+func (reader *kafkaReader) uuid() [16]byte {
+	var uuid [16]byte
+	copy(uuid[:], reader.take(16))
+	return uuid
+}
+
+func (reader *kafkaReader) int8() int8 {
+	if data := reader.take(1); data != nil {
+		return int8(data[0])
+	}
+	return 0
+}
+
+func (reader *kafkaReader) int16() int16 {
+	if data := reader.take(2); data != nil {
+		return int16(binary.BigEndian.Uint16(data))
+	}
+	return 0
+}
+
+func (reader *kafkaReader) int32() int32 {
+	if data := reader.take(4); data != nil {
+		return int32(binary.BigEndian.Uint32(data))
+	}
+	return 0
+}
+
+func (reader *kafkaReader) uvarint() uint64 {
+	if reader.failed {
+		return 0
+	}
+	value, n := binary.Uvarint(reader.data)
+	if n <= 0 {
+		reader.failed = true
+		return 0
+	}
+	reader.data = reader.data[n:]
+	return value
+}
+
+// length reads the length of a string, array or bytes field, which is -1 for null values.
+// Non-flexible versions use a fixed size prefix, flexible versions a varint of the length plus one.
+func (reader *kafkaReader) length(fixedSize int) int {
+	var length int
+	if reader.flexible {
+		length = int(reader.uvarint()) - 1
+	} else if fixedSize == 2 {
+		length = int(reader.int16())
+	} else {
+		length = int(reader.int32())
+	}
+	if length < -1 {
+		reader.failed = true
+	}
+	return length
+}
+
+// string reads a (nullable) string, returning "" for null strings
+func (reader *kafkaReader) string() string {
+	length := reader.length(2)
+	if length <= 0 {
+		return ""
+	}
+	return string(reader.take(length))
+}
+
+// arrayLength reads the number of elements in an array, which is 0 for null arrays
+func (reader *kafkaReader) arrayLength() int {
+	length := reader.length(4)
+	// every element takes at least one byte, so a longer array can't be valid
+	if length > len(reader.data) {
+		reader.failed = true
+	}
+	if length < 0 || reader.failed {
+		return 0
+	}
+	return length
+}
+
+// skipBytes skips a (nullable) bytes field
+func (reader *kafkaReader) skipBytes() {
+	if length := reader.length(4); length > 0 {
+		reader.skip(length)
+	}
+}
+
+// skipInt32Array skips an array of int32 values
+func (reader *kafkaReader) skipInt32Array() {
+	reader.skip(reader.arrayLength() * 4)
+}
+
+// skipTaggedFields skips the tagged fields at the end of a struct in flexible versions
+func (reader *kafkaReader) skipTaggedFields() {
+	if !reader.flexible {
+		return
+	}
+	for count := reader.uvarint(); count > 0 && reader.ok(); count-- {
+		reader.uvarint() // tag
+		reader.skip(int(reader.uvarint()))
+	}
+}
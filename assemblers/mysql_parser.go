@@ -0,0 +1,357 @@
+package assemblers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	// mysqlMaxCapture is the number of bytes kept from each packet, enough for all but the largest queries
+	mysqlMaxCapture = 64 * 1024
+	// mysqlMaxPacketLength is the largest payload a packet can carry,
+	// larger payloads are split into packets of this length followed by a shorter one
+	mysqlMaxPacketLength = 0xffffff
+	// mysqlMaxPreparedStatements limits how many prepared statements we remember per connection
+	mysqlMaxPreparedStatements = 1000
+
+	mysqlComQuery       = 0x03
+	mysqlComStmtPrepare = 0x16
+	mysqlComStmtExecute = 0x17
+	mysqlComStmtClose   = 0x19
+
+	mysqlClientCompress        = 0x00000020
+	mysqlClientSsl             = 0x00000800
+	mysqlClientQueryAttributes = 0x08000000
+
+	mysqlServerMoreResultsExist = 0x0008
+)
+
+// mysqlResponseState is how far through the response to a command the server is
+type mysqlResponseState int
+
+const (
+	// waiting for an OK, ERR or the start of a result set
+	mysqlAwaitingResponse mysqlResponseState = iota
+	// reading the column definitions of a result set
+	mysqlColumnDefinitions
+	// read all column definitions, the next packet is either an EOF or the first row
+	mysqlColumnDefinitionsDone
+	// reading the rows of a result set
+	mysqlRows
+)
+
+// mysqlParser parses the MySQL client/server protocol.
+//
+// Queries (COM_QUERY) and prepared statement executions (COM_STMT_EXECUTE) are matched to the
+// OK, ERR or result set the server responds with, sending a SqlEvent for each one. Prepared
+// statements (COM_STMT_PREPARE) are remembered so executions can report their statement, and
+// only send an event if they fail.
+//
+// The protocol is strictly request/response, so there is only ever one command waiting for its response.
+type mysqlParser struct {
+	client *framedReader
+	server *framedReader
+	// the previous packet was full, so the next one continues its payload
+	clientContinued bool
+	serverContinued bool
+	// capabilities the client asked for in its handshake response, if we saw it
+	capabilities uint32
+	// the connection is encrypted or compressed, so there's nothing more we can parse
+	unreadable bool
+
+	statements    map[uint32]string
+	command       *mysqlCommand
+	commandsCount int64
+}
+
+// mysqlCommand is a command waiting for its response
+type mysqlCommand struct {
+	id           int64
+	kind         byte
+	statement    string
+	timestamp    time.Time
+	packetCount  int
+	state        mysqlResponseState
+	columns      uint64
+	rowsAffected int64
+}
+
+func newMysqlParser() *mysqlParser {
+	return &mysqlParser{
+		client:     newFramedReader(4, mysqlMaxCapture, parseMysqlHeader),
+		server:     newFramedReader(4, mysqlMaxCapture, parseMysqlHeader),
+		statements: make(map[uint32]string),
+	}
+}
+
+// parseMysqlHeader parses a packet header, made up of the payload length (3 bytes, little endian) and a sequence ID
+func parseMysqlHeader(header []byte) (int, bool) {
+	return int(header[0]) | int(header[1])<<8 | int(header[2])<<16, true
+}
+
+// parse reads MySQL packets sent by the client and server, sending a SqlEvent for each completed command
+func (parser *mysqlParser) parse(stream *tcpStream, requestId int64, timestamp time.Time, isClient bool, buffer *bufio.Reader, packetCount int) (bool, error) {
+	if parser.unreadable {
+		return true, nil
+	}
+	data, err := io.ReadAll(buffer)
+	if err != nil || len(data) == 0 {
+		return false, err
+	}
+
+	if isClient {
+		err = parser.client.read(data, func(header []byte, payload []byte, truncated bool) {
+			continuation := parser.clientContinued
+			parser.clientContinued = isFullMysqlPacket(header)
+			if !continuation {
+				parser.handleClientPacket(header[3], payload, timestamp, packetCount)
+			}
+		})
+	} else {
+		err = parser.server.read(data, func(header []byte, payload []byte, truncated bool) {
+			continuation := parser.serverContinued
+			parser.serverContinued = isFullMysqlPacket(header)
+			if !continuation {
+				parser.handleServerPacket(stream, payload, isFullMysqlPacket(header), timestamp, packetCount)
+			}
+		})
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func isFullMysqlPacket(header []byte) bool {
+	length, _ := parseMysqlHeader(header)
+	return length == mysqlMaxPacketLength
+}
+
+func (parser *mysqlParser) handleClientPacket(sequenceId byte, payload []byte, timestamp time.Time, packetCount int) {
+	if len(payload) == 0 {
+		return
+	}
+	// the handshake response is the only packet a client sends with sequence ID 1
+	if sequenceId == 1 {
+		if len(payload) >= 4 {
+			parser.capabilities = binary.LittleEndian.Uint32(payload)
+			// an SSL request is a truncated handshake response, sent in the clear before the TLS handshake
+			if parser.capabilities&mysqlClientSsl != 0 && len(payload) == 32 {
+				parser.unreadable = true
+			}
+			if parser.capabilities&mysqlClientCompress != 0 {
+				parser.unreadable = true
+			}
+		}
+		return
+	}
+	// commands always start a new sequence, anything else is eg the contents of a LOAD DATA LOCAL file
+	if sequenceId != 0 {
+		return
+	}
+
+	parser.command = nil
+	command := &mysqlCommand{
+		kind:         payload[0],
+		timestamp:    timestamp,
+		packetCount:  packetCount,
+		rowsAffected: -1,
+	}
+	switch payload[0] {
+	case mysqlComQuery:
+		command.statement = parser.queryStatement(payload[1:])
+	case mysqlComStmtPrepare:
+		command.statement = string(payload[1:])
+	case mysqlComStmtExecute:
+		if len(payload) < 5 {
+			return
+		}
+		command.statement = parser.statements[binary.LittleEndian.Uint32(payload[1:])]
+	case mysqlComStmtClose:
+		if len(payload) >= 5 {
+			delete(parser.statements, binary.LittleEndian.Uint32(payload[1:]))
+		}
+		return
+	default:
+		// other commands (eg COM_PING) aren't reported
+		return
+	}
+	parser.commandsCount++
+	command.id = parser.commandsCount
+	command.statement = normalizeSql(command.statement, mysqlDialect)
+	parser.command = command
+}
+
+// queryStatement returns the statement from a COM_QUERY payload
+func (parser *mysqlParser) queryStatement(payload []byte) string {
+	if parser.capabilities&mysqlClientQueryAttributes == 0 {
+		return string(payload)
+	}
+	// query attributes come before the statement, we only skip the (common) case where there aren't any
+	paramCount, n := readMysqlLengthEncodedInt(payload)
+	if n == 0 || paramCount != 0 {
+		return ""
+	}
+	_, m := readMysqlLengthEncodedInt(payload[n:])
+	return string(payload[n+m:])
+}
+
+func (parser *mysqlParser) handleServerPacket(stream *tcpStream, payload []byte, full bool, timestamp time.Time, packetCount int) {
+	command := parser.command
+	if command == nil || len(payload) == 0 {
+		return
+	}
+
+	switch command.state {
+	case mysqlAwaitingResponse:
+		switch payload[0] {
+		case 0x00: // OK
+			if command.kind == mysqlComStmtPrepare {
+				// remember the statement, the column and parameter definitions that follow aren't needed
+				if len(payload) >= 5 {
+					if len(parser.statements) >= mysqlMaxPreparedStatements {
+						clear(parser.statements)
+					}
+					parser.statements[binary.LittleEndian.Uint32(payload[1:])] = command.statement
+				}
+				parser.command = nil
+				return
+			}
+			affectedRows, statusFlags := parseMysqlOk(payload)
+			command.rowsAffected = max(command.rowsAffected, 0) + affectedRows
+			parser.completeResult(stream, statusFlags, timestamp, packetCount)
+		case 0xff: // ERR
+			parser.completeError(stream, payload, timestamp, packetCount)
+		case 0xfb:
+			// LOCAL INFILE request, the server responds once the client has sent the file
+		default:
+			columns, n := readMysqlLengthEncodedInt(payload)
+			if n == 0 || columns == 0 {
+				parser.command = nil
+				return
+			}
+			command.columns = columns
+			command.state = mysqlColumnDefinitions
+		}
+	case mysqlColumnDefinitions:
+		command.columns--
+		if command.columns == 0 {
+			command.state = mysqlColumnDefinitionsDone
+		}
+	case mysqlColumnDefinitionsDone:
+		command.state = mysqlRows
+		// unless the client asked for CLIENT_DEPRECATE_EOF, an EOF packet separates the columns from the rows.
+		// EOF packets are always 5 bytes, while the OK packet that ends a result set without any rows is longer.
+		if payload[0] == 0xfe && len(payload) == 5 {
+			return
+		}
+		parser.handleServerPacket(stream, payload, full, timestamp, packetCount)
+	case mysqlRows:
+		switch {
+		case payload[0] == 0xfe && !full: // EOF or OK
+			var statusFlags uint16
+			if len(payload) == 5 {
+				statusFlags = binary.LittleEndian.Uint16(payload[3:])
+			} else {
+				_, statusFlags = parseMysqlOk(payload)
+			}
+			command.rowsAffected = max(command.rowsAffected, 0)
+			parser.completeResult(stream, statusFlags, timestamp, packetCount)
+		case payload[0] == 0xff:
+			parser.completeError(stream, payload, timestamp, packetCount)
+		default:
+			command.rowsAffected = max(command.rowsAffected, 0) + 1
+		}
+	}
+}
+
+// completeResult sends a SqlEvent for the command, unless the server has told us more results follow
+func (parser *mysqlParser) completeResult(stream *tcpStream, statusFlags uint16, timestamp time.Time, packetCount int) {
+	if statusFlags&mysqlServerMoreResultsExist != 0 {
+		parser.command.state = mysqlAwaitingResponse
+		return
+	}
+	parser.sendEvent(stream, "", "", timestamp, packetCount)
+}
+
+// completeError sends a SqlEvent for the command with the error from an ERR packet
+func (parser *mysqlParser) completeError(stream *tcpStream, payload []byte, timestamp time.Time, packetCount int) {
+	var errorCode, errorMessage string
+	if len(payload) >= 3 {
+		errorCode = strconv.Itoa(int(binary.LittleEndian.Uint16(payload[1:])))
+		message := payload[3:]
+		// skip the SQL state marker and SQL state
+		if len(message) >= 6 && message[0] == '#' {
+			message = message[6:]
+		}
+		errorMessage = string(message)
+	}
+	parser.sendEvent(stream, errorCode, errorMessage, timestamp, packetCount)
+}
+
+func (parser *mysqlParser) sendEvent(stream *tcpStream, errorCode string, errorMessage string, timestamp time.Time, packetCount int) {
+	command := parser.command
+	parser.command = nil
+	stream.sendEvent(NewSqlEvent(
+		stream.ident,
+		command.id,
+		command.timestamp,
+		timestamp,
+		command.packetCount,
+		packetCount,
+		stream.srcIP,
+		stream.dstIP,
+		"mysql",
+		command.statement,
+		command.rowsAffected,
+		errorCode,
+		errorMessage,
+	))
+}
+
+// parseMysqlOk returns the affected rows and status flags from an OK packet
+func parseMysqlOk(payload []byte) (int64, uint16) {
+	payload = payload[1:]
+	affectedRows, n := readMysqlLengthEncodedInt(payload)
+	payload = payload[n:]
+	// last insert ID
+	_, n = readMysqlLengthEncodedInt(payload)
+	payload = payload[n:]
+	var statusFlags uint16
+	if len(payload) >= 2 {
+		statusFlags = binary.LittleEndian.Uint16(payload)
+	}
+	return int64(affectedRows), statusFlags
+}
+
+// readMysqlLengthEncodedInt reads a length-encoded integer,
+// returning its value and the number of bytes read (0 if it isn't valid)
+func readMysqlLengthEncodedInt(data []byte) (uint64, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	var size int
+	switch data[0] {
+	case 0xfc:
+		size = 2
+	case 0xfd:
+		size = 3
+	case 0xfe:
+		size = 8
+	case 0xfb, 0xff:
+		return 0, 0
+	default:
+		return uint64(data[0]), 1
+	}
+	if len(data) < size+1 {
+		return 0, 0
+	}
+	var value uint64
+	for i := size; i > 0; i-- {
+		value = value<<8 | uint64(data[i])
+	}
+	return value, size + 1
+}
@@ -0,0 +1,157 @@
+package assemblers
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+func newMysqlTestStream() *tcpStream {
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	transportFlow := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0xd4, 0x31}, []byte{0x0c, 0xea}) // 54321 -> 3306
+	return NewTcpStream(netFlow, transportFlow, config.Config{
+		MySQLPorts: []string{"3306"},
+	}, make(chan Event, 10))
+}
+
+// mysqlPacket builds a packet with the given sequence ID whose payload is the given parts joined together
+func mysqlPacket(sequenceId byte, parts ...string) []byte {
+	var payload []byte
+	for _, part := range parts {
+		payload = append(payload, part...)
+	}
+	packet := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), sequenceId}
+	return append(packet, payload...)
+}
+
+func mysqlHandshakeResponse(capabilities uint32, length int) []byte {
+	payload := make([]byte, length)
+	binary.LittleEndian.PutUint32(payload, capabilities)
+	return mysqlPacket(1, string(payload))
+}
+
+func TestMysqlParserQueries(t *testing.T) {
+	stream := newMysqlTestStream()
+	require.IsType(t, &mysqlParser{}, stream.parsers[0])
+	requestTime := time.Now()
+	responseTime := requestTime.Add(time.Millisecond)
+
+	// the server greets the client, which logs in
+	stream.parse(mysqlPacket(0, "\x0a8.0.36\x00"), 0, requestTime, false, 1)
+	stream.parse(mysqlHandshakeResponse(0x000fa685, 64), 0, requestTime, true, 1)
+	stream.parse(mysqlPacket(2, "\x00\x00\x00\x02\x00\x00\x00"), 0, requestTime, false, 2)
+
+	// a result set with an EOF packet after the column definitions
+	query := mysqlPacket(0, "\x03", "SELECT name, email FROM users WHERE id = 42 AND name = 'bob'")
+	stream.parse(query[:10], 0, requestTime, true, 2)
+	stream.parse(query[10:], 0, requestTime, true, 3)
+	stream.parse(concatBytes(
+		mysqlPacket(1, "\x02"),
+		mysqlPacket(2, "\x03def\x00\x05users\x05users\x04name\x04name"),
+		mysqlPacket(3, "\x03def\x00\x05users\x05users\x05email\x05email"),
+		mysqlPacket(4, "\xfe\x00\x00\x02\x00"),
+		mysqlPacket(5, "\x03bob\x0fbob@example.com"),
+		mysqlPacket(6, "\x03bob\x0fbob@example.org"),
+		mysqlPacket(7, "\xfe\x00\x00\x02\x00"),
+	), 0, responseTime, false, 4)
+
+	// an OK packet with affected rows
+	stream.parse(mysqlPacket(0, "\x03", "UPDATE users SET active = 1 WHERE created < \"2020-01-01\""), 0, requestTime, true, 5)
+	stream.parse(mysqlPacket(1, "\x00\x03\x00\x02\x00\x00\x00"), 0, responseTime, false, 6)
+
+	// an error
+	stream.parse(mysqlPacket(0, "\x03", "SELECT * FROM missing"), 0, requestTime, true, 7)
+	stream.parse(mysqlPacket(1, "\xff\x7a\x04#42S02Table 'app.missing' doesn't exist"), 0, responseTime, false, 8)
+
+	// commands that aren't reported (COM_PING)
+	stream.parse(mysqlPacket(0, "\x0e"), 0, requestTime, true, 9)
+	stream.parse(mysqlPacket(1, "\x00\x00\x00\x02\x00\x00\x00"), 0, responseTime, false, 10)
+
+	// multiple statements, with SERVER_MORE_RESULTS_EXISTS set on the first OK
+	stream.parse(mysqlPacket(0, "\x03", "DELETE FROM a; DELETE FROM b"), 0, requestTime, true, 11)
+	stream.parse(concatBytes(
+		mysqlPacket(1, "\x00\x02\x00\x0a\x00\x00\x00"),
+		mysqlPacket(2, "\x00\x05\x00\x02\x00\x00\x00"),
+	), 0, responseTime, false, 12)
+
+	expected := []struct {
+		statement    string
+		rowsAffected int64
+		errorCode    string
+		errorMessage string
+	}{
+		{"SELECT name, email FROM users WHERE id = ? AND name = ?", 2, "", ""},
+		{"UPDATE users SET active = ? WHERE created < ?", 3, "", ""},
+		{"SELECT * FROM missing", -1, "1146", "Table 'app.missing' doesn't exist"},
+		{"DELETE FROM a; DELETE FROM b", 7, "", ""},
+	}
+	require.Len(t, stream.eventsChan, len(expected))
+	for i, want := range expected {
+		event := (<-stream.eventsChan).(*SqlEvent)
+		assert.Equal(t, int64(i+1), event.RequestId())
+		assert.Equal(t, "mysql", event.DbSystem())
+		assert.Equal(t, want.statement, event.Statement())
+		assert.Equal(t, want.rowsAffected, event.RowsAffected())
+		assert.Equal(t, want.errorCode, event.ErrorCode())
+		assert.Equal(t, want.errorMessage, event.ErrorMessage())
+		assert.Equal(t, requestTime, event.RequestTimestamp())
+		assert.Equal(t, responseTime, event.ResponseTimestamp())
+		assert.Equal(t, "10.0.0.1", event.SrcIp())
+		assert.Equal(t, "10.0.0.2", event.DstIp())
+	}
+}
+
+func TestMysqlParserPreparedStatements(t *testing.T) {
+	stream := newMysqlTestStream()
+
+	// we joined the connection after the handshake, and the client uses CLIENT_DEPRECATE_EOF
+	stream.parse(mysqlPacket(0, "\x16", "SELECT id FROM users WHERE name = ?"), 0, time.Now(), true, 1)
+	stream.parse(concatBytes(
+		mysqlPacket(1, "\x00\x07\x00\x00\x00\x01\x00\x01\x00\x00\x00\x00"),
+		mysqlPacket(2, "\x03def\x00\x00\x00\x01?\x00"),
+		mysqlPacket(3, "\x03def\x00\x05users\x05users\x02id\x02id"),
+	), 0, time.Now(), false, 2)
+
+	stream.parse(mysqlPacket(0, "\x17\x07\x00\x00\x00\x00\x01\x00\x00\x00\x00\x01\xfe\x00\x03bob"), 0, time.Now(), true, 3)
+	stream.parse(concatBytes(
+		mysqlPacket(1, "\x01"),
+		mysqlPacket(2, "\x03def\x00\x05users\x05users\x02id\x02id"),
+		mysqlPacket(3, "\x00\x00\x01\x00\x00\x00"),
+		mysqlPacket(4, "\xfe\x00\x00\x02\x00\x00\x00"),
+	), 0, time.Now(), false, 4)
+
+	// closing the statement doesn't get a response
+	stream.parse(mysqlPacket(0, "\x19\x07\x00\x00\x00"), 0, time.Now(), true, 5)
+	stream.parse(mysqlPacket(0, "\x16", "SELEC 1"), 0, time.Now(), true, 6)
+	stream.parse(mysqlPacket(1, "\xff\x28\x04#42000You have an error in your SQL syntax"), 0, time.Now(), false, 7)
+
+	require.Len(t, stream.eventsChan, 2)
+	event := (<-stream.eventsChan).(*SqlEvent)
+	assert.Equal(t, int64(2), event.RequestId())
+	assert.Equal(t, "SELECT id FROM users WHERE name = ?", event.Statement())
+	assert.Equal(t, int64(1), event.RowsAffected())
+	assert.Equal(t, "", event.ErrorCode())
+
+	event = (<-stream.eventsChan).(*SqlEvent)
+	assert.Equal(t, "SELEC ?", event.Statement())
+	assert.Equal(t, "1064", event.ErrorCode())
+	assert.Empty(t, stream.parsers[0].(*mysqlParser).statements)
+}
+
+func TestMysqlParserStopsAfterSslRequest(t *testing.T) {
+	stream := newMysqlTestStream()
+
+	stream.parse(mysqlPacket(0, "\x0a8.0.36\x00"), 0, time.Now(), false, 1)
+	stream.parse(mysqlHandshakeResponse(0x000fae85, 32), 0, time.Now(), true, 1)
+	stream.parse(mysqlPacket(0, "\x03", "SELECT 1"), 0, time.Now(), true, 2)
+
+	assert.True(t, stream.parsers[0].(*mysqlParser).unreadable)
+	assert.Nil(t, stream.parsers[0].(*mysqlParser).command)
+}
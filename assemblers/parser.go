@@ -2,10 +2,82 @@ package assemblers
 
 import (
 	"bufio"
+	"slices"
 	"time"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
 )
 
 // parser parses a request or response
 type parser interface {
 	parse(stream *tcpStream, requestId int64, timestamp time.Time, isClient bool, buffer *bufio.Reader, packetCount int) (bool, error)
 }
+
+// streamCloser is implemented by parsers that need to know when their stream closes,
+// eg to report requests that never got a response
+type streamCloser interface {
+	close(stream *tcpStream)
+}
+
+// streamEvicter is implemented by parsers that hold messages waiting for their counterpart,
+// so they can be evicted periodically rather than only when more data arrives
+type streamEvicter interface {
+	evict(stream *tcpStream, now time.Time)
+}
+
+// newStreamParsers returns the parsers used for a new TCP stream between the given ports.
+//
+// Binary protocols can't be reliably recognised from their content, so they are parsed
+// based on the server's port. The returned bool is true when srcPort is the server's port,
+// which happens when the first packet we see for a connection was sent by the server.
+func newStreamParsers(config config.Config, srcPort string, dstPort string) ([]parser, bool) {
+	portParsers := []struct {
+		ports     []string
+		newParser func() parser
+	}{
+		{config.RedisPorts, func() parser { return newRedisParser() }},
+		{config.PostgresPorts, func() parser { return newPostgresParser() }},
+		{config.MySQLPorts, func() parser { return newMysqlParser() }},
+		{config.KafkaPorts, func() parser { return newKafkaParser() }},
+		{config.TLSPorts, func() parser { return newTlsParser() }},
+	}
+	for _, portParser := range portParsers {
+		switch {
+		case slices.Contains(portParser.ports, dstPort):
+			return []parser{portParser.newParser()}, false
+		case slices.Contains(portParser.ports, srcPort):
+			return []parser{portParser.newParser()}, true
+		}
+	}
+
+	http2Parser := newHttp2Parser(config.HTTPHeadersToExtract, config.HTTPMatcherTTL, config.HTTPMatcherMaxEntries)
+	// the HTTP/2 parser goes first as it only claims connections that start with the HTTP/2 preface
+	return []parser{
+		http2Parser,
+		newHttpParser(
+			config.HTTPHeadersToExtract,
+			newRequestResponseMatcher(config.HTTPMatcherTTL, config.HTTPMatcherMaxEntries),
+			http2Parser,
+			newHttpBodyCaptureOptions(config),
+		),
+	}, false
+}
+
+// capturesAllPackets returns true if the BPF filter captures every packet between the ports.
+// Other connections are only captured by packets that start with a HTTP request or response.
+func capturesAllPackets(config config.Config, srcPort string, dstPort string) bool {
+	for _, ports := range [][]string{
+		config.HTTPPorts,
+		config.HTTP2Ports,
+		config.RedisPorts,
+		config.PostgresPorts,
+		config.MySQLPorts,
+		config.KafkaPorts,
+		config.TLSPorts,
+	} {
+		if slices.Contains(ports, srcPort) || slices.Contains(ports, dstPort) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package assemblers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/pcap"
+	"github.com/rs/zerolog/log"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+// newPcapFilePacketSource creates a packet source that reads packets from a pcap or pcapng capture file.
+// The packet source's channel is closed once all packets in the file have been read.
+func newPcapFilePacketSource(config config.Config) (*gopacket.PacketSource, error) {
+	log.Debug().
+		Str("pcap_file", config.PcapFile).
+		Bool("realtime", config.PcapFileRealtime).
+		Str("bpf_filter", config.BpfFilter).
+		Msg("Configuring pcap file packet source")
+	handle, err := pcap.OpenOffline(config.PcapFile)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("pcap_file", config.PcapFile).
+			Msg("Failed to open pcap file")
+		return nil, err
+	}
+	if config.BpfFilter != "" {
+		if err = handle.SetBPFFilter(config.BpfFilter); err != nil {
+			log.Error().
+				Err(err).
+				Msg("Error setting BPF filter")
+			return nil, err
+		}
+	}
+
+	return gopacket.NewPacketSource(
+		handle,
+		handle.LinkType(),
+	), nil
+}
+
+// replayPacer delays packets read from a capture file so they are processed
+// at the same pace they were originally captured.
+type replayPacer struct {
+	startedAt       time.Time
+	firstCapturedAt time.Time
+}
+
+// wait blocks until a packet with the given capture timestamp is due to be processed,
+// relative to when the first packet was processed.
+//
+// Returns false if the context is cancelled while waiting.
+func (p *replayPacer) wait(ctx context.Context, capturedAt time.Time) bool {
+	if p.startedAt.IsZero() {
+		p.startedAt = time.Now()
+		p.firstCapturedAt = capturedAt
+		return true
+	}
+
+	delay := time.Until(p.startedAt.Add(capturedAt.Sub(p.firstCapturedAt)))
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,152 @@
+package assemblers
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+// testPacket describes a TCP segment written to a test capture
+type testPacket struct {
+	fromClient bool
+	syn, ack   bool
+	fin        bool
+	seq, ackNo uint32
+	payload    string
+	offset     time.Duration
+}
+
+// writeTestCapture serializes the given TCP segments between a client and server into an in-memory pcap capture
+func writeTestCapture(t *testing.T, start time.Time, packets []testPacket) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	writer := pcapgo.NewWriter(buf)
+	require.NoError(t, writer.WriteFileHeader(65536, layers.LinkTypeEthernet))
+
+	for _, p := range packets {
+		data := serializeTestPacket(t, 54321, p)
+		ci := gopacket.CaptureInfo{
+			Timestamp:     start.Add(p.offset),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}
+		require.NoError(t, writer.WritePacket(ci, data))
+	}
+	return buf
+}
+
+// serializeTestPacket serializes a TCP segment between a client using the given port and a server on port 8080
+func serializeTestPacket(t *testing.T, clientPort layers.TCPPort, p testPacket) []byte {
+	clientIP, serverIP := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	clientMAC := net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	serverMAC := net.HardwareAddr{0, 0, 0, 0, 0, 2}
+	eth := &layers.Ethernet{SrcMAC: clientMAC, DstMAC: serverMAC, EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: clientIP, DstIP: serverIP}
+	tcp := &layers.TCP{SrcPort: clientPort, DstPort: 8080, Seq: p.seq, Ack: p.ackNo, SYN: p.syn, ACK: p.ack, FIN: p.fin, Window: 65535}
+	if !p.fromClient {
+		eth.SrcMAC, eth.DstMAC = serverMAC, clientMAC
+		ip.SrcIP, ip.DstIP = serverIP, clientIP
+		tcp.SrcPort, tcp.DstPort = 8080, clientPort
+	}
+	require.NoError(t, tcp.SetNetworkLayerForChecksum(ip))
+
+	data := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(data, opts, eth, ip, tcp, gopacket.Payload(p.payload)))
+	return data.Bytes()
+}
+
+// testHttpExchange is a complete HTTP request/response exchange, including the TCP handshake and close
+func testHttpExchange() []testPacket {
+	request := "GET /check HTTP/1.1\r\nHost: example.com\r\nUser-Agent: teapot-checker/1.0\r\n\r\n"
+	response := "HTTP/1.1 418 I'm a teapot\r\nContent-Length: 0\r\n\r\n"
+	return []testPacket{
+		{fromClient: true, syn: true, seq: 100},
+		{fromClient: false, syn: true, ack: true, seq: 500, ackNo: 101, offset: time.Millisecond},
+		{fromClient: true, ack: true, seq: 101, ackNo: 501, offset: 2 * time.Millisecond},
+		{fromClient: true, ack: true, seq: 101, ackNo: 501, payload: request, offset: 3 * time.Millisecond},
+		{fromClient: false, ack: true, seq: 501, ackNo: 101 + uint32(len(request)), payload: response, offset: 8 * time.Millisecond},
+		{fromClient: true, ack: true, fin: true, seq: 101 + uint32(len(request)), ackNo: 501 + uint32(len(response)), offset: 9 * time.Millisecond},
+	}
+}
+
+func newTestAssemblerConfig() config.Config {
+	return config.Config{
+		Nooptcheck:         true,
+		Ignorefsmerr:       true,
+		StreamFlushTimeout: 10 * time.Second,
+		StreamCloseTimeout: 90 * time.Second,
+		PacketSource:       "file",
+		HTTPHeadersToExtract: []string{
+			"User-Agent",
+		},
+	}
+}
+
+func TestReplayCaptureFileEmitsEventsAndStopsAtEOF(t *testing.T) {
+	captureStart := time.Date(2023, 11, 1, 12, 0, 0, 0, time.UTC)
+	capture := writeTestCapture(t, captureStart, testHttpExchange())
+
+	reader, err := pcapgo.NewReader(capture)
+	require.NoError(t, err)
+	packetSource := gopacket.NewPacketSource(reader, reader.LinkType())
+
+	eventsChan := make(chan Event, 10)
+	assembler := newTcpAssembler(newTestAssemblerConfig(), packetSource, eventsChan)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go assembler.Start(context.Background(), &wg)
+
+	select {
+	case <-assembler.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("assembler did not stop at the end of the capture file")
+	}
+	wg.Wait()
+
+	require.Len(t, eventsChan, 1)
+	event := (<-eventsChan).(*HttpEvent)
+	assert.Equal(t, "GET", event.Request().Method)
+	assert.Equal(t, "/check", event.Request().RequestURI)
+	assert.Equal(t, "teapot-checker/1.0", event.Request().Header.Get("User-Agent"))
+	assert.Equal(t, 418, event.Response().StatusCode)
+	assert.Equal(t, "10.0.0.1", event.SrcIp())
+	assert.Equal(t, "10.0.0.2", event.DstIp())
+	// timestamps come from the capture, not the wall clock
+	assert.Equal(t, captureStart.Add(3*time.Millisecond), event.RequestTimestamp().UTC())
+	assert.Equal(t, captureStart.Add(8*time.Millisecond), event.ResponseTimestamp().UTC())
+	// the assembler's clock follows the capture
+	assert.Equal(t, captureStart.Add(9*time.Millisecond), assembler.now().UTC())
+	// the events channel is closed once the assembler has sent its last event
+	_, ok := <-eventsChan
+	assert.False(t, ok)
+}
+
+func TestReplayPacerWaitsForCaptureOffsets(t *testing.T) {
+	pacer := &replayPacer{}
+	capturedAt := time.Date(2023, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	// first packet is processed immediately
+	assert.True(t, pacer.wait(context.Background(), capturedAt))
+
+	// next packet waits for the same gap as in the capture
+	started := time.Now()
+	assert.True(t, pacer.wait(context.Background(), capturedAt.Add(50*time.Millisecond)))
+	assert.GreaterOrEqual(t, time.Since(started), 40*time.Millisecond)
+
+	// waiting is abandoned when the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, pacer.wait(ctx, capturedAt.Add(time.Hour)))
+}
@@ -0,0 +1,362 @@
+package assemblers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// postgresMaxCapture is the number of bytes kept from each message, enough for all but the largest queries
+	postgresMaxCapture = 64 * 1024
+	// postgresMaxMessageLength is the largest message length the protocol allows
+	postgresMaxMessageLength = 1 << 30
+	// postgresMaxPendingQueries limits how many queries we hold on to while waiting for their results
+	postgresMaxPendingQueries = 1000
+	// postgresMaxPreparedStatements limits how many prepared statements we remember per connection
+	postgresMaxPreparedStatements = 1000
+
+	postgresProtocolVersion3 = 196608
+	postgresSslRequestCode   = 80877103
+	postgresGssEncRequest    = 80877104
+)
+
+// postgresFrontendMessageTypes are the message types sent by clients after startup
+const postgresFrontendMessageTypes = "BCDEFHPQSXcdfp"
+
+// postgresBackendMessageTypes are the message types sent by servers
+const postgresBackendMessageTypes = "123ACDEGHIKNRSTVWZcdnpst"
+
+// postgresQueryKind is the kind of client message we're waiting on a result for
+type postgresQueryKind int
+
+const (
+	// a Query message using the simple query protocol, complete when the server is ready for the next query
+	postgresSimpleQuery postgresQueryKind = iota
+	// an Execute message using the extended query protocol, complete when the portal has run
+	postgresExecute
+	// a Sync message, which ends an extended query protocol batch
+	postgresSync
+)
+
+// postgresParser parses the PostgreSQL frontend/backend protocol (version 3).
+//
+// Queries sent using the simple query protocol (Query) and the extended query protocol
+// (Parse/Bind/Execute/Sync) are matched to their CommandComplete or ErrorResponse messages,
+// sending a SqlEvent for each query. If too many queries wait for their results, we've lost track
+// of which result belongs to which query, so we stop following the connection.
+type postgresParser struct {
+	frontend *framedReader
+	backend  *framedReader
+	// set once we've worked out whether the client sent a startup message or we joined mid-connection
+	frontendStarted bool
+	// the client asked to encrypt the connection and is waiting for the server to accept or refuse
+	awaitingEncryptionResponse bool
+	// the server agreed to encrypt the connection, so there's nothing more we can parse
+	encrypted bool
+
+	// queries of prepared statements and portals, by name, used to find the query run by Execute
+	statements   map[string]string
+	portals      map[string]string
+	pending      []*postgresQuery
+	queriesCount int64
+}
+
+// postgresQuery is a query waiting for its result
+type postgresQuery struct {
+	id           int64
+	kind         postgresQueryKind
+	statement    string
+	timestamp    time.Time
+	packetCount  int
+	rowsAffected int64
+	errorCode    string
+	errorMessage string
+}
+
+func newPostgresParser() *postgresParser {
+	return &postgresParser{
+		backend:    newFramedReader(5, postgresMaxCapture, parsePostgresTypedHeader(postgresBackendMessageTypes)),
+		statements: make(map[string]string),
+		portals:    make(map[string]string),
+	}
+}
+
+// parsePostgresTypedHeader returns a header parser for messages that start with a type byte
+// followed by their length (which includes the length itself)
+func parsePostgresTypedHeader(messageTypes string) func(header []byte) (int, bool) {
+	return func(header []byte) (int, bool) {
+		length := binary.BigEndian.Uint32(header[1:])
+		if strings.IndexByte(messageTypes, header[0]) < 0 || length < 4 || length > postgresMaxMessageLength {
+			return 0, false
+		}
+		return int(length) - 4, true
+	}
+}
+
+// parsePostgresStartupHeader parses the header of untyped messages sent by a client before startup is complete
+func parsePostgresStartupHeader(header []byte) (int, bool) {
+	length := binary.BigEndian.Uint32(header)
+	if length < 8 || length > postgresMaxCapture {
+		return 0, false
+	}
+	return int(length) - 4, true
+}
+
+// parse reads PostgreSQL messages sent by the client and server, sending a SqlEvent for each completed query
+func (parser *postgresParser) parse(stream *tcpStream, requestId int64, timestamp time.Time, isClient bool, buffer *bufio.Reader, packetCount int) (bool, error) {
+	if parser.encrypted {
+		return true, nil
+	}
+	data, err := io.ReadAll(buffer)
+	if err != nil || len(data) == 0 {
+		return false, err
+	}
+
+	if isClient {
+		if !parser.frontendStarted {
+			parser.frontendStarted = true
+			// startup messages don't have a type, and start with a length well below 2^24
+			if data[0] == 0 {
+				parser.frontend = newFramedReader(4, postgresMaxCapture, parsePostgresStartupHeader)
+			} else {
+				parser.frontend = newFramedReader(5, postgresMaxCapture, parsePostgresTypedHeader(postgresFrontendMessageTypes))
+			}
+		}
+		if parser.frontend.desynced {
+			return true, nil
+		}
+		err = parser.frontend.read(data, func(header []byte, body []byte, truncated bool) {
+			parser.handleFrontendMessage(stream, header, body, timestamp, packetCount)
+		})
+	} else {
+		if parser.awaitingEncryptionResponse {
+			// the server responds to encryption requests with a single byte
+			parser.awaitingEncryptionResponse = false
+			if data[0] == 'S' || data[0] == 'G' {
+				parser.encrypted = true
+				return true, nil
+			}
+			data = data[1:]
+		}
+		if parser.backend.desynced {
+			return true, nil
+		}
+		err = parser.backend.read(data, func(header []byte, body []byte, truncated bool) {
+			parser.handleBackendMessage(stream, header[0], body, timestamp, packetCount)
+		})
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (parser *postgresParser) handleFrontendMessage(stream *tcpStream, header []byte, body []byte, timestamp time.Time, packetCount int) {
+	// untyped startup phase messages
+	if parser.frontend.headerLength == 4 {
+		if len(body) < 4 {
+			return
+		}
+		switch binary.BigEndian.Uint32(body) {
+		case postgresSslRequestCode, postgresGssEncRequest:
+			parser.awaitingEncryptionResponse = true
+		case postgresProtocolVersion3:
+			// everything after the startup message has a type
+			parser.frontend.headerLength = 5
+			parser.frontend.parseHeader = parsePostgresTypedHeader(postgresFrontendMessageTypes)
+		}
+		return
+	}
+
+	fields := bytes.Split(body, []byte{0})
+	field := func(i int) string {
+		if i < len(fields) {
+			return string(fields[i])
+		}
+		return ""
+	}
+	switch header[0] {
+	case 'Q':
+		parser.addPendingQuery(stream, postgresSimpleQuery, field(0), timestamp, packetCount)
+	case 'P':
+		if len(parser.statements) >= postgresMaxPreparedStatements {
+			clear(parser.statements)
+		}
+		parser.statements[field(0)] = field(1)
+	case 'B':
+		if len(parser.portals) >= postgresMaxPreparedStatements {
+			clear(parser.portals)
+		}
+		parser.portals[field(0)] = parser.statements[field(1)]
+	case 'E':
+		parser.addPendingQuery(stream, postgresExecute, parser.portals[field(0)], timestamp, packetCount)
+	case 'S':
+		parser.addPendingQuery(stream, postgresSync, "", timestamp, packetCount)
+	case 'C':
+		// close a prepared statement or portal
+		if len(body) > 0 {
+			name := strings.TrimRight(string(body[1:]), "\x00")
+			if body[0] == 'S' {
+				delete(parser.statements, name)
+			} else {
+				delete(parser.portals, name)
+			}
+		}
+	}
+}
+
+func (parser *postgresParser) addPendingQuery(stream *tcpStream, kind postgresQueryKind, statement string, timestamp time.Time, packetCount int) {
+	if parser.frontend.desynced {
+		return
+	}
+	if len(parser.pending) >= postgresMaxPendingQueries {
+		parser.desync(stream)
+		return
+	}
+	query := &postgresQuery{
+		kind:         kind,
+		timestamp:    timestamp,
+		packetCount:  packetCount,
+		rowsAffected: -1,
+	}
+	if kind != postgresSync {
+		parser.queriesCount++
+		query.id = parser.queriesCount
+		query.statement = normalizeSql(statement, postgresDialect)
+	}
+	parser.pending = append(parser.pending, query)
+}
+
+func (parser *postgresParser) handleBackendMessage(stream *tcpStream, messageType byte, body []byte, timestamp time.Time, packetCount int) {
+	if parser.backend.desynced || len(parser.pending) == 0 {
+		return
+	}
+	query := parser.pending[0]
+
+	switch messageType {
+	case 'C', 'I', 's': // CommandComplete, EmptyQueryResponse, PortalSuspended
+		if messageType == 'C' {
+			if rows := postgresRowsAffected(string(bytes.TrimRight(body, "\x00"))); rows >= 0 {
+				// a simple query can contain more than one statement
+				query.rowsAffected = max(query.rowsAffected, 0) + rows
+			}
+		}
+		if query.kind == postgresExecute {
+			parser.completeQuery(stream, timestamp, packetCount)
+		}
+	case 'E': // ErrorResponse
+		query.errorCode, query.errorMessage = parsePostgresError(body)
+		if query.kind != postgresExecute {
+			return
+		}
+		parser.completeQuery(stream, timestamp, packetCount)
+		// after an error, the server skips everything up to the next Sync
+		for len(parser.pending) > 0 && parser.pending[0].kind == postgresExecute {
+			parser.pending = parser.pending[1:]
+		}
+	case 'Z': // ReadyForQuery
+		if query.kind == postgresSimpleQuery {
+			parser.completeQuery(stream, timestamp, packetCount)
+			return
+		}
+		// drop the Sync and anything before it the server didn't respond to
+		for len(parser.pending) > 0 {
+			kind := parser.pending[0].kind
+			parser.pending = parser.pending[1:]
+			if kind == postgresSync {
+				return
+			}
+		}
+	}
+}
+
+// completeQuery sends a SqlEvent for the oldest pending query
+func (parser *postgresParser) completeQuery(stream *tcpStream, timestamp time.Time, packetCount int) {
+	query := parser.pending[0]
+	parser.pending = parser.pending[1:]
+	stream.sendEvent(NewSqlEvent(
+		stream.ident,
+		query.id,
+		query.timestamp,
+		timestamp,
+		query.packetCount,
+		packetCount,
+		stream.srcIP,
+		stream.dstIP,
+		"postgresql",
+		query.statement,
+		query.rowsAffected,
+		query.errorCode,
+		query.errorMessage,
+	))
+}
+
+// desync stops following the connection once too many queries are waiting for results,
+// sending the waiting queries without their results
+func (parser *postgresParser) desync(stream *tcpStream) {
+	stats.desynced_max_pending.Add(1)
+	parser.frontend.desynced = true
+	parser.backend.desynced = true
+	for _, query := range parser.pending {
+		if query.kind == postgresSync {
+			continue
+		}
+		stream.sendEvent(NewSqlEvent(
+			stream.ident,
+			query.id,
+			query.timestamp,
+			time.Time{},
+			query.packetCount,
+			0,
+			stream.srcIP,
+			stream.dstIP,
+			"postgresql",
+			query.statement,
+			-1,
+			"",
+			"",
+		))
+	}
+	parser.pending = nil
+}
+
+// postgresRowsAffected returns the number of rows from a CommandComplete tag, eg "INSERT 0 5" or "SELECT 3",
+// or -1 for commands that don't report rows
+func postgresRowsAffected(tag string) int64 {
+	fields := strings.Fields(tag)
+	if len(fields) < 2 {
+		return -1
+	}
+	switch fields[0] {
+	case "INSERT", "UPDATE", "DELETE", "SELECT", "MERGE", "MOVE", "FETCH", "COPY":
+		if rows, err := strconv.ParseInt(fields[len(fields)-1], 10, 64); err == nil {
+			return rows
+		}
+	}
+	return -1
+}
+
+// parsePostgresError returns the SQLSTATE code and message from an ErrorResponse message
+func parsePostgresError(body []byte) (code string, message string) {
+	for len(body) > 1 {
+		fieldType := body[0]
+		end := bytes.IndexByte(body[1:], 0)
+		if end < 0 {
+			end = len(body) - 1
+		}
+		value := string(body[1 : end+1])
+		switch fieldType {
+		case 'C':
+			code = value
+		case 'M':
+			message = value
+		}
+		body = body[min(end+2, len(body)):]
+	}
+	return code, message
+}
@@ -0,0 +1,211 @@
+package assemblers
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+func newPostgresTestStream() *tcpStream {
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	transportFlow := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0xd4, 0x31}, []byte{0x15, 0x38}) // 54321 -> 5432
+	return NewTcpStream(netFlow, transportFlow, config.Config{
+		PostgresPorts: []string{"5432"},
+	}, make(chan Event, 10))
+}
+
+// postgresMessage builds a typed message whose body is the given fields joined together
+func postgresMessage(messageType byte, body ...string) []byte {
+	var payload []byte
+	for _, field := range body {
+		payload = append(payload, field...)
+	}
+	message := []byte{messageType, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(message[1:], uint32(len(payload)+4))
+	return append(message, payload...)
+}
+
+func postgresStartupMessage(code uint32, body string) []byte {
+	message := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(message, uint32(8+len(body)))
+	binary.BigEndian.PutUint32(message[4:], code)
+	return append(message, body...)
+}
+
+func postgresErrorResponse(code string, message string) []byte {
+	return postgresMessage('E', "SERROR\x00", "C"+code+"\x00", "M"+message+"\x00", "\x00")
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var data []byte
+	for _, part := range parts {
+		data = append(data, part...)
+	}
+	return data
+}
+
+func TestPostgresParserSimpleQueries(t *testing.T) {
+	stream := newPostgresTestStream()
+	require.IsType(t, &postgresParser{}, stream.parsers[0])
+	requestTime := time.Now()
+	responseTime := requestTime.Add(time.Millisecond)
+
+	// the client asks for TLS, the server refuses, and the client starts up without it
+	stream.parse(postgresStartupMessage(postgresSslRequestCode, ""), 0, requestTime, true, 1)
+	stream.parse([]byte("N"), 0, requestTime, false, 1)
+	stream.parse(postgresStartupMessage(postgresProtocolVersion3, "user\x00app\x00\x00"), 0, requestTime, true, 2)
+	stream.parse(concatBytes(
+		postgresMessage('R', "\x00\x00\x00\x00"),
+		postgresMessage('Z', "I"),
+	), 0, requestTime, false, 2)
+
+	query := postgresMessage('Q', "SELECT name FROM users WHERE id = 42; DELETE FROM sessions WHERE user_id = 42\x00")
+	// split the query across segments
+	stream.parse(query[:3], 0, requestTime, true, 3)
+	stream.parse(query[3:], 0, requestTime, true, 4)
+	stream.parse(postgresMessage('Q', "SELECT * FROM missing\x00"), 0, requestTime, true, 5)
+
+	stream.parse(concatBytes(
+		postgresMessage('T', "\x00\x01name\x00"),
+		postgresMessage('D', "\x00\x01\x00\x00\x00\x03bob"),
+		postgresMessage('C', "SELECT 1\x00"),
+		postgresMessage('C', "DELETE 3\x00"),
+		postgresMessage('Z', "I"),
+		postgresErrorResponse("42P01", `relation "missing" does not exist`),
+		postgresMessage('Z', "I"),
+	), 0, responseTime, false, 6)
+
+	require.Len(t, stream.eventsChan, 2)
+	event := (<-stream.eventsChan).(*SqlEvent)
+	assert.Equal(t, int64(1), event.RequestId())
+	assert.Equal(t, "postgresql", event.DbSystem())
+	assert.Equal(t, "SELECT name FROM users WHERE id = ?; DELETE FROM sessions WHERE user_id = ?", event.Statement())
+	assert.Equal(t, "SELECT", event.Operation())
+	assert.Equal(t, int64(4), event.RowsAffected())
+	assert.Equal(t, "", event.ErrorCode())
+	assert.Equal(t, requestTime, event.RequestTimestamp())
+	assert.Equal(t, responseTime, event.ResponseTimestamp())
+	assert.Equal(t, 4, event.RequestPacketCount())
+	assert.Equal(t, 6, event.ResponsePacketCount())
+	assert.Equal(t, "10.0.0.1", event.SrcIp())
+	assert.Equal(t, "10.0.0.2", event.DstIp())
+
+	event = (<-stream.eventsChan).(*SqlEvent)
+	assert.Equal(t, int64(2), event.RequestId())
+	assert.Equal(t, int64(-1), event.RowsAffected())
+	assert.Equal(t, "42P01", event.ErrorCode())
+	assert.Equal(t, `relation "missing" does not exist`, event.ErrorMessage())
+}
+
+func TestPostgresParserExtendedQueries(t *testing.T) {
+	stream := newPostgresTestStream()
+
+	// we joined the connection after startup, so the first message has a type
+	stream.parse(concatBytes(
+		postgresMessage('P', "insert_user\x00", "INSERT INTO users (name) VALUES ($1)\x00", "\x00\x00"),
+		postgresMessage('B', "\x00", "insert_user\x00", "\x00\x00\x00\x01\x00\x00\x00\x03bob\x00\x00"),
+		postgresMessage('E', "\x00", "\x00\x00\x00\x00"),
+		postgresMessage('P', "\x00", "UPDATE users SET name = 'x' WHERE id = $1\x00", "\x00\x00"),
+		postgresMessage('B', "\x00", "\x00", "\x00\x00\x00\x00\x00\x00"),
+		postgresMessage('E', "\x00", "\x00\x00\x00\x00"),
+		postgresMessage('S'),
+		// the second batch fails on its first Execute, so the server skips the second
+		postgresMessage('B', "\x00", "insert_user\x00", "\x00\x00\x00\x00\x00\x00"),
+		postgresMessage('E', "\x00", "\x00\x00\x00\x00"),
+		postgresMessage('B', "\x00", "insert_user\x00", "\x00\x00\x00\x00\x00\x00"),
+		postgresMessage('E', "\x00", "\x00\x00\x00\x00"),
+		postgresMessage('S'),
+	), 0, time.Now(), true, 1)
+
+	stream.parse(concatBytes(
+		postgresMessage('1'),
+		postgresMessage('2'),
+		postgresMessage('C', "INSERT 0 1\x00"),
+		postgresMessage('1'),
+		postgresMessage('2'),
+		postgresMessage('C', "UPDATE 2\x00"),
+		postgresMessage('Z', "I"),
+		postgresMessage('2'),
+		postgresErrorResponse("23502", `null value in column "name" violates not-null constraint`),
+		postgresMessage('Z', "I"),
+	), 0, time.Now(), false, 2)
+
+	expected := []struct {
+		statement    string
+		rowsAffected int64
+		errorCode    string
+	}{
+		{"INSERT INTO users (name) VALUES ($1)", 1, ""},
+		{"UPDATE users SET name = ? WHERE id = $1", 2, ""},
+		{"INSERT INTO users (name) VALUES ($1)", -1, "23502"},
+	}
+	require.Len(t, stream.eventsChan, len(expected))
+	for i, want := range expected {
+		event := (<-stream.eventsChan).(*SqlEvent)
+		assert.Equal(t, int64(i+1), event.RequestId())
+		assert.Equal(t, want.statement, event.Statement())
+		assert.Equal(t, want.rowsAffected, event.RowsAffected())
+		assert.Equal(t, want.errorCode, event.ErrorCode())
+	}
+	parser := stream.parsers[0].(*postgresParser)
+	assert.Empty(t, parser.pending)
+}
+
+func TestPostgresParserStopsAfterTls(t *testing.T) {
+	stream := newPostgresTestStream()
+
+	stream.parse(postgresStartupMessage(postgresSslRequestCode, ""), 0, time.Now(), true, 1)
+	stream.parse([]byte("S"), 0, time.Now(), false, 1)
+	// the rest of the connection is a TLS handshake and encrypted data
+	stream.parse([]byte{0x16, 0x03, 0x01, 0x02, 0x00, 0x01}, 0, time.Now(), true, 2)
+	stream.parse(postgresMessage('Q', "SELECT 1\x00"), 0, time.Now(), true, 3)
+
+	parser := stream.parsers[0].(*postgresParser)
+	assert.True(t, parser.encrypted)
+	assert.Empty(t, parser.pending)
+}
+
+func TestPostgresParserDesyncsWhenTooManyQueriesArePending(t *testing.T) {
+	stream := newPostgresTestStream()
+	stream.eventsChan = make(chan Event, postgresMaxPendingQueries)
+	requestTime := time.Now()
+
+	// joined mid-connection, with every query but the last waiting for its result
+	var queries []byte
+	for i := 0; i < postgresMaxPendingQueries/2; i++ {
+		queries = append(queries, postgresMessage('Q', "SELECT 1\x00")...)
+		queries = append(queries, postgresMessage('S')...)
+	}
+	queries = append(queries, postgresMessage('Q', "SELECT 2\x00")...)
+	stream.parse(queries, 0, requestTime, true, 1)
+
+	// the waiting queries are sent without results
+	require.Len(t, stream.eventsChan, postgresMaxPendingQueries/2)
+	for i := 0; i < postgresMaxPendingQueries/2; i++ {
+		event := (<-stream.eventsChan).(*SqlEvent)
+		assert.Equal(t, int64(i+1), event.RequestId())
+		assert.Equal(t, "SELECT ?", event.Statement())
+		assert.Equal(t, requestTime, event.RequestTimestamp())
+		assert.True(t, event.ResponseTimestamp().IsZero())
+		assert.Equal(t, int64(-1), event.RowsAffected())
+	}
+
+	// and we no longer follow the connection, so results aren't matched to the wrong queries
+	stream.parse(concatBytes(
+		postgresMessage('C', "SELECT 1\x00"),
+		postgresMessage('Z', "I"),
+	), 0, time.Now(), false, 2)
+	stream.parse(postgresMessage('Q', "SELECT 3\x00"), 0, time.Now(), true, 3)
+	stream.parse(concatBytes(
+		postgresMessage('C', "SELECT 1\x00"),
+		postgresMessage('Z', "I"),
+	), 0, time.Now(), false, 4)
+	assert.Empty(t, stream.eventsChan)
+}
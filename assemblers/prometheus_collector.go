@@ -0,0 +1,89 @@
+package assemblers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// assemblerMetric is a Prometheus metric whose value is read from the assembler's stats when it's scraped
+type assemblerMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(a *tcpAssembler) float64
+}
+
+func newAssemblerMetric(name string, help string, valueType prometheus.ValueType, constLabels prometheus.Labels, value func(a *tcpAssembler) float64) assemblerMetric {
+	return assemblerMetric{
+		desc:      prometheus.NewDesc(prometheus.BuildFQName("hny_network_agent", "", name), help, nil, constLabels),
+		valueType: valueType,
+		value:     value,
+	}
+}
+
+// assemblerMetrics are the assembler stats sent in the tcp_assembler_stats event that make sense as metrics
+var assemblerMetrics = []assemblerMetric{
+	newAssemblerMetric("packets_received_total", "Packets received by the packet source.", prometheus.CounterValue, nil,
+		func(a *tcpAssembler) float64 { return float64(stats.source_received.Load()) }),
+	newAssemblerMetric("packets_dropped_total", "Packets dropped by the packet source because they weren't read in time.", prometheus.CounterValue, nil,
+		func(a *tcpAssembler) float64 { return float64(stats.source_dropped.Load()) }),
+	newAssemblerMetric("packets_interface_dropped_total", "Packets dropped by the network interface.", prometheus.CounterValue, nil,
+		func(a *tcpAssembler) float64 { return float64(stats.source_if_dropped.Load()) }),
+	newAssemblerMetric("packet_source_queue_freezes_total", "Times the afpacket packet source's queue was frozen.", prometheus.CounterValue, nil,
+		func(a *tcpAssembler) float64 { return float64(stats.source_queue_freezes.Load()) }),
+	newAssemblerMetric("tcp_streams_total", "TCP streams created by the assembler.", prometheus.CounterValue, nil,
+		func(a *tcpAssembler) float64 { return float64(stats.total_streams.Load()) }),
+	newAssemblerMetric("tcp_streams_active", "TCP streams currently being reassembled.", prometheus.GaugeValue, nil,
+		func(a *tcpAssembler) float64 { return float64(stats.active_streams.Load()) }),
+	newAssemblerMetric("tcp_packets_rejected_total", "TCP packets rejected by the assembler.", prometheus.CounterValue, prometheus.Labels{"reason": "fsm"},
+		func(a *tcpAssembler) float64 { return float64(stats.rejectFsm.Load()) }),
+	newAssemblerMetric("tcp_packets_rejected_total", "TCP packets rejected by the assembler.", prometheus.CounterValue, prometheus.Labels{"reason": "options"},
+		func(a *tcpAssembler) float64 { return float64(stats.rejectOpt.Load()) }),
+	newAssemblerMetric("tcp_connections_rejected_total", "TCP connections rejected by the assembler's state machine.", prometheus.CounterValue, nil,
+		func(a *tcpAssembler) float64 { return float64(stats.rejectConnFsm.Load()) }),
+	newAssemblerMetric("http_matcher_evicted_total", "HTTP requests or responses sent without their counterpart.", prometheus.CounterValue, prometheus.Labels{"reason": "timeout"},
+		func(a *tcpAssembler) float64 { return float64(stats.http_unmatched_timeout.Load()) }),
+	newAssemblerMetric("http_matcher_evicted_total", "HTTP requests or responses sent without their counterpart.", prometheus.CounterValue, prometheus.Labels{"reason": "max_entries"},
+		func(a *tcpAssembler) float64 { return float64(stats.http_unmatched_max_entries.Load()) }),
+	newAssemblerMetric("http_matcher_evicted_total", "HTTP requests or responses sent without their counterpart.", prometheus.CounterValue, prometheus.Labels{"reason": "stream_closed"},
+		func(a *tcpAssembler) float64 { return float64(stats.http_unmatched_stream_closed.Load()) }),
+	newAssemblerMetric("http_matcher_evicted_total", "HTTP requests or responses sent without their counterpart.", prometheus.CounterValue, prometheus.Labels{"reason": "not_captured"},
+		func(a *tcpAssembler) float64 { return float64(stats.http_unmatched_not_captured.Load()) }),
+	newAssemblerMetric("http_body_capture_bytes", "Bytes of memory held by captured HTTP bodies.", prometheus.GaugeValue, nil,
+		func(a *tcpAssembler) float64 { return float64(stats.http_body_capture_bytes.Load()) }),
+	newAssemblerMetric("http_body_capture_memory_exhausted_total", "HTTP bodies truncated because the capture memory limit was reached.", prometheus.CounterValue, nil,
+		func(a *tcpAssembler) float64 { return float64(stats.http_body_capture_memory_exhausted.Load()) }),
+	newAssemblerMetric("grpc_message_buffer_bytes", "Bytes of memory held by compressed gRPC messages waiting to be decompressed.", prometheus.GaugeValue, nil,
+		func(a *tcpAssembler) float64 { return float64(stats.grpc_message_buffer_bytes.Load()) }),
+	newAssemblerMetric("grpc_message_buffer_exhausted_total", "Compressed gRPC messages not decompressed because the buffer memory limit was reached.", prometheus.CounterValue, nil,
+		func(a *tcpAssembler) float64 { return float64(stats.grpc_message_buffer_exhausted.Load()) }),
+	newAssemblerMetric("connections_desynced_max_pending_total", "Connections no longer parsed because too many requests were waiting for their responses.", prometheus.CounterValue, nil,
+		func(a *tcpAssembler) float64 { return float64(stats.desynced_max_pending.Load()) }),
+	newAssemblerMetric("event_queue_length", "Events waiting to be handled.", prometheus.GaugeValue, nil,
+		func(a *tcpAssembler) float64 { return float64(len(a.eventsChan)) }),
+	newAssemblerMetric("shard_queue_length", "Packets waiting to be reassembled by shards.", prometheus.GaugeValue, nil,
+		func(a *tcpAssembler) float64 { return float64(a.shardQueueLength()) }),
+}
+
+// assemblerCollector collects the assembler's stats as Prometheus metrics
+type assemblerCollector struct {
+	assembler *tcpAssembler
+}
+
+var _ prometheus.Collector = (*assemblerCollector)(nil)
+
+func (c *assemblerCollector) Describe(descs chan<- *prometheus.Desc) {
+	for _, metric := range assemblerMetrics {
+		descs <- metric.desc
+	}
+}
+
+func (c *assemblerCollector) Collect(metrics chan<- prometheus.Metric) {
+	for _, metric := range assemblerMetrics {
+		metrics <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, metric.value(c.assembler))
+	}
+}
+
+// RegisterMetrics registers the assembler's stats as Prometheus metrics, including packets dropped by the packet source
+// and the number of events waiting to be handled
+func (a *tcpAssembler) RegisterMetrics(registerer prometheus.Registerer) error {
+	return registerer.Register(&assemblerCollector{assembler: a})
+}
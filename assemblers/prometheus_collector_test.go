@@ -0,0 +1,28 @@
+package assemblers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssemblerCollectorReportsStats(t *testing.T) {
+	assembler := &tcpAssembler{eventsChan: make(chan Event, 10)}
+	assembler.eventsChan <- &HttpEvent{}
+	assembler.eventsChan <- &HttpEvent{}
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, assembler.RegisterMetrics(registry))
+	assert.Equal(t, len(assemblerMetrics), testutil.CollectAndCount(registry))
+
+	expected := `
+# HELP hny_network_agent_event_queue_length Events waiting to be handled.
+# TYPE hny_network_agent_event_queue_length gauge
+hny_network_agent_event_queue_length 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(registry, strings.NewReader(expected), "hny_network_agent_event_queue_length"))
+}
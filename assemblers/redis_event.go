@@ -0,0 +1,68 @@
+package assemblers
+
+import (
+	"time"
+)
+
+// RedisEvent represents a Redis command and its reply
+type RedisEvent struct {
+	eventBase
+	command      string
+	keyCount     int
+	replyType    string
+	errorMessage string
+}
+
+// Make sure RedisEvent implements Event interface
+var _ Event = (*RedisEvent)(nil)
+
+func NewRedisEvent(
+	streamIdent string,
+	requestId int64,
+	requestTimestamp time.Time,
+	responseTimestamp time.Time,
+	requestPacketCount int,
+	responsePacketCount int,
+	srcIp string,
+	dstIp string,
+	command string,
+	keyCount int,
+	replyType string,
+	errorMessage string) *RedisEvent {
+	return &RedisEvent{
+		eventBase: eventBase{
+			streamIdent:         streamIdent,
+			requestId:           requestId,
+			requestTimestamp:    requestTimestamp,
+			responseTimestamp:   responseTimestamp,
+			requestPacketCount:  requestPacketCount,
+			responsePacketCount: responsePacketCount,
+			srcIp:               srcIp,
+			dstIp:               dstIp,
+		},
+		command:      command,
+		keyCount:     keyCount,
+		replyType:    replyType,
+		errorMessage: errorMessage,
+	}
+}
+
+// Command returns the upper-case name of the command, eg "GET"
+func (event *RedisEvent) Command() string {
+	return event.command
+}
+
+// KeyCount returns the number of keys the command operates on
+func (event *RedisEvent) KeyCount() int {
+	return event.keyCount
+}
+
+// ReplyType returns the RESP type of the reply, eg "bulk_string" or "error"
+func (event *RedisEvent) ReplyType() string {
+	return event.replyType
+}
+
+// ErrorMessage returns the error sent in reply to the command, if any
+func (event *RedisEvent) ErrorMessage() string {
+	return event.errorMessage
+}
@@ -0,0 +1,295 @@
+package assemblers
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisMaxPendingCommands limits how many commands we hold on to while waiting for their replies
+const redisMaxPendingCommands = 1000
+
+// redisReplyTypes maps RESP type bytes to the reply type names used on events
+var redisReplyTypes = map[byte]string{
+	'+': "simple_string",
+	'-': "error",
+	':': "integer",
+	'$': "bulk_string",
+	'*': "array",
+	'_': "null",
+	',': "double",
+	'#': "boolean",
+	'!': "blob_error",
+	'=': "verbatim_string",
+	'(': "big_number",
+	'%': "map",
+	'~': "set",
+	'>': "push",
+}
+
+// redisKeylessCommands are commands that don't operate on any keys
+var redisKeylessCommands = map[string]bool{
+	"AUTH": true, "CLIENT": true, "CLUSTER": true, "COMMAND": true, "CONFIG": true, "DBSIZE": true,
+	"DISCARD": true, "ECHO": true, "EXEC": true, "FLUSHALL": true, "FLUSHDB": true, "HELLO": true,
+	"INFO": true, "KEYS": true, "MULTI": true, "PING": true, "PSUBSCRIBE": true, "PUBLISH": true,
+	"PUNSUBSCRIBE": true, "QUIT": true, "RANDOMKEY": true, "READONLY": true, "READWRITE": true,
+	"RESET": true, "SCAN": true, "SCRIPT": true, "SELECT": true, "SUBSCRIBE": true, "TIME": true,
+	"UNSUBSCRIBE": true, "UNWATCH": true,
+}
+
+// redisAllKeysCommands are commands where every argument is a key
+var redisAllKeysCommands = map[string]bool{
+	"DEL": true, "EXISTS": true, "MGET": true, "PFCOUNT": true, "SDIFF": true, "SINTER": true,
+	"SUNION": true, "TOUCH": true, "UNLINK": true, "WATCH": true,
+}
+
+// redisSubscriptionCommands are commands the server confirms once for each channel or pattern,
+// with an array (or a push in RESP3) starting with the command's name in lower case
+var redisSubscriptionCommands = map[string]bool{
+	"SUBSCRIBE": true, "PSUBSCRIBE": true, "SSUBSCRIBE": true,
+	"UNSUBSCRIBE": true, "PUNSUBSCRIBE": true, "SUNSUBSCRIBE": true,
+}
+
+// redisParser parses Redis commands and replies sent using RESP2 or RESP3.
+//
+// Redis replies to commands in the order they were sent, so replies are matched to commands
+// in order, which also works for pipelined commands. If too many commands wait for their replies,
+// we've lost track of which reply belongs to which command, so we stop following the connection.
+type redisParser struct {
+	commands      *respReader
+	replies       *respReader
+	pending       []redisCommand
+	commandsCount int64
+	// the subscription command whose first confirmation was matched to it, and how many more
+	// confirmations it gets, or -1 if it unsubscribes from every channel so the number isn't known
+	confirming    string
+	confirmations int
+}
+
+// redisCommand is a command waiting for its reply
+type redisCommand struct {
+	id       int64
+	name     string
+	keyCount int
+	// the number of confirmations the server sends for a subscription command, -1 if unknown
+	confirmations int
+	timestamp     time.Time
+	packetCount   int
+}
+
+func newRedisParser() *redisParser {
+	return &redisParser{
+		commands: newRespReader(true),
+		replies:  newRespReader(false),
+	}
+}
+
+// parse reads Redis commands sent by the client and replies sent by the server,
+// sending a RedisEvent for each reply that matches a command.
+func (parser *redisParser) parse(stream *tcpStream, requestId int64, timestamp time.Time, isClient bool, buffer *bufio.Reader, packetCount int) (bool, error) {
+	reader := parser.replies
+	if isClient {
+		reader = parser.commands
+	}
+	// we've already reported we can't follow this direction of the connection
+	if reader.desynced {
+		return true, nil
+	}
+
+	data, err := io.ReadAll(buffer)
+	if err != nil {
+		return false, err
+	}
+	if isClient {
+		err = reader.read(data, func(value respValue) {
+			parser.storeCommand(stream, value, timestamp, packetCount)
+		})
+	} else {
+		err = reader.read(data, func(value respValue) {
+			parser.matchReply(stream, value, timestamp, packetCount)
+		})
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (parser *redisParser) storeCommand(stream *tcpStream, value respValue, timestamp time.Time, packetCount int) {
+	// commands are always sent as arrays of bulk strings, or inline
+	if parser.commands.desynced || value.typ != '*' || len(value.elements) == 0 {
+		return
+	}
+	if len(parser.pending) >= redisMaxPendingCommands {
+		parser.desync(stream)
+		return
+	}
+	parser.commandsCount++
+	name := strings.ToUpper(value.elements[0])
+	confirmations := 0
+	if redisSubscriptionCommands[name] {
+		// unsubscribing without any channels unsubscribes from all of them
+		confirmations = value.length - 1
+		if confirmations == 0 {
+			confirmations = -1
+		}
+	}
+	parser.pending = append(parser.pending, redisCommand{
+		id:            parser.commandsCount,
+		name:          name,
+		keyCount:      redisKeyCount(name, value.elements, value.length),
+		confirmations: confirmations,
+		timestamp:     timestamp,
+		packetCount:   packetCount,
+	})
+}
+
+func (parser *redisParser) matchReply(stream *tcpStream, value respValue, timestamp time.Time, packetCount int) {
+	if parser.replies.desynced {
+		return
+	}
+	name, isConfirmation := redisSubscriptionConfirmation(value)
+	if isConfirmation && parser.isFurtherConfirmation(name) {
+		return
+	}
+	if len(parser.pending) == 0 {
+		return
+	}
+	// RESP3 sends confirmations as pushes, but the first one is still the reply to its command
+	if isRedisPush(value) && !(isConfirmation && parser.pending[0].name == name) {
+		return
+	}
+	command := parser.pending[0]
+	parser.pending = parser.pending[1:]
+	parser.confirming, parser.confirmations = "", 0
+	if command.confirmations != 0 {
+		parser.confirming = command.name
+		parser.confirmations = command.confirmations
+		if parser.confirmations > 0 {
+			parser.confirmations--
+		}
+	}
+
+	replyType := redisReplyTypes[value.typ]
+	if value.null {
+		replyType = "null"
+	}
+	var errorMessage string
+	if value.typ == '-' || value.typ == '!' {
+		errorMessage = value.str
+	}
+
+	stream.sendEvent(NewRedisEvent(
+		stream.ident,
+		command.id,
+		command.timestamp,
+		timestamp,
+		command.packetCount,
+		packetCount,
+		stream.srcIP,
+		stream.dstIP,
+		command.name,
+		command.keyCount,
+		replyType,
+		errorMessage,
+	))
+}
+
+// isFurtherConfirmation returns true if a subscription confirmation is another one for the
+// command whose first confirmation has already been matched, rather than for the next waiting command
+func (parser *redisParser) isFurtherConfirmation(name string) bool {
+	if parser.confirming != name || parser.confirmations == 0 {
+		return false
+	}
+	if parser.confirmations > 0 {
+		parser.confirmations--
+		return true
+	}
+	// the number of confirmations isn't known, so they continue until the next command's start
+	if len(parser.pending) > 0 && parser.pending[0].name == name {
+		parser.confirmations = 0
+		return false
+	}
+	return true
+}
+
+// desync stops following the connection once too many commands are waiting for replies,
+// sending the waiting commands without their replies
+func (parser *redisParser) desync(stream *tcpStream) {
+	stats.desynced_max_pending.Add(1)
+	parser.commands.desync()
+	parser.replies.desync()
+	for _, command := range parser.pending {
+		stream.sendEvent(NewRedisEvent(
+			stream.ident,
+			command.id,
+			command.timestamp,
+			time.Time{},
+			command.packetCount,
+			0,
+			stream.srcIP,
+			stream.dstIP,
+			command.name,
+			command.keyCount,
+			"",
+			"",
+		))
+	}
+	parser.pending = nil
+}
+
+// isRedisPush returns true for values the server sends without a command, eg pub/sub messages
+func isRedisPush(value respValue) bool {
+	if value.typ == '>' {
+		return true
+	}
+	// RESP2 sends pub/sub messages as arrays
+	if value.typ == '*' && len(value.elements) > 0 {
+		switch value.elements[0] {
+		case "message", "pmessage", "smessage":
+			return true
+		}
+	}
+	return false
+}
+
+// redisSubscriptionConfirmation returns the upper case name of the subscription command a value confirms,
+// if it's a confirmation: an array (or a push in RESP3) of the command's name, the channel and the subscription count
+func redisSubscriptionConfirmation(value respValue) (string, bool) {
+	if (value.typ != '*' && value.typ != '>') || value.length != 3 || len(value.elements) == 0 {
+		return "", false
+	}
+	name := strings.ToUpper(value.elements[0])
+	if value.elements[0] != strings.ToLower(name) || !redisSubscriptionCommands[name] {
+		return "", false
+	}
+	return name, true
+}
+
+// redisKeyCount returns the number of keys used by a command, given its first few arguments
+// and the total number of elements in the command (including its name).
+//
+// Most commands operate on a single key given as their first argument.
+func redisKeyCount(name string, elements []string, length int) int {
+	args := length - 1
+	switch {
+	case args <= 0 || redisKeylessCommands[name]:
+		return 0
+	case redisAllKeysCommands[name]:
+		return args
+	case name == "MSET" || name == "MSETNX":
+		return args / 2
+	case name == "EVAL" || name == "EVALSHA" || name == "EVAL_RO" || name == "EVALSHA_RO" || name == "FCALL" || name == "FCALL_RO":
+		// scripts are given the number of keys that follow as their second argument
+		if len(elements) > 2 {
+			if keys, err := strconv.Atoi(elements[2]); err == nil {
+				return keys
+			}
+		}
+		return 0
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,206 @@
+package assemblers
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+func newRedisTestStream(srcPort uint16, dstPort uint16) *tcpStream {
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	transportFlow := gopacket.NewFlow(layers.EndpointTCPPort,
+		[]byte{byte(srcPort >> 8), byte(srcPort)},
+		[]byte{byte(dstPort >> 8), byte(dstPort)})
+	return NewTcpStream(netFlow, transportFlow, config.Config{
+		RedisPorts: []string{"6379"},
+	}, make(chan Event, 10))
+}
+
+func TestRedisParserMatchesPipelinedCommands(t *testing.T) {
+	stream := newRedisTestStream(54321, 6379)
+	require.IsType(t, &redisParser{}, stream.parsers[0])
+	requestTime := time.Now()
+	responseTime := requestTime.Add(time.Millisecond)
+
+	commands := "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n" +
+		"*5\r\n$4\r\nmset\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n" +
+		"*4\r\n$4\r\nEVAL\r\n$8\r\nreturn 1\r\n$1\r\n1\r\n$3\r\nkey\r\n" +
+		"PING\r\n" +
+		"*1\r\n$5\r\nBOGUS\r\n"
+	// split a bulk string across segments
+	stream.parse([]byte(commands[:12]), 0, requestTime, true, 1)
+	stream.parse([]byte(commands[12:]), 0, requestTime, true, 2)
+
+	replies := "$3\r\nbar\r\n" +
+		"+OK\r\n" +
+		"|1\r\n+key-popularity\r\n%1\r\n$1\r\na\r\n,0.1923\r\n" + // RESP3 attribute ahead of the reply it describes
+		":1\r\n" +
+		">3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n" + // RESP3 push that isn't a reply
+		"+PONG\r\n" +
+		"-ERR unknown command 'BOGUS'\r\n"
+	stream.parse([]byte(replies), 0, responseTime, false, 3)
+
+	expected := []struct {
+		command      string
+		keyCount     int
+		replyType    string
+		errorMessage string
+	}{
+		{"GET", 1, "bulk_string", ""},
+		{"MSET", 2, "simple_string", ""},
+		{"EVAL", 1, "integer", ""},
+		{"PING", 0, "simple_string", ""},
+		{"BOGUS", 0, "error", "ERR unknown command 'BOGUS'"},
+	}
+	require.Len(t, stream.eventsChan, len(expected))
+	for i, want := range expected {
+		event := (<-stream.eventsChan).(*RedisEvent)
+		assert.Equal(t, int64(i+1), event.RequestId())
+		assert.Equal(t, want.command, event.Command())
+		assert.Equal(t, want.keyCount, event.KeyCount())
+		assert.Equal(t, want.replyType, event.ReplyType())
+		assert.Equal(t, want.errorMessage, event.ErrorMessage())
+		assert.Equal(t, requestTime, event.RequestTimestamp())
+		assert.Equal(t, responseTime, event.ResponseTimestamp())
+		assert.Equal(t, 3, event.ResponsePacketCount())
+		assert.Equal(t, "10.0.0.1", event.SrcIp())
+		assert.Equal(t, "10.0.0.2", event.DstIp())
+	}
+}
+
+func TestRedisParserReplyTypes(t *testing.T) {
+	testCases := []struct {
+		reply     string
+		replyType string
+	}{
+		{"$-1\r\n", "null"},
+		{"*-1\r\n", "null"},
+		{"_\r\n", "null"},
+		{"*0\r\n", "array"},
+		{"*2\r\n*1\r\n:1\r\n$0\r\n\r\n", "array"},
+		{"%1\r\n+key\r\n*2\r\n:1\r\n:2\r\n", "map"},
+		{"~1\r\n#t\r\n", "set"},
+		{",3.14\r\n", "double"},
+		{"(3492890328409238509324850943850943825024385\r\n", "big_number"},
+		{"=15\r\ntxt:Some string\r\n", "verbatim_string"},
+		{"!21\r\nSYNTAX invalid syntax\r\n", "blob_error"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.replyType, func(t *testing.T) {
+			stream := newRedisTestStream(54321, 6379)
+			stream.parse([]byte("*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"), 0, time.Now(), true, 1)
+			stream.parse([]byte(tc.reply), 0, time.Now(), false, 1)
+
+			require.Len(t, stream.eventsChan, 1)
+			event := (<-stream.eventsChan).(*RedisEvent)
+			assert.Equal(t, tc.replyType, event.ReplyType())
+		})
+	}
+}
+
+func TestRedisStreamFirstSeenFromServer(t *testing.T) {
+	// the first packet we saw was sent by the server, so the stream's flow is reversed
+	stream := newRedisTestStream(6379, 54321)
+	assert.True(t, stream.reversed)
+	assert.Equal(t, "10.0.0.2", stream.srcIP)
+	assert.Equal(t, "54321", stream.srcPort)
+	assert.Equal(t, "6379", stream.dstPort)
+}
+
+func TestRespReaderStopsAfterInvalidData(t *testing.T) {
+	reader := newRespReader(false)
+	values := 0
+	emit := func(respValue) { values++ }
+
+	assert.ErrorIs(t, reader.read([]byte("not resp\r\n"), emit), errRespDesync)
+	assert.ErrorIs(t, reader.read([]byte("+OK\r\n"), emit), errRespDesync)
+	assert.Equal(t, 0, values)
+}
+
+func TestRedisParserDesyncsWhenTooManyCommandsArePending(t *testing.T) {
+	stream := newRedisTestStream(54321, 6379)
+	stream.eventsChan = make(chan Event, redisMaxPendingCommands)
+	requestTime := time.Now()
+
+	commands := strings.Repeat("*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", redisMaxPendingCommands+1)
+	stream.parse([]byte(commands), 0, requestTime, true, 1)
+
+	// the waiting commands are sent without replies
+	require.Len(t, stream.eventsChan, redisMaxPendingCommands)
+	for i := 0; i < redisMaxPendingCommands; i++ {
+		event := (<-stream.eventsChan).(*RedisEvent)
+		assert.Equal(t, int64(i+1), event.RequestId())
+		assert.Equal(t, "GET", event.Command())
+		assert.Equal(t, requestTime, event.RequestTimestamp())
+		assert.True(t, event.ResponseTimestamp().IsZero())
+		assert.Equal(t, "", event.ReplyType())
+	}
+
+	// and we no longer follow the connection, so replies aren't matched to the wrong commands
+	stream.parse([]byte("$3\r\nbar\r\n"), 0, time.Now(), false, 2)
+	stream.parse([]byte("*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"), 0, time.Now(), true, 3)
+	stream.parse([]byte("$3\r\nbar\r\n"), 0, time.Now(), false, 4)
+	assert.Empty(t, stream.eventsChan)
+}
+
+func TestRedisParserMatchesSubscriptionCommandsOnceEach(t *testing.T) {
+	testCases := []struct {
+		name             string
+		confirmation     byte
+		confirmationType string
+	}{
+		{"RESP2", '*', "array"},
+		{"RESP3", '>', "push"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stream := newRedisTestStream(54321, 6379)
+			confirm := func(command string, channel string, count int) string {
+				return string(tc.confirmation) + "3\r\n$" + strconv.Itoa(len(command)) + "\r\n" + command + "\r\n" +
+					"$" + strconv.Itoa(len(channel)) + "\r\n" + channel + "\r\n:" + strconv.Itoa(count) + "\r\n"
+			}
+
+			stream.parse([]byte(
+				"*3\r\n$9\r\nSUBSCRIBE\r\n$1\r\na\r\n$1\r\nb\r\n"+
+					"*2\r\n$9\r\nSUBSCRIBE\r\n$1\r\nc\r\n"+
+					"*1\r\n$11\r\nUNSUBSCRIBE\r\n"+
+					"*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n",
+			), 0, time.Now(), true, 1)
+			stream.parse([]byte(
+				confirm("subscribe", "a", 1)+
+					confirm("subscribe", "b", 2)+
+					confirm("subscribe", "c", 3)+
+					// unsubscribing from every channel confirms each of them
+					confirm("unsubscribe", "a", 2)+
+					confirm("unsubscribe", "b", 1)+
+					confirm("unsubscribe", "c", 0)+
+					"$3\r\nbar\r\n",
+			), 0, time.Now(), false, 1)
+
+			expected := []struct {
+				command   string
+				replyType string
+			}{
+				{"SUBSCRIBE", tc.confirmationType},
+				{"SUBSCRIBE", tc.confirmationType},
+				{"UNSUBSCRIBE", tc.confirmationType},
+				{"GET", "bulk_string"},
+			}
+			require.Len(t, stream.eventsChan, len(expected))
+			for i, want := range expected {
+				event := (<-stream.eventsChan).(*RedisEvent)
+				assert.Equal(t, int64(i+1), event.RequestId())
+				assert.Equal(t, want.command, event.Command())
+				assert.Equal(t, want.replyType, event.ReplyType())
+			}
+		})
+	}
+}
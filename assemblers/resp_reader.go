@@ -0,0 +1,212 @@
+package assemblers
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	// respMaxLineLength is the longest RESP line (type, simple value or length) we buffer
+	// while waiting for its line ending before giving up on the connection
+	respMaxLineLength = 64 * 1024
+	// respMaxCapturedElements is the number of elements kept from a top-level array, enough
+	// for a command's name and the arguments needed to work out how many keys it uses
+	respMaxCapturedElements = 3
+	// respMaxCapturedLength is the number of bytes kept from each captured string
+	respMaxCapturedLength = 256
+)
+
+var errRespDesync = errors.New("invalid RESP data")
+
+// respValue is a summary of a top-level RESP value, a Redis command or reply.
+// Large values are not kept in full; only what's needed to describe the command or reply.
+type respValue struct {
+	// RESP type byte, eg '+' for simple strings or '*' for arrays
+	typ byte
+	// true for null bulk strings and null arrays
+	null bool
+	// simple string, error or first bytes of a bulk string
+	str string
+	// number of elements in an aggregate value
+	length int
+	// the first few elements of an aggregate value, empty for nested aggregates
+	elements []string
+}
+
+// respAggregate is an aggregate value that is still waiting on some of its elements
+type respAggregate struct {
+	typ       byte
+	remaining int
+}
+
+// respReader incrementally reads RESP2 and RESP3 values from one direction of a connection.
+// Values can be split across reassembled segments in any way.
+type respReader struct {
+	// a partial line carried over between segments
+	pending []byte
+	// remaining bytes of a bulk string, including its line ending
+	bulkRemaining int
+	bulkTyp       byte
+	bulkLength    int
+	bulkValue     []byte
+	// aggregate values that are still waiting for elements, outermost first
+	stack   []respAggregate
+	current respValue
+	// allow inline commands, which are only sent by clients
+	allowInline bool
+	desynced    bool
+}
+
+func newRespReader(allowInline bool) *respReader {
+	return &respReader{allowInline: allowInline}
+}
+
+// read reads the data and calls emit for each complete top-level value
+func (reader *respReader) read(data []byte, emit func(respValue)) error {
+	if reader.desynced {
+		return errRespDesync
+	}
+	for len(data) > 0 {
+		if reader.bulkRemaining > 0 {
+			n := min(reader.bulkRemaining, len(data))
+			if captureLength := min(reader.bulkLength, respMaxCapturedLength); len(reader.bulkValue) < captureLength {
+				reader.bulkValue = append(reader.bulkValue, data[:min(n, captureLength-len(reader.bulkValue))]...)
+			}
+			reader.bulkRemaining -= n
+			data = data[n:]
+			if reader.bulkRemaining == 0 {
+				reader.valueDone(reader.bulkTyp, false, string(reader.bulkValue), emit)
+				reader.bulkValue = reader.bulkValue[:0]
+			}
+			continue
+		}
+
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			if len(reader.pending)+len(data) > respMaxLineLength {
+				return reader.desync()
+			}
+			reader.pending = append(reader.pending, data...)
+			return nil
+		}
+		line := data[:end+1]
+		if len(reader.pending) > 0 {
+			line = append(reader.pending, line...)
+			reader.pending = reader.pending[:0]
+		}
+		data = data[end+1:]
+		if err := reader.readLine(bytes.TrimRight(line, "\r\n"), emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (reader *respReader) readLine(line []byte, emit func(respValue)) error {
+	if len(line) == 0 {
+		// blank lines between inline commands are ignored by Redis
+		if reader.allowInline && len(reader.stack) == 0 {
+			return nil
+		}
+		return reader.desync()
+	}
+	typ := line[0]
+	switch typ {
+	case '+', '-', ':', ',', '#', '(':
+		reader.valueDone(typ, false, string(line[1:min(len(line), respMaxCapturedLength+1)]), emit)
+	case '_':
+		reader.valueDone(typ, true, "", emit)
+	case '$', '!', '=':
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return reader.desync()
+		}
+		if length < 0 {
+			reader.valueDone(typ, true, "", emit)
+			return nil
+		}
+		reader.bulkTyp = typ
+		reader.bulkLength = length
+		// include the line ending after the bulk data
+		reader.bulkRemaining = length + 2
+	case '*', '~', '>', '%', '|':
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return reader.desync()
+		}
+		if len(reader.stack) == 0 {
+			reader.current = respValue{typ: typ, length: max(length, 0)}
+		}
+		// maps and attributes are made up of key/value pairs
+		if typ == '%' || typ == '|' {
+			length *= 2
+		}
+		if length <= 0 {
+			reader.aggregateDone(typ, length < 0, emit)
+			return nil
+		}
+		reader.stack = append(reader.stack, respAggregate{typ: typ, remaining: length})
+	default:
+		if !reader.allowInline || len(reader.stack) > 0 {
+			return reader.desync()
+		}
+		// inline commands are sent as space separated words, eg "PING"
+		words := strings.Fields(string(line))
+		value := respValue{typ: '*', length: len(words)}
+		for i := 0; i < len(words) && i < respMaxCapturedElements; i++ {
+			value.elements = append(value.elements, words[i])
+		}
+		emit(value)
+	}
+	return nil
+}
+
+// valueDone records a complete non-aggregate value
+func (reader *respReader) valueDone(typ byte, null bool, str string, emit func(respValue)) {
+	if len(reader.stack) == 0 {
+		emit(respValue{typ: typ, null: null, str: str})
+		return
+	}
+	if len(reader.stack) == 1 && len(reader.current.elements) < respMaxCapturedElements {
+		reader.current.elements = append(reader.current.elements, str)
+	}
+	reader.elementDone(emit)
+}
+
+// aggregateDone records a complete aggregate value
+func (reader *respReader) aggregateDone(typ byte, null bool, emit func(respValue)) {
+	if len(reader.stack) == 0 {
+		reader.current.null = null
+		value := reader.current
+		reader.current = respValue{}
+		// attributes describe the value that follows them, so aren't values themselves
+		if typ != '|' {
+			emit(value)
+		}
+		return
+	}
+	if len(reader.stack) == 1 && len(reader.current.elements) < respMaxCapturedElements {
+		reader.current.elements = append(reader.current.elements, "")
+	}
+	reader.elementDone(emit)
+}
+
+// elementDone counts a complete element towards the innermost aggregate
+func (reader *respReader) elementDone(emit func(respValue)) {
+	top := &reader.stack[len(reader.stack)-1]
+	top.remaining--
+	if top.remaining > 0 {
+		return
+	}
+	reader.stack = reader.stack[:len(reader.stack)-1]
+	reader.aggregateDone(top.typ, false, emit)
+}
+
+func (reader *respReader) desync() error {
+	reader.desynced = true
+	reader.pending = nil
+	reader.stack = nil
+	return errRespDesync
+}
@@ -0,0 +1,82 @@
+package assemblers
+
+import (
+	"time"
+)
+
+// SqlEvent represents a SQL statement sent to a database server and the server's response
+type SqlEvent struct {
+	eventBase
+	dbSystem     string
+	statement    string
+	rowsAffected int64
+	errorCode    string
+	errorMessage string
+}
+
+// Make sure SqlEvent implements Event interface
+var _ Event = (*SqlEvent)(nil)
+
+func NewSqlEvent(
+	streamIdent string,
+	requestId int64,
+	requestTimestamp time.Time,
+	responseTimestamp time.Time,
+	requestPacketCount int,
+	responsePacketCount int,
+	srcIp string,
+	dstIp string,
+	dbSystem string,
+	statement string,
+	rowsAffected int64,
+	errorCode string,
+	errorMessage string) *SqlEvent {
+	return &SqlEvent{
+		eventBase: eventBase{
+			streamIdent:         streamIdent,
+			requestId:           requestId,
+			requestTimestamp:    requestTimestamp,
+			responseTimestamp:   responseTimestamp,
+			requestPacketCount:  requestPacketCount,
+			responsePacketCount: responsePacketCount,
+			srcIp:               srcIp,
+			dstIp:               dstIp,
+		},
+		dbSystem:     dbSystem,
+		statement:    statement,
+		rowsAffected: rowsAffected,
+		errorCode:    errorCode,
+		errorMessage: errorMessage,
+	}
+}
+
+// DbSystem returns the database system the statement was sent to, eg "postgresql"
+func (event *SqlEvent) DbSystem() string {
+	return event.dbSystem
+}
+
+// Statement returns the normalized SQL statement, with literal values replaced by "?"
+func (event *SqlEvent) Statement() string {
+	return event.statement
+}
+
+// Operation returns the upper-case first keyword of the statement, eg "SELECT"
+func (event *SqlEvent) Operation() string {
+	return sqlOperation(event.statement)
+}
+
+// RowsAffected returns the number of rows returned or changed by the statement, or -1 if unknown
+func (event *SqlEvent) RowsAffected() int64 {
+	return event.rowsAffected
+}
+
+// ErrorCode returns the error code sent by the server if the statement failed,
+// eg a SQLSTATE for PostgreSQL
+func (event *SqlEvent) ErrorCode() string {
+	return event.errorCode
+}
+
+// ErrorMessage returns the error message sent by the server if the statement failed
+func (event *SqlEvent) ErrorMessage() string {
+	return event.errorMessage
+}
@@ -0,0 +1,189 @@
+package assemblers
+
+import (
+	"strings"
+)
+
+// sqlDialect describes the parts of a SQL dialect that affect how statements are normalized
+type sqlDialect struct {
+	// double quotes delimit strings (MySQL) rather than identifiers (PostgreSQL)
+	doubleQuotedStrings bool
+	// backslashes escape characters in all strings, not just E'' strings
+	backslashEscapes bool
+	// $tag$...$tag$ delimits strings
+	dollarQuotedStrings bool
+	// # starts a comment
+	hashComments bool
+}
+
+var (
+	postgresDialect = sqlDialect{dollarQuotedStrings: true}
+	mysqlDialect    = sqlDialect{doubleQuotedStrings: true, backslashEscapes: true, hashComments: true}
+)
+
+// normalizeSql replaces the literal values in a SQL statement with "?", removes comments
+// and collapses whitespace, so statements that only differ by their values look the same
+// and values (which may be sensitive) aren't sent with events.
+//
+// Placeholders such as $1 and ? are kept as they are.
+func normalizeSql(statement string, dialect sqlDialect) string {
+	var normalized strings.Builder
+	normalized.Grow(len(statement))
+	space := false
+	writeToken := func(token string) {
+		if space && normalized.Len() > 0 {
+			normalized.WriteByte(' ')
+		}
+		space = false
+		normalized.WriteString(token)
+	}
+
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		switch {
+		case isSqlSpace(c):
+			space = true
+			i++
+		case c == '-' && strings.HasPrefix(statement[i:], "--"), c == '#' && dialect.hashComments:
+			end := strings.IndexByte(statement[i:], '\n')
+			if end < 0 {
+				end = len(statement) - i
+			}
+			space = true
+			i += end
+		case c == '/' && strings.HasPrefix(statement[i:], "/*"):
+			end := strings.Index(statement[i+2:], "*/")
+			if end < 0 {
+				i = len(statement)
+			} else {
+				i += end + 4
+			}
+			space = true
+		case c == '\'':
+			i = skipSqlQuoted(statement, i, '\'', dialect.backslashEscapes)
+			writeToken("?")
+		case c == '"' && dialect.doubleQuotedStrings:
+			i = skipSqlQuoted(statement, i, '"', dialect.backslashEscapes)
+			writeToken("?")
+		case c == '"' || c == '`':
+			// quoted identifiers are kept as they are
+			end := skipSqlQuoted(statement, i, c, false)
+			writeToken(statement[i:end])
+			i = end
+		case c == '$' && dialect.dollarQuotedStrings && i+1 < len(statement) && !isSqlDigit(statement[i+1]):
+			if end, ok := skipSqlDollarQuoted(statement, i); ok {
+				writeToken("?")
+				i = end
+			} else {
+				writeToken("$")
+				i++
+			}
+		case isSqlDigit(c) || (c == '.' && i+1 < len(statement) && isSqlDigit(statement[i+1])):
+			end := skipSqlNumber(statement, i)
+			writeToken("?")
+			i = end
+		case isSqlIdentifierChar(c):
+			end := i + 1
+			for end < len(statement) && isSqlIdentifierChar(statement[end]) {
+				end++
+			}
+			token := statement[i:end]
+			// E'' strings allow backslash escapes in PostgreSQL
+			if (token == "E" || token == "e") && end < len(statement) && statement[end] == '\'' {
+				end = skipSqlQuoted(statement, end, '\'', true)
+				token = "?"
+			}
+			writeToken(token)
+			i = end
+		default:
+			writeToken(statement[i : i+1])
+			i++
+		}
+	}
+	return normalized.String()
+}
+
+// sqlOperation returns the upper-case first keyword of a SQL statement, eg "SELECT"
+func sqlOperation(statement string) string {
+	statement = strings.TrimLeft(statement, "( ")
+	end := 0
+	for end < len(statement) && isSqlIdentifierChar(statement[end]) {
+		end++
+	}
+	return strings.ToUpper(statement[:end])
+}
+
+// skipSqlQuoted returns the index after the quoted string or identifier starting at i.
+// A doubled quote character is an escaped quote.
+func skipSqlQuoted(statement string, i int, quote byte, backslashEscapes bool) int {
+	for i++; i < len(statement); i++ {
+		switch statement[i] {
+		case '\\':
+			if backslashEscapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(statement) && statement[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(statement)
+}
+
+// skipSqlDollarQuoted returns the index after the dollar-quoted string starting at i, eg $body$...$body$
+func skipSqlDollarQuoted(statement string, i int) (int, bool) {
+	tagEnd := i + 1
+	for tagEnd < len(statement) && statement[tagEnd] != '$' {
+		if !isSqlIdentifierChar(statement[tagEnd]) {
+			return 0, false
+		}
+		tagEnd++
+	}
+	if tagEnd >= len(statement) {
+		return 0, false
+	}
+	tag := statement[i : tagEnd+1]
+	end := strings.Index(statement[tagEnd+1:], tag)
+	if end < 0 {
+		return len(statement), true
+	}
+	return tagEnd + 1 + end + len(tag), true
+}
+
+// skipSqlNumber returns the index after the numeric literal starting at i, eg 42, 3.14, 1e-3 or 0xff
+func skipSqlNumber(statement string, i int) int {
+	if strings.HasPrefix(statement[i:], "0x") || strings.HasPrefix(statement[i:], "0X") {
+		i += 2
+		for i < len(statement) && isSqlIdentifierChar(statement[i]) {
+			i++
+		}
+		return i
+	}
+	for i < len(statement) {
+		c := statement[i]
+		switch {
+		case isSqlDigit(c) || c == '.':
+			i++
+		case (c == 'e' || c == 'E') && i+1 < len(statement) && (isSqlDigit(statement[i+1]) || statement[i+1] == '-' || statement[i+1] == '+'):
+			i += 2
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func isSqlSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isSqlDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSqlIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || isSqlDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
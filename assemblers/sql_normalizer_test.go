@@ -0,0 +1,64 @@
+package assemblers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeSql(t *testing.T) {
+	testCases := []struct {
+		name      string
+		statement string
+		dialect   sqlDialect
+		expected  string
+	}{
+		{
+			name:      "literals",
+			statement: "SELECT * FROM users WHERE name = 'O''Brien' AND age > 42 AND score < -1.5e3",
+			dialect:   postgresDialect,
+			expected:  "SELECT * FROM users WHERE name = ? AND age > ? AND score < -?",
+		},
+		{
+			name:      "placeholders and quoted identifiers",
+			statement: `UPDATE "User Table" SET "name" = $1 WHERE id = $2`,
+			dialect:   postgresDialect,
+			expected:  `UPDATE "User Table" SET "name" = $1 WHERE id = $2`,
+		},
+		{
+			name:      "comments and whitespace",
+			statement: "/* app:web */ SELECT id\n\t-- the id\n  FROM   t",
+			dialect:   postgresDialect,
+			expected:  "SELECT id FROM t",
+		},
+		{
+			name:      "postgres escape and dollar quoted strings",
+			statement: `SELECT E'it\'s', $body$ don't $body$, $$x$$`,
+			dialect:   postgresDialect,
+			expected:  "SELECT ?, ?, ?",
+		},
+		{
+			name:      "mysql strings",
+			statement: "INSERT INTO `t` (a, b) VALUES (\"x\\\"y\", 0xFF) # trailing",
+			dialect:   mysqlDialect,
+			expected:  "INSERT INTO `t` (a, b) VALUES (?, ?)",
+		},
+		{
+			name:      "identifiers containing digits",
+			statement: "SELECT col1 FROM table2 WHERE ? IN (1, 2, 3)",
+			dialect:   mysqlDialect,
+			expected:  "SELECT col1 FROM table2 WHERE ? IN (?, ?, ?)",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, normalizeSql(tc.statement, tc.dialect))
+		})
+	}
+}
+
+func TestSqlOperation(t *testing.T) {
+	assert.Equal(t, "SELECT", sqlOperation("select 1"))
+	assert.Equal(t, "SELECT", sqlOperation("(SELECT 1) UNION (SELECT 2)"))
+	assert.Equal(t, "", sqlOperation(""))
+}
@@ -13,7 +13,6 @@ import (
 	"github.com/gopacket/gopacket/pcap"
 	"github.com/gopacket/gopacket/reassembly"
 	"github.com/honeycombio/libhoney-go"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/honeycombio/honeycomb-network-agent/config"
@@ -33,6 +32,23 @@ var stats struct {
 	source_received   atomic.Uint64
 	source_dropped    atomic.Uint64
 	source_if_dropped atomic.Uint64
+	// queue freezes are only reported by the afpacket packet source
+	source_queue_freezes atomic.Uint64
+	// HTTP requests or responses sent without their counterpart, by the reason they were evicted
+	http_unmatched_timeout       atomic.Uint64
+	http_unmatched_max_entries   atomic.Uint64
+	http_unmatched_stream_closed atomic.Uint64
+	http_unmatched_not_captured  atomic.Uint64
+	// bytes of memory currently held by captured HTTP bodies, and how many bodies were cut short because it ran out
+	http_body_capture_bytes            atomic.Int64
+	http_body_capture_memory_exhausted atomic.Uint64
+	// bytes of memory currently held by compressed gRPC messages waiting to be decompressed,
+	// and how many messages weren't decompressed because it ran out
+	grpc_message_buffer_bytes     atomic.Int64
+	grpc_message_buffer_exhausted atomic.Uint64
+	// connections we stopped following because too many requests were waiting for their responses,
+	// so we couldn't tell which response belonged to which request
+	desynced_max_pending atomic.Uint64
 }
 
 func IncrementStreamCount() uint64 {
@@ -57,13 +73,23 @@ func (c *Context) GetCaptureInfo() gopacket.CaptureInfo {
 }
 
 type tcpAssembler struct {
-	startedAt     time.Time
-	config        config.Config
-	packetSource  *gopacket.PacketSource
-	streamFactory *tcpStreamFactory
-	streamPool    *reassembly.StreamPool
-	assembler     *reassembly.Assembler
-	eventsChan    chan Event
+	startedAt    time.Time
+	config       config.Config
+	packetSource *gopacket.PacketSource
+	shards       []*assemblerShard
+	shardsWg     *sync.WaitGroup
+	eventsChan   chan Event
+	done         chan struct{}
+	// dns matches DNS queries sent over UDP to their responses, nil unless DNS ports are configured
+	dns *dnsTracker
+
+	// replaying is set when packets are read from a capture file instead of a live interface.
+	// The assembler's clock then follows packet capture timestamps instead of the wall clock.
+	replaying   bool
+	replayPacer *replayPacer
+	// capture timestamp of the most recent packet in unix nanoseconds,
+	// read by shard goroutines so must be accessed atomically
+	lastPacketTimestamp *atomic.Int64
 }
 
 func NewTcpAssembler(config config.Config, eventsChan chan Event) tcpAssembler {
@@ -76,34 +102,73 @@ func NewTcpAssembler(config config.Config, eventsChan chan Event) tcpAssembler {
 		if err != nil {
 			log.Fatal().Err(err).Msg("Failed to setup pcap handle")
 		}
-	// TODO: other data sources (eg afpacket, pfring, etc)
+	case "afpacket":
+		packetSource, err = newAfpacketPacketSource(config)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to setup afpacket handle")
+		}
+	case "file":
+		packetSource, err = newPcapFilePacketSource(config)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to setup pcap file handle")
+		}
+	// TODO: other data sources (eg pfring, etc)
 	default:
 		log.Fatal().Str("packet_source", config.PacketSource).Msg("Unknown packet source")
 	}
 
+	return newTcpAssembler(config, packetSource, eventsChan)
+}
+
+// newTcpAssembler creates a tcpAssembler that reads packets from the given packet source
+func newTcpAssembler(config config.Config, packetSource *gopacket.PacketSource, eventsChan chan Event) tcpAssembler {
 	packetSource.Lazy = config.Lazy
 	packetSource.NoCopy = true
 
-	streamFactory := NewTcpStreamFactory(config, eventsChan)
-	streamPool := reassembly.NewStreamPool(&streamFactory)
-	assembler := reassembly.NewAssembler(streamPool)
+	// each shard gets an equal share of the total page limit so overall memory usage stays the same
+	shardCount := max(config.AssemblerShards, 1)
+	shards := make([]*assemblerShard, shardCount)
+	for i := range shards {
+		shards[i] = newAssemblerShard(i, config, config.MaxBufferedPagesTotal/shardCount, eventsChan)
+	}
 
-	// Set total max pages and per-connection max pages -- this is very important to limit memory usage
-	assembler.AssemblerOptions.MaxBufferedPagesTotal = config.MaxBufferedPagesTotal
-	assembler.AssemblerOptions.MaxBufferedPagesPerConnection = config.MaxBufferedPagesPerConnection
+	var pacer *replayPacer
+	if config.PacketSource == "file" && config.PcapFileRealtime {
+		pacer = &replayPacer{}
+	}
+
+	var dns *dnsTracker
+	if len(config.DNSPorts) > 0 {
+		dns = newDnsTracker(config.DNSPorts, eventsChan)
+	}
 
 	return tcpAssembler{
-		config:        config,
-		packetSource:  packetSource,
-		streamFactory: &streamFactory,
-		streamPool:    streamPool,
-		assembler:     assembler,
-		eventsChan:    eventsChan,
+		config:              config,
+		packetSource:        packetSource,
+		shards:              shards,
+		shardsWg:            &sync.WaitGroup{},
+		eventsChan:          eventsChan,
+		done:                make(chan struct{}),
+		dns:                 dns,
+		replaying:           config.PacketSource == "file",
+		replayPacer:         pacer,
+		lastPacketTimestamp: &atomic.Int64{},
 	}
 }
 
+// Done returns a channel that is closed when the assembler has stopped,
+// either because the context was cancelled or the packet source has no more packets.
+// The events channel is closed first.
+func (h *tcpAssembler) Done() <-chan struct{} {
+	return h.done
+}
+
 func (h *tcpAssembler) Start(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer close(h.done)
+	// nothing sends events once the assembler has stopped, so closing the channel tells event handlers
+	// they've been sent every event
+	defer close(h.eventsChan)
 
 	// Tick on the tightest loop. The flush timeout is the shorter of the two timeouts using this ticker.
 	// Tick even more frequently than the flush interval (4 is somewhat arbitrary)
@@ -112,25 +177,50 @@ func (h *tcpAssembler) Start(ctx context.Context, wg *sync.WaitGroup) {
 	h.startedAt = time.Now()
 	defragger := ip4defrag.NewIPv4Defragmenter()
 
+	// expire DNS queries that haven't had a response, a nil channel never fires when DNS isn't captured
+	var dnsExpireTicks <-chan time.Time
+	if h.dns != nil {
+		dnsExpireTicker := time.NewTicker(time.Second)
+		defer dnsExpireTicker.Stop()
+		dnsExpireTicks = dnsExpireTicker.C
+	}
+
+	// with more than one shard, each shard reassembles and flushes its streams in its own goroutine
+	sharded := len(h.shards) > 1
+	if sharded {
+		flushCloseTicker.Stop()
+		for _, shard := range h.shards {
+			h.shardsWg.Add(1)
+			go shard.run(h.shardsWg, h.now)
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			h.Stop()
 			return
 		case <-flushCloseTicker.C:
-			flushed, closed := h.assembler.FlushWithOptions(
-				reassembly.FlushOptions{
-					T:  time.Now().Add(-h.config.StreamFlushTimeout),
-					TC: time.Now().Add(-h.config.StreamCloseTimeout),
-				},
-			)
-			log.Debug().
-				Int("flushed", flushed).
-				Int("closed", closed).
-				Msg("Flushing old streams")
+			h.shards[0].flush(h.now())
 		case <-statsTicker.C:
 			h.logAssemblerStats()
-		case packet := <-h.packetSource.Packets():
+		case <-dnsExpireTicks:
+			h.dns.expire(h.now())
+		case packet, ok := <-h.packetSource.Packets():
+			if !ok {
+				// the packet source has no more packets, eg we reached the end of a capture file
+				log.Info().Msg("Packet source exhausted")
+				h.Stop()
+				return
+			}
+			if h.replaying {
+				timestamp := packet.Metadata().CaptureInfo.Timestamp
+				if h.replayPacer != nil && !h.replayPacer.wait(ctx, timestamp) {
+					h.Stop()
+					return
+				}
+				h.lastPacketTimestamp.Store(timestamp.UnixNano())
+			}
 			if packet.NetworkLayer() == nil {
 				// can't use this packet
 				continue
@@ -177,41 +267,89 @@ func (h *tcpAssembler) Start(ctx context.Context, wg *sync.WaitGroup) {
 					ack:         reassembly.Sequence(tcp.Ack),
 				}
 				stats.totalsz += len(tcp.Payload)
-				h.assembler.AssembleWithContext(packet.NetworkLayer().NetworkFlow(), tcp, &context)
+				shardPacket := shardPacket{
+					netFlow: packet.NetworkLayer().NetworkFlow(),
+					tcp:     tcp,
+					context: &context,
+				}
+				if sharded {
+					shard := h.shards[shardForFlow(shardPacket.netFlow, tcp.TransportFlow(), len(h.shards))]
+					shard.packets <- shardPacket
+				} else {
+					h.shards[0].assemble(shardPacket)
+				}
+			} else if udpLayer := packet.Layer(layers.LayerTypeUDP); udpLayer != nil && h.dns != nil {
+				h.dns.handlePacket(packet.NetworkLayer().NetworkFlow(), udpLayer.(*layers.UDP), packet.Metadata().CaptureInfo.Timestamp)
 			}
 		}
 	}
 }
 
+// Stop closes every open stream, which sends their events (eg unmatched requests and connection events),
+// so the events channel must still be read until the assembler closes it
 func (h *tcpAssembler) Stop() {
-	closed := h.assembler.FlushAll()
-	if zerolog.GlobalLevel() >= zerolog.DebugLevel {
-		// this uses stdlib's log, but oh well
-		h.streamPool.Dump()
+	// let shard goroutines finish reassembling queued packets before closing their streams
+	if len(h.shards) > 1 {
+		for _, shard := range h.shards {
+			close(shard.packets)
+		}
+		h.shardsWg.Wait()
+	}
+
+	closed := 0
+	for _, shard := range h.shards {
+		closed += shard.stop()
+	}
+	// the capture has ended, so queries still waiting for a response won't get one
+	if h.dns != nil {
+		h.dns.flush()
 	}
 
 	h.logAssemblerStats()
 	log.Debug().
 		Int("closed", closed).
-		Str("assembler_page_usage", h.assembler.Dump()).
+		Int("shards", len(h.shards)).
 		Msg("Stopping TCP assembler")
 }
 
+// now returns the current time according to the assembler.
+// When replaying a capture file, this is the capture timestamp of the most recent packet
+// so stream flush and close timeouts are relative to the capture rather than the wall clock.
+func (h *tcpAssembler) now() time.Time {
+	if h.replaying {
+		if timestamp := h.lastPacketTimestamp.Load(); timestamp != 0 {
+			return time.Unix(0, timestamp)
+		}
+	}
+	return time.Now()
+}
+
 func (a *tcpAssembler) logAssemblerStats() {
 	statsFields := map[string]interface{}{
-		"uptime_ms":          time.Since(a.startedAt).Milliseconds(),
-		"IPdefrag":           stats.ipdefrag,
-		"rejected_FSM":       stats.rejectFsm.Load(),
-		"rejected_Options":   stats.rejectOpt.Load(),
-		"total_TCP_bytes":    stats.totalsz,
-		"conn_rejected_FSM":  stats.rejectConnFsm.Load(),
-		"source_received":    stats.source_received.Load(),
-		"source_dropped":     stats.source_dropped.Load(),
-		"source_if_dropped":  stats.source_if_dropped.Load(),
-		"event_queue_length": len(a.eventsChan),
-		"goroutines":         runtime.NumGoroutine(),
-		"total_streams":      stats.total_streams.Load(),
-		"active_streams":     stats.active_streams.Load(),
+		"uptime_ms":                          time.Since(a.startedAt).Milliseconds(),
+		"IPdefrag":                           stats.ipdefrag,
+		"rejected_FSM":                       stats.rejectFsm.Load(),
+		"rejected_Options":                   stats.rejectOpt.Load(),
+		"total_TCP_bytes":                    stats.totalsz,
+		"conn_rejected_FSM":                  stats.rejectConnFsm.Load(),
+		"source_received":                    stats.source_received.Load(),
+		"source_dropped":                     stats.source_dropped.Load(),
+		"source_if_dropped":                  stats.source_if_dropped.Load(),
+		"source_queue_freezes":               stats.source_queue_freezes.Load(),
+		"http_matcher_evicted_timeout":       stats.http_unmatched_timeout.Load(),
+		"http_matcher_evicted_max_entries":   stats.http_unmatched_max_entries.Load(),
+		"http_matcher_evicted_stream_closed": stats.http_unmatched_stream_closed.Load(),
+		"http_matcher_evicted_not_captured":  stats.http_unmatched_not_captured.Load(),
+		"http_body_capture_bytes":            stats.http_body_capture_bytes.Load(),
+		"http_body_capture_memory_exhausted": stats.http_body_capture_memory_exhausted.Load(),
+		"grpc_message_buffer_bytes":          stats.grpc_message_buffer_bytes.Load(),
+		"grpc_message_buffer_exhausted":      stats.grpc_message_buffer_exhausted.Load(),
+		"desynced_max_pending":               stats.desynced_max_pending.Load(),
+		"event_queue_length":                 len(a.eventsChan),
+		"shard_queue_length":                 a.shardQueueLength(),
+		"goroutines":                         runtime.NumGoroutine(),
+		"total_streams":                      stats.total_streams.Load(),
+		"active_streams":                     stats.active_streams.Load(),
 	}
 	statsEvent := libhoney.NewEvent()
 	statsEvent.Dataset = a.config.StatsDataset
@@ -224,6 +362,15 @@ func (a *tcpAssembler) logAssemblerStats() {
 		Msg("TCP assembler stats")
 }
 
+// shardQueueLength returns the total number of packets waiting to be reassembled by shards
+func (a *tcpAssembler) shardQueueLength() int {
+	length := 0
+	for _, shard := range a.shards {
+		length += len(shard.packets)
+	}
+	return length
+}
+
 func newPcapPacketSource(config config.Config) (*gopacket.PacketSource, error) {
 	log.Debug().
 		Str("interface", config.Interface).
@@ -264,8 +411,9 @@ func logPcapHandleStats(handle *pcap.Handle) {
 			log.Error().Err(err).Msg("Failed to get pcap handle stats")
 			continue
 		}
-		stats.source_received.Add(uint64(pcapStats.PacketsReceived))
-		stats.source_dropped.Add(uint64(pcapStats.PacketsDropped))
-		stats.source_if_dropped.Add(uint64(pcapStats.PacketsIfDropped))
+		// pcap's stats are totals since the handle was opened
+		stats.source_received.Store(uint64(pcapStats.PacketsReceived))
+		stats.source_dropped.Store(uint64(pcapStats.PacketsDropped))
+		stats.source_if_dropped.Store(uint64(pcapStats.PacketsIfDropped))
 	}
 }
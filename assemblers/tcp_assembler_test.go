@@ -0,0 +1,127 @@
+package assemblers
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+// liveTestPacketSource returns its packets as if they were captured live,
+// then waits for more until it's closed
+type liveTestPacketSource struct {
+	packets [][]byte
+	closed  chan struct{}
+}
+
+func (source *liveTestPacketSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if len(source.packets) == 0 {
+		<-source.closed
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	data := source.packets[0]
+	source.packets = source.packets[1:]
+	return data, gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}, nil
+}
+
+// testConnectionPackets serializes the packets of each connection, each from its own client port
+func testConnectionPackets(t *testing.T, connections int, packets []testPacket) [][]byte {
+	var data [][]byte
+	for port := layers.TCPPort(40000); port < layers.TCPPort(40000+connections); port++ {
+		for _, packet := range packets {
+			data = append(data, serializeTestPacket(t, port, packet))
+		}
+	}
+	return data
+}
+
+// stopTestAssembler runs an assembler over the packets with a small event buffer,
+// then stops it once the given number of connections are open and returns the events it sent
+func stopTestAssembler(t *testing.T, config config.Config, packets [][]byte, openConnections int) []Event {
+	source := &liveTestPacketSource{packets: packets, closed: make(chan struct{})}
+	defer close(source.closed)
+
+	config.PacketSource = "pcap"
+	eventsChan := make(chan Event, 4)
+	assembler := newTcpAssembler(config, gopacket.NewPacketSource(source, layers.LinkTypeEthernet), eventsChan)
+
+	activeStreams := stats.active_streams.Load()
+	captureCtx, stopCapture := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go assembler.Start(captureCtx, &wg)
+
+	// the event handler keeps handling events until the assembler closes the channel
+	handled := make(chan []Event)
+	go func() {
+		var events []Event
+		for event := range eventsChan {
+			events = append(events, event)
+		}
+		handled <- events
+	}()
+
+	require.Eventually(t, func() bool { return stats.active_streams.Load() == activeStreams+uint64(openConnections) }, 5*time.Second, 10*time.Millisecond)
+	stopCapture()
+
+	select {
+	case <-assembler.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("assembler did not stop")
+	}
+	wg.Wait()
+	return <-handled
+}
+
+func TestAssemblerStopsWithMoreOpenConnectionsThanTheEventBuffer(t *testing.T) {
+	config := newTestAssemblerConfig()
+	config.ConnectionEvents = true
+
+	events := stopTestAssembler(t, config, testConnectionPackets(t, 50, testHttpExchange()[:3]), 50)
+	assert.Len(t, events, 50)
+	for _, event := range events {
+		assert.IsType(t, &ConnectionEvent{}, event)
+	}
+}
+
+func TestAssemblerStopsWithMoreUnansweredRequestsThanTheEventBuffer(t *testing.T) {
+	events := stopTestAssembler(t, newTestAssemblerConfig(), testConnectionPackets(t, 50, testHttpExchange()[:4]), 50)
+	assert.Len(t, events, 50)
+	for _, event := range events {
+		require.IsType(t, &HttpEvent{}, event)
+		assert.Equal(t, unmatchedStreamClosed, event.(*HttpEvent).UnmatchedReason())
+	}
+}
+
+func TestAssemblerStopsWithMoreDnsQueriesThanTheEventBuffer(t *testing.T) {
+	var packets [][]byte
+	for id := uint16(1); id <= 50; id++ {
+		eth := &layers.Ethernet{SrcMAC: []byte{0, 0, 0, 0, 0, 1}, DstMAC: []byte{0, 0, 0, 0, 0, 2}, EthernetType: layers.EthernetTypeIPv4}
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 53}}
+		udp := &layers.UDP{SrcPort: 40000, DstPort: 53}
+		require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+		data := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		require.NoError(t, gopacket.SerializeLayers(data, opts, eth, ip, udp, newTestDnsQuery(id, "api.example.com", layers.DNSTypeA)))
+		packets = append(packets, data.Bytes())
+	}
+	// packets are handled in order, so once the connection is open every query is waiting for a response
+	packets = append(packets, testConnectionPackets(t, 1, testHttpExchange()[:1])...)
+
+	config := newTestAssemblerConfig()
+	config.DNSPorts = []string{"53"}
+	events := stopTestAssembler(t, config, packets, 1)
+	assert.Len(t, events, 50)
+	for _, event := range events {
+		require.IsType(t, &DnsEvent{}, event)
+		assert.True(t, event.(*DnsEvent).TimedOut())
+	}
+}
@@ -0,0 +1,148 @@
+package assemblers
+
+import (
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// Reasons a TCP connection ended, reported on ConnectionEvents
+const (
+	connectionEndFin     = "fin"
+	connectionEndRst     = "rst"
+	connectionEndTimeout = "timeout"
+)
+
+// Reasons a call failed at the TCP level, reported on ConnectionFailureEvents
+const (
+	connectionFailureRefused    = "connection_refused"
+	connectionFailureSynTimeout = "syn_timeout"
+	connectionFailureReset      = "reset"
+)
+
+// tcpConnection records the lifecycle of a TCP connection and signs of network problems
+// from the packets the stream accepts, so we can send a ConnectionEvent when it closes
+type tcpConnection struct {
+	firstTimestamp time.Time
+	lastTimestamp  time.Time
+	// capture timestamps of the three-way handshake, zero when we didn't see that packet
+	synTimestamp          time.Time
+	synAckTimestamp       time.Time
+	handshakeAckTimestamp time.Time
+	clientBytes           int64
+	serverBytes           int64
+	clientPackets         int
+	serverPackets         int
+	fin                   bool
+	rst                   bool
+	// the client sent data the server hasn't responded to yet, since the timestamp
+	awaitingResponse      bool
+	awaitingResponseSince time.Time
+	// set once a ConnectionFailureEvent has been sent, so we only send one per connection
+	failed bool
+	// number of events sent for requests made on the connection
+	requestCount int
+	client       tcpDirection
+	server       tcpDirection
+	// the connection's totals, and since the last request event was sent
+	stats        TcpStats
+	requestStats TcpStats
+}
+
+// packet records a packet sent by the client or server
+func (conn *tcpConnection) packet(tcp *layers.TCP, timestamp time.Time, isClient bool) {
+	if conn.firstTimestamp.IsZero() {
+		conn.firstTimestamp = timestamp
+	}
+	if timestamp.After(conn.lastTimestamp) {
+		conn.lastTimestamp = timestamp
+	}
+
+	if isClient {
+		conn.clientBytes += int64(len(tcp.Payload))
+		conn.clientPackets++
+		if len(tcp.Payload) > 0 && !conn.awaitingResponse {
+			conn.awaitingResponse = true
+			conn.awaitingResponseSince = timestamp
+		}
+	} else {
+		conn.serverBytes += int64(len(tcp.Payload))
+		conn.serverPackets++
+		if len(tcp.Payload) > 0 {
+			conn.awaitingResponse = false
+		}
+	}
+
+	switch {
+	case tcp.SYN && !tcp.ACK:
+		// clients retry SYNs that aren't answered, we measure from the first attempt
+		if conn.synTimestamp.IsZero() {
+			conn.synTimestamp = timestamp
+		}
+	case tcp.SYN && tcp.ACK:
+		if conn.synAckTimestamp.IsZero() {
+			conn.synAckTimestamp = timestamp
+		}
+	case tcp.ACK && isClient && !conn.synAckTimestamp.IsZero() && conn.handshakeAckTimestamp.IsZero():
+		conn.handshakeAckTimestamp = timestamp
+	}
+	if tcp.FIN {
+		conn.fin = true
+	}
+	if tcp.RST {
+		conn.rst = true
+	}
+
+	sender, receiver := &conn.client, &conn.server
+	if !isClient {
+		sender, receiver = receiver, sender
+	}
+	conn.segment(sender, tcp, timestamp)
+	conn.ack(sender, receiver, tcp, timestamp)
+	if tcp.Window == 0 && !tcp.RST {
+		conn.record(func(stats *TcpStats) { stats.ZeroWindows++ })
+	}
+}
+
+// resetFailure returns why a reset sent by the client or server means a call failed,
+// or "" if the connection was reset while idle
+func (conn *tcpConnection) resetFailure(isClient bool) string {
+	switch {
+	case !isClient && !conn.synTimestamp.IsZero() && conn.synAckTimestamp.IsZero():
+		// nothing is listening on the server's port
+		return connectionFailureRefused
+	case conn.awaitingResponse:
+		return connectionFailureReset
+	}
+	return ""
+}
+
+// synTimedOut returns true if the client sent a SYN that was never answered
+func (conn *tcpConnection) synTimedOut() bool {
+	return !conn.synTimestamp.IsZero() && conn.serverPackets == 0 && !conn.rst
+}
+
+// handshakeDurations returns the time from the client's SYN to the server's SYN-ACK,
+// and from the SYN-ACK to the client's ACK, or -1 for either we didn't see both packets of
+func (conn *tcpConnection) handshakeDurations() (synToSynAck time.Duration, synAckToAck time.Duration) {
+	synToSynAck, synAckToAck = -1, -1
+	if !conn.synTimestamp.IsZero() && !conn.synAckTimestamp.IsZero() {
+		synToSynAck = conn.synAckTimestamp.Sub(conn.synTimestamp)
+	}
+	if !conn.synAckTimestamp.IsZero() && !conn.handshakeAckTimestamp.IsZero() {
+		synAckToAck = conn.handshakeAckTimestamp.Sub(conn.synAckTimestamp)
+	}
+	return synToSynAck, synAckToAck
+}
+
+// endReason returns why the connection ended, a reset takes priority over a graceful close
+// and connections we didn't see either for were flushed after being idle
+func (conn *tcpConnection) endReason() string {
+	switch {
+	case conn.rst:
+		return connectionEndRst
+	case conn.fin:
+		return connectionEndFin
+	}
+	return connectionEndTimeout
+}
@@ -0,0 +1,137 @@
+package assemblers
+
+import (
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/reassembly"
+)
+
+// tcpOutOfOrderThreshold is how soon after the highest segment an earlier segment must arrive to be counted
+// as out of order rather than a retransmission, until we have an RTT sample to use instead
+const tcpOutOfOrderThreshold = 3 * time.Millisecond
+
+// TcpStats counts signs of network problems seen on a TCP connection, and summarises samples of its round trip time.
+//
+// RTT samples are the time from a segment being captured to its acknowledgement being captured,
+// so they're the round trip time between the agent and the receiver.
+// Only the packets matched by the capture filter are seen, so stats are only reported for connections
+// where every packet is captured: connections captured by payload, like plain HTTP outside HTTP_PORTS,
+// would only count the packets that start a request or response.
+type TcpStats struct {
+	// segments carrying data that was already sent
+	Retransmissions int
+	// segments that arrived shortly after a segment that followed them
+	OutOfOrder int
+	// acknowledgements repeated without new data or a window update, usually sent when a segment was lost
+	DuplicateAcks int
+	// packets advertising a zero receive window, the receiver can't keep up with the sender
+	ZeroWindows int
+	RttSamples  int
+	RttMin      time.Duration
+	RttMax      time.Duration
+	RttTotal    time.Duration
+}
+
+// RttMean returns the mean of the RTT samples, or 0 if there weren't any
+func (stats TcpStats) RttMean() time.Duration {
+	if stats.RttSamples == 0 {
+		return 0
+	}
+	return stats.RttTotal / time.Duration(stats.RttSamples)
+}
+
+func (stats *TcpStats) addRttSample(rtt time.Duration) {
+	if stats.RttSamples == 0 || rtt < stats.RttMin {
+		stats.RttMin = rtt
+	}
+	if rtt > stats.RttMax {
+		stats.RttMax = rtt
+	}
+	stats.RttSamples++
+	stats.RttTotal += rtt
+}
+
+// tcpDirection tracks the segments and acknowledgements sent one way on a TCP connection
+type tcpDirection struct {
+	seen bool
+	// the sequence number following the highest segment sent, and when that segment was captured
+	nextSeq          reassembly.Sequence
+	nextSeqTimestamp time.Time
+	ackSeen          bool
+	lastAck          reassembly.Sequence
+	lastWindow       uint16
+	// a segment being timed for an RTT sample until the other side acknowledges it, only one is timed at a time
+	rttPending   bool
+	rttSeq       reassembly.Sequence
+	rttTimestamp time.Time
+}
+
+// segment records a segment sent in this direction that carries data, a SYN or a FIN
+func (conn *tcpConnection) segment(sender *tcpDirection, tcp *layers.TCP, timestamp time.Time) {
+	length := len(tcp.Payload)
+	if tcp.SYN || tcp.FIN {
+		length++
+	}
+	if length == 0 {
+		return
+	}
+	seq := reassembly.Sequence(tcp.Seq)
+	end := seq.Add(length)
+	if !sender.seen || seq.Difference(sender.nextSeq) <= 0 {
+		// new data
+		sender.seen = true
+		sender.nextSeq = end
+		sender.nextSeqTimestamp = timestamp
+		if !sender.rttPending {
+			sender.rttPending = true
+			sender.rttSeq = end
+			sender.rttTimestamp = timestamp
+		}
+		return
+	}
+
+	// the segment starts before data we've already seen
+	threshold := tcpOutOfOrderThreshold
+	if conn.stats.RttSamples > 0 {
+		threshold = conn.stats.RttMin
+	}
+	if timestamp.Sub(sender.nextSeqTimestamp) < threshold {
+		conn.record(func(stats *TcpStats) { stats.OutOfOrder++ })
+	} else {
+		conn.record(func(stats *TcpStats) { stats.Retransmissions++ })
+		// we can't tell which copy of a retransmitted segment is acknowledged, so stop timing
+		sender.rttPending = false
+	}
+	if end.Difference(sender.nextSeq) < 0 {
+		sender.nextSeq = end
+	}
+}
+
+// ack records an acknowledgement sent in this direction for segments sent by the receiver
+func (conn *tcpConnection) ack(sender *tcpDirection, receiver *tcpDirection, tcp *layers.TCP, timestamp time.Time) {
+	if !tcp.ACK || tcp.RST {
+		return
+	}
+	ack := reassembly.Sequence(tcp.Ack)
+	// segments carrying data can be held back waiting for the application, so they don't give RTT samples
+	if receiver.rttPending && receiver.rttSeq.Difference(ack) >= 0 && len(tcp.Payload) == 0 {
+		rtt := timestamp.Sub(receiver.rttTimestamp)
+		conn.record(func(stats *TcpStats) { stats.addRttSample(rtt) })
+		receiver.rttPending = false
+	}
+
+	pureAck := len(tcp.Payload) == 0 && !tcp.SYN && !tcp.FIN
+	if pureAck && sender.ackSeen && ack == sender.lastAck && tcp.Window == sender.lastWindow && tcp.Window != 0 {
+		conn.record(func(stats *TcpStats) { stats.DuplicateAcks++ })
+	}
+	sender.ackSeen = true
+	sender.lastAck = ack
+	sender.lastWindow = tcp.Window
+}
+
+// record updates the connection's totals and the stats since the last request event
+func (conn *tcpConnection) record(update func(stats *TcpStats)) {
+	update(&conn.stats)
+	update(&conn.requestStats)
+}
@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"strconv"
+	"time"
 
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/layers"
@@ -31,12 +33,33 @@ type tcpStream struct {
 	srcPort    string
 	dstPort    string
 	buffer     *bufio.Reader
-	parsers    []parser
+	// the data being parsed, read through the buffer
+	data    *bytes.Reader
+	segment []byte
+	parsers []parser
+	// the capture timestamp of the last packet in the data being parsed,
+	// parsers are passed the timestamp of the first
+	lastTimestamp time.Time
+	// the number of bytes missing between the previous data in the same direction and the data being parsed,
+	// eg from packets that were lost or not captured
+	skipped int
+	// set when every packet on the connection is captured, rather than only
+	// those that start with a HTTP request or response
+	fullCapture bool
+	// set when the first packet seen for the connection was sent by the server,
+	// so gopacket's client and server directions are the wrong way round
+	reversed bool
+	// the connection's handshake, traffic, network problems and close,
+	// sent as a ConnectionEvent when enabled
+	connection tcpConnection
+	// set once gopacket has finished with the stream
+	closed bool
 }
 
 func NewTcpStream(net gopacket.Flow, transport gopacket.Flow, config config.Config, eventsChan chan Event) *tcpStream {
 	streamId := IncrementStreamCount()
-	return &tcpStream{
+	data := bytes.NewReader(nil)
+	stream := &tcpStream{
 		id:     streamId,
 		ident:  fmt.Sprintf("%s:%s:%d", net, transport, streamId),
 		config: config,
@@ -50,15 +73,35 @@ func NewTcpStream(net gopacket.Flow, transport gopacket.Flow, config config.Conf
 		dstIP:      net.Dst().String(),
 		srcPort:    transport.Src().String(),
 		dstPort:    transport.Dst().String(),
-		buffer:     bufio.NewReader(bytes.NewReader(nil)),
-		parsers: []parser{
-			newHttpParser(config.HTTPHeadersToExtract),
-		},
+		buffer:     bufio.NewReader(data),
+		data:       data,
 	}
+	stream.parsers, stream.reversed = newStreamParsers(config, stream.srcPort, stream.dstPort)
+	stream.fullCapture = capturesAllPackets(config, stream.srcPort, stream.dstPort)
+	if stream.reversed {
+		// make sure the client is always the source of events
+		stream.srcIP, stream.dstIP = stream.dstIP, stream.srcIP
+		stream.srcPort, stream.dstPort = stream.dstPort, stream.srcPort
+	}
+	return stream
 }
 
 // Accept implements gopacket's [reassembly.Stream.Accept] interface.
 func (stream *tcpStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	isClient := dir == reassembly.TCPDirClientToServer
+	if stream.reversed {
+		isClient = !isClient
+	}
+	stream.connection.packet(tcp, ci.Timestamp, isClient)
+	if stream.config.ConnectionFailureEvents && tcp.RST && !stream.connection.failed {
+		if reason := stream.connection.resetFailure(isClient); reason != "" {
+			resetBy := "server"
+			if isClient {
+				resetBy = "client"
+			}
+			stream.sendConnectionFailureEvent(reason, resetBy, ci.Timestamp)
+		}
+	}
 	// FSM
 	if !stream.tcpstate.CheckState(tcp, dir) {
 		// Error("FSM", "%s: Packet rejected by FSM (state:%s)\n", t.ident, t.tcpstate.String())
@@ -110,8 +153,11 @@ func (stream *tcpStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir re
 // Note: the reassembly.ScatterGather param (sg) is reused after each ReassembledSG call, so copy what's needed out of it before return.
 func (stream *tcpStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
 	// Get the direction of the packet (client to server or server to client)
-	dir, _, _, _ := sg.Info()
+	dir, _, _, skip := sg.Info()
 	isClient := dir == reassembly.TCPDirClientToServer
+	if stream.reversed {
+		isClient = !isClient
+	}
 
 	// get our custom context that includes the TCP seq/ack numbers
 	ctx, ok := ac.(*Context)
@@ -120,9 +166,9 @@ func (stream *tcpStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembl
 			Msg("Failed to cast given AssemblerContext to ContextWithSeq")
 	}
 
-	// We use TCP SEQ & ACK numbers to identify request/response pairs
-	// A request's ACK corresponds to the SEQ of it's matching response
+	// We use TCP SEQ & ACK numbers to identify the data, as a request's ACK corresponds to the SEQ of it's response
 	// https://madpackets.com/2018/04/25/tcp-sequence-and-acknowledgement-numbers-explained/
+	// A segment can contain several requests or responses, so parsers match them up themselves.
 	var requestId int64
 	if isClient {
 		requestId = int64(ctx.ack)
@@ -137,16 +183,75 @@ func (stream *tcpStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembl
 	len, _ := sg.Lengths()
 	data := sg.Fetch(len)
 
+	// the data can span several packets, so we know when its first and last bytes were captured
+	firstTimestamp := sg.CaptureInfo(0).Timestamp
+	if firstTimestamp.IsZero() {
+		firstTimestamp = ctx.CaptureInfo.Timestamp
+	}
+	stream.lastTimestamp = sg.CaptureInfo(len - 1).Timestamp
+	if stream.lastTimestamp.IsZero() {
+		stream.lastTimestamp = ctx.CaptureInfo.Timestamp
+	}
+
+	// gopacket reports -1 for the first data in each direction, which doesn't follow anything we could have missed
+	stream.skipped = max(skip, 0)
+
+	stream.parse(data, requestId, firstTimestamp, isClient, packetCount)
+}
+
+// lastByteTimestamp returns the capture timestamp of the last packet in the data being parsed,
+// or the timestamp of the first packet (passed to parsers) when we don't know it
+func (stream *tcpStream) lastByteTimestamp(firstTimestamp time.Time) time.Time {
+	if stream.lastTimestamp.Before(firstTimestamp) {
+		return firstTimestamp
+	}
+	return stream.lastTimestamp
+}
+
+// sendEvent sends an event for a request made on the stream's connection,
+// with the network problems seen on the connection since the previous request's event
+// if every packet on the connection is captured
+func (stream *tcpStream) sendEvent(event Event) {
+	stream.connection.requestCount++
+	if event, ok := event.(tcpStatsSetter); ok && stream.fullCapture {
+		event.setTcpStats(stream.connection.requestStats)
+	}
+	stream.connection.requestStats = TcpStats{}
+	stream.eventsChan <- event
+}
+
+// consumed returns the number of bytes of the data being parsed that have been read from the buffer,
+// so parsers can tell where a message starts when the data holds several
+func (stream *tcpStream) consumed() int64 {
+	return stream.data.Size() - int64(stream.data.Len()) - int64(stream.buffer.Buffered())
+}
+
+// unread returns the data being parsed from the given offset, which is only valid until the next segment is parsed
+func (stream *tcpStream) unread(offset int64) []byte {
+	return stream.segment[offset:]
+}
+
+// prepend puts data carried over from earlier segments in front of the data still to be parsed
+func (stream *tcpStream) prepend(data []byte) {
+	stream.segment = append(data, stream.unread(stream.consumed())...)
+	stream.data.Reset(stream.segment)
+	stream.buffer.Reset(stream.data)
+}
+
+// parse passes reassembled data to each of the stream's parsers in turn until one of them can parse it
+func (stream *tcpStream) parse(data []byte, requestId int64, timestamp time.Time, isClient bool, packetCount int) {
 	// reset the buffer reader to use the new packet data
 	// bufio.NewReader creates a new 16 byte buffer on each call,
 	// so we reset the existing buffer with those bytes instead of
 	// allocating new memory
 	// https://github.com/golang/go/blob/master/src/bufio/bufio.go#L57
-	stream.buffer.Reset(bytes.NewReader(data))
+	stream.segment = data
+	stream.data.Reset(data)
+	stream.buffer.Reset(stream.data)
 
 	// loop through the parsers until we find one that can parse the request/response
 	for _, parser := range stream.parsers {
-		success, err := parser.parse(stream, requestId, ctx.CaptureInfo.Timestamp, isClient, stream.buffer, packetCount)
+		success, err := parser.parse(stream, requestId, timestamp, isClient, stream.buffer, packetCount)
 		if err != nil {
 			// if we hit the end of the stream, stop trying to parse
 			if err == io.EOF || err == io.ErrUnexpectedEOF {
@@ -169,6 +274,15 @@ func (stream *tcpStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembl
 	}
 }
 
+// evict lets the stream's parsers send messages that have waited too long for their counterpart
+func (stream *tcpStream) evict(now time.Time) {
+	for _, parser := range stream.parsers {
+		if evicter, ok := parser.(streamEvicter); ok {
+			evicter.evict(stream, now)
+		}
+	}
+}
+
 // ReassemblyComplete implements gopacket's [reassembly.Stream.ReassemblyComplete] interface.
 // Called when gopacket's assembly internals has decided that there is no more data for this Stream
 // (e.g. FIN or RST packet, timed out without new data).
@@ -179,6 +293,71 @@ func (stream *tcpStream) ReassemblyComplete(ac reassembly.AssemblerContext) bool
 	log.Debug().
 		Str("stream_ident", stream.ident).
 		Msg("Connection closed")
+	for _, parser := range stream.parsers {
+		if closer, ok := parser.(streamCloser); ok {
+			closer.close(stream)
+		}
+	}
+	// unanswered SYNs are retried until the client gives up, so we wait for the connection to be closed as idle
+	if stream.config.ConnectionFailureEvents && !stream.connection.failed && stream.connection.synTimedOut() {
+		stream.sendConnectionFailureEvent(connectionFailureSynTimeout, "", time.Time{})
+	}
+	if stream.config.ConnectionEvents {
+		stream.sendConnectionEvent()
+	}
+	stream.closed = true
 	DecrementActiveStreamCount()
 	return true // remove the connection, heck with the last ACK
 }
+
+// sendConnectionEvent sends a ConnectionEvent describing the stream's connection
+func (stream *tcpStream) sendConnectionEvent() {
+	conn := &stream.connection
+	synToSynAck, synAckToAck := conn.handshakeDurations()
+	var tcpStats *TcpStats
+	if stream.fullCapture {
+		tcpStats = &conn.stats
+	}
+	stream.eventsChan <- NewConnectionEvent(
+		stream.ident,
+		int64(stream.id),
+		conn.firstTimestamp,
+		conn.lastTimestamp,
+		conn.clientPackets,
+		conn.serverPackets,
+		stream.srcIP,
+		stream.dstIP,
+		synToSynAck,
+		synAckToAck,
+		conn.clientBytes,
+		conn.serverBytes,
+		conn.endReason(),
+		conn.requestCount,
+		tcpStats,
+	)
+}
+
+// sendConnectionFailureEvent sends a ConnectionFailureEvent for a call that failed at the TCP level,
+// with the side that reset the connection and when, if it was reset
+func (stream *tcpStream) sendConnectionFailureEvent(reason string, resetBy string, resetTimestamp time.Time) {
+	conn := &stream.connection
+	conn.failed = true
+	requestTimestamp := conn.synTimestamp
+	if reason == connectionFailureReset {
+		requestTimestamp = conn.awaitingResponseSince
+	}
+	serverPort, _ := strconv.Atoi(stream.dstPort)
+	stream.eventsChan <- NewConnectionFailureEvent(
+		stream.ident,
+		int64(stream.id),
+		requestTimestamp,
+		resetTimestamp,
+		conn.clientPackets,
+		conn.serverPackets,
+		stream.srcIP,
+		stream.dstIP,
+		serverPort,
+		reason,
+		resetBy,
+	)
+}
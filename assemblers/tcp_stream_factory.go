@@ -1,6 +1,8 @@
 package assemblers
 
 import (
+	"time"
+
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/layers"
 	"github.com/gopacket/gopacket/reassembly"
@@ -12,12 +14,15 @@ import (
 type tcpStreamFactory struct {
 	config     config.Config
 	eventsChan chan Event
+	// the streams created by the factory, until they're seen to be closed when evicting
+	streams map[*tcpStream]struct{}
 }
 
 func NewTcpStreamFactory(config config.Config, eventsChan chan Event) tcpStreamFactory {
 	return tcpStreamFactory{
 		config:     config,
 		eventsChan: eventsChan,
+		streams:    map[*tcpStream]struct{}{},
 	}
 }
 
@@ -27,5 +32,20 @@ func (factory *tcpStreamFactory) New(net, transport gopacket.Flow, tcp *layers.T
 		Str("transport", transport.String()).
 		Msg("NEW tcp stream")
 	IncrementActiveStreamCount()
-	return NewTcpStream(net, transport, factory.config, factory.eventsChan)
+	stream := NewTcpStream(net, transport, factory.config, factory.eventsChan)
+	factory.streams[stream] = struct{}{}
+	return stream
+}
+
+// evict lets the parsers of each open stream evict messages that have waited too long for their counterpart,
+// so they're sent even when no more data arrives on the connection.
+// It must be called from the goroutine that reassembles the factory's streams.
+func (factory *tcpStreamFactory) evict(now time.Time) {
+	for stream := range factory.streams {
+		if stream.closed {
+			delete(factory.streams, stream)
+			continue
+		}
+		stream.evict(now)
+	}
 }
@@ -0,0 +1,251 @@
+package assemblers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/reassembly"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTcpStreamSendsConnectionEvent(t *testing.T) {
+	stream := newHttpTestStream()
+	stream.config.ConnectionEvents = true
+	stream.config.Ignorefsmerr = true
+	stream.config.Nooptcheck = true
+	start := time.Now()
+	ms := func(n int) time.Time { return start.Add(time.Duration(n) * time.Millisecond) }
+	accept := func(tcp *layers.TCP, timestamp time.Time, dir reassembly.TCPFlowDirection) {
+		var startStream bool
+		stream.Accept(tcp, gopacket.CaptureInfo{Timestamp: timestamp}, dir, 0, &startStream, nil)
+	}
+
+	accept(&layers.TCP{SYN: true}, ms(0), reassembly.TCPDirClientToServer)
+	accept(&layers.TCP{SYN: true, ACK: true}, ms(2), reassembly.TCPDirServerToClient)
+	accept(&layers.TCP{ACK: true}, ms(3), reassembly.TCPDirClientToServer)
+	request := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	response := "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+	accept(&layers.TCP{ACK: true, PSH: true, BaseLayer: layers.BaseLayer{Payload: []byte(request)}}, ms(10), reassembly.TCPDirClientToServer)
+	accept(&layers.TCP{ACK: true, PSH: true, BaseLayer: layers.BaseLayer{Payload: []byte(response)}}, ms(20), reassembly.TCPDirServerToClient)
+	stream.parse([]byte(request), 1, ms(10), true, 1)
+	stream.parse([]byte(response), 1, ms(20), false, 1)
+	accept(&layers.TCP{FIN: true, ACK: true}, ms(30), reassembly.TCPDirClientToServer)
+	accept(&layers.TCP{FIN: true, ACK: true}, ms(31), reassembly.TCPDirServerToClient)
+
+	IncrementActiveStreamCount()
+	stream.ReassemblyComplete(nil)
+	require.Len(t, stream.eventsChan, 2)
+	<-stream.eventsChan // the HTTP event
+
+	event := (<-stream.eventsChan).(*ConnectionEvent)
+	assert.Equal(t, "10.0.0.1", event.SrcIp())
+	assert.Equal(t, "10.0.0.2", event.DstIp())
+	assert.Equal(t, ms(0), event.RequestTimestamp())
+	assert.Equal(t, ms(31), event.ResponseTimestamp())
+	assert.Equal(t, 31*time.Millisecond, event.Duration())
+	assert.Equal(t, 2*time.Millisecond, event.SynToSynAck())
+	assert.Equal(t, time.Millisecond, event.SynAckToAck())
+	assert.Equal(t, 3*time.Millisecond, event.HandshakeRtt())
+	assert.Equal(t, int64(len(request)), event.ClientBytes())
+	assert.Equal(t, int64(len(response)), event.ServerBytes())
+	assert.Equal(t, 4, event.RequestPacketCount())
+	assert.Equal(t, 3, event.ResponsePacketCount())
+	assert.Equal(t, "fin", event.EndReason())
+	assert.Equal(t, 1, event.RequestCount())
+}
+
+func TestTcpStreamReportsResetAndMissingHandshake(t *testing.T) {
+	stream := newHttpTestStream()
+	stream.config.ConnectionEvents = true
+	stream.config.Ignorefsmerr = true
+	stream.config.Nooptcheck = true
+	start := time.Now()
+	var startStream bool
+
+	// the connection was opened before we started capturing
+	stream.Accept(&layers.TCP{ACK: true}, gopacket.CaptureInfo{Timestamp: start}, reassembly.TCPDirClientToServer, 0, &startStream, nil)
+	stream.Accept(&layers.TCP{RST: true}, gopacket.CaptureInfo{Timestamp: start.Add(time.Second)}, reassembly.TCPDirServerToClient, 0, &startStream, nil)
+
+	IncrementActiveStreamCount()
+	stream.ReassemblyComplete(nil)
+	require.Len(t, stream.eventsChan, 1)
+	event := (<-stream.eventsChan).(*ConnectionEvent)
+	assert.Equal(t, time.Duration(-1), event.HandshakeRtt())
+	assert.Equal(t, "rst", event.EndReason())
+	assert.Equal(t, 0, event.RequestCount())
+}
+
+func TestTcpStreamDoesNotSendConnectionEventWhenDisabled(t *testing.T) {
+	stream := newHttpTestStream()
+	IncrementActiveStreamCount()
+	stream.ReassemblyComplete(nil)
+	assert.Empty(t, stream.eventsChan)
+}
+
+func TestTcpStreamTracksNetworkProblems(t *testing.T) {
+	stream := newHttpTestStream()
+	stream.config.ConnectionEvents = true
+	stream.config.Ignorefsmerr = true
+	stream.config.Nooptcheck = true
+	start := time.Now()
+	at := func(d time.Duration) gopacket.CaptureInfo { return gopacket.CaptureInfo{Timestamp: start.Add(d)} }
+	client := func(tcp *layers.TCP, ci gopacket.CaptureInfo) {
+		var startStream bool
+		stream.Accept(tcp, ci, reassembly.TCPDirClientToServer, 0, &startStream, nil)
+	}
+	server := func(tcp *layers.TCP, ci gopacket.CaptureInfo) {
+		var startStream bool
+		stream.Accept(tcp, ci, reassembly.TCPDirServerToClient, 0, &startStream, nil)
+	}
+	data := func(seq uint32, ack uint32) *layers.TCP {
+		return &layers.TCP{Seq: seq, Ack: ack, ACK: true, Window: 1000, BaseLayer: layers.BaseLayer{Payload: make([]byte, 10)}}
+	}
+
+	// the handshake gives an RTT sample each way, 2ms to the server and 1ms to the client
+	client(&layers.TCP{Seq: 100, SYN: true, Window: 1000}, at(0))
+	server(&layers.TCP{Seq: 500, Ack: 101, SYN: true, ACK: true, Window: 1000}, at(2*time.Millisecond))
+	client(&layers.TCP{Seq: 101, Ack: 501, ACK: true, Window: 1000}, at(3*time.Millisecond))
+
+	// the first segment is sent again long after the second
+	client(data(101, 501), at(10*time.Millisecond))
+	client(data(111, 501), at(11*time.Millisecond))
+	client(data(101, 501), at(300*time.Millisecond))
+	// the server asks for the missing data twice
+	server(&layers.TCP{Seq: 501, Ack: 111, ACK: true, Window: 1000}, at(301*time.Millisecond))
+	server(&layers.TCP{Seq: 501, Ack: 111, ACK: true, Window: 1000}, at(302*time.Millisecond))
+	// a segment arrives less than an RTT after the one that follows it
+	client(data(131, 501), at(400*time.Millisecond))
+	client(data(121, 501), at(400*time.Millisecond+500*time.Microsecond))
+	// the server acknowledges everything 100ms later, but can't take any more
+	server(&layers.TCP{Seq: 501, Ack: 141, ACK: true, Window: 0}, at(500*time.Millisecond))
+
+	expected := TcpStats{
+		Retransmissions: 1,
+		OutOfOrder:      1,
+		DuplicateAcks:   1,
+		ZeroWindows:     1,
+		RttSamples:      3,
+		RttMin:          time.Millisecond,
+		RttMax:          100 * time.Millisecond,
+		RttTotal:        103 * time.Millisecond,
+	}
+
+	// request events carry what happened since the previous request event
+	stream.parse([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), 1, start, true, 1)
+	stream.parse([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"), 1, start.Add(time.Second), false, 1)
+	require.Len(t, stream.eventsChan, 1)
+	stats, ok := (<-stream.eventsChan).(*HttpEvent).TcpStats()
+	assert.True(t, ok)
+	assert.Equal(t, expected, stats)
+	assert.Equal(t, TcpStats{}, stream.connection.requestStats)
+
+	// connection events carry the connection's totals
+	IncrementActiveStreamCount()
+	stream.ReassemblyComplete(nil)
+	require.Len(t, stream.eventsChan, 1)
+	event := (<-stream.eventsChan).(*ConnectionEvent)
+	stats, ok = event.TcpStats()
+	assert.True(t, ok)
+	assert.Equal(t, expected, stats)
+	assert.Equal(t, 103*time.Millisecond/3, stats.RttMean())
+}
+
+func TestTcpStreamLeavesOutNetworkProblemsWhenPayloadFiltered(t *testing.T) {
+	stream := newPayloadFilteredHttpTestStream()
+	stream.config.ConnectionEvents = true
+	start := time.Now()
+
+	stream.parse([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), 1, start, true, 1)
+	stream.parse([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"), 1, start.Add(time.Second), false, 1)
+	require.Len(t, stream.eventsChan, 1)
+	_, ok := (<-stream.eventsChan).(*HttpEvent).TcpStats()
+	assert.False(t, ok)
+
+	IncrementActiveStreamCount()
+	stream.ReassemblyComplete(nil)
+	require.Len(t, stream.eventsChan, 1)
+	_, ok = (<-stream.eventsChan).(*ConnectionEvent).TcpStats()
+	assert.False(t, ok)
+}
+
+func TestTcpStreamSendsConnectionFailureEvents(t *testing.T) {
+	newStream := func() *tcpStream {
+		stream := newHttpTestStream()
+		stream.config.ConnectionFailureEvents = true
+		stream.config.Ignorefsmerr = true
+		stream.config.Nooptcheck = true
+		return stream
+	}
+	start := time.Now()
+	at := func(d time.Duration) gopacket.CaptureInfo { return gopacket.CaptureInfo{Timestamp: start.Add(d)} }
+	accept := func(stream *tcpStream, tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection) {
+		var startStream bool
+		stream.Accept(tcp, ci, dir, 0, &startStream, nil)
+	}
+	request := layers.BaseLayer{Payload: []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")}
+
+	t.Run("refused", func(t *testing.T) {
+		stream := newStream()
+		accept(stream, &layers.TCP{SYN: true, Window: 1000}, at(0), reassembly.TCPDirClientToServer)
+		accept(stream, &layers.TCP{RST: true, ACK: true}, at(time.Millisecond), reassembly.TCPDirServerToClient)
+		require.Len(t, stream.eventsChan, 1)
+		event := (<-stream.eventsChan).(*ConnectionFailureEvent)
+		assert.Equal(t, "connection_refused", event.Reason())
+		assert.Equal(t, "server", event.ResetBy())
+		assert.Equal(t, 8080, event.ServerPort())
+		assert.Equal(t, "10.0.0.1", event.SrcIp())
+		assert.Equal(t, "10.0.0.2", event.DstIp())
+		assert.Equal(t, start, event.RequestTimestamp())
+		assert.Equal(t, start.Add(time.Millisecond), event.ResponseTimestamp())
+
+		// only one failure is sent per connection
+		IncrementActiveStreamCount()
+		stream.ReassemblyComplete(nil)
+		assert.Empty(t, stream.eventsChan)
+	})
+
+	t.Run("syn timeout", func(t *testing.T) {
+		stream := newStream()
+		accept(stream, &layers.TCP{SYN: true, Window: 1000}, at(0), reassembly.TCPDirClientToServer)
+		accept(stream, &layers.TCP{SYN: true, Window: 1000}, at(time.Second), reassembly.TCPDirClientToServer)
+		assert.Empty(t, stream.eventsChan)
+
+		IncrementActiveStreamCount()
+		stream.ReassemblyComplete(nil)
+		require.Len(t, stream.eventsChan, 1)
+		event := (<-stream.eventsChan).(*ConnectionFailureEvent)
+		assert.Equal(t, "syn_timeout", event.Reason())
+		assert.Equal(t, "", event.ResetBy())
+		assert.Equal(t, start, event.RequestTimestamp())
+		assert.True(t, event.ResponseTimestamp().IsZero())
+		assert.Equal(t, 2, event.RequestPacketCount())
+		assert.Equal(t, 0, event.ResponsePacketCount())
+	})
+
+	t.Run("reset mid-request", func(t *testing.T) {
+		stream := newStream()
+		accept(stream, &layers.TCP{SYN: true, Window: 1000}, at(0), reassembly.TCPDirClientToServer)
+		accept(stream, &layers.TCP{SYN: true, ACK: true, Window: 1000}, at(time.Millisecond), reassembly.TCPDirServerToClient)
+		accept(stream, &layers.TCP{ACK: true, Window: 1000, BaseLayer: request}, at(2*time.Millisecond), reassembly.TCPDirClientToServer)
+		accept(stream, &layers.TCP{RST: true}, at(5*time.Millisecond), reassembly.TCPDirServerToClient)
+		require.Len(t, stream.eventsChan, 1)
+		event := (<-stream.eventsChan).(*ConnectionFailureEvent)
+		assert.Equal(t, "reset", event.Reason())
+		assert.Equal(t, "server", event.ResetBy())
+		assert.Equal(t, start.Add(2*time.Millisecond), event.RequestTimestamp())
+		assert.Equal(t, start.Add(5*time.Millisecond), event.ResponseTimestamp())
+	})
+
+	t.Run("reset while idle", func(t *testing.T) {
+		stream := newStream()
+		accept(stream, &layers.TCP{ACK: true, Window: 1000, BaseLayer: request}, at(0), reassembly.TCPDirClientToServer)
+		accept(stream, &layers.TCP{ACK: true, Window: 1000, BaseLayer: layers.BaseLayer{Payload: []byte("HTTP/1.1 200 OK\r\n\r\n")}}, at(time.Millisecond), reassembly.TCPDirServerToClient)
+		accept(stream, &layers.TCP{RST: true}, at(time.Minute), reassembly.TCPDirClientToServer)
+		IncrementActiveStreamCount()
+		stream.ReassemblyComplete(nil)
+		assert.Empty(t, stream.eventsChan)
+	})
+}
@@ -0,0 +1,134 @@
+package assemblers
+
+import (
+	"time"
+)
+
+// TlsHandshake describes what the client and server agreed on during a TLS handshake
+type TlsHandshake struct {
+	// ServerName is the server name the client asked for using SNI
+	ServerName string
+	// OfferedVersion is the highest TLS version offered by the client
+	OfferedVersion uint16
+	// NegotiatedVersion and CipherSuite are chosen by the server, zero if it didn't choose them
+	NegotiatedVersion uint16
+	CipherSuite       uint16
+	// OfferedAlpn are the application protocols offered by the client using ALPN
+	OfferedAlpn []string
+	// NegotiatedAlpn is the application protocol chosen by the server, which is encrypted in TLS 1.3
+	NegotiatedAlpn string
+	// Duration is the time from the ClientHello to the client's first encrypted data, or -1 if it didn't complete
+	Duration time.Duration
+	// Alerts are the codes of the unencrypted alerts sent by either side
+	Alerts []uint8
+	// CertNotAfter is when the server's certificate expires, zero if it wasn't visible
+	CertNotAfter time.Time
+}
+
+// TlsEvent represents a TLS handshake, from the client's ClientHello to the point the connection is encrypted.
+//
+// The request timestamp is the ClientHello and the response timestamp the ServerHello,
+// which is zero if the server didn't send one.
+type TlsEvent struct {
+	eventBase
+	handshake TlsHandshake
+}
+
+// Make sure TlsEvent implements Event interface
+var _ Event = (*TlsEvent)(nil)
+
+func NewTlsEvent(
+	streamIdent string,
+	requestId int64,
+	requestTimestamp time.Time,
+	responseTimestamp time.Time,
+	requestPacketCount int,
+	responsePacketCount int,
+	srcIp string,
+	dstIp string,
+	handshake TlsHandshake) *TlsEvent {
+	return &TlsEvent{
+		eventBase: eventBase{
+			streamIdent:         streamIdent,
+			requestId:           requestId,
+			requestTimestamp:    requestTimestamp,
+			responseTimestamp:   responseTimestamp,
+			requestPacketCount:  requestPacketCount,
+			responsePacketCount: responsePacketCount,
+			srcIp:               srcIp,
+			dstIp:               dstIp,
+		},
+		handshake: handshake,
+	}
+}
+
+// ServerName returns the server name the client asked for using SNI, or "" if it didn't send one
+func (event *TlsEvent) ServerName() string {
+	return event.handshake.ServerName
+}
+
+// OfferedVersion returns the highest TLS version offered by the client, eg "1.3"
+func (event *TlsEvent) OfferedVersion() string {
+	return tlsVersionName(event.handshake.OfferedVersion)
+}
+
+// NegotiatedVersion returns the TLS version chosen by the server, eg "1.2", or "" if the server didn't choose one
+func (event *TlsEvent) NegotiatedVersion() string {
+	if event.handshake.NegotiatedVersion == 0 {
+		return ""
+	}
+	return tlsVersionName(event.handshake.NegotiatedVersion)
+}
+
+// CipherSuite returns the name of the cipher suite chosen by the server, eg "TLS_AES_128_GCM_SHA256",
+// or "" if the server didn't choose one
+func (event *TlsEvent) CipherSuite() string {
+	if event.handshake.NegotiatedVersion == 0 {
+		return ""
+	}
+	return tlsCipherSuiteName(event.handshake.CipherSuite)
+}
+
+// OfferedAlpn returns the application protocols offered by the client using ALPN, eg ["h2", "http/1.1"]
+func (event *TlsEvent) OfferedAlpn() []string {
+	return event.handshake.OfferedAlpn
+}
+
+// NegotiatedAlpn returns the application protocol chosen by the server using ALPN, or "" if it didn't choose one.
+// The server's choice is encrypted in TLS 1.3.
+func (event *TlsEvent) NegotiatedAlpn() string {
+	return event.handshake.NegotiatedAlpn
+}
+
+// HandshakeDuration returns the time from the ClientHello to the client's first encrypted application data,
+// or -1 if the handshake didn't complete.
+// For TLS 1.3 the first encrypted data is the client's Finished message, for earlier versions it's the first request.
+func (event *TlsEvent) HandshakeDuration() time.Duration {
+	return event.handshake.Duration
+}
+
+// Completed returns true if the handshake completed and the client started sending encrypted data
+func (event *TlsEvent) Completed() bool {
+	return event.handshake.Duration >= 0
+}
+
+// Alerts returns the codes of the unencrypted alerts sent by either side during the handshake
+func (event *TlsEvent) Alerts() []uint8 {
+	return event.handshake.Alerts
+}
+
+// AlertNames returns the names of the unencrypted alerts sent by either side during the handshake,
+// eg "handshake_failure"
+func (event *TlsEvent) AlertNames() []string {
+	names := make([]string, len(event.handshake.Alerts))
+	for i, alert := range event.handshake.Alerts {
+		names[i] = tlsAlertName(alert)
+	}
+	return names
+}
+
+// CertificateNotAfter returns when the server's certificate expires, or zero if it wasn't visible.
+// Certificates are only sent unencrypted before TLS 1.3.
+func (event *TlsEvent) CertificateNotAfter() time.Time {
+	return event.handshake.CertNotAfter
+}
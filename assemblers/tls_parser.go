@@ -0,0 +1,385 @@
+package assemblers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	tlsRecordHeaderLength = 5
+	// tlsMaxRecordLength is the largest record body allowed, which is the largest plaintext
+	// plus the expansion allowed for encrypted records
+	tlsMaxRecordLength = 16384 + 2048
+	// tlsMaxHandshakeLength limits how much of a handshake message we buffer, which is enough for most certificate chains
+	tlsMaxHandshakeLength = 64 * 1024
+	// tlsMaxAlpnProtocols limits how many offered application protocols are kept
+	tlsMaxAlpnProtocols = 16
+
+	tlsRecordChangeCipherSpec = 20
+	tlsRecordAlert            = 21
+	tlsRecordHandshake        = 22
+	tlsRecordApplicationData  = 23
+
+	tlsHandshakeClientHello = 1
+	tlsHandshakeServerHello = 2
+	tlsHandshakeCertificate = 11
+
+	tlsExtensionServerName        = 0
+	tlsExtensionAlpn              = 16
+	tlsExtensionSupportedVersions = 43
+
+	tlsVersion13 = 0x0304
+)
+
+// tlsHelloRetryRequestRandom is the random value a server sends in a ServerHello to ask the client
+// to send another ClientHello, see RFC 8446 section 4.1.3
+var tlsHelloRetryRequestRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// tlsVersionNames are the names of the TLS protocol versions
+var tlsVersionNames = map[uint16]string{
+	0x0300: "SSL 3.0",
+	0x0301: "1.0",
+	0x0302: "1.1",
+	0x0303: "1.2",
+	0x0304: "1.3",
+}
+
+func tlsVersionName(version uint16) string {
+	if name, ok := tlsVersionNames[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+func tlsCipherSuiteName(cipherSuite uint16) string {
+	return tls.CipherSuiteName(cipherSuite)
+}
+
+// tlsAlertNames are the names of the TLS alerts, by code
+var tlsAlertNames = map[uint8]string{
+	0:   "close_notify",
+	10:  "unexpected_message",
+	20:  "bad_record_mac",
+	22:  "record_overflow",
+	40:  "handshake_failure",
+	42:  "bad_certificate",
+	43:  "unsupported_certificate",
+	44:  "certificate_revoked",
+	45:  "certificate_expired",
+	46:  "certificate_unknown",
+	47:  "illegal_parameter",
+	48:  "unknown_ca",
+	49:  "access_denied",
+	50:  "decode_error",
+	51:  "decrypt_error",
+	70:  "protocol_version",
+	71:  "insufficient_security",
+	80:  "internal_error",
+	86:  "inappropriate_fallback",
+	90:  "user_canceled",
+	109: "missing_extension",
+	110: "unsupported_extension",
+	112: "unrecognized_name",
+	113: "bad_certificate_status_response",
+	115: "unknown_psk_identity",
+	116: "certificate_required",
+	120: "no_application_protocol",
+}
+
+func tlsAlertName(alert uint8) string {
+	if name, ok := tlsAlertNames[alert]; ok {
+		return name
+	}
+	return fmt.Sprintf("alert%d", alert)
+}
+
+// isTlsGrease returns true for the reserved values clients send to check servers ignore values they don't know,
+// see RFC 8701
+func isTlsGrease(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+// parseTlsRecordHeader parses the header every TLS record starts with, returning the length of the record
+func parseTlsRecordHeader(header []byte) (int, bool) {
+	recordType, major := header[0], header[1]
+	length := int(header[3])<<8 | int(header[4])
+	if recordType < tlsRecordChangeCipherSpec || recordType > tlsRecordApplicationData || major != 3 || length > tlsMaxRecordLength {
+		return 0, false
+	}
+	return length, true
+}
+
+// tlsParser parses the unencrypted start of a TLS connection, sending a TlsEvent describing the handshake.
+//
+// Nothing is decrypted, so we stop following the connection once the handshake completes, it fails with an alert,
+// or the client starts sending encrypted data.
+type tlsParser struct {
+	clientRecords *framedReader
+	serverRecords *framedReader
+	// handshake messages can be split across records
+	clientHandshake []byte
+	serverHandshake []byte
+	// each side's handshake messages are encrypted once it has sent ChangeCipherSpec,
+	// or for TLS 1.3 everything the server sends after its ServerHello
+	clientEncrypted bool
+	serverEncrypted bool
+	done            bool
+
+	requestId            int64
+	clientHelloTimestamp time.Time
+	serverHelloTimestamp time.Time
+	clientPacketCount    int
+	serverPacketCount    int
+	handshake            TlsHandshake
+}
+
+func newTlsParser() *tlsParser {
+	return &tlsParser{
+		clientRecords: newFramedReader(tlsRecordHeaderLength, tlsMaxRecordLength, parseTlsRecordHeader),
+		serverRecords: newFramedReader(tlsRecordHeaderLength, tlsMaxRecordLength, parseTlsRecordHeader),
+	}
+}
+
+// parse reads TLS records sent by the client and server until the handshake is over
+func (parser *tlsParser) parse(stream *tcpStream, requestId int64, timestamp time.Time, isClient bool, buffer *bufio.Reader, packetCount int) (bool, error) {
+	// the rest of the connection is encrypted
+	if parser.done {
+		return true, nil
+	}
+	reader := parser.serverRecords
+	if isClient {
+		reader = parser.clientRecords
+		parser.clientPacketCount += packetCount
+	} else {
+		parser.serverPacketCount += packetCount
+	}
+	// we've already reported we can't follow this direction of the connection
+	if reader.desynced {
+		return true, nil
+	}
+
+	data, err := io.ReadAll(buffer)
+	if err != nil {
+		return false, err
+	}
+	err = reader.read(data, func(header []byte, body []byte, truncated bool) {
+		if !parser.done {
+			parser.readRecord(stream, header[0], body, requestId, timestamp, isClient)
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// close sends an event for handshakes that didn't complete before the connection closed
+func (parser *tlsParser) close(stream *tcpStream) {
+	if !parser.done {
+		parser.sendEvent(stream, time.Time{})
+	}
+}
+
+func (parser *tlsParser) readRecord(stream *tcpStream, recordType byte, body []byte, requestId int64, timestamp time.Time, isClient bool) {
+	switch recordType {
+	case tlsRecordHandshake:
+		if isClient && !parser.clientEncrypted {
+			parser.clientHandshake = parser.readHandshakeMessages(append(parser.clientHandshake, body...), requestId, timestamp, true)
+		} else if !isClient && !parser.serverEncrypted {
+			parser.serverHandshake = parser.readHandshakeMessages(append(parser.serverHandshake, body...), requestId, timestamp, false)
+		}
+	case tlsRecordChangeCipherSpec:
+		if isClient {
+			parser.clientEncrypted = true
+		} else {
+			parser.serverEncrypted = true
+		}
+	case tlsRecordAlert:
+		// encrypted alerts are longer than the level and description of a plaintext alert
+		if len(body) != 2 {
+			return
+		}
+		parser.handshake.Alerts = append(parser.handshake.Alerts, body[1])
+		if body[0] == 2 { // fatal
+			parser.sendEvent(stream, time.Time{})
+		}
+	case tlsRecordApplicationData:
+		// the client only sends encrypted data once it has everything it needs from the server
+		if isClient && !parser.serverHelloTimestamp.IsZero() {
+			parser.sendEvent(stream, timestamp)
+		}
+	}
+}
+
+// readHandshakeMessages reads the complete handshake messages in the data,
+// returning the start of a message that continues in the next record
+func (parser *tlsParser) readHandshakeMessages(data []byte, requestId int64, timestamp time.Time, isClient bool) []byte {
+	for len(data) >= 4 {
+		messageType := data[0]
+		length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		if length > tlsMaxHandshakeLength {
+			// we can't follow the rest of this side's handshake
+			if isClient {
+				parser.clientEncrypted = true
+			} else {
+				parser.serverEncrypted = true
+			}
+			return nil
+		}
+		if len(data) < 4+length {
+			return data
+		}
+		message := &tlsReader{data: data[4 : 4+length]}
+		switch {
+		case isClient && messageType == tlsHandshakeClientHello:
+			parser.readClientHello(message, requestId, timestamp)
+		case !isClient && messageType == tlsHandshakeServerHello:
+			parser.readServerHello(message, timestamp)
+		case !isClient && messageType == tlsHandshakeCertificate:
+			parser.readCertificate(message)
+		}
+		data = data[4+length:]
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+func (parser *tlsParser) readClientHello(message *tlsReader, requestId int64, timestamp time.Time) {
+	// clients send a second ClientHello if the server asks them to retry, the handshake started with the first
+	if !parser.clientHelloTimestamp.IsZero() {
+		return
+	}
+	version := message.uint16()
+	message.skip(32)   // random
+	message.vector8()  // session ID
+	message.vector16() // cipher suites
+	message.vector8()  // compression methods
+	if !message.ok() {
+		return
+	}
+	parser.requestId = requestId
+	parser.clientHelloTimestamp = timestamp
+	parser.handshake.OfferedVersion = version
+	if message.empty() {
+		return
+	}
+
+	extensions := message.vector16()
+	for extensions.ok() && !extensions.empty() {
+		extensionType := extensions.uint16()
+		extension := extensions.vector16()
+		switch extensionType {
+		case tlsExtensionServerName:
+			names := extension.vector16()
+			for names.ok() && !names.empty() {
+				nameType := names.uint8()
+				name := names.vector16()
+				if nameType == 0 && name.ok() { // host name
+					parser.handshake.ServerName = string(name.data)
+				}
+			}
+		case tlsExtensionAlpn:
+			protocols := extension.vector16()
+			for protocols.ok() && !protocols.empty() && len(parser.handshake.OfferedAlpn) < tlsMaxAlpnProtocols {
+				if protocol := protocols.vector8(); protocol.ok() {
+					parser.handshake.OfferedAlpn = append(parser.handshake.OfferedAlpn, string(protocol.data))
+				}
+			}
+		case tlsExtensionSupportedVersions:
+			// TLS 1.3 clients offer versions here, keeping the legacy version at TLS 1.2
+			versions := extension.vector8()
+			for versions.ok() && !versions.empty() {
+				if version := versions.uint16(); versions.ok() && !isTlsGrease(version) && version > parser.handshake.OfferedVersion {
+					parser.handshake.OfferedVersion = version
+				}
+			}
+		}
+	}
+}
+
+func (parser *tlsParser) readServerHello(message *tlsReader, timestamp time.Time) {
+	version := message.uint16()
+	random := message.take(32)
+	message.vector8() // session ID
+	cipherSuite := message.uint16()
+	message.uint8() // compression method
+	if !message.ok() {
+		return
+	}
+	if parser.serverHelloTimestamp.IsZero() {
+		parser.serverHelloTimestamp = timestamp
+	}
+	parser.handshake.NegotiatedVersion = version
+	parser.handshake.CipherSuite = cipherSuite
+
+	if !message.empty() {
+		extensions := message.vector16()
+		for extensions.ok() && !extensions.empty() {
+			extensionType := extensions.uint16()
+			extension := extensions.vector16()
+			switch extensionType {
+			case tlsExtensionAlpn:
+				if protocol := extension.vector16().vector8(); protocol.ok() {
+					parser.handshake.NegotiatedAlpn = string(protocol.data)
+				}
+			case tlsExtensionSupportedVersions:
+				// TLS 1.3 servers choose the version here, keeping the legacy version at TLS 1.2
+				if version := extension.uint16(); extension.ok() {
+					parser.handshake.NegotiatedVersion = version
+				}
+			}
+		}
+	}
+
+	// TLS 1.3 encrypts the rest of the server's handshake, unless it's asking the client to retry
+	if parser.handshake.NegotiatedVersion >= tlsVersion13 && !bytes.Equal(random, tlsHelloRetryRequestRandom) {
+		parser.serverEncrypted = true
+	}
+}
+
+// readCertificate reads the expiry of the server's own certificate, which is the first in the chain
+func (parser *tlsParser) readCertificate(message *tlsReader) {
+	certificate := message.vector24().vector24()
+	if !certificate.ok() {
+		return
+	}
+	if parsed, err := x509.ParseCertificate(certificate.data); err == nil {
+		parser.handshake.CertNotAfter = parsed.NotAfter
+	}
+}
+
+// sendEvent sends a TlsEvent for the handshake, which completed at the given time or failed if it's zero,
+// and stops following the connection
+func (parser *tlsParser) sendEvent(stream *tcpStream, completedTimestamp time.Time) {
+	parser.done = true
+	parser.clientHandshake, parser.serverHandshake = nil, nil
+	// we started capturing part way through the handshake
+	if parser.clientHelloTimestamp.IsZero() {
+		return
+	}
+	parser.handshake.Duration = -1
+	if !completedTimestamp.IsZero() {
+		parser.handshake.Duration = max(completedTimestamp.Sub(parser.clientHelloTimestamp), 0)
+	}
+	stream.sendEvent(NewTlsEvent(
+		stream.ident,
+		parser.requestId,
+		parser.clientHelloTimestamp,
+		parser.serverHelloTimestamp,
+		parser.clientPacketCount,
+		parser.serverPacketCount,
+		stream.srcIP,
+		stream.dstIP,
+		parser.handshake,
+	))
+}
@@ -0,0 +1,239 @@
+package assemblers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+var testCertificateNotAfter = time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newTlsTestStream() (*tcpStream, chan Event) {
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	transportFlow := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0xd4, 0x31}, []byte{0x01, 0xbb})
+	events := make(chan Event, 10)
+	return NewTcpStream(netFlow, transportFlow, config.Config{
+		TLSPorts: []string{"443"},
+	}, events), events
+}
+
+// capturedWrite is the data written by one side of a connection
+type capturedWrite struct {
+	isClient bool
+	data     []byte
+}
+
+// capturingConn records everything written to a connection, in the order it was written by either side
+type capturingConn struct {
+	net.Conn
+	isClient bool
+	mutex    *sync.Mutex
+	writes   *[]capturedWrite
+}
+
+func (conn *capturingConn) Write(data []byte) (int, error) {
+	conn.mutex.Lock()
+	*conn.writes = append(*conn.writes, capturedWrite{conn.isClient, append([]byte{}, data...)})
+	conn.mutex.Unlock()
+	return conn.Conn.Write(data)
+}
+
+func newTestCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "api.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     testCertificateNotAfter,
+		DNSNames:     []string{"api.example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// captureTlsConnection runs a real TLS handshake between a client and server,
+// followed by a request if it succeeds, and returns what each side wrote
+func captureTlsConnection(t *testing.T, clientConfig *tls.Config, serverConfig *tls.Config) []capturedWrite {
+	clientPipe, serverPipe := net.Pipe()
+	mutex := &sync.Mutex{}
+	writes := []capturedWrite{}
+	client := tls.Client(&capturingConn{clientPipe, true, mutex, &writes}, clientConfig)
+	server := tls.Server(&capturingConn{serverPipe, false, mutex, &writes}, serverConfig)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// close the pipe rather than the TLS connection, which waits for the other side to read its close_notify
+		defer serverPipe.Close()
+		if server.Handshake() == nil {
+			server.Read(make([]byte, 64))
+		}
+	}()
+	if client.Handshake() == nil {
+		client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	}
+	clientPipe.Close()
+	wg.Wait()
+	return writes
+}
+
+// replay parses the captured writes, one millisecond apart, and returns the time of the first write
+func replay(stream *tcpStream, writes []capturedWrite) time.Time {
+	start := time.Now()
+	for i, write := range writes {
+		stream.parse(write.data, 0, start.Add(time.Duration(i)*time.Millisecond), write.isClient, 1)
+	}
+	return start
+}
+
+func TestTlsParserReadsCompletedHandshakes(t *testing.T) {
+	testCases := []struct {
+		name             string
+		version          uint16
+		cipherSuites     []uint16
+		expectedVersion  string
+		expectedCipher   string
+		expectedAlpn     string
+		expectedNotAfter time.Time
+	}{
+		{
+			name:             "TLS 1.3",
+			version:          tls.VersionTLS13,
+			expectedVersion:  "1.3",
+			expectedCipher:   "TLS_AES_128_GCM_SHA256",
+			expectedAlpn:     "",          // the server's extensions are encrypted
+			expectedNotAfter: time.Time{}, // and so is its certificate
+		},
+		{
+			name:             "TLS 1.2",
+			version:          tls.VersionTLS12,
+			cipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			expectedVersion:  "1.2",
+			expectedCipher:   "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			expectedAlpn:     "h2",
+			expectedNotAfter: testCertificateNotAfter,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			writes := captureTlsConnection(t,
+				&tls.Config{
+					ServerName:         "api.example.com",
+					NextProtos:         []string{"h2", "http/1.1"},
+					InsecureSkipVerify: true,
+					CipherSuites:       tc.cipherSuites,
+				},
+				&tls.Config{
+					Certificates:           []tls.Certificate{newTestCertificate(t)},
+					NextProtos:             []string{"h2"},
+					MaxVersion:             tc.version,
+					CipherSuites:           tc.cipherSuites,
+					SessionTicketsDisabled: true,
+				})
+			stream, events := newTlsTestStream()
+			require.IsType(t, &tlsParser{}, stream.parsers[0])
+			start := replay(stream, writes)
+			stream.ReassemblyComplete(nil)
+
+			require.Len(t, events, 1)
+			event := (<-events).(*TlsEvent)
+			assert.Equal(t, "api.example.com", event.ServerName())
+			assert.Equal(t, "1.3", event.OfferedVersion())
+			assert.Equal(t, tc.expectedVersion, event.NegotiatedVersion())
+			assert.Equal(t, tc.expectedCipher, event.CipherSuite())
+			assert.Equal(t, []string{"h2", "http/1.1"}, event.OfferedAlpn())
+			assert.Equal(t, tc.expectedAlpn, event.NegotiatedAlpn())
+			assert.True(t, event.Completed())
+			assert.Empty(t, event.Alerts())
+			assert.Equal(t, tc.expectedNotAfter, event.CertificateNotAfter().UTC())
+			assert.Equal(t, start, event.RequestTimestamp())
+			assert.Equal(t, start.Add(time.Millisecond), event.ResponseTimestamp())
+			assert.Greater(t, event.HandshakeDuration(), time.Millisecond)
+			assert.Equal(t, "10.0.0.1", event.SrcIp())
+			assert.Equal(t, "10.0.0.2", event.DstIp())
+		})
+	}
+}
+
+func TestTlsParserReportsFatalAlerts(t *testing.T) {
+	writes := captureTlsConnection(t,
+		&tls.Config{
+			ServerName:         "api.example.com",
+			MaxVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: true,
+		},
+		&tls.Config{
+			Certificates: []tls.Certificate{newTestCertificate(t)},
+			MinVersion:   tls.VersionTLS13,
+		})
+	stream, events := newTlsTestStream()
+	replay(stream, writes)
+	stream.ReassemblyComplete(nil)
+
+	require.Len(t, events, 1)
+	event := (<-events).(*TlsEvent)
+	assert.Equal(t, "1.2", event.OfferedVersion())
+	assert.Equal(t, "", event.NegotiatedVersion())
+	assert.Equal(t, "", event.CipherSuite())
+	assert.False(t, event.Completed())
+	assert.Equal(t, []string{"protocol_version"}, event.AlertNames())
+	assert.True(t, event.ResponseTimestamp().IsZero())
+}
+
+func TestTlsParserReportsHandshakesInterruptedByClose(t *testing.T) {
+	writes := captureTlsConnection(t,
+		&tls.Config{
+			ServerName:         "api.example.com",
+			InsecureSkipVerify: true,
+		},
+		&tls.Config{
+			Certificates:           []tls.Certificate{newTestCertificate(t)},
+			SessionTicketsDisabled: true,
+		})
+	stream, events := newTlsTestStream()
+	// only the ClientHello and the server's response were captured
+	replay(stream, writes[:2])
+	assert.Len(t, events, 0)
+	stream.ReassemblyComplete(nil)
+
+	require.Len(t, events, 1)
+	event := (<-events).(*TlsEvent)
+	assert.Equal(t, "1.3", event.NegotiatedVersion())
+	assert.False(t, event.Completed())
+	assert.Equal(t, time.Duration(-1), event.HandshakeDuration())
+}
+
+func TestTlsParserIgnoresConnectionsJoinedMidHandshake(t *testing.T) {
+	writes := captureTlsConnection(t,
+		&tls.Config{
+			ServerName:         "api.example.com",
+			InsecureSkipVerify: true,
+		},
+		&tls.Config{
+			Certificates:           []tls.Certificate{newTestCertificate(t)},
+			SessionTicketsDisabled: true,
+		})
+	stream, events := newTlsTestStream()
+	replay(stream, writes[1:])
+	stream.ReassemblyComplete(nil)
+
+	assert.Len(t, events, 0)
+}
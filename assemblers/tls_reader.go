@@ -0,0 +1,73 @@
+package assemblers
+
+// tlsReader reads the fields of a TLS handshake message.
+//
+// Reading past the end of the data marks the reader as failed, after which
+// all reads return zero values, so a message can be decoded without checking each read.
+type tlsReader struct {
+	data   []byte
+	failed bool
+}
+
+func (reader *tlsReader) ok() bool {
+	return !reader.failed
+}
+
+func (reader *tlsReader) empty() bool {
+	return len(reader.data) == 0
+}
+
+func (reader *tlsReader) take(n int) []byte {
+	if reader.failed || n < 0 || n > len(reader.data) {
+		reader.failed = true
+		return nil
+	}
+	data := reader.data[:n]
+	reader.data = reader.data[n:]
+	return data
+}
+
+func (reader *tlsReader) skip(n int) {
+	reader.take(n)
+}
+
+func (reader *tlsReader) uint8() uint8 {
+	if data := reader.take(1); data != nil {
+		return data[0]
+	}
+	return 0
+}
+
+func (reader *tlsReader) uint16() uint16 {
+	if data := reader.take(2); data != nil {
+		return uint16(data[0])<<8 | uint16(data[1])
+	}
+	return 0
+}
+
+func (reader *tlsReader) uint24() int {
+	if data := reader.take(3); data != nil {
+		return int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+	}
+	return 0
+}
+
+// vector8 reads a field prefixed with its one byte length, returning a reader for its contents
+func (reader *tlsReader) vector8() *tlsReader {
+	return reader.vector(int(reader.uint8()))
+}
+
+// vector16 reads a field prefixed with its two byte length, returning a reader for its contents
+func (reader *tlsReader) vector16() *tlsReader {
+	return reader.vector(int(reader.uint16()))
+}
+
+// vector24 reads a field prefixed with its three byte length, returning a reader for its contents
+func (reader *tlsReader) vector24() *tlsReader {
+	return reader.vector(reader.uint24())
+}
+
+func (reader *tlsReader) vector(length int) *tlsReader {
+	data := reader.take(length)
+	return &tlsReader{data: data, failed: reader.failed}
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -75,11 +78,81 @@ type Config struct {
 	StreamCloseTimeout time.Duration
 
 	// Packet source (defaults to pcap).
+	// Set via PACKET_SOURCE environment variable.
+	// Use "afpacket" to capture using AF_PACKET memory-mapped rings instead of libpcap,
+	// or "file" to replay packets from a capture file instead of a live interface.
 	PacketSource string
 
+	// Size in bytes of each block in the AF_PACKET ring buffer (defaults to 1MiB).
+	// Must be a multiple of the system page size.
+	// Set via AFPACKET_BLOCK_SIZE environment variable.
+	AfpacketBlockSize int
+
+	// Number of blocks in the AF_PACKET ring buffer (defaults to 64).
+	// Set via AFPACKET_NUM_BLOCKS environment variable.
+	AfpacketNumBlocks int
+
+	// AF_PACKET fanout group ID used to share packets between sockets (defaults to -1 which disables fanout).
+	// Must be between 0 and 65535 to join a group.
+	// Set via AFPACKET_FANOUT_GROUP environment variable.
+	AfpacketFanoutGroup int
+
+	// Path to a pcap or pcapng capture file to replay packets from when PacketSource is "file".
+	// Set via PCAP_FILE environment variable.
+	PcapFile string
+
+	// Replay packets from the capture file at the speed they were originally captured,
+	// otherwise packets are replayed as fast as possible.
+	// Set via PCAP_FILE_REALTIME environment variable.
+	PcapFileRealtime bool
+
 	// Channel buffer size (defaults to 1000).
 	BpfFilter string
 
+	// TCP ports that HTTP/1.x servers listen on (defaults to none).
+	// HTTP is otherwise only captured from packets that start with a request or response, so bodies spanning
+	// several packets are cut short and sized from their Content-Length. All packets to and from these ports
+	// are captured, so whole bodies are followed, at the cost of capturing more traffic.
+	// Set via HTTP_PORTS environment variable.
+	HTTPPorts []string
+
+	// TCP ports that carry cleartext HTTP/2 traffic (defaults to none).
+	// HTTP/2 frames don't start with anything the BPF filter can match on,
+	// so all packets to and from these ports are captured.
+	// Set via HTTP2_PORTS environment variable.
+	HTTP2Ports []string
+
+	// TCP ports that Redis servers listen on (defaults to none).
+	// All packets to and from these ports are captured and parsed as Redis commands and replies.
+	// Set via REDIS_PORTS environment variable.
+	RedisPorts []string
+
+	// TCP ports that PostgreSQL servers listen on (defaults to none).
+	// All packets to and from these ports are captured and parsed as PostgreSQL queries and results.
+	// Set via POSTGRES_PORTS environment variable.
+	PostgresPorts []string
+
+	// TCP ports that MySQL servers listen on (defaults to none).
+	// All packets to and from these ports are captured and parsed as MySQL commands and responses.
+	// Set via MYSQL_PORTS environment variable.
+	MySQLPorts []string
+
+	// TCP ports that Kafka brokers listen on (defaults to none).
+	// All packets to and from these ports are captured and parsed as Kafka requests and responses.
+	// Set via KAFKA_PORTS environment variable.
+	KafkaPorts []string
+
+	// TCP ports that carry TLS (defaults to none).
+	// All packets to and from these ports are captured, but only the unencrypted handshake is parsed
+	// and sent as TLS events, nothing is decrypted.
+	// Set via TLS_PORTS environment variable.
+	TLSPorts []string
+
+	// UDP ports that DNS servers listen on (defaults to none).
+	// DNS queries and responses sent to and from these ports are matched and sent as DNS events.
+	// Set via DNS_PORTS environment variable.
+	DNSPorts []string
+
 	// Maximum number of HTTP events waiting to be processed to buffer before dropping.
 	ChannelBufferSize int
 
@@ -89,6 +162,11 @@ type Config struct {
 	// Maximum number of TCP reassembly pages per connection.
 	MaxBufferedPagesPerConnection int
 
+	// Number of independent TCP assemblers that packets are spread across by connection (defaults to 1).
+	// Each assembler reassembles its connections on its own goroutine.
+	// Set via ASSEMBLER_SHARDS environment variable.
+	AssemblerShards int
+
 	// The IP address of the node the agent is running on.
 	AgentNodeIP string
 
@@ -110,16 +188,161 @@ type Config struct {
 	// Include the request URL in the event.
 	IncludeRequestURL bool
 
+	// Patterns that replace matching URL path segments with a placeholder in the http.route attribute,
+	// in the form "placeholder=regexp" (defaults to none). Numeric IDs, UUIDs and hex hashes are always replaced.
+	// Patterns are comma separated, so can't contain commas.
+	// Set via HTTP_ROUTE_PATTERNS environment variable, eg "locale=[a-z]{2}-[a-z]{2}".
+	HTTPRoutePatterns []string
+
+	// Path to an OpenAPI spec, in JSON or YAML, whose routes are matched against URL paths
+	// before any patterns are applied (defaults to none).
+	// Set via HTTP_ROUTE_OPENAPI_SPEC environment variable.
+	HTTPRouteOpenAPISpec string
+
 	// The list of HTTP headers to extract from a HTTP request/response.
 	HTTPHeadersToExtract []string
 
-	// Event Handler type to use for sending events.
-	EventHandlerType string
+	// How long a HTTP request or response waits for its counterpart before it's sent as unmatched (defaults to 30s),
+	// and how long a HTTP/2 stream waits for its next frame.
+	// Set via HTTP_MATCHER_TTL environment variable.
+	HTTPMatcherTTL time.Duration
+
+	// Maximum number of HTTP requests and responses, or HTTP/2 streams, per connection waiting for their counterpart (defaults to 1000).
+	// The oldest are sent as unmatched to make room for new ones.
+	// Set via HTTP_MATCHER_MAX_ENTRIES environment variable.
+	HTTPMatcherMaxEntries int
+
+	// Which HTTP/1.x bodies to capture and send with events, "request", "response" or both (defaults to none).
+	// Only bodies with an allowed content type are captured, and gzipped bodies are decompressed.
+	// Set via HTTP_BODY_CAPTURE environment variable, eg "request,response".
+	HTTPBodyCapture []string
+
+	// Maximum number of bytes captured from the start of each body (defaults to 1024).
+	// Set via HTTP_BODY_MAX_BYTES environment variable.
+	HTTPBodyMaxBytes int
+
+	// Content types of the bodies that are captured, which can use wildcards like "text/*"
+	// (defaults to "application/json,application/problem+json,text/plain").
+	// Set via HTTP_BODY_CONTENT_TYPES environment variable.
+	HTTPBodyContentTypes []string
+
+	// Response status codes of the requests and responses whose bodies are captured, as single codes ("404"),
+	// ranges ("500-599") or classes ("5xx") (defaults to all status codes).
+	// Request bodies are kept for requests that never got a response.
+	// Set via HTTP_BODY_STATUS_CODES environment variable.
+	HTTPBodyStatusCodes []string
+
+	// Maximum number of bytes held by captured bodies across all connections, including those of events
+	// waiting to be handled (defaults to 10MiB).
+	// Bodies are truncated once it's reached, so capture can't use unbounded memory.
+	// Set via HTTP_BODY_MAX_MEMORY environment variable.
+	HTTPBodyMaxMemory int
+
+	// Send an event for each TCP connection when it closes, with its handshake round trip time, duration,
+	// bytes and packets sent each way, why it ended and the number of requests made on it (defaults to false).
+	// HTTP/1.x is captured by payload, so bytes and packets on those connections only count the packets
+	// that start a request or response.
+	// Set via CONNECTION_EVENTS environment variable.
+	ConnectionEvents bool
+
+	// Send an event when a call fails at the TCP level (defaults to false): the server refused the connection,
+	// never answered the client's SYN, or the connection was reset while the client waited for a response.
+	// Unanswered SYNs are reported once the connection is closed as idle.
+	// Set via CONNECTION_FAILURE_EVENTS environment variable.
+	ConnectionFailureEvents bool
+
+	// Export request rate, error and duration metrics for HTTP requests from the otel handler (defaults to false).
+	// Requests are grouped by source workload, destination service, method, route and status class.
+	// The agent's own runtime and host metrics are exported with them.
+	// Set via RED_METRICS environment variable.
+	REDMetrics bool
+
+	// How often RED metrics are exported (defaults to 60s).
+	// Set via RED_METRICS_INTERVAL environment variable.
+	REDMetricsInterval time.Duration
+
+	// Honeycomb destination dataset for RED metrics.
+	// Set via RED_METRICS_DATASET environment variable.
+	REDMetricsDataset string
+
+	// Maximum number of distinct attribute sets RED metrics are recorded with (defaults to 1000).
+	// Requests that would add more are recorded with the otel.metric.overflow attribute instead.
+	// Set via RED_METRICS_MAX_SERIES environment variable.
+	REDMetricsMaxSeries int
+
+	// Serve Prometheus metrics for HTTP traffic between services and the agent's health (defaults to false).
+	// Set via PROMETHEUS_METRICS environment variable.
+	PrometheusMetrics bool
+
+	// Address the Prometheus /metrics endpoint listens on (defaults to 0.0.0.0:9464).
+	// Set via PROMETHEUS_ADDRESS environment variable.
+	PrometheusAddress string
+
+	// Maximum number of distinct label sets the Prometheus HTTP traffic metrics are recorded with (defaults to 1000).
+	// Requests that would add more are recorded with "overflow" labels instead.
+	// Set via PROMETHEUS_MAX_SERIES environment variable.
+	PrometheusMaxSeries int
+
+	// Aggregate calls between services into a service dependency graph (defaults to false).
+	// Each edge is a client workload calling a server service over a protocol, with its call and error counts
+	// and p50 and p99 latency. The graph is sent as one event per edge every interval, and the current graph
+	// is served as JSON and Graphviz DOT by the debug service.
+	// Set via SERVICE_GRAPH environment variable.
+	ServiceGraph bool
+
+	// How often the service graph is sent, and the window its edges are aggregated over (defaults to 60s).
+	// Set via SERVICE_GRAPH_INTERVAL environment variable.
+	ServiceGraphInterval time.Duration
+
+	// Honeycomb destination dataset for service graph edges.
+	// Set via SERVICE_GRAPH_DATASET environment variable.
+	ServiceGraphDataset string
+
+	// Maximum number of edges in the service graph per interval (defaults to 1000).
+	// Calls that would add more are recorded in an edge between "overflow" services instead.
+	// Set via SERVICE_GRAPH_MAX_EDGES environment variable.
+	ServiceGraphMaxEdges int
+
+	// Event handlers used to send events (defaults to otel): "otel", "libhoney", or "stdout" and "file"
+	// to write events as JSON lines with the libhoney handler's fields instead of sending them.
+	// Every event is sent to each handler, eg to send both spans and libhoney events during a migration.
+	// Set via HANDLER_TYPE environment variable, eg "otel,libhoney".
+	EventHandlerTypes []string
+
+	// Path of the file events are written to by the "file" event handler (defaults to events.jsonl).
+	// Set via EVENTS_FILE environment variable.
+	EventsFile string
+
+	// Size in bytes the events file can reach before it's rotated (defaults to 100MiB, 0 disables rotation).
+	// Set via EVENTS_FILE_MAX_SIZE environment variable.
+	EventsFileMaxSize int
+
+	// Number of rotated events files to keep (defaults to 5).
+	// Set via EVENTS_FILE_MAX_BACKUPS environment variable.
+	EventsFileMaxBackups int
 }
 
 // NewConfig returns a new Config struct.
 // Values are set from environment variables if they exist, otherwise they are set to default
 func NewConfig() Config {
+	httpPorts, _ := utils.LookupEnvAsStringSlice("HTTP_PORTS")
+	http2Ports, _ := utils.LookupEnvAsStringSlice("HTTP2_PORTS")
+	redisPorts, _ := utils.LookupEnvAsStringSlice("REDIS_PORTS")
+	postgresPorts, _ := utils.LookupEnvAsStringSlice("POSTGRES_PORTS")
+	mysqlPorts, _ := utils.LookupEnvAsStringSlice("MYSQL_PORTS")
+	kafkaPorts, _ := utils.LookupEnvAsStringSlice("KAFKA_PORTS")
+	tlsPorts, _ := utils.LookupEnvAsStringSlice("TLS_PORTS")
+	dnsPorts, _ := utils.LookupEnvAsStringSlice("DNS_PORTS")
+	httpRoutePatterns, _ := utils.LookupEnvAsStringSlice("HTTP_ROUTE_PATTERNS")
+	httpBodyCapture, _ := utils.LookupEnvAsStringSlice("HTTP_BODY_CAPTURE")
+	httpBodyStatusCodes, _ := utils.LookupEnvAsStringSlice("HTTP_BODY_STATUS_CODES")
+	connectionEvents := utils.LookupEnvOrBool("CONNECTION_EVENTS", false)
+	connectionFailureEvents := utils.LookupEnvOrBool("CONNECTION_FAILURE_EVENTS", false)
+	bpfFilter := buildBpfFilter(dnsPorts, httpPorts, http2Ports, redisPorts, postgresPorts, mysqlPorts, kafkaPorts, tlsPorts)
+	if connectionEvents || connectionFailureEvents {
+		// HTTP is captured by payload, so we also need the packets that open and close connections
+		bpfFilter += " or " + pcapTcpControlPackets
+	}
 	return Config{
 		APIKey:                        utils.LookupEnvOrString("HONEYCOMB_API_KEY", ""),
 		Endpoint:                      utils.LookupEnvOrString("HONEYCOMB_API_ENDPOINT", "https://api.honeycomb.io"),
@@ -139,11 +362,25 @@ func NewConfig() Config {
 		Promiscuous:                   true,
 		StreamFlushTimeout:            time.Duration(10 * time.Second),
 		StreamCloseTimeout:            time.Duration(90 * time.Second),
-		PacketSource:                  "pcap",
-		BpfFilter:                     buildBpfFilter(),
+		PacketSource:                  utils.LookupEnvOrString("PACKET_SOURCE", "pcap"),
+		AfpacketBlockSize:             utils.LookupEnvOrInt("AFPACKET_BLOCK_SIZE", 1<<20),
+		AfpacketNumBlocks:             utils.LookupEnvOrInt("AFPACKET_NUM_BLOCKS", 64),
+		AfpacketFanoutGroup:           utils.LookupEnvOrInt("AFPACKET_FANOUT_GROUP", -1),
+		PcapFile:                      utils.LookupEnvOrString("PCAP_FILE", ""),
+		PcapFileRealtime:              utils.LookupEnvOrBool("PCAP_FILE_REALTIME", false),
+		BpfFilter:                     bpfFilter,
+		HTTPPorts:                     httpPorts,
+		HTTP2Ports:                    http2Ports,
+		RedisPorts:                    redisPorts,
+		PostgresPorts:                 postgresPorts,
+		MySQLPorts:                    mysqlPorts,
+		KafkaPorts:                    kafkaPorts,
+		TLSPorts:                      tlsPorts,
+		DNSPorts:                      dnsPorts,
 		ChannelBufferSize:             1000,
 		MaxBufferedPagesTotal:         150_000,
 		MaxBufferedPagesPerConnection: 4000,
+		AssemblerShards:               utils.LookupEnvOrInt("ASSEMBLER_SHARDS", 1),
 		AgentNodeIP:                   utils.LookupEnvOrString("AGENT_NODE_IP", ""),
 		AgentNodeName:                 utils.LookupEnvOrString("AGENT_NODE_NAME", ""),
 		AgentServiceAccount:           utils.LookupEnvOrString("AGENT_SERVICE_ACCOUNT_NAME", ""),
@@ -151,8 +388,33 @@ func NewConfig() Config {
 		AgentPodName:                  utils.LookupEnvOrString("AGENT_POD_NAME", ""),
 		AdditionalAttributes:          utils.LookupEnvAsStringMap("ADDITIONAL_ATTRIBUTES"),
 		IncludeRequestURL:             utils.LookupEnvOrBool("INCLUDE_REQUEST_URL", true),
+		HTTPRoutePatterns:             httpRoutePatterns,
+		HTTPRouteOpenAPISpec:          utils.LookupEnvOrString("HTTP_ROUTE_OPENAPI_SPEC", ""),
 		HTTPHeadersToExtract:          getHTTPHeadersToExtract(),
-		EventHandlerType:              utils.LookupEnvOrString("HANDLER_TYPE", "otel"),
+		HTTPMatcherTTL:                utils.LookupEnvOrDuration("HTTP_MATCHER_TTL", 30*time.Second),
+		HTTPMatcherMaxEntries:         utils.LookupEnvOrInt("HTTP_MATCHER_MAX_ENTRIES", 1000),
+		HTTPBodyCapture:               httpBodyCapture,
+		HTTPBodyMaxBytes:              utils.LookupEnvOrInt("HTTP_BODY_MAX_BYTES", 1024),
+		HTTPBodyContentTypes:          getHTTPBodyContentTypes(),
+		HTTPBodyStatusCodes:           httpBodyStatusCodes,
+		HTTPBodyMaxMemory:             utils.LookupEnvOrInt("HTTP_BODY_MAX_MEMORY", 10<<20),
+		ConnectionEvents:              connectionEvents,
+		ConnectionFailureEvents:       connectionFailureEvents,
+		REDMetrics:                    utils.LookupEnvOrBool("RED_METRICS", false),
+		REDMetricsInterval:            utils.LookupEnvOrDuration("RED_METRICS_INTERVAL", time.Minute),
+		REDMetricsDataset:             utils.LookupEnvOrString("RED_METRICS_DATASET", "hny-network-metrics"),
+		REDMetricsMaxSeries:           utils.LookupEnvOrInt("RED_METRICS_MAX_SERIES", 1000),
+		PrometheusMetrics:             utils.LookupEnvOrBool("PROMETHEUS_METRICS", false),
+		PrometheusAddress:             utils.LookupEnvOrString("PROMETHEUS_ADDRESS", "0.0.0.0:9464"),
+		PrometheusMaxSeries:           utils.LookupEnvOrInt("PROMETHEUS_MAX_SERIES", 1000),
+		ServiceGraph:                  utils.LookupEnvOrBool("SERVICE_GRAPH", false),
+		ServiceGraphInterval:          utils.LookupEnvOrDuration("SERVICE_GRAPH_INTERVAL", time.Minute),
+		ServiceGraphDataset:           utils.LookupEnvOrString("SERVICE_GRAPH_DATASET", "hny-network-graph"),
+		ServiceGraphMaxEdges:          utils.LookupEnvOrInt("SERVICE_GRAPH_MAX_EDGES", 1000),
+		EventHandlerTypes:             getEventHandlerTypes(),
+		EventsFile:                    utils.LookupEnvOrString("EVENTS_FILE", "events.jsonl"),
+		EventsFileMaxSize:             utils.LookupEnvOrInt("EVENTS_FILE_MAX_SIZE", 100<<20),
+		EventsFileMaxBackups:          utils.LookupEnvOrInt("EVENTS_FILE_MAX_BACKUPS", 5),
 	}
 }
 
@@ -173,6 +435,8 @@ var httpPayloadsStartWith = []string{
 	"GET ", "POST", "PUT ", "DELE", "HEAD", "OPTI", "PATC", "TRAC", "CONN",
 	// HTTP/1.x is the response start
 	"HTTP",
+	// HTTP/2 connection preface sent by the client ("PRI * HTTP/2.0")
+	"PRI ",
 }
 
 // pcapComputeTcpHeaderOffset is a [pcap filter] sub-string for pcap
@@ -199,8 +463,34 @@ func pcapTcpPayloadStartsWith(s string) (filter string, err error) {
 	return fmt.Sprintf("tcp[%s:4] = 0x%s", pcapComputeTcpHeaderOffset, hex.EncodeToString([]byte(s))), nil
 }
 
-// buildBpfFilter builds a BPF filter to only capture HTTP traffic
-func buildBpfFilter() string {
+// pcapTcpControlPackets is a [pcap filter] string that matches TCP packets that open or close a connection.
+//
+// [pcap filter]: https://www.tcpdump.org/manpages/pcap-filter.7.html
+const pcapTcpControlPackets = "(tcp[tcpflags] & (tcp-syn|tcp-fin|tcp-rst) != 0)"
+
+// pcapTcpPort returns a [pcap filter] string that matches all TCP packets to or from the given port.
+//
+// [pcap filter]: https://www.tcpdump.org/manpages/pcap-filter.7.html
+func pcapTcpPort(port string) (filter string, err error) {
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("pcapTcpPort: invalid port %q", port)
+	}
+	return fmt.Sprintf("tcp port %s", port), nil
+}
+
+// pcapUdpPort returns a [pcap filter] string that matches all UDP packets to or from the given port.
+//
+// [pcap filter]: https://www.tcpdump.org/manpages/pcap-filter.7.html
+func pcapUdpPort(port string) (filter string, err error) {
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("pcapUdpPort: invalid port %q", port)
+	}
+	return fmt.Sprintf("udp port %s", port), nil
+}
+
+// buildBpfFilter builds a BPF filter to only capture HTTP traffic,
+// plus all traffic on the given UDP ports and lists of TCP ports for protocols we can't match by payload.
+func buildBpfFilter(udpPorts []string, tcpPortLists ...[]string) string {
 	// TODO: Move this logic somewhere more HTTP-flavored
 	// TODO "not host me", // how do we get our current IP?
 
@@ -211,6 +501,20 @@ func buildBpfFilter() string {
 			filters = append(filters, filter)
 		}
 	}
+	for _, port := range udpPorts {
+		filter, err := pcapUdpPort(port)
+		if err == nil {
+			filters = append(filters, filter)
+		}
+	}
+	for _, tcpPorts := range tcpPortLists {
+		for _, port := range tcpPorts {
+			filter, err := pcapTcpPort(port)
+			if err == nil {
+				filters = append(filters, filter)
+			}
+		}
+	}
 	return strings.Join(filters, " or ")
 }
 
@@ -226,8 +530,71 @@ func (e *InvalidAPIKeyError) Error() string {
 	return "Invalid API key"
 }
 
+type MissingPcapFileError struct{}
+
+func (e *MissingPcapFileError) Error() string {
+	return "Missing pcap file for file packet source"
+}
+
+type InvalidAfpacketBlockSizeError struct {
+	BlockSize int
+}
+
+func (e *InvalidAfpacketBlockSizeError) Error() string {
+	return fmt.Sprintf("Invalid afpacket block size %d, must be a positive multiple of the page size (%d)", e.BlockSize, os.Getpagesize())
+}
+
+type InvalidAfpacketNumBlocksError struct {
+	NumBlocks int
+}
+
+func (e *InvalidAfpacketNumBlocksError) Error() string {
+	return fmt.Sprintf("Invalid afpacket number of blocks %d, must be positive", e.NumBlocks)
+}
+
+type InvalidAfpacketFanoutGroupError struct {
+	FanoutGroup int
+}
+
+func (e *InvalidAfpacketFanoutGroupError) Error() string {
+	return fmt.Sprintf("Invalid afpacket fanout group %d, must be -1 or between 0 and %d", e.FanoutGroup, math.MaxUint16)
+}
+
 // Validate checks that the config is valid
 func (c *Config) Validate() error {
+	e := []error{}
+	if c.PacketSource == "file" && c.PcapFile == "" {
+		e = append(e, &MissingPcapFileError{})
+	}
+	if c.PacketSource == "afpacket" {
+		e = append(e, c.validateAfpacket()...)
+	}
+	// events written locally aren't sent to Honeycomb, so don't need an API key
+	if c.sendsToHoneycomb() {
+		e = append(e, c.validateAPIKey()...)
+	}
+	// returns nil if no errors in slice
+	return errors.Join(e...)
+}
+
+// validateAfpacket returns the problems with the AF_PACKET ring buffer and fanout settings
+func (c *Config) validateAfpacket() []error {
+	e := []error{}
+	if c.AfpacketBlockSize <= 0 || c.AfpacketBlockSize%os.Getpagesize() != 0 {
+		e = append(e, &InvalidAfpacketBlockSizeError{BlockSize: c.AfpacketBlockSize})
+	}
+	if c.AfpacketNumBlocks <= 0 {
+		e = append(e, &InvalidAfpacketNumBlocksError{NumBlocks: c.AfpacketNumBlocks})
+	}
+	// fanout group IDs are 16 bits, larger ones would join a different group
+	if c.AfpacketFanoutGroup < -1 || c.AfpacketFanoutGroup > math.MaxUint16 {
+		e = append(e, &InvalidAfpacketFanoutGroupError{FanoutGroup: c.AfpacketFanoutGroup})
+	}
+	return e
+}
+
+// validateAPIKey returns the problems with the API key used to send events to Honeycomb
+func (c *Config) validateAPIKey() []error {
 	e := []error{}
 	if c.APIKey == "" {
 		e = append(e, &MissingAPIKeyError{})
@@ -241,8 +608,34 @@ func (c *Config) Validate() error {
 	if _, err := libhoney.VerifyAPIKey(libhoneyConfig); err != nil {
 		e = append(e, &InvalidAPIKeyError{})
 	}
-	// returns nil if no errors in slice
-	return errors.Join(e...)
+	return e
+}
+
+// sendsToHoneycomb returns true if any of the event handlers send events to Honeycomb
+// rather than writing them locally
+func (c *Config) sendsToHoneycomb() bool {
+	if len(c.EventHandlerTypes) == 0 {
+		return true
+	}
+	for _, handlerType := range c.EventHandlerTypes {
+		if handlerType != "stdout" && handlerType != "file" {
+			return true
+		}
+	}
+	return false
+}
+
+// getEventHandlerTypes returns the event handlers to use from a user-defined list in HANDLER_TYPE,
+// or the otel handler if no list is given.
+func getEventHandlerTypes() []string {
+	handlerTypes, _ := utils.LookupEnvAsStringSlice("HANDLER_TYPE")
+	for i, handlerType := range handlerTypes {
+		handlerTypes[i] = strings.TrimSpace(handlerType)
+	}
+	if len(handlerTypes) == 0 {
+		return []string{"otel"}
+	}
+	return handlerTypes
 }
 
 var defaultHeadersToExtract = []string{
@@ -258,3 +651,18 @@ func getHTTPHeadersToExtract() []string {
 	}
 	return defaultHeadersToExtract
 }
+
+var defaultHTTPBodyContentTypes = []string{
+	"application/json",
+	"application/problem+json",
+	"text/plain",
+}
+
+// getHTTPBodyContentTypes returns the content types of HTTP bodies that can be captured
+// based on a user-defined list in HTTP_BODY_CONTENT_TYPES, or the default content types if no list is given.
+func getHTTPBodyContentTypes() []string {
+	if contentTypes, found := utils.LookupEnvAsStringSlice("HTTP_BODY_CONTENT_TYPES"); found {
+		return contentTypes
+	}
+	return defaultHTTPBodyContentTypes
+}
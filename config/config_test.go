@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -67,6 +68,47 @@ func TestEnvVars(t *testing.T) {
 	t.Setenv("ADDITIONAL_ATTRIBUTES", "key1=value1,key2=value2")
 	t.Setenv("INCLUDE_REQUEST_URL", "false")
 	t.Setenv("HTTP_HEADERS", "header1,header2")
+	t.Setenv("HTTP_ROUTE_PATTERNS", "locale=[a-z]{2}-[a-z]{2},sku=SKU-[0-9]+")
+	t.Setenv("HTTP_ROUTE_OPENAPI_SPEC", "/etc/openapi.yaml")
+	t.Setenv("HTTP_MATCHER_TTL", "1m")
+	t.Setenv("HTTP_MATCHER_MAX_ENTRIES", "50")
+	t.Setenv("HTTP_BODY_CAPTURE", "request,response")
+	t.Setenv("HTTP_BODY_MAX_BYTES", "256")
+	t.Setenv("HTTP_BODY_CONTENT_TYPES", "application/json,text/*")
+	t.Setenv("HTTP_BODY_STATUS_CODES", "5xx,429")
+	t.Setenv("HTTP_BODY_MAX_MEMORY", "1048576")
+	t.Setenv("CONNECTION_EVENTS", "true")
+	t.Setenv("CONNECTION_FAILURE_EVENTS", "true")
+	t.Setenv("RED_METRICS", "true")
+	t.Setenv("RED_METRICS_INTERVAL", "10s")
+	t.Setenv("RED_METRICS_DATASET", "test-metrics-dataset")
+	t.Setenv("RED_METRICS_MAX_SERIES", "50")
+	t.Setenv("PROMETHEUS_METRICS", "true")
+	t.Setenv("PROMETHEUS_ADDRESS", "127.0.0.1:9100")
+	t.Setenv("PROMETHEUS_MAX_SERIES", "20")
+	t.Setenv("SERVICE_GRAPH", "true")
+	t.Setenv("SERVICE_GRAPH_INTERVAL", "30s")
+	t.Setenv("SERVICE_GRAPH_DATASET", "test-graph-dataset")
+	t.Setenv("SERVICE_GRAPH_MAX_EDGES", "100")
+	t.Setenv("HANDLER_TYPE", "otel, file")
+	t.Setenv("EVENTS_FILE", "/tmp/events.jsonl")
+	t.Setenv("EVENTS_FILE_MAX_SIZE", "1024")
+	t.Setenv("EVENTS_FILE_MAX_BACKUPS", "2")
+	t.Setenv("PACKET_SOURCE", "file")
+	t.Setenv("PCAP_FILE", "/tmp/capture.pcapng")
+	t.Setenv("PCAP_FILE_REALTIME", "true")
+	t.Setenv("AFPACKET_BLOCK_SIZE", "2097152")
+	t.Setenv("AFPACKET_NUM_BLOCKS", "32")
+	t.Setenv("AFPACKET_FANOUT_GROUP", "42")
+	t.Setenv("ASSEMBLER_SHARDS", "4")
+	t.Setenv("HTTP_PORTS", "80,8000")
+	t.Setenv("HTTP2_PORTS", "8080,50051")
+	t.Setenv("REDIS_PORTS", "6379")
+	t.Setenv("POSTGRES_PORTS", "5432")
+	t.Setenv("MYSQL_PORTS", "3306")
+	t.Setenv("KAFKA_PORTS", "9092")
+	t.Setenv("TLS_PORTS", "443")
+	t.Setenv("DNS_PORTS", "53")
 
 	config := NewConfig()
 	assert.Equal(t, "1234567890123456789012", config.APIKey)
@@ -84,6 +126,48 @@ func TestEnvVars(t *testing.T) {
 	assert.Equal(t, map[string]string{"key1": "value1", "key2": "value2"}, config.AdditionalAttributes)
 	assert.Equal(t, false, config.IncludeRequestURL)
 	assert.Equal(t, []string{"header1", "header2"}, config.HTTPHeadersToExtract)
+	assert.Equal(t, []string{"locale=[a-z]{2}-[a-z]{2}", "sku=SKU-[0-9]+"}, config.HTTPRoutePatterns)
+	assert.Equal(t, "/etc/openapi.yaml", config.HTTPRouteOpenAPISpec)
+	assert.Equal(t, time.Minute, config.HTTPMatcherTTL)
+	assert.Equal(t, 50, config.HTTPMatcherMaxEntries)
+	assert.Equal(t, []string{"request", "response"}, config.HTTPBodyCapture)
+	assert.Equal(t, 256, config.HTTPBodyMaxBytes)
+	assert.Equal(t, []string{"application/json", "text/*"}, config.HTTPBodyContentTypes)
+	assert.Equal(t, []string{"5xx", "429"}, config.HTTPBodyStatusCodes)
+	assert.Equal(t, 1048576, config.HTTPBodyMaxMemory)
+	assert.Equal(t, true, config.ConnectionEvents)
+	assert.Equal(t, true, config.ConnectionFailureEvents)
+	assert.Equal(t, true, config.REDMetrics)
+	assert.Equal(t, 10*time.Second, config.REDMetricsInterval)
+	assert.Equal(t, "test-metrics-dataset", config.REDMetricsDataset)
+	assert.Equal(t, 50, config.REDMetricsMaxSeries)
+	assert.Equal(t, true, config.PrometheusMetrics)
+	assert.Equal(t, "127.0.0.1:9100", config.PrometheusAddress)
+	assert.Equal(t, 20, config.PrometheusMaxSeries)
+	assert.Equal(t, true, config.ServiceGraph)
+	assert.Equal(t, 30*time.Second, config.ServiceGraphInterval)
+	assert.Equal(t, "test-graph-dataset", config.ServiceGraphDataset)
+	assert.Equal(t, 100, config.ServiceGraphMaxEdges)
+	assert.Equal(t, []string{"otel", "file"}, config.EventHandlerTypes)
+	assert.Equal(t, "/tmp/events.jsonl", config.EventsFile)
+	assert.Equal(t, 1024, config.EventsFileMaxSize)
+	assert.Equal(t, 2, config.EventsFileMaxBackups)
+	assert.Equal(t, "file", config.PacketSource)
+	assert.Equal(t, "/tmp/capture.pcapng", config.PcapFile)
+	assert.Equal(t, true, config.PcapFileRealtime)
+	assert.Equal(t, 2097152, config.AfpacketBlockSize)
+	assert.Equal(t, 32, config.AfpacketNumBlocks)
+	assert.Equal(t, 42, config.AfpacketFanoutGroup)
+	assert.Equal(t, 4, config.AssemblerShards)
+	assert.Equal(t, []string{"80", "8000"}, config.HTTPPorts)
+	assert.Equal(t, []string{"8080", "50051"}, config.HTTP2Ports)
+	assert.Equal(t, []string{"6379"}, config.RedisPorts)
+	assert.Equal(t, []string{"5432"}, config.PostgresPorts)
+	assert.Equal(t, []string{"3306"}, config.MySQLPorts)
+	assert.Equal(t, []string{"9092"}, config.KafkaPorts)
+	assert.Equal(t, []string{"443"}, config.TLSPorts)
+	assert.Equal(t, []string{"53"}, config.DNSPorts)
+	assert.Contains(t, config.BpfFilter, "udp port 53 or tcp port 80 or tcp port 8000 or tcp port 8080 or tcp port 50051 or tcp port 6379 or tcp port 5432 or tcp port 3306 or tcp port 9092 or tcp port 443 or (tcp[tcpflags] & (tcp-syn|tcp-fin|tcp-rst) != 0)")
 }
 
 func TestEmptyHeadersEnvVar(t *testing.T) {
@@ -116,11 +200,123 @@ func TestEnvVarsDefault(t *testing.T) {
 	assert.Equal(t, map[string]string{}, config.AdditionalAttributes)
 	assert.Equal(t, true, config.IncludeRequestURL)
 	assert.Equal(t, []string{"User-Agent", "Traceparent"}, config.HTTPHeadersToExtract)
-	assert.Equal(t, "otel", config.EventHandlerType)
+	assert.Equal(t, []string{}, config.HTTPRoutePatterns)
+	assert.Equal(t, "", config.HTTPRouteOpenAPISpec)
+	assert.Equal(t, 30*time.Second, config.HTTPMatcherTTL)
+	assert.Equal(t, 1000, config.HTTPMatcherMaxEntries)
+	assert.Equal(t, []string{}, config.HTTPBodyCapture)
+	assert.Equal(t, 1024, config.HTTPBodyMaxBytes)
+	assert.Equal(t, []string{"application/json", "application/problem+json", "text/plain"}, config.HTTPBodyContentTypes)
+	assert.Equal(t, []string{}, config.HTTPBodyStatusCodes)
+	assert.Equal(t, 10485760, config.HTTPBodyMaxMemory)
+	assert.Equal(t, false, config.ConnectionEvents)
+	assert.Equal(t, false, config.ConnectionFailureEvents)
+	assert.Equal(t, false, config.REDMetrics)
+	assert.Equal(t, time.Minute, config.REDMetricsInterval)
+	assert.Equal(t, "hny-network-metrics", config.REDMetricsDataset)
+	assert.Equal(t, 1000, config.REDMetricsMaxSeries)
+	assert.Equal(t, false, config.PrometheusMetrics)
+	assert.Equal(t, "0.0.0.0:9464", config.PrometheusAddress)
+	assert.Equal(t, 1000, config.PrometheusMaxSeries)
+	assert.Equal(t, false, config.ServiceGraph)
+	assert.Equal(t, time.Minute, config.ServiceGraphInterval)
+	assert.Equal(t, "hny-network-graph", config.ServiceGraphDataset)
+	assert.Equal(t, 1000, config.ServiceGraphMaxEdges)
+	assert.Equal(t, []string{"otel"}, config.EventHandlerTypes)
+	assert.Equal(t, "events.jsonl", config.EventsFile)
+	assert.Equal(t, 100<<20, config.EventsFileMaxSize)
+	assert.Equal(t, 5, config.EventsFileMaxBackups)
+	assert.Equal(t, "pcap", config.PacketSource)
+	assert.Equal(t, "", config.PcapFile)
+	assert.Equal(t, false, config.PcapFileRealtime)
+	assert.Equal(t, 1<<20, config.AfpacketBlockSize)
+	assert.Equal(t, 64, config.AfpacketNumBlocks)
+	assert.Equal(t, -1, config.AfpacketFanoutGroup)
+	assert.Equal(t, 1, config.AssemblerShards)
+	assert.Equal(t, []string{}, config.HTTPPorts)
+	assert.Equal(t, []string{}, config.HTTP2Ports)
+	assert.Equal(t, []string{}, config.RedisPorts)
+	assert.Equal(t, []string{}, config.PostgresPorts)
+	assert.Equal(t, []string{}, config.MySQLPorts)
+	assert.Equal(t, []string{}, config.KafkaPorts)
+	assert.Equal(t, []string{}, config.TLSPorts)
+	assert.Equal(t, []string{}, config.DNSPorts)
+}
+
+func TestValidateDoesNotRequireAPIKeyForLocalHandlers(t *testing.T) {
+	config := Config{Endpoint: "https://api.honeycomb.io", EventHandlerTypes: []string{"stdout", "file"}}
+	assert.NoError(t, config.Validate())
+	assert.False(t, config.sendsToHoneycomb())
+
+	config.EventHandlerTypes = []string{"stdout", "otel"}
+	assert.True(t, config.sendsToHoneycomb())
+}
+
+func TestValidateRequiresPcapFileForFileSource(t *testing.T) {
+	config := Config{PacketSource: "file", Endpoint: "http://localhost:8080"}
+	var missingPcapFile *MissingPcapFileError
+	assert.ErrorAs(t, config.Validate(), &missingPcapFile)
+
+	config.PcapFile = "capture.pcap"
+	assert.NoError(t, config.Validate())
+}
+
+func TestAfpacketEnvVarsThatAreNotNumbersUseDefaults(t *testing.T) {
+	t.Setenv("AFPACKET_BLOCK_SIZE", "1MiB")
+	t.Setenv("AFPACKET_NUM_BLOCKS", "many")
+	t.Setenv("AFPACKET_FANOUT_GROUP", "group")
+
+	config := NewConfig()
+	assert.Equal(t, 1<<20, config.AfpacketBlockSize)
+	assert.Equal(t, 64, config.AfpacketNumBlocks)
+	assert.Equal(t, -1, config.AfpacketFanoutGroup)
+}
+
+func TestValidateAfpacketSettings(t *testing.T) {
+	pageSize := os.Getpagesize()
+	testCases := []struct {
+		name        string
+		blockSize   int
+		numBlocks   int
+		fanoutGroup int
+		// a pointer to the type of error expected, nil if the settings are valid
+		target any
+	}{
+		{"defaults", 1 << 20, 64, -1, nil},
+		{"page sized blocks", pageSize, 1, 0, nil},
+		{"largest fanout group", 1 << 20, 64, 65535, nil},
+		{"zero block size", 0, 64, -1, new(*InvalidAfpacketBlockSizeError)},
+		{"negative block size", -pageSize, 64, -1, new(*InvalidAfpacketBlockSizeError)},
+		{"block size not a multiple of the page size", pageSize + 1, 64, -1, new(*InvalidAfpacketBlockSizeError)},
+		{"no blocks", 1 << 20, 0, -1, new(*InvalidAfpacketNumBlocksError)},
+		{"negative number of blocks", 1 << 20, -1, -1, new(*InvalidAfpacketNumBlocksError)},
+		{"fanout group below -1", 1 << 20, 64, -2, new(*InvalidAfpacketFanoutGroupError)},
+		{"fanout group over 16 bits", 1 << 20, 64, 65536, new(*InvalidAfpacketFanoutGroupError)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := Config{
+				PacketSource:        "afpacket",
+				AfpacketBlockSize:   tc.blockSize,
+				AfpacketNumBlocks:   tc.numBlocks,
+				AfpacketFanoutGroup: tc.fanoutGroup,
+				Endpoint:            "http://localhost:8080",
+			}
+			if tc.target == nil {
+				assert.NoError(t, config.Validate())
+			} else {
+				assert.ErrorAs(t, config.Validate(), tc.target)
+			}
+		})
+	}
+
+	// the settings aren't used by the other packet sources
+	config := Config{PacketSource: "pcap", Endpoint: "http://localhost:8080"}
+	assert.NoError(t, config.Validate())
 }
 
 func Test_Config_buildBpfFilter(t *testing.T) {
-	captureFilter := buildBpfFilter()
+	captureFilter := buildBpfFilter(nil)
 
 	assert.Equal(t,
 		len(httpPayloadsStartWith)-1,
@@ -139,6 +335,17 @@ func Test_Config_buildBpfFilter(t *testing.T) {
 	}
 }
 
+func Test_Config_buildBpfFilterWithPorts(t *testing.T) {
+	captureFilter := buildBpfFilter([]string{"53", "99999"}, []string{"8080", "not-a-port", "50051"})
+
+	assert.Equal(t,
+		len(httpPayloadsStartWith)+2,
+		strings.Count(captureFilter, " or "),
+		"invalid ports are skipped",
+	)
+	assert.True(t, strings.HasSuffix(captureFilter, " or udp port 53 or tcp port 8080 or tcp port 50051"))
+}
+
 func Test_Config_pcapTcpPayloadStartsWith(t *testing.T) {
 	testCases := []struct {
 		startsWith     string
@@ -4,13 +4,17 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/honeycombio/honeycomb-network-agent/assemblers"
 	"github.com/honeycombio/honeycomb-network-agent/config"
 	"github.com/honeycombio/honeycomb-network-agent/utils"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	v1 "k8s.io/api/core/v1"
 )
 
 // EventHandler is an interface for event handlers
@@ -20,19 +24,77 @@ type EventHandler interface {
 	handleEvent(event assemblers.Event)
 }
 
-// NewEventHandler returns an event handler based on the config's selected handler type.
-func NewEventHandler(config config.Config, cachedK8sClient *utils.CachedK8sClient, eventsChannel chan assemblers.Event, version string) EventHandler {
-	var eventHandler EventHandler
-	switch config.EventHandlerType {
-	case "libhoney":
-		eventHandler = NewLibhoneyEventHandler(config, cachedK8sClient, eventsChannel, version)
+// NewEventHandler returns an event handler based on the config's selected handler types.
+// If more than one is selected, each event is sent to all of them.
+// Events are also recorded in the service graph if it isn't nil.
+func NewEventHandler(config config.Config, cachedK8sClient *utils.CachedK8sClient, eventsChannel chan assemblers.Event, version string, serviceGraph *ServiceGraph) EventHandler {
+	handlerTypes := resolveEventHandlerTypes(config.EventHandlerTypes)
+	// a single selected handler holds up the assembler rather than dropping events when it can't keep up,
+	// whether or not metrics are recorded alongside it
+	var blockingTypes []string
+	if len(handlerTypes) == 1 {
+		blockingTypes = []string{handlerTypes[0]}
+	}
+	if config.PrometheusMetrics {
+		// traffic metrics are recorded from their own queue, so they can't hold up sending events
+		handlerTypes = append(handlerTypes, "prometheus")
+	}
+	if serviceGraph != nil {
+		handlerTypes = append(handlerTypes, "servicegraph")
+	}
+	if len(handlerTypes) == 1 {
+		return newEventHandler(handlerTypes[0], config, cachedK8sClient, eventsChannel, version)
+	}
+	return newFanOutEventHandler(eventsChannel, config.ChannelBufferSize, handlerTypes, blockingTypes, func(handlerType string, queue chan assemblers.Event) EventHandler {
+		if handlerType == "servicegraph" {
+			return newServiceGraphEventHandler(serviceGraph, queue)
+		}
+		return newEventHandler(handlerType, config, cachedK8sClient, queue, version)
+	})
+}
+
+// releaseEvent gives back the memory held by the event, eg its captured bodies, once a handler has finished with it
+func releaseEvent(event assemblers.Event) {
+	if releaser, ok := event.(assemblers.Releaser); ok {
+		releaser.Release()
+	}
+}
+
+// resolveEventHandlerTypes replaces unknown handler types with libhoney and removes duplicates
+func resolveEventHandlerTypes(handlerTypes []string) []string {
+	resolved := []string{}
+	for _, handlerType := range handlerTypes {
+		switch handlerType {
+		case "libhoney", "otel", "stdout", "file":
+		default:
+			log.Warn().Str("event_handler_type", handlerType).Msg("Unknown event handler type. Using libhoney.")
+			handlerType = "libhoney"
+		}
+		if !slices.Contains(resolved, handlerType) {
+			resolved = append(resolved, handlerType)
+		}
+	}
+	if len(resolved) == 0 {
+		log.Warn().Msg("No event handler type. Using libhoney.")
+		resolved = append(resolved, "libhoney")
+	}
+	return resolved
+}
+
+// newEventHandler returns an event handler of the given type that handles events from the events channel
+func newEventHandler(handlerType string, config config.Config, cachedK8sClient *utils.CachedK8sClient, eventsChannel chan assemblers.Event, version string) EventHandler {
+	switch handlerType {
 	case "otel":
-		eventHandler = NewOtelHandler(config, cachedK8sClient, eventsChannel, version)
+		return NewOtelHandler(config, cachedK8sClient, eventsChannel, version)
+	case "stdout":
+		return NewStdoutEventHandler(config, cachedK8sClient, eventsChannel, version)
+	case "file":
+		return NewFileEventHandler(config, cachedK8sClient, eventsChannel, version)
+	case "prometheus":
+		return newPrometheusEventHandler(config, cachedK8sClient, eventsChannel)
 	default:
-		log.Warn().Str("event_handler_type", config.EventHandlerType).Msg("Unknown event handler type. Using libhoney.")
-		eventHandler = NewLibhoneyEventHandler(config, cachedK8sClient, eventsChannel, version)
+		return NewLibhoneyEventHandler(config, cachedK8sClient, eventsChannel, version)
 	}
-	return eventHandler
 }
 
 // sanitizeHeaders takes a map of headers and returns a new map with the keys sanitized
@@ -56,3 +118,188 @@ func sanitizeHeaders(isRequest bool, header http.Header) map[string]string {
 	}
 	return headers
 }
+
+// httpProtocolVersion returns the HTTP version in the form used by the network.protocol.version attribute,
+// eg "1.1" or "2". Returns an empty string if the version is unknown.
+func httpProtocolVersion(protoMajor int, protoMinor int) string {
+	switch {
+	case protoMajor >= 2:
+		return fmt.Sprintf("%d", protoMajor)
+	case protoMajor == 1:
+		return fmt.Sprintf("%d.%d", protoMajor, protoMinor)
+	default:
+		return ""
+	}
+}
+
+// timestampFieldNames returns the names of the fields that hold when an event's request and response started.
+// HTTP and gRPC events use the HTTP field names, events for other protocols use protocol-neutral ones.
+func timestampFieldNames(event assemblers.Event) (string, string) {
+	switch event.(type) {
+	case *assemblers.HttpEvent, *assemblers.GrpcEvent:
+		return "http.request.timestamp", "http.response.timestamp"
+	default:
+		return "request.timestamp", "response.timestamp"
+	}
+}
+
+// transferDurations returns the durations that tell the server's think time apart from slow transfers,
+// in milliseconds keyed by field name: how long the request took to upload, the time to first byte (TTFB)
+// from the end of the request to the start of the response, and how long the response took to download.
+// Durations without timestamps to base them on are left out, including those that need the last byte
+// of a request or response whose end wasn't captured.
+func transferDurations(event assemblers.Event) map[string]int64 {
+	durations := make(map[string]int64, 3)
+	requestEndKnown := !event.RequestTimestamp().IsZero() && !event.RequestPartial()
+	if requestEndKnown {
+		durations["http.request.upload_duration_ms"] = event.RequestLastByteTimestamp().Sub(event.RequestTimestamp()).Milliseconds()
+	}
+	if !event.ResponseTimestamp().IsZero() && !event.ResponsePartial() {
+		durations["http.response.download_duration_ms"] = event.ResponseLastByteTimestamp().Sub(event.ResponseTimestamp()).Milliseconds()
+	}
+	if requestEndKnown && !event.ResponseTimestamp().IsZero() {
+		// the server can respond before it has the whole request, eg to reject a large upload
+		durations["http.response.time_to_first_byte_ms"] = max(event.ResponseTimestamp().Sub(event.RequestLastByteTimestamp()), time.Duration(0)).Milliseconds()
+	}
+	return durations
+}
+
+// handshakeDurations returns the durations of a TCP connection's three-way handshake in fractional milliseconds
+// keyed by field name, as handshakes within a cluster often take less than a millisecond.
+// Durations of handshakes we didn't see are left out.
+func handshakeDurations(event *assemblers.ConnectionEvent) map[string]float64 {
+	durations := make(map[string]float64, 3)
+	if event.SynToSynAck() >= 0 {
+		durations["tcp.handshake.syn_to_syn_ack_ms"] = float64(event.SynToSynAck()) / float64(time.Millisecond)
+	}
+	if event.SynAckToAck() >= 0 {
+		durations["tcp.handshake.syn_ack_to_ack_ms"] = float64(event.SynAckToAck()) / float64(time.Millisecond)
+	}
+	if event.HandshakeRtt() >= 0 {
+		durations["tcp.handshake.rtt_ms"] = float64(event.HandshakeRtt()) / float64(time.Millisecond)
+	}
+	return durations
+}
+
+// tcpStatsEvent is implemented by events that can carry the network problems seen on their TCP connection
+type tcpStatsEvent interface {
+	TcpStats() (assemblers.TcpStats, bool)
+}
+
+// tcpStatsAttributes returns the network problems seen on the event's TCP connection as attributes,
+// so slow requests can be told apart from slow networks.
+// There are none for connections that weren't fully captured.
+// RTTs are in fractional milliseconds and left out when there weren't any samples.
+func tcpStatsAttributes(event tcpStatsEvent) []attribute.KeyValue {
+	stats, ok := event.TcpStats()
+	if !ok {
+		return nil
+	}
+	attrs := []attribute.KeyValue{
+		attribute.Int("tcp.retransmissions", stats.Retransmissions),
+		attribute.Int("tcp.out_of_order", stats.OutOfOrder),
+		attribute.Int("tcp.duplicate_acks", stats.DuplicateAcks),
+		attribute.Int("tcp.zero_windows", stats.ZeroWindows),
+	}
+	if stats.RttSamples > 0 {
+		attrs = append(attrs,
+			attribute.Int("tcp.rtt.sample_count", stats.RttSamples),
+			attribute.Float64("tcp.rtt.min_ms", float64(stats.RttMin)/float64(time.Millisecond)),
+			attribute.Float64("tcp.rtt.mean_ms", float64(stats.RttMean())/float64(time.Millisecond)),
+			attribute.Float64("tcp.rtt.max_ms", float64(stats.RttMax)/float64(time.Millisecond)),
+		)
+	}
+	return attrs
+}
+
+// connectionFailureNames are the event names for the reasons a call failed at the TCP level
+var connectionFailureNames = map[string]string{
+	"connection_refused": "TCP connection refused",
+	"syn_timeout":        "TCP SYN timeout",
+	"reset":              "TCP connection reset",
+}
+
+func connectionFailureName(reason string) string {
+	if name, ok := connectionFailureNames[reason]; ok {
+		return name
+	}
+	return "TCP connection failure"
+}
+
+// workloadName returns the name of the workload that runs the pod with the IP address, eg the name of a deployment,
+// or the IP address if it isn't a known pod
+func workloadName(k8sClient *utils.CachedK8sClient, agentIP string, ip string) string {
+	// the client is nil when running without a kubernetes cluster, and the agent can't be told apart from
+	// other pods using the host network
+	if k8sClient == nil || ip == agentIP {
+		return ip
+	}
+	if pod := k8sClient.GetPodByIPAddr(ip); pod != nil {
+		return podWorkloadName(pod)
+	}
+	return ip
+}
+
+// serviceName returns the name of the service with the IP address, or of the service that selects the pod with the
+// IP address, falling back to the pod's workload name or the IP address if neither is known
+func serviceName(k8sClient *utils.CachedK8sClient, agentIP string, ip string) string {
+	if k8sClient == nil || ip == agentIP {
+		return ip
+	}
+	if service := k8sClient.GetServiceByIPAddr(ip); service != nil {
+		return service.Name
+	}
+	if pod := k8sClient.GetPodByIPAddr(ip); pod != nil {
+		if service := k8sClient.GetServiceForPod(pod); service != nil {
+			return service.Name
+		}
+		return podWorkloadName(pod)
+	}
+	return ip
+}
+
+// podWorkloadName returns the name of the pod's controller, eg a stateful set or job, or the pod's name if it doesn't have one
+func podWorkloadName(pod *v1.Pod) string {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Controller == nil || !*owner.Controller {
+			continue
+		}
+		// deployments own their pods through replica sets named after the deployment and a hash of the pod template
+		if hash := pod.Labels["pod-template-hash"]; owner.Kind == "ReplicaSet" && hash != "" {
+			if name, found := strings.CutSuffix(owner.Name, "-"+hash); found {
+				return name
+			}
+		}
+		return owner.Name
+	}
+	return pod.Name
+}
+
+// seriesLimiter bounds the number of distinct series a metric is recorded with, so a flood of new routes or
+// workloads can't use unbounded memory in the agent or the metrics backend
+type seriesLimiter[K comparable] struct {
+	maxSeries int
+	mutex     sync.Mutex
+	series    map[K]struct{}
+}
+
+func newSeriesLimiter[K comparable](maxSeries int) *seriesLimiter[K] {
+	return &seriesLimiter[K]{
+		maxSeries: maxSeries,
+		series:    map[K]struct{}{},
+	}
+}
+
+// allow returns true if the series has already been recorded or there's room for another series
+func (limiter *seriesLimiter[K]) allow(key K) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if _, found := limiter.series[key]; found {
+		return true
+	}
+	if len(limiter.series) >= limiter.maxSeries {
+		return false
+	}
+	limiter.series[key] = struct{}{}
+	return true
+}
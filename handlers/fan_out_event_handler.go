@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/honeycombio/honeycomb-network-agent/assemblers"
+)
+
+// fanOutDrainTimeout is how long the fan-out handler waits for handlers to catch up with their queues
+// when it's stopped, so a stuck backend can't stop the agent from exiting
+const fanOutDrainTimeout = 5 * time.Second
+
+// fanOutEventHandler is an event handler that sends each event to several event handlers.
+//
+// Events are read from the events channel once and added to a queue for each handler. A handler that can't
+// keep up only drops the events that don't fit in its own queue, rather than blocking the others,
+// unless it's a blocking handler: those hold up reading more events until there's room in their queue,
+// like a handler reading the events channel on its own.
+type fanOutEventHandler struct {
+	eventsChan chan assemblers.Event
+	targets    []*fanOutTarget
+}
+
+// fanOutTarget is an event handler and the queue of events waiting to be handled by it
+type fanOutTarget struct {
+	handlerType string
+	handler     EventHandler
+	queue       chan assemblers.Event
+	// set when events wait for room in the queue rather than being dropped
+	blocking bool
+	// the number of events dropped because the queue was full
+	dropped atomic.Uint64
+}
+
+var _ EventHandler = (*fanOutEventHandler)(nil)
+
+// newFanOutEventHandler creates a new event handler that sends events to a handler of each type,
+// created by newHandler to read from a queue holding up to queueSize events.
+// Handlers of the blocking types never drop events.
+func newFanOutEventHandler(eventsChan chan assemblers.Event, queueSize int, handlerTypes []string, blockingTypes []string, newHandler func(handlerType string, queue chan assemblers.Event) EventHandler) *fanOutEventHandler {
+	handler := &fanOutEventHandler{eventsChan: eventsChan}
+	for _, handlerType := range handlerTypes {
+		queue := make(chan assemblers.Event, queueSize)
+		handler.targets = append(handler.targets, &fanOutTarget{
+			handlerType: handlerType,
+			handler:     newHandler(handlerType, queue),
+			queue:       queue,
+			blocking:    slices.Contains(blockingTypes, handlerType),
+		})
+	}
+	log.Info().Strs("event_handler_types", handlerTypes).Msg("Sending events to multiple event handlers")
+	return handler
+}
+
+// Start starts the event handlers and begins sending them events from the events channel
+// When the context is cancelled, the event handlers are given time to handle their queued events, then stopped.
+// When the events channel is closed, the handlers' queues are closed and Start returns once every handler has
+// handled its queued events.
+func (handler *fanOutEventHandler) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	// the handlers are stopped separately so they can catch up once no more events are coming
+	targetsCtx, stopTargets := context.WithCancel(context.Background())
+	wgTargets := sync.WaitGroup{}
+	for _, target := range handler.targets {
+		wgTargets.Add(1)
+		go target.handler.Start(targetsCtx, &wgTargets)
+	}
+
+	defer stopTargets()
+	for {
+		select {
+		case <-ctx.Done():
+			handler.drain(fanOutDrainTimeout)
+			stopTargets()
+			wgTargets.Wait()
+			return
+		case event, ok := <-handler.eventsChan:
+			if !ok {
+				for _, target := range handler.targets {
+					close(target.queue)
+				}
+				wgTargets.Wait()
+				return
+			}
+			handler.handleEvent(event)
+		}
+	}
+}
+
+// drain waits until the handlers' queues are empty, or the timeout is reached
+func (handler *fanOutEventHandler) drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for _, target := range handler.targets {
+		for len(target.queue) > 0 {
+			if time.Now().After(deadline) {
+				log.Warn().
+					Str("event_handler_type", target.handlerType).
+					Int("queued_events", len(target.queue)).
+					Msg("Event handler did not handle its queued events in time")
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// Close closes all of the event handlers, flushing any pending events
+func (handler *fanOutEventHandler) Close() {
+	wg := sync.WaitGroup{}
+	for _, target := range handler.targets {
+		if dropped := target.dropped.Load(); dropped > 0 {
+			log.Warn().
+				Str("event_handler_type", target.handlerType).
+				Uint64("dropped_events", dropped).
+				Msg("Event handler dropped events because its queue was full")
+		}
+		wg.Add(1)
+		go func(target *fanOutTarget) {
+			defer wg.Done()
+			target.handler.Close()
+		}(target)
+	}
+	wg.Wait()
+}
+
+// handleEvent adds the event to each handler's queue, dropping it for handlers whose queue is full
+// or waiting for room in the queues of blocking handlers
+func (handler *fanOutEventHandler) handleEvent(event assemblers.Event) {
+	// each handler releases the event, including those it's dropped for
+	if releaser, ok := event.(assemblers.Releaser); ok {
+		releaser.Retain(len(handler.targets) - 1)
+	}
+	for _, target := range handler.targets {
+		if target.blocking {
+			target.queue <- event
+			continue
+		}
+		select {
+		case target.queue <- event:
+		default:
+			releaseEvent(event)
+			droppedEvents.WithLabelValues(target.handlerType).Inc()
+			if target.dropped.Add(1) == 1 {
+				log.Warn().
+					Str("event_handler_type", target.handlerType).
+					Msg("Event handler queue is full, dropping events")
+			}
+		}
+	}
+}
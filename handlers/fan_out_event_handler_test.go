@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/honeycombio/honeycomb-network-agent/assemblers"
+)
+
+// recordingEventHandler is an event handler that records the events it handles
+type recordingEventHandler struct {
+	eventsChan chan assemblers.Event
+	// if set, handling each event waits until it's closed
+	blocked chan struct{}
+	mutex   sync.Mutex
+	events  []assemblers.Event
+	closed  bool
+}
+
+func (handler *recordingEventHandler) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-handler.eventsChan:
+			if !ok {
+				return
+			}
+			handler.handleEvent(event)
+			releaseEvent(event)
+		}
+	}
+}
+
+func (handler *recordingEventHandler) Close() {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	handler.closed = true
+}
+
+func (handler *recordingEventHandler) handleEvent(event assemblers.Event) {
+	handler.mutex.Lock()
+	handler.events = append(handler.events, event)
+	handler.mutex.Unlock()
+	if handler.blocked != nil {
+		<-handler.blocked
+	}
+}
+
+func (handler *recordingEventHandler) handled() int {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	return len(handler.events)
+}
+
+func newTestFanOutEventHandler(queueSize int, blocked chan struct{}, blockingTypes ...string) (*fanOutEventHandler, chan assemblers.Event, map[string]*recordingEventHandler) {
+	eventsChannel := make(chan assemblers.Event, 10)
+	recorders := map[string]*recordingEventHandler{}
+	handler := newFanOutEventHandler(eventsChannel, queueSize, []string{"fast", "slow"}, blockingTypes, func(handlerType string, queue chan assemblers.Event) EventHandler {
+		recorder := &recordingEventHandler{eventsChan: queue}
+		if handlerType == "slow" {
+			recorder.blocked = blocked
+		}
+		recorders[handlerType] = recorder
+		return recorder
+	})
+	return handler, eventsChannel, recorders
+}
+
+func Test_fanOutEventHandler_sendsEventsToAllHandlers(t *testing.T) {
+	handler, eventsChannel, recorders := newTestFanOutEventHandler(10, nil)
+	ctx, done := context.WithCancel(context.Background())
+	wgTest := sync.WaitGroup{}
+	wgTest.Add(1)
+	go handler.Start(ctx, &wgTest)
+
+	now := time.Now()
+	events := []assemblers.Event{
+		testEvent{requestTimestamp: now, responseTimestamp: now}.dnsEvent(),
+		testEvent{requestTimestamp: now, responseTimestamp: now}.redisEvent(),
+	}
+	for _, event := range events {
+		eventsChannel <- event
+	}
+	assert.Eventually(t, func() bool { return len(eventsChannel) == 0 }, time.Second, time.Millisecond)
+
+	done()
+	wgTest.Wait()
+	handler.Close()
+
+	for handlerType, recorder := range recorders {
+		assert.Equal(t, events, recorder.events, handlerType)
+		assert.True(t, recorder.closed, handlerType)
+	}
+}
+
+func Test_fanOutEventHandler_slowHandlerDoesNotBlockOthers(t *testing.T) {
+	blocked := make(chan struct{})
+	handler, eventsChannel, recorders := newTestFanOutEventHandler(2, blocked)
+	ctx, done := context.WithCancel(context.Background())
+	wgTest := sync.WaitGroup{}
+	wgTest.Add(1)
+	go handler.Start(ctx, &wgTest)
+
+	now := time.Now()
+	eventsChannel <- testEvent{requestTimestamp: now, responseTimestamp: now}.dnsEvent()
+	// wait for the slow handler to get stuck on the first event
+	require.Eventually(t, func() bool { return recorders["slow"].handled() == 1 }, time.Second, time.Millisecond)
+
+	// the fast handler keeps up, while two more fit in the slow handler's queue and the rest are dropped for it
+	for i := 2; i <= 5; i++ {
+		eventsChannel <- testEvent{requestTimestamp: now, responseTimestamp: now}.dnsEvent()
+		require.Eventually(t, func() bool { return recorders["fast"].handled() == i }, time.Second, time.Millisecond)
+	}
+	assert.Equal(t, uint64(2), handler.targets[1].dropped.Load())
+	assert.Equal(t, uint64(0), handler.targets[0].dropped.Load())
+
+	// queued events are handled before the handlers are stopped
+	close(blocked)
+	done()
+	wgTest.Wait()
+	handler.Close()
+	assert.Equal(t, 3, recorders["slow"].handled())
+}
+
+func Test_fanOutEventHandler_closedEventsChannelDrainsQueues(t *testing.T) {
+	blocked := make(chan struct{})
+	handler, eventsChannel, recorders := newTestFanOutEventHandler(10, blocked)
+	wgTest := sync.WaitGroup{}
+	wgTest.Add(1)
+	stopped := make(chan struct{})
+	go func() {
+		handler.Start(context.Background(), &wgTest)
+		close(stopped)
+	}()
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		eventsChannel <- testEvent{requestTimestamp: now, responseTimestamp: now}.dnsEvent()
+	}
+	close(eventsChannel)
+	require.Eventually(t, func() bool { return recorders["fast"].handled() == 3 }, time.Second, time.Millisecond)
+
+	// the slow handler still has queued events, so the fan-out handler hasn't finished
+	select {
+	case <-stopped:
+		t.Fatal("fan-out handler stopped before the slow handler handled its queued events")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(blocked)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("fan-out handler did not stop after its handlers drained their queues")
+	}
+	wgTest.Wait()
+	assert.Equal(t, 3, recorders["slow"].handled())
+}
+
+func Test_resolveEventHandlerTypes(t *testing.T) {
+	assert.Equal(t, []string{"otel", "libhoney", "file"}, resolveEventHandlerTypes([]string{"otel", "bogus", "libhoney", "file", "otel"}))
+	assert.Equal(t, []string{"libhoney"}, resolveEventHandlerTypes(nil))
+}
+
+// releaseCountingEvent is an event that counts the handlers still holding it
+type releaseCountingEvent struct {
+	*assemblers.HttpEvent
+	holders atomic.Int32
+}
+
+func (event *releaseCountingEvent) Retain(n int) {
+	event.holders.Add(int32(n))
+}
+
+func (event *releaseCountingEvent) Release() {
+	event.holders.Add(-1)
+}
+
+func Test_fanOutEventHandler_retainsEventsForEachHandler(t *testing.T) {
+	handler, _, _ := newTestFanOutEventHandler(1, nil)
+	queued := &releaseCountingEvent{HttpEvent: testEvent{requestTimestamp: time.Now(), responseTimestamp: time.Now()}.httpEvent()}
+	queued.holders.Store(1)
+	dropped := &releaseCountingEvent{HttpEvent: testEvent{requestTimestamp: time.Now(), responseTimestamp: time.Now()}.httpEvent()}
+	dropped.holders.Store(1)
+
+	handler.handleEvent(queued)
+	// both queues are full, so the event is released for each handler straight away
+	handler.handleEvent(dropped)
+
+	assert.Equal(t, int32(2), queued.holders.Load())
+	assert.Equal(t, int32(0), dropped.holders.Load())
+}
+
+func Test_fanOutEventHandler_blockingHandlerDoesNotDropEvents(t *testing.T) {
+	handler, _, _ := newTestFanOutEventHandler(1, nil, "slow")
+	handler.handleEvent(testEvent{requestTimestamp: time.Now(), responseTimestamp: time.Now()}.httpEvent())
+
+	// neither handler has been started, so both queues are full
+	handled := make(chan struct{})
+	go func() {
+		handler.handleEvent(testEvent{requestTimestamp: time.Now(), responseTimestamp: time.Now()}.httpEvent())
+		close(handled)
+	}()
+	select {
+	case <-handled:
+		t.Fatal("event was dropped for the blocking handler")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// once the blocking handler takes an event from its queue, the next one is added
+	<-handler.targets[1].queue
+	<-handled
+	assert.Len(t, handler.targets[1].queue, 1)
+	assert.Equal(t, uint64(0), handler.targets[1].dropped.Load())
+	assert.Equal(t, uint64(1), handler.targets[0].dropped.Load())
+}
@@ -7,33 +7,252 @@ import (
 	"github.com/honeycombio/honeycomb-network-agent/assemblers"
 )
 
-func createTestHttpEvent(requestTimestamp, responseTimestamp time.Time) *assemblers.HttpEvent {
-	return createTestHttpEventWithRequestHeader(requestTimestamp, responseTimestamp, nil)
+// testEvent describes an event for handler tests.
+// Events of every type are sent on the same connection with the same packet counts,
+// and the fields after the timestamps are only used by the event types they're grouped under.
+type testEvent struct {
+	requestId                 int64
+	requestTimestamp          time.Time
+	requestLastByteTimestamp  time.Time
+	responseTimestamp         time.Time
+	responseLastByteTimestamp time.Time
+
+	// HTTP, where nil sends a User-Agent and Connection header
+	requestHeader *http.Header
+	// gRPC
+	grpcStatusCode int
+	// Redis and SQL
+	errorCode    string
+	errorMessage string
+	// Kafka
+	kafkaErrorCodes []int16
+	// DNS, where an empty response code is NOERROR for queries that were answered
+	dnsResponseCode string
+	dnsTimedOut     bool
+	// TCP connections, where negative handshake durations weren't seen
+	synToSynAck time.Duration
+	synAckToAck time.Duration
+	endReason   string
+	// TCP connection failures
+	failureReason string
+	resetBy       string
+	// TLS, where a zero version is a handshake the server rejected
+	tlsVersion        uint16
+	handshakeDuration time.Duration
+	tlsAlerts         []uint8
+}
+
+const (
+	testStreamIdent         = "c->s:1->2"
+	testSrcIp               = "1.2.3.4"
+	testDstIp               = "5.6.7.8"
+	testRequestPacketCount  = 2
+	testResponsePacketCount = 3
+)
+
+func (e testEvent) requestInfo() assemblers.MessageInfo {
+	return assemblers.MessageInfo{Timestamp: e.requestTimestamp, LastByteTimestamp: e.requestLastByteTimestamp, PacketCount: testRequestPacketCount}
 }
 
-func createTestHttpEventWithRequestHeader(requestTimestamp, responseTimestamp time.Time, requestHeader *http.Header) *assemblers.HttpEvent {
+func (e testEvent) responseInfo() assemblers.MessageInfo {
+	return assemblers.MessageInfo{Timestamp: e.responseTimestamp, LastByteTimestamp: e.responseLastByteTimestamp, PacketCount: testResponsePacketCount}
+}
+
+func (e testEvent) httpEvent() *assemblers.HttpEvent {
+	requestHeader := e.requestHeader
 	if requestHeader == nil {
 		requestHeader = &http.Header{"User-Agent": []string{"teapot-checker/1.0"}, "Connection": []string{"keep-alive"}}
 	}
 	return assemblers.NewHttpEvent(
-		"c->s:1->2",
-		0,
-		requestTimestamp,
-		responseTimestamp,
-		2,
-		3,
-		"1.2.3.4",
-		"5.6.7.8",
+		testStreamIdent,
+		e.requestId,
+		testSrcIp,
+		testDstIp,
 		&http.Request{
 			Method:        "GET",
 			RequestURI:    "/check?teapot=true",
 			ContentLength: 42,
 			Header:        *requestHeader,
 		},
+		assemblers.HttpMessageInfo{MessageInfo: e.requestInfo(), SeqAck: 1234567},
 		&http.Response{
-			StatusCode:    418,
-			ContentLength: 84,
-			Header:        http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}, "X-Custom-Header": []string{"tea-party"}},
+			StatusCode:       418,
+			ContentLength:    84,
+			TransferEncoding: []string{"chunked"},
+			Header:           http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}, "X-Custom-Header": []string{"tea-party"}},
+		},
+		assemblers.HttpMessageInfo{MessageInfo: e.responseInfo(), ContentEncoding: "gzip"},
+	)
+}
+
+func (e testEvent) grpcEvent() *assemblers.GrpcEvent {
+	return assemblers.NewGrpcEvent(
+		testStreamIdent,
+		e.requestId,
+		testSrcIp,
+		testDstIp,
+		e.requestInfo(),
+		e.responseInfo(),
+		assemblers.GrpcCall{
+			Path:             "/teapot.v1.TeapotService/Brew",
+			RequestHeader:    http.Header{"User-Agent": []string{"grpc-go/1.60.0"}},
+			ResponseHeader:   http.Header{},
+			StatusCode:       e.grpcStatusCode,
+			StatusMessage:    "I'm a teapot",
+			RequestMessages:  assemblers.GrpcMessageStats{Count: 1, CompressedSize: 12, UncompressedSize: 12},
+			ResponseMessages: assemblers.GrpcMessageStats{Count: 3, CompressedSize: 30, UncompressedSize: -1},
+		},
+	)
+}
+
+func (e testEvent) redisEvent() *assemblers.RedisEvent {
+	return assemblers.NewRedisEvent(
+		testStreamIdent,
+		e.requestId,
+		e.requestTimestamp,
+		e.responseTimestamp,
+		testRequestPacketCount,
+		testResponsePacketCount,
+		testSrcIp,
+		testDstIp,
+		"MGET",
+		2,
+		"array",
+		e.errorMessage,
+	)
+}
+
+func (e testEvent) sqlEvent() *assemblers.SqlEvent {
+	return assemblers.NewSqlEvent(
+		testStreamIdent,
+		e.requestId,
+		e.requestTimestamp,
+		e.responseTimestamp,
+		testRequestPacketCount,
+		testResponsePacketCount,
+		testSrcIp,
+		testDstIp,
+		"postgresql",
+		"SELECT * FROM users WHERE id = ?",
+		1,
+		e.errorCode,
+		e.errorMessage,
+	)
+}
+
+func (e testEvent) kafkaEvent() *assemblers.KafkaEvent {
+	return assemblers.NewKafkaEvent(
+		testStreamIdent,
+		e.requestId,
+		e.requestTimestamp,
+		e.responseTimestamp,
+		testRequestPacketCount,
+		testResponsePacketCount,
+		testSrcIp,
+		testDstIp,
+		0,
+		9,
+		7,
+		"orders-service",
+		[]string{"orders"},
+		[]string{"AAAAAAAAAAAAAAAAAAAAAQ"},
+		"",
+		e.kafkaErrorCodes,
+	)
+}
+
+func (e testEvent) dnsEvent() *assemblers.DnsEvent {
+	responseCode := e.dnsResponseCode
+	if responseCode == "" && !e.dnsTimedOut {
+		responseCode = "NOERROR"
+	}
+	return assemblers.NewDnsEvent(
+		testStreamIdent,
+		e.requestId,
+		e.requestTimestamp,
+		e.responseTimestamp,
+		testRequestPacketCount,
+		testResponsePacketCount,
+		testSrcIp,
+		testDstIp,
+		"api.example.com",
+		"A",
+		responseCode,
+		2,
+		e.dnsTimedOut,
+	)
+}
+
+func (e testEvent) connectionEvent() *assemblers.ConnectionEvent {
+	return assemblers.NewConnectionEvent(
+		testStreamIdent,
+		e.requestId,
+		e.requestTimestamp,
+		e.responseTimestamp,
+		testRequestPacketCount,
+		testResponsePacketCount,
+		testSrcIp,
+		testDstIp,
+		e.synToSynAck,
+		e.synAckToAck,
+		1200,
+		3400,
+		e.endReason,
+		2,
+		&assemblers.TcpStats{
+			Retransmissions: 3,
+			RttSamples:      2,
+			RttMin:          time.Millisecond,
+			RttMax:          3 * time.Millisecond,
+			RttTotal:        4 * time.Millisecond,
+		},
+	)
+}
+
+func (e testEvent) connectionFailureEvent() *assemblers.ConnectionFailureEvent {
+	return assemblers.NewConnectionFailureEvent(
+		testStreamIdent,
+		e.requestId,
+		e.requestTimestamp,
+		e.responseTimestamp,
+		testRequestPacketCount,
+		testResponsePacketCount,
+		testSrcIp,
+		testDstIp,
+		8080,
+		e.failureReason,
+		e.resetBy,
+	)
+}
+
+func (e testEvent) tlsEvent() *assemblers.TlsEvent {
+	// the server picks a cipher suite that belongs to the version it negotiated
+	var cipherSuite uint16
+	switch {
+	case e.tlsVersion >= 0x0304:
+		cipherSuite = 0x1301 // TLS_AES_128_GCM_SHA256
+	case e.tlsVersion != 0:
+		cipherSuite = 0xc02f // TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	}
+	return assemblers.NewTlsEvent(
+		testStreamIdent,
+		e.requestId,
+		e.requestTimestamp,
+		e.responseTimestamp,
+		testRequestPacketCount,
+		testResponsePacketCount,
+		testSrcIp,
+		testDstIp,
+		assemblers.TlsHandshake{
+			ServerName:        "api.example.com",
+			OfferedVersion:    0x0304,
+			NegotiatedVersion: e.tlsVersion,
+			CipherSuite:       cipherSuite,
+			OfferedAlpn:       []string{"h2", "http/1.1"},
+			NegotiatedAlpn:    "h2",
+			Duration:          e.handshakeDuration,
+			Alerts:            e.tlsAlerts,
+			CertNotAfter:      time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
 		},
 	)
 }
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"io"
+	"os"
+
+	"github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/rs/zerolog/log"
+
+	"github.com/honeycombio/honeycomb-network-agent/assemblers"
+	"github.com/honeycombio/honeycomb-network-agent/config"
+	"github.com/honeycombio/honeycomb-network-agent/utils"
+)
+
+// jsonLinesEventHandler is an event handler that writes events as JSON lines instead of sending them to Honeycomb.
+//
+// Each line is a libhoney event with the same fields the libhoney handler sends, eg
+// {"data":{"name":"HTTP GET",...},"samplerate":1,"time":"...","dataset":"hny-network-agent"}.
+// Because the output is deterministic apart from the meta.event_handled_at and
+// meta.*_latency_ms fields, it can be used as a golden output in tests.
+type jsonLinesEventHandler struct {
+	*libhoneyEventHandler
+	// closes the destination once pending events have been written
+	closer io.Closer
+}
+
+var _ EventHandler = (*jsonLinesEventHandler)(nil)
+
+// NewStdoutEventHandler creates a new event handler that writes events as JSON lines to stdout
+func NewStdoutEventHandler(config config.Config, k8sClient *utils.CachedK8sClient, eventsChan chan assemblers.Event, version string) EventHandler {
+	return newJsonLinesEventHandler(config, k8sClient, eventsChan, version, os.Stdout, nil)
+}
+
+// NewFileEventHandler creates a new event handler that writes events as JSON lines to a file that's rotated by size
+func NewFileEventHandler(config config.Config, k8sClient *utils.CachedK8sClient, eventsChan chan assemblers.Event, version string) EventHandler {
+	file, err := newRotatingFileWriter(config.EventsFile, int64(config.EventsFileMaxSize), config.EventsFileMaxBackups)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", config.EventsFile).Msg("Failed to open events file")
+	}
+	log.Info().Str("path", config.EventsFile).Msg("Writing events to file")
+	return newJsonLinesEventHandler(config, k8sClient, eventsChan, version, file, file)
+}
+
+// newJsonLinesEventHandler creates a new event handler that writes events as JSON lines to the writer,
+// closing the closer if it isn't nil when the handler is closed
+func newJsonLinesEventHandler(config config.Config, k8sClient *utils.CachedK8sClient, eventsChan chan assemblers.Event, version string, writer io.Writer, closer io.Closer) *jsonLinesEventHandler {
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		Dataset:      config.Dataset,
+		Transmission: &transmission.WriterSender{W: writer},
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create JSON lines event writer")
+	}
+	addGlobalFields(client.AddField, config, version)
+	return &jsonLinesEventHandler{
+		libhoneyEventHandler: &libhoneyEventHandler{
+			config:     config,
+			k8sClient:  k8sClient,
+			eventsChan: eventsChan,
+			routes:     newRouteTemplaterFromConfig(config),
+			client:     client,
+		},
+		closer: closer,
+	}
+}
+
+// Close writes any pending events, then closes the destination
+func (handler *jsonLinesEventHandler) Close() {
+	handler.libhoneyEventHandler.Close()
+	if handler.closer != nil {
+		if err := handler.closer.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close events file")
+		}
+	}
+}
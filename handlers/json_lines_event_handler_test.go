@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/honeycombio/honeycomb-network-agent/assemblers"
+	"github.com/honeycombio/honeycomb-network-agent/config"
+	"github.com/honeycombio/honeycomb-network-agent/utils"
+)
+
+func Test_jsonLinesEventHandler_writesEvents(t *testing.T) {
+	requestTimestamp := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	event := testEvent{
+		requestId:         1234,
+		requestTimestamp:  requestTimestamp,
+		responseTimestamp: requestTimestamp.Add(2 * time.Millisecond),
+	}.dnsEvent()
+
+	srcPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "src-pod",
+			Namespace: "unit-tests",
+			UID:       "src-pod-uid",
+		},
+		Status: v1.PodStatus{
+			PodIP: event.SrcIp(),
+		},
+	}
+	fakeCachedK8sClient := utils.NewCachedK8sClient(fake.NewSimpleClientset(srcPod))
+	cancelableCtx, done := context.WithCancel(context.Background())
+	fakeCachedK8sClient.Start(cancelableCtx)
+
+	eventsChannel := make(chan assemblers.Event, 1)
+	wgTest := sync.WaitGroup{}
+	testConfig := config.Config{
+		Dataset:              "test-dataset",
+		AgentNodeName:        "test-node",
+		AdditionalAttributes: map[string]string{"environment": "test"},
+	}
+
+	var output bytes.Buffer
+	handler := newJsonLinesEventHandler(testConfig, fakeCachedK8sClient, eventsChannel, "test", &output, nil)
+	wgTest.Add(1)
+	go handler.Start(cancelableCtx, &wgTest)
+
+	eventsChannel <- event
+	time.Sleep(10 * time.Millisecond) // give the handler time to process the event
+
+	done()
+	wgTest.Wait()
+	handler.Close()
+
+	lines := bytes.Split(bytes.TrimSpace(output.Bytes()), []byte("\n"))
+	require.Len(t, lines, 1)
+	var written struct {
+		Data    map[string]interface{} `json:"data"`
+		Time    time.Time              `json:"time"`
+		Dataset string                 `json:"dataset"`
+	}
+	require.NoError(t, json.Unmarshal(lines[0], &written))
+	assert.Equal(t, "test-dataset", written.Dataset)
+	assert.Equal(t, requestTimestamp, written.Time)
+
+	// remove dynamic time-based data before comparing
+	delete(written.Data, "meta.event_handled_at")
+	delete(written.Data, "meta.request.capture_to_handle.latency_ms")
+	delete(written.Data, "meta.response.capture_to_handle.latency_ms")
+
+	// numbers are decoded as float64
+	expectedData := map[string]interface{}{
+		"honeycomb.agent.name":       "Honeycomb Network Agent",
+		"honeycomb.agent.version":    "test",
+		"meta.agent.node.name":       "test-node",
+		"environment":                "test",
+		"name":                       "DNS A",
+		"client.socket.address":      "1.2.3.4",
+		"server.socket.address":      "5.6.7.8",
+		"meta.stream.ident":          "c->s:1->2",
+		"meta.request_id":            float64(1234),
+		"meta.request.packet_count":  float64(testRequestPacketCount),
+		"meta.response.packet_count": float64(testResponsePacketCount),
+		"network.transport":          "udp",
+		"dns.question.name":          "api.example.com",
+		"dns.question.type":          "A",
+		"dns.response_code":          "NOERROR",
+		"dns.answers.count":          float64(2),
+		"duration_ms":                float64(2),
+		"request.timestamp":          "2023-10-01T12:00:00Z",
+		"response.timestamp":         "2023-10-01T12:00:00.002Z",
+		"source.k8s.resource.type":   "pod",
+		"source.k8s.namespace.name":  "unit-tests",
+		"source.k8s.pod.name":        "src-pod",
+		"source.k8s.pod.uid":         "src-pod-uid",
+	}
+	assert.Equal(t, expectedData, written.Data)
+}
+
+func Test_rotatingFileWriter_rotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	writer, err := newRotatingFileWriter(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		_, err := writer.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	// each file holds as many lines as fit, and only the two most recent rotated files are kept
+	for name, expected := range map[string]string{
+		"events.jsonl":   "six\n",
+		"events.jsonl.1": "four\nfive\n",
+		"events.jsonl.2": "three\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(data), name)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// reopening appends to the existing file
+	writer, err = newRotatingFileWriter(path, 10, 2)
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("seven\n"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "six\nseven\n", string(data))
+}
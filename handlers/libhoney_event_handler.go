@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,6 +22,9 @@ type libhoneyEventHandler struct {
 	config     config.Config
 	k8sClient  *utils.CachedK8sClient
 	eventsChan chan assemblers.Event
+	routes     *routeTemplater
+	// the client events are sent with, nil to use the global libhoney client
+	client *libhoney.Client
 }
 
 var _ EventHandler = (*libhoneyEventHandler)(nil)
@@ -28,31 +32,40 @@ var _ EventHandler = (*libhoneyEventHandler)(nil)
 // NewLibhoneyEventHandler creates a new event handler that sends events using libhoney
 func NewLibhoneyEventHandler(config config.Config, k8sClient *utils.CachedK8sClient, eventsChan chan assemblers.Event, version string) EventHandler {
 	initLibhoney(config, version)
+	go countLibhoneySendErrors(libhoney.TxResponses())
 	return &libhoneyEventHandler{
 		config:     config,
 		k8sClient:  k8sClient,
 		eventsChan: eventsChan,
+		routes:     newRouteTemplaterFromConfig(config),
 	}
 }
 
 // Start starts the event handler and begins handling events from the events channel
-// When the context is cancelled, the event handler will stop handling events
+// When the context is cancelled or the events channel is closed, the event handler will stop handling events
 func (handler *libhoneyEventHandler) Start(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	var event assemblers.Event
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case event = <-handler.eventsChan:
+		case event, ok := <-handler.eventsChan:
+			if !ok {
+				return
+			}
 			handler.handleEvent(event)
+			releaseEvent(event)
 		}
 	}
 }
 
 // Close closes the libhoney client, flushing any pending events.
 func (handler *libhoneyEventHandler) Close() {
+	if handler.client != nil {
+		handler.client.Close()
+		return
+	}
 	libhoney.Close()
 }
 
@@ -68,41 +81,51 @@ func initLibhoney(config config.Config, version string) func() {
 	})
 
 	// configure global fields that are set on all events
-	libhoney.AddField("honeycomb.agent.name", "Honeycomb Network Agent")
-	libhoney.AddField("honeycomb.agent.version", version)
+	addGlobalFields(libhoney.AddField, config, version)
+
+	return libhoney.Close
+}
+
+// addGlobalFields adds the fields that are set on all events using the given libhoney client's AddField func
+func addGlobalFields(addField func(name string, val interface{}), config config.Config, version string) {
+	addField("honeycomb.agent.name", "Honeycomb Network Agent")
+	addField("honeycomb.agent.version", version)
 
 	if config.AgentNodeIP != "" {
-		libhoney.AddField("meta.agent.node.ip", config.AgentNodeIP)
+		addField("meta.agent.node.ip", config.AgentNodeIP)
 	}
 	if config.AgentNodeName != "" {
-		libhoney.AddField("meta.agent.node.name", config.AgentNodeName)
+		addField("meta.agent.node.name", config.AgentNodeName)
 	}
 	if config.AgentServiceAccount != "" {
-		libhoney.AddField("meta.agent.serviceaccount.name", config.AgentServiceAccount)
+		addField("meta.agent.serviceaccount.name", config.AgentServiceAccount)
 	}
 	// because we use hostnetwork in deployments, the pod IP and node IP are the same
 	if config.AgentPodIP != "" {
-		libhoney.AddField("meta.agent.pod.ip", config.AgentPodIP)
+		addField("meta.agent.pod.ip", config.AgentPodIP)
 	}
 	if config.AgentPodName != "" {
-		libhoney.AddField("meta.agent.pod.name", config.AgentPodName)
+		addField("meta.agent.pod.name", config.AgentPodName)
 	}
 	for k, v := range config.AdditionalAttributes {
-		libhoney.AddField(k, v)
+		addField(k, v)
 	}
-
-	return libhoney.Close
 }
 
 // handleEvent transforms a captured event into a libhoney event and sends it
 func (handler *libhoneyEventHandler) handleEvent(event assemblers.Event) {
 	// the telemetry event to send
-	var ev *libhoney.Event = libhoney.NewEvent()
+	var ev *libhoney.Event
+	if handler.client != nil {
+		ev = handler.client.NewEvent()
+	} else {
+		ev = libhoney.NewEvent()
+	}
 
 	handler.setTimestampsAndDurationIfValid(ev, event)
 
 	ev.AddField("meta.stream.ident", event.StreamIdent())
-	ev.AddField("meta.seqack", event.RequestId())
+	ev.AddField("meta.request_id", event.RequestId())
 	ev.AddField("meta.request.packet_count", event.RequestPacketCount())
 	ev.AddField("meta.response.packet_count", event.ResponsePacketCount())
 
@@ -110,10 +133,7 @@ func (handler *libhoneyEventHandler) handleEvent(event assemblers.Event) {
 	ev.AddField(string(semconv.ServerSocketAddressKey), event.DstIp())
 
 	// add custom fields based on the event type
-	switch event.(type) {
-	case *assemblers.HttpEvent:
-		handler.addHttpFields(ev, event.(*assemblers.HttpEvent))
-	}
+	handler.addProtocolFields(ev, event)
 
 	ev.Add(handler.k8sClient.GetK8sAttrsForSourceIP(handler.config.AgentPodIP, event.SrcIp()))
 	ev.Add(handler.k8sClient.GetK8sAttrsForDestinationIP(handler.config.AgentPodIP, event.DstIp()))
@@ -131,6 +151,30 @@ func (handler *libhoneyEventHandler) handleEvent(event assemblers.Event) {
 	}
 }
 
+// addProtocolFields adds the fields specific to the event's type, eg its HTTP request and response
+func (handler *libhoneyEventHandler) addProtocolFields(ev *libhoney.Event, event assemblers.Event) {
+	switch event.(type) {
+	case *assemblers.HttpEvent:
+		handler.addHttpFields(ev, event.(*assemblers.HttpEvent))
+	case *assemblers.GrpcEvent:
+		handler.addGrpcFields(ev, event.(*assemblers.GrpcEvent))
+	case *assemblers.RedisEvent:
+		handler.addRedisFields(ev, event.(*assemblers.RedisEvent))
+	case *assemblers.SqlEvent:
+		handler.addSqlFields(ev, event.(*assemblers.SqlEvent))
+	case *assemblers.KafkaEvent:
+		handler.addKafkaFields(ev, event.(*assemblers.KafkaEvent))
+	case *assemblers.DnsEvent:
+		handler.addDnsFields(ev, event.(*assemblers.DnsEvent))
+	case *assemblers.ConnectionEvent:
+		handler.addConnectionFields(ev, event.(*assemblers.ConnectionEvent))
+	case *assemblers.ConnectionFailureEvent:
+		handler.addConnectionFailureFields(ev, event.(*assemblers.ConnectionFailureEvent))
+	case *assemblers.TlsEvent:
+		handler.addTlsFields(ev, event.(*assemblers.TlsEvent))
+	}
+}
+
 // setTimestampsAndDurationIfValid sets time-related fields in the emitted telemetry
 // about the request/response cycle.
 //
@@ -138,6 +182,7 @@ func (handler *libhoneyEventHandler) handleEvent(event assemblers.Event) {
 // computes and includes durations for which there are correct timestamps to based them upon.
 func (handler *libhoneyEventHandler) setTimestampsAndDurationIfValid(honeyEvent *libhoney.Event, event assemblers.Event) {
 	honeyEvent.AddField("meta.event_handled_at", time.Now())
+	requestTimestampField, responseTimestampField := timestampFieldNames(event)
 	switch {
 	case event.RequestTimestamp().IsZero() && event.ResponseTimestamp().IsZero():
 		// no request or response, which is weird, but let's send what we do know
@@ -149,7 +194,7 @@ func (handler *libhoneyEventHandler) setTimestampsAndDurationIfValid(honeyEvent
 		honeyEvent.AddField("meta.timestamps_missing", "request")
 		// but we have a response
 		honeyEvent.Timestamp = event.ResponseTimestamp()
-		honeyEvent.AddField("http.response.timestamp", event.ResponseTimestamp())
+		honeyEvent.AddField(responseTimestampField, event.ResponseTimestamp())
 		honeyEvent.AddField("meta.response.capture_to_handle.latency_ms", time.Since(event.ResponseTimestamp()).Milliseconds())
 
 	case event.ResponseTimestamp().IsZero(): // have request, no response
@@ -157,29 +202,48 @@ func (handler *libhoneyEventHandler) setTimestampsAndDurationIfValid(honeyEvent
 		honeyEvent.AddField("meta.timestamps_missing", "response")
 		// but we have a request
 		honeyEvent.Timestamp = event.RequestTimestamp()
-		honeyEvent.AddField("http.request.timestamp", event.RequestTimestamp())
+		honeyEvent.AddField(requestTimestampField, event.RequestTimestamp())
 		honeyEvent.AddField("meta.request.capture_to_handle.latency_ms", time.Since(event.RequestTimestamp()).Milliseconds())
 
 	default: // the happiest of paths, we have both request and response
 		honeyEvent.Timestamp = event.RequestTimestamp()
-		honeyEvent.AddField("http.request.timestamp", event.RequestTimestamp())
-		honeyEvent.AddField("http.response.timestamp", event.ResponseTimestamp())
+		honeyEvent.AddField(requestTimestampField, event.RequestTimestamp())
+		honeyEvent.AddField(responseTimestampField, event.ResponseTimestamp())
 		honeyEvent.AddField("meta.request.capture_to_handle.latency_ms", time.Since(event.RequestTimestamp()).Milliseconds())
 		honeyEvent.AddField("meta.response.capture_to_handle.latency_ms", time.Since(event.ResponseTimestamp()).Milliseconds())
-		honeyEvent.AddField("duration_ms", event.ResponseTimestamp().Sub(event.RequestTimestamp()).Milliseconds())
+		// the response's last byte marks the end of the request/response cycle
+		honeyEvent.AddField("duration_ms", event.ResponseLastByteTimestamp().Sub(event.RequestTimestamp()).Milliseconds())
 	}
 }
 
 func (handler *libhoneyEventHandler) addHttpFields(ev *libhoney.Event, event *assemblers.HttpEvent) {
+	if seqAck, ok := event.SeqAck(); ok {
+		ev.AddField("meta.seqack", seqAck)
+	}
 	// request attributes
 	if event.Request() != nil {
 		ev.AddField("name", fmt.Sprintf("HTTP %s", event.Request().Method))
 		ev.AddField(string(semconv.HTTPRequestMethodKey), event.Request().Method)
 		ev.AddField(string(semconv.UserAgentOriginalKey), event.Request().Header.Get("User-Agent"))
 		ev.AddField(string(semconv.HTTPRequestBodySizeKey), event.Request().ContentLength)
-		if handler.config.IncludeRequestURL {
-			url, err := url.ParseRequestURI(event.Request().RequestURI)
-			if err == nil {
+		if len(event.Request().TransferEncoding) > 0 {
+			ev.AddField("http.request.transfer_encoding", strings.Join(event.Request().TransferEncoding, ", "))
+		}
+		if event.RequestContentEncoding() != "" {
+			ev.AddField("http.request.content_encoding", event.RequestContentEncoding())
+		}
+		if event.RequestBody() != "" {
+			ev.AddField("http.request.body.content", event.RequestBody())
+			ev.AddField("http.request.body.truncated", event.RequestBodyTruncated())
+		}
+		if version := httpProtocolVersion(event.Request().ProtoMajor, event.Request().ProtoMinor); version != "" {
+			ev.AddField(string(semconv.NetworkProtocolVersionKey), version)
+		}
+		url, err := url.ParseRequestURI(event.Request().RequestURI)
+		if err == nil {
+			// the route is always included as it doesn't have the IDs that make the raw path high cardinality
+			ev.AddField(string(semconv.HTTPRouteKey), handler.routes.route(url.Path))
+			if handler.config.IncludeRequestURL {
 				ev.AddField(string(semconv.URLPathKey), url.Path)
 			}
 		}
@@ -206,6 +270,16 @@ func (handler *libhoneyEventHandler) addHttpFields(ev *libhoney.Event, event *as
 			ev.AddField("error", "HTTP client error")
 		}
 		ev.AddField(string(semconv.HTTPResponseBodySizeKey), event.Response().ContentLength)
+		if len(event.Response().TransferEncoding) > 0 {
+			ev.AddField("http.response.transfer_encoding", strings.Join(event.Response().TransferEncoding, ", "))
+		}
+		if event.ResponseContentEncoding() != "" {
+			ev.AddField("http.response.content_encoding", event.ResponseContentEncoding())
+		}
+		if event.ResponseBody() != "" {
+			ev.AddField("http.response.body.content", event.ResponseBody())
+			ev.AddField("http.response.body.truncated", event.ResponseBodyTruncated())
+		}
 		// by this point, we've already extracted headers based on HTTP_HEADERS list
 		// so we can safely add the headers to the event
 		for k, v := range sanitizeHeaders(false, event.Response().Header) {
@@ -214,4 +288,198 @@ func (handler *libhoneyEventHandler) addHttpFields(ev *libhoney.Event, event *as
 	} else {
 		ev.AddField("http.response.missing", "no response on this event")
 	}
+
+	for key, duration := range transferDurations(event) {
+		ev.AddField(key, duration)
+	}
+	for _, attr := range tcpStatsAttributes(event) {
+		ev.AddField(string(attr.Key), attr.Value.AsInterface())
+	}
+
+	// the request or response was evicted before its counterpart was seen
+	if event.UnmatchedReason() != "" {
+		ev.AddField("http.unmatched_reason", event.UnmatchedReason())
+		if event.Request() != nil && event.Response() == nil {
+			ev.AddField("error", "HTTP response not seen")
+		}
+	}
+}
+
+func (handler *libhoneyEventHandler) addGrpcFields(ev *libhoney.Event, event *assemblers.GrpcEvent) {
+	ev.AddField("name", fmt.Sprintf("gRPC %s", strings.TrimPrefix(event.Path(), "/")))
+	ev.AddField(string(semconv.RPCSystemKey), "grpc")
+	ev.AddField(string(semconv.RPCServiceKey), event.Service())
+	ev.AddField(string(semconv.RPCMethodKey), event.Method())
+	ev.AddField("rpc.grpc.request.message_count", event.RequestMessages().Count)
+	ev.AddField("rpc.grpc.request.compressed_size", event.RequestMessages().CompressedSize)
+	ev.AddField("rpc.grpc.response.message_count", event.ResponseMessages().Count)
+	ev.AddField("rpc.grpc.response.compressed_size", event.ResponseMessages().CompressedSize)
+	if size := event.RequestMessages().UncompressedSize; size >= 0 {
+		ev.AddField("rpc.grpc.request.uncompressed_size", size)
+	}
+	if size := event.ResponseMessages().UncompressedSize; size >= 0 {
+		ev.AddField("rpc.grpc.response.uncompressed_size", size)
+	}
+
+	// by this point, we've already extracted headers based on HTTP_HEADERS list
+	// so we can safely add the headers to the event
+	for k, v := range sanitizeHeaders(true, event.RequestHeader()) {
+		ev.AddField(k, v)
+	}
+	for k, v := range sanitizeHeaders(false, event.ResponseHeader()) {
+		ev.AddField(k, v)
+	}
+
+	if event.StatusCode() >= 0 {
+		ev.AddField(string(semconv.RPCGRPCStatusCodeKey), event.StatusCode())
+		if event.StatusMessage() != "" {
+			ev.AddField("rpc.grpc.status_message", event.StatusMessage())
+		}
+		if event.StatusCode() != 0 {
+			ev.AddField("error", "gRPC error")
+		}
+	} else {
+		ev.AddField("rpc.grpc.status_code.missing", "no grpc-status on this event")
+	}
+	for _, attr := range tcpStatsAttributes(event) {
+		ev.AddField(string(attr.Key), attr.Value.AsInterface())
+	}
+}
+
+func (handler *libhoneyEventHandler) addRedisFields(ev *libhoney.Event, event *assemblers.RedisEvent) {
+	ev.AddField("name", fmt.Sprintf("Redis %s", event.Command()))
+	ev.AddField(string(semconv.DBSystemKey), "redis")
+	ev.AddField(string(semconv.DBOperationKey), event.Command())
+	ev.AddField("db.redis.key_count", event.KeyCount())
+	ev.AddField("db.redis.reply_type", event.ReplyType())
+	if event.ErrorMessage() != "" {
+		ev.AddField("db.redis.error_message", event.ErrorMessage())
+		ev.AddField("error", "Redis error")
+	}
+}
+
+func (handler *libhoneyEventHandler) addSqlFields(ev *libhoney.Event, event *assemblers.SqlEvent) {
+	ev.AddField("name", strings.TrimSpace(fmt.Sprintf("%s %s", event.DbSystem(), event.Operation())))
+	ev.AddField(string(semconv.DBSystemKey), event.DbSystem())
+	ev.AddField(string(semconv.DBStatementKey), event.Statement())
+	ev.AddField(string(semconv.DBOperationKey), event.Operation())
+	if event.RowsAffected() >= 0 {
+		ev.AddField("db.rows_affected", event.RowsAffected())
+	}
+	if event.ErrorCode() != "" || event.ErrorMessage() != "" {
+		ev.AddField("db.response.status_code", event.ErrorCode())
+		ev.AddField("db.error_message", event.ErrorMessage())
+		ev.AddField("error", "Database error")
+	}
+}
+
+func (handler *libhoneyEventHandler) addKafkaFields(ev *libhoney.Event, event *assemblers.KafkaEvent) {
+	ev.AddField("name", fmt.Sprintf("Kafka %s", event.ApiName()))
+	ev.AddField(string(semconv.MessagingSystemKey), "kafka")
+	ev.AddField("messaging.kafka.api_key", event.ApiKey())
+	ev.AddField("messaging.kafka.api_name", event.ApiName())
+	ev.AddField("messaging.kafka.api_version", event.ApiVersion())
+	ev.AddField("messaging.kafka.correlation_id", event.CorrelationId())
+	if event.Operation() != "" {
+		ev.AddField(string(semconv.MessagingOperationKey), event.Operation())
+	}
+	if event.ClientId() != "" {
+		ev.AddField(string(semconv.MessagingClientIDKey), event.ClientId())
+	}
+	if len(event.Topics()) == 1 {
+		ev.AddField(string(semconv.MessagingDestinationNameKey), event.Topics()[0])
+	}
+	if len(event.Topics()) > 0 {
+		ev.AddField("messaging.kafka.topics", event.Topics())
+	}
+	if len(event.TopicIds()) > 0 {
+		ev.AddField("messaging.kafka.topic_ids", event.TopicIds())
+	}
+	if event.ConsumerGroup() != "" {
+		ev.AddField(string(semconv.MessagingKafkaConsumerGroupKey), event.ConsumerGroup())
+	}
+	if len(event.ErrorCodes()) > 0 {
+		ev.AddField("messaging.kafka.error_codes", event.ErrorCodes())
+		ev.AddField("error", "Kafka error")
+	}
+}
+
+func (handler *libhoneyEventHandler) addDnsFields(ev *libhoney.Event, event *assemblers.DnsEvent) {
+	ev.AddField("name", fmt.Sprintf("DNS %s", event.QueryType()))
+	ev.AddField(string(semconv.NetworkTransportKey), "udp")
+	ev.AddField("dns.question.name", event.QueryName())
+	ev.AddField("dns.question.type", event.QueryType())
+	if event.TimedOut() {
+		ev.AddField("dns.timed_out", true)
+		ev.AddField("error", "DNS timeout")
+		return
+	}
+	ev.AddField("dns.response_code", event.ResponseCode())
+	ev.AddField("dns.answers.count", event.AnswerCount())
+	if event.ResponseCode() != "NOERROR" {
+		ev.AddField("error", "DNS error")
+	}
+}
+
+func (handler *libhoneyEventHandler) addConnectionFields(ev *libhoney.Event, event *assemblers.ConnectionEvent) {
+	ev.AddField("name", "TCP connection")
+	ev.AddField(string(semconv.NetworkTransportKey), "tcp")
+	ev.AddField("tcp.connection.end_reason", event.EndReason())
+	ev.AddField("tcp.connection.request_count", event.RequestCount())
+	ev.AddField("tcp.client.bytes", event.ClientBytes())
+	ev.AddField("tcp.server.bytes", event.ServerBytes())
+	ev.AddField("tcp.client.packet_count", event.RequestPacketCount())
+	ev.AddField("tcp.server.packet_count", event.ResponsePacketCount())
+	for key, duration := range handshakeDurations(event) {
+		ev.AddField(key, duration)
+	}
+	for _, attr := range tcpStatsAttributes(event) {
+		ev.AddField(string(attr.Key), attr.Value.AsInterface())
+	}
+	if event.EndReason() == "rst" {
+		ev.AddField("error", "TCP connection reset")
+	}
+}
+
+func (handler *libhoneyEventHandler) addConnectionFailureFields(ev *libhoney.Event, event *assemblers.ConnectionFailureEvent) {
+	ev.AddField("name", connectionFailureName(event.Reason()))
+	ev.AddField(string(semconv.NetworkTransportKey), "tcp")
+	ev.AddField(string(semconv.ServerPortKey), event.ServerPort())
+	ev.AddField("tcp.failure.reason", event.Reason())
+	ev.AddField("error", connectionFailureName(event.Reason()))
+	if event.ResetBy() != "" {
+		ev.AddField("tcp.reset_by", event.ResetBy())
+	}
+}
+
+func (handler *libhoneyEventHandler) addTlsFields(ev *libhoney.Event, event *assemblers.TlsEvent) {
+	ev.AddField("name", "TLS handshake")
+	ev.AddField(string(semconv.NetworkTransportKey), "tcp")
+	ev.AddField("tls.protocol.name", "tls")
+	ev.AddField("tls.client.offered_version", event.OfferedVersion())
+	ev.AddField("tls.established", event.Completed())
+	if event.ServerName() != "" {
+		ev.AddField("tls.client.server_name", event.ServerName())
+	}
+	if len(event.OfferedAlpn()) > 0 {
+		ev.AddField("tls.client.offered_alpn", event.OfferedAlpn())
+	}
+	if event.NegotiatedVersion() != "" {
+		ev.AddField("tls.protocol.version", event.NegotiatedVersion())
+		ev.AddField("tls.cipher", event.CipherSuite())
+	}
+	if event.NegotiatedAlpn() != "" {
+		ev.AddField("tls.next_protocol", event.NegotiatedAlpn())
+	}
+	if !event.CertificateNotAfter().IsZero() {
+		ev.AddField("tls.server.not_after", event.CertificateNotAfter().UTC().Format(time.RFC3339))
+	}
+	if len(event.Alerts()) > 0 {
+		ev.AddField("tls.alerts", event.AlertNames())
+	}
+	if event.Completed() {
+		ev.AddField("tls.handshake_duration_ms", float64(event.HandshakeDuration())/float64(time.Millisecond))
+	} else {
+		ev.AddField("error", "TLS handshake failed")
+	}
 }
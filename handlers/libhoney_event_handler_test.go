@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"net/http"
 	"sync"
 	"testing"
 	"time"
@@ -23,7 +24,7 @@ func Test_libhoneyEventHandler_handleEvent(t *testing.T) {
 	// Test Data - an assembled HTTP Event
 	requestTimestamp := time.Now()
 	responseTimestamp := requestTimestamp.Add(3 * time.Millisecond)
-	event := createTestHttpEvent(requestTimestamp, responseTimestamp)
+	event := testEvent{requestTimestamp: requestTimestamp, responseTimestamp: responseTimestamp}.httpEvent()
 
 	// Test Data - k8s metadata
 	srcPod := &v1.Pod{
@@ -94,10 +95,12 @@ func Test_libhoneyEventHandler_handleEvent(t *testing.T) {
 		"client.socket.address":                "1.2.3.4",
 		"server.socket.address":                "5.6.7.8",
 		"meta.stream.ident":                    "c->s:1->2",
-		"meta.seqack":                          int64(0),
+		"meta.request_id":                      int64(0),
+		"meta.seqack":                          int64(1234567),
 		"meta.request.packet_count":            int(2),
 		"meta.response.packet_count":           int(3),
 		"http.request.method":                  "GET",
+		"http.route":                           "/check",
 		"url.path":                             "/check",
 		"http.request.body.size":               int64(42),
 		"http.request.header.user_agent":       "teapot-checker/1.0",
@@ -108,6 +111,11 @@ func Test_libhoneyEventHandler_handleEvent(t *testing.T) {
 		"http.response.timestamp":              responseTimestamp,
 		"http.response.status_code":            418,
 		"http.response.body.size":              int64(84),
+		"http.response.transfer_encoding":      "chunked",
+		"http.response.content_encoding":       "gzip",
+		"http.request.upload_duration_ms":      int64(0),
+		"http.response.time_to_first_byte_ms":  int64(3),
+		"http.response.download_duration_ms":   int64(0),
 		"error":                                "HTTP client error",
 		"duration_ms":                          int64(3),
 		"user_agent.original":                  "teapot-checker/1.0",
@@ -128,7 +136,7 @@ func Test_libhoneyEventHandler_handleEvent_doesNotSetUrlPath(t *testing.T) {
 	// Test Data - an assembled HTTP Event
 	requestTimestamp := time.Now()
 	responseTimestamp := requestTimestamp.Add(3 * time.Millisecond)
-	event := createTestHttpEvent(requestTimestamp, responseTimestamp)
+	event := testEvent{requestTimestamp: requestTimestamp, responseTimestamp: responseTimestamp}.httpEvent()
 
 	// create a fake k8s clientset with the test pod metadata and start the cached client with it
 	fakeCachedK8sClient := utils.NewCachedK8sClient(fake.NewSimpleClientset())
@@ -167,6 +175,8 @@ func Test_libhoneyEventHandler_handleEvent_doesNotSetUrlPath(t *testing.T) {
 	attrs := events[0].Data
 
 	assert.NotContains(t, attrs, "url.path")
+	// the route doesn't include IDs, so is sent without the URL
+	assert.Equal(t, "/check", attrs["http.route"])
 }
 
 func Test_libhoneyEventHandler_handleEvent_routed_to_service(t *testing.T) {
@@ -175,7 +185,7 @@ func Test_libhoneyEventHandler_handleEvent_routed_to_service(t *testing.T) {
 	// Test Data - an assembled HTTP Event
 	requestTimestamp := time.Now()
 	responseTimestamp := requestTimestamp.Add(3 * time.Millisecond)
-	event := createTestHttpEvent(requestTimestamp, responseTimestamp)
+	event := testEvent{requestTimestamp: requestTimestamp, responseTimestamp: responseTimestamp}.httpEvent()
 
 	// Test Data - k8s metadata
 	srcPod := &v1.Pod{
@@ -245,10 +255,12 @@ func Test_libhoneyEventHandler_handleEvent_routed_to_service(t *testing.T) {
 		"client.socket.address":                "1.2.3.4",
 		"server.socket.address":                "5.6.7.8",
 		"meta.stream.ident":                    "c->s:1->2",
-		"meta.seqack":                          int64(0),
+		"meta.request_id":                      int64(0),
+		"meta.seqack":                          int64(1234567),
 		"meta.request.packet_count":            int(2),
 		"meta.response.packet_count":           int(3),
 		"http.request.method":                  "GET",
+		"http.route":                           "/check",
 		"url.path":                             "/check",
 		"http.request.body.size":               int64(42),
 		"http.request.header.user_agent":       "teapot-checker/1.0",
@@ -259,6 +271,11 @@ func Test_libhoneyEventHandler_handleEvent_routed_to_service(t *testing.T) {
 		"http.response.timestamp":              responseTimestamp,
 		"http.response.status_code":            418,
 		"http.response.body.size":              int64(84),
+		"http.response.transfer_encoding":      "chunked",
+		"http.response.content_encoding":       "gzip",
+		"http.request.upload_duration_ms":      int64(0),
+		"http.response.time_to_first_byte_ms":  int64(3),
+		"http.response.download_duration_ms":   int64(0),
 		"error":                                "HTTP client error",
 		"duration_ms":                          int64(3),
 		"user_agent.original":                  "teapot-checker/1.0",
@@ -330,7 +347,7 @@ func Test_reportingTimesAndDurations(t *testing.T) {
 	for _, tC := range testCases {
 		t.Run(tC.desc, func(t *testing.T) {
 			ev := libhoney.NewEvent()
-			event := createTestHttpEvent(tC.reqTime, tC.respTime)
+			event := testEvent{requestTimestamp: tC.reqTime, responseTimestamp: tC.respTime}.httpEvent()
 
 			handler.setTimestampsAndDurationIfValid(ev, event)
 
@@ -353,6 +370,97 @@ func Test_reportingTimesAndDurations(t *testing.T) {
 	}
 }
 
+func Test_libhoneyEventHandler_timestampFieldNames(t *testing.T) {
+	requestTimestamp := time.Date(1978, time.September, 21, 11, 30, 0, 0, time.UTC)
+	responseTimestamp := requestTimestamp.Add(3 * time.Millisecond)
+
+	testCases := []struct {
+		desc                   string
+		event                  assemblers.Event
+		requestTimestampField  string
+		responseTimestampField string
+	}{
+		{"HTTP", testEvent{
+			requestTimestamp:  requestTimestamp,
+			responseTimestamp: responseTimestamp,
+		}.httpEvent(), "http.request.timestamp", "http.response.timestamp"},
+		{"gRPC", testEvent{
+			requestTimestamp:  requestTimestamp,
+			responseTimestamp: responseTimestamp,
+		}.grpcEvent(), "http.request.timestamp", "http.response.timestamp"},
+		{"Redis", testEvent{
+			requestTimestamp:  requestTimestamp,
+			responseTimestamp: responseTimestamp,
+		}.redisEvent(), "request.timestamp", "response.timestamp"},
+		{"DNS", testEvent{
+			requestTimestamp:  requestTimestamp,
+			responseTimestamp: responseTimestamp,
+		}.dnsEvent(), "request.timestamp", "response.timestamp"},
+	}
+	handler := &libhoneyEventHandler{}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			ev := libhoney.NewEvent()
+			handler.setTimestampsAndDurationIfValid(ev, tC.event)
+
+			assert.Equal(t, requestTimestamp, ev.Fields()[tC.requestTimestampField])
+			assert.Equal(t, responseTimestamp, ev.Fields()[tC.responseTimestampField])
+			if tC.requestTimestampField != "http.request.timestamp" {
+				assert.NotContains(t, ev.Fields(), "http.request.timestamp")
+				assert.NotContains(t, ev.Fields(), "http.response.timestamp")
+			}
+		})
+	}
+}
+
+func Test_libhoneyEventHandler_transferDurations(t *testing.T) {
+	requestTimestamp := time.Date(1978, time.September, 21, 11, 30, 0, 0, time.UTC)
+	event := testEvent{
+		requestTimestamp:          requestTimestamp,
+		requestLastByteTimestamp:  requestTimestamp.Add(5 * time.Millisecond),
+		responseTimestamp:         requestTimestamp.Add(25 * time.Millisecond),
+		responseLastByteTimestamp: requestTimestamp.Add(40 * time.Millisecond),
+	}.httpEvent()
+
+	handler := &libhoneyEventHandler{}
+	ev := libhoney.NewEvent()
+	handler.setTimestampsAndDurationIfValid(ev, event)
+	handler.addHttpFields(ev, event)
+
+	assert.Subset(t, ev.Fields(), map[string]interface{}{
+		"duration_ms":                         int64(40),
+		"http.request.upload_duration_ms":     int64(5),
+		"http.response.time_to_first_byte_ms": int64(20),
+		"http.response.download_duration_ms":  int64(15),
+	})
+}
+
+func Test_libhoneyEventHandler_transferDurationsLeavesOutPartialMessages(t *testing.T) {
+	requestTimestamp := time.Date(1978, time.September, 21, 11, 30, 0, 0, time.UTC)
+	event := assemblers.NewHttpEvent(
+		"c->s:1->2",
+		0,
+		"1.2.3.4",
+		"5.6.7.8",
+		&http.Request{Method: "POST", RequestURI: "/upload"},
+		assemblers.HttpMessageInfo{
+			MessageInfo: assemblers.MessageInfo{Timestamp: requestTimestamp, LastByteTimestamp: requestTimestamp.Add(5 * time.Millisecond), PacketCount: 2},
+		},
+		&http.Response{StatusCode: 200},
+		assemblers.HttpMessageInfo{
+			MessageInfo: assemblers.MessageInfo{Timestamp: requestTimestamp.Add(25 * time.Millisecond), PacketCount: 1, Partial: true},
+		},
+	)
+
+	handler := &libhoneyEventHandler{}
+	ev := libhoney.NewEvent()
+	handler.addHttpFields(ev, event)
+
+	assert.Equal(t, int64(5), ev.Fields()["http.request.upload_duration_ms"])
+	assert.Equal(t, int64(20), ev.Fields()["http.response.time_to_first_byte_ms"])
+	assert.NotContains(t, ev.Fields(), "http.response.download_duration_ms")
+}
+
 // setupTestLibhoney configures a Libhoney with a mock transmission for testing.
 //
 // Events sent can be found on the mock transmission:
@@ -372,3 +480,151 @@ func setupTestLibhoney(t testing.TB) *transmission.MockSender {
 
 	return mockTransmission
 }
+
+func Test_libhoneyEventHandler_addProtocolFields(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		event          assemblers.Event
+		expectedFields map[string]interface{}
+		missingFields  []string
+	}{
+		{
+			desc:  "gRPC",
+			event: testEvent{}.grpcEvent(),
+			expectedFields: map[string]interface{}{
+				"name":                               "gRPC teapot.v1.TeapotService/Brew",
+				"rpc.system":                         "grpc",
+				"rpc.service":                        "teapot.v1.TeapotService",
+				"rpc.method":                         "Brew",
+				"rpc.grpc.status_code":               0,
+				"rpc.grpc.status_message":            "I'm a teapot",
+				"rpc.grpc.request.message_count":     1,
+				"rpc.grpc.request.compressed_size":   int64(12),
+				"rpc.grpc.request.uncompressed_size": int64(12),
+				"rpc.grpc.response.message_count":    3,
+				"rpc.grpc.response.compressed_size":  int64(30),
+				"http.request.header.user_agent":     "grpc-go/1.60.0",
+			},
+			missingFields: []string{"rpc.grpc.response.uncompressed_size", "error"},
+		},
+		{
+			desc:  "Redis",
+			event: testEvent{errorMessage: "ERR unknown command"}.redisEvent(),
+			expectedFields: map[string]interface{}{
+				"name":                   "Redis MGET",
+				"db.system":              "redis",
+				"db.operation":           "MGET",
+				"db.redis.key_count":     2,
+				"db.redis.reply_type":    "array",
+				"db.redis.error_message": "ERR unknown command",
+				"error":                  "Redis error",
+			},
+		},
+		{
+			desc:  "SQL",
+			event: testEvent{errorCode: "42P01", errorMessage: `relation "users" does not exist`}.sqlEvent(),
+			expectedFields: map[string]interface{}{
+				"name":                    "postgresql SELECT",
+				"db.system":               "postgresql",
+				"db.statement":            "SELECT * FROM users WHERE id = ?",
+				"db.operation":            "SELECT",
+				"db.rows_affected":        int64(1),
+				"db.response.status_code": "42P01",
+				"db.error_message":        `relation "users" does not exist`,
+				"error":                   "Database error",
+			},
+		},
+		{
+			desc:  "Kafka",
+			event: testEvent{kafkaErrorCodes: []int16{3}}.kafkaEvent(),
+			expectedFields: map[string]interface{}{
+				"name":                           "Kafka Produce",
+				"messaging.system":               "kafka",
+				"messaging.kafka.api_key":        int16(0),
+				"messaging.kafka.api_name":       "Produce",
+				"messaging.kafka.api_version":    int16(9),
+				"messaging.kafka.correlation_id": int32(7),
+				"messaging.operation":            "publish",
+				"messaging.client_id":            "orders-service",
+				"messaging.destination.name":     "orders",
+				"messaging.kafka.topics":         []string{"orders"},
+				"messaging.kafka.topic_ids":      []string{"AAAAAAAAAAAAAAAAAAAAAQ"},
+				"messaging.kafka.error_codes":    []int16{3},
+				"error":                          "Kafka error",
+			},
+		},
+		{
+			desc:  "DNS",
+			event: testEvent{dnsResponseCode: "NXDOMAIN"}.dnsEvent(),
+			expectedFields: map[string]interface{}{
+				"name":              "DNS A",
+				"network.transport": "udp",
+				"dns.question.name": "api.example.com",
+				"dns.question.type": "A",
+				"dns.response_code": "NXDOMAIN",
+				"dns.answers.count": 2,
+				"error":             "DNS error",
+			},
+		},
+		{
+			desc:  "TCP connection",
+			event: testEvent{synToSynAck: -1, synAckToAck: -1, endReason: "rst"}.connectionEvent(),
+			expectedFields: map[string]interface{}{
+				"name":                         "TCP connection",
+				"network.transport":            "tcp",
+				"tcp.connection.end_reason":    "rst",
+				"tcp.connection.request_count": 2,
+				"tcp.client.bytes":             int64(1200),
+				"tcp.server.bytes":             int64(3400),
+				"tcp.client.packet_count":      testRequestPacketCount,
+				"tcp.server.packet_count":      testResponsePacketCount,
+				"error":                        "TCP connection reset",
+			},
+			missingFields: []string{"tcp.handshake.rtt_ms"},
+		},
+		{
+			desc:  "TLS handshake",
+			event: testEvent{tlsVersion: 0x0303, handshakeDuration: 2 * time.Millisecond}.tlsEvent(),
+			expectedFields: map[string]interface{}{
+				"name":                       "TLS handshake",
+				"network.transport":          "tcp",
+				"tls.protocol.name":          "tls",
+				"tls.client.offered_version": "1.3",
+				"tls.established":            true,
+				"tls.client.server_name":     "api.example.com",
+				"tls.client.offered_alpn":    []string{"h2", "http/1.1"},
+				"tls.protocol.version":       "1.2",
+				"tls.cipher":                 "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+				"tls.next_protocol":          "h2",
+				"tls.server.not_after":       "2030-01-02T03:04:05Z",
+				"tls.handshake_duration_ms":  2.0,
+			},
+			missingFields: []string{"error"},
+		},
+		{
+			desc:  "TCP connection failure",
+			event: testEvent{failureReason: "reset", resetBy: "client"}.connectionFailureEvent(),
+			expectedFields: map[string]interface{}{
+				"name":               "TCP connection reset",
+				"network.transport":  "tcp",
+				"server.port":        8080,
+				"tcp.failure.reason": "reset",
+				"tcp.reset_by":       "client",
+				"error":              "TCP connection reset",
+			},
+		},
+	}
+	handler := &libhoneyEventHandler{}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			ev := libhoney.NewEvent()
+			handler.addProtocolFields(ev, tC.event)
+
+			// global fields may have been added to the event by other tests, so only check the event type's own
+			assert.Subset(t, ev.Fields(), tC.expectedFields)
+			for _, field := range tC.missingFields {
+				assert.NotContains(t, ev.Fields(), field)
+			}
+		})
+	}
+}
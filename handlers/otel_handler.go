@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,6 +26,9 @@ type otelHandler struct {
 	eventsChan   chan assemblers.Event
 	tracer       trace.Tracer
 	otelShutdown func()
+	routes       *routeTemplater
+	// nil unless RED metrics are enabled
+	redMetrics *redMetrics
 }
 
 var _ EventHandler = (*otelHandler)(nil)
@@ -48,33 +52,52 @@ func NewOtelHandler(config config.Config, k8sClient *utils.CachedK8sClient, even
 			"meta.agent.pod.name":            config.AgentPodName,
 			"net.component":                  "proxy", // I'm an interstitial! ᕕ( ᐛ )ᕗ
 		}),
-		otelconfig.WithMetricsEnabled(false),
+		otelconfig.WithMetricsEnabled(config.REDMetrics),
+		otelconfig.WithMetricsReportingPeriod(config.REDMetricsInterval),
+		otelconfig.WithMetricsHeaders(map[string]string{
+			"x-honeycomb-dataset": config.REDMetricsDataset,
+		}),
+		otelconfig.WithErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			sendErrors.WithLabelValues("otel").Inc()
+			log.Warn().Err(err).Msg("OpenTelemetry error")
+		})),
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to configure OpenTelemetry")
 	}
 
-	return &otelHandler{
+	handler := &otelHandler{
 		config:       config,
 		k8sClient:    k8sClient,
 		eventsChan:   eventsChan,
 		tracer:       otel.Tracer(config.Dataset),
 		otelShutdown: otelShutdown,
+		routes:       newRouteTemplaterFromConfig(config),
+	}
+	if config.REDMetrics {
+		handler.redMetrics, err = newRedMetrics(otel.Meter(config.Dataset), k8sClient, config.AgentPodIP, handler.routes, config.REDMetricsMaxSeries)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create RED metrics")
+		}
 	}
+	return handler
 }
 
 // Start starts the event handler and begins handling events from the events channel
-// When the context is cancelled, the event handler will stop handling events
+// When the context is cancelled or the events channel is closed, the event handler will stop handling events
 func (handler *otelHandler) Start(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	var event assemblers.Event
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case event = <-handler.eventsChan:
+		case event, ok := <-handler.eventsChan:
+			if !ok {
+				return
+			}
 			handler.handleEvent(event)
+			releaseEvent(event)
 		}
 	}
 }
@@ -97,6 +120,22 @@ func (handler *otelHandler) handleEvent(event assemblers.Event) {
 	switch event.(type) {
 	case *assemblers.HttpEvent:
 		handler.createHTTPSpan(event.(*assemblers.HttpEvent), startTime, endTime, attrs)
+	case *assemblers.GrpcEvent:
+		handler.createGrpcSpan(event.(*assemblers.GrpcEvent), startTime, endTime, attrs)
+	case *assemblers.RedisEvent:
+		handler.createRedisSpan(event.(*assemblers.RedisEvent), startTime, endTime, attrs)
+	case *assemblers.SqlEvent:
+		handler.createSqlSpan(event.(*assemblers.SqlEvent), startTime, endTime, attrs)
+	case *assemblers.KafkaEvent:
+		handler.createKafkaSpan(event.(*assemblers.KafkaEvent), startTime, endTime, attrs)
+	case *assemblers.DnsEvent:
+		handler.createDnsSpan(event.(*assemblers.DnsEvent), startTime, endTime, attrs)
+	case *assemblers.ConnectionEvent:
+		handler.createConnectionSpan(event.(*assemblers.ConnectionEvent), startTime, endTime, attrs)
+	case *assemblers.ConnectionFailureEvent:
+		handler.createConnectionFailureSpan(event.(*assemblers.ConnectionFailureEvent), startTime, endTime, attrs)
+	case *assemblers.TlsEvent:
+		handler.createTlsSpan(event.(*assemblers.TlsEvent), startTime, endTime, attrs)
 	default:
 		log.Warn().Msg("Unknown event type")
 		return
@@ -111,19 +150,25 @@ func (handler *otelHandler) createHTTPSpan(event *assemblers.HttpEvent, startTim
 		spanName = event.Request().Method
 	}
 
+	attrs := append(incomingAttrs, handler.resolveHTTPAttributes(event)...)
+	handler.createSpan(handler.getContextFromHTTPEvent(event), event, spanName, startTime, endTime, attrs)
+	handler.redMetrics.record(event)
+}
+
+// createSpan creates and ends a span for an event, adding the attributes common to all event types
+func (handler *otelHandler) createSpan(ctx context.Context, event assemblers.Event, spanName string, startTime, endTime time.Time, incomingAttrs []attribute.KeyValue) {
 	attrs := []attribute.KeyValue{
 		attribute.String("meta.stream.ident", event.StreamIdent()),
-		attribute.Int64("meta.seqack", event.RequestId()),
+		attribute.Int64("meta.request_id", event.RequestId()),
 		attribute.Int("meta.request.packet_count", event.RequestPacketCount()),
 		attribute.Int("meta.response.packet_count", event.ResponsePacketCount()),
 		semconv.ClientSocketAddress(event.SrcIp()),
 		semconv.ServerSocketAddress(event.DstIp()),
 	}
 	attrs = append(attrs, incomingAttrs...)
-	attrs = append(attrs, handler.resolveHTTPAttributes(event)...)
 
 	_, span := handler.tracer.Start(
-		handler.getContextFromHTTPEvent(event),
+		ctx,
 		spanName,
 		trace.WithTimestamp(startTime),
 		trace.WithAttributes(attrs...),
@@ -142,6 +187,9 @@ func (handler *otelHandler) createHTTPSpan(event *assemblers.HttpEvent, startTim
 }
 
 func (handler *otelHandler) resolveHTTPAttributes(event *assemblers.HttpEvent) (attrs []attribute.KeyValue) {
+	if seqAck, ok := event.SeqAck(); ok {
+		attrs = append(attrs, attribute.Int64("meta.seqack", seqAck))
+	}
 	// request attributes
 	if event.Request() != nil {
 		attrs = append(attrs,
@@ -150,14 +198,32 @@ func (handler *otelHandler) resolveHTTPAttributes(event *assemblers.HttpEvent) (
 			semconv.HTTPRequestBodySize(int(event.Request().ContentLength)),
 			semconv.HTTPRequestContentLength(int(event.Request().ContentLength)), // dual-send; deprecated in favor of HTTPRequestBodySize
 		)
+		if len(event.Request().TransferEncoding) > 0 {
+			attrs = append(attrs, attribute.String("http.request.transfer_encoding", strings.Join(event.Request().TransferEncoding, ", ")))
+		}
+		if event.RequestContentEncoding() != "" {
+			attrs = append(attrs, attribute.String("http.request.content_encoding", event.RequestContentEncoding()))
+		}
+		if event.RequestBody() != "" {
+			attrs = append(attrs,
+				attribute.String("http.request.body.content", event.RequestBody()),
+				attribute.Bool("http.request.body.truncated", event.RequestBodyTruncated()),
+			)
+		}
+
+		if version := httpProtocolVersion(event.Request().ProtoMajor, event.Request().ProtoMinor); version != "" {
+			attrs = append(attrs, semconv.NetworkProtocolVersion(version))
+		}
 
 		// by this point, we've already extracted headers based on HTTP_HEADERS list
 		// so we can safely add the headers to the event
 		attrs = append(attrs, headerToAttributes(true, event.Request().Header)...)
 
-		if handler.config.IncludeRequestURL {
-			url, err := url.ParseRequestURI(event.Request().RequestURI)
-			if err == nil {
+		url, err := url.ParseRequestURI(event.Request().RequestURI)
+		if err == nil {
+			// the route is always included as it doesn't have the IDs that make the raw path high cardinality
+			attrs = append(attrs, semconv.HTTPRoute(handler.routes.route(url.Path)))
+			if handler.config.IncludeRequestURL {
 				attrs = append(attrs,
 					semconv.URLPath(url.Path),
 					semconv.HTTPTarget(url.Path), // dual-send; deprecated in favor of URLPath
@@ -176,6 +242,18 @@ func (handler *otelHandler) resolveHTTPAttributes(event *assemblers.HttpEvent) (
 			semconv.HTTPResponseBodySize(int(event.Response().ContentLength)),
 			semconv.HTTPResponseContentLength(int(event.Response().ContentLength)), // dual-send; deprecated in favor of HTTPResponseBodySize
 		)
+		if len(event.Response().TransferEncoding) > 0 {
+			attrs = append(attrs, attribute.String("http.response.transfer_encoding", strings.Join(event.Response().TransferEncoding, ", ")))
+		}
+		if event.ResponseContentEncoding() != "" {
+			attrs = append(attrs, attribute.String("http.response.content_encoding", event.ResponseContentEncoding()))
+		}
+		if event.ResponseBody() != "" {
+			attrs = append(attrs,
+				attribute.String("http.response.body.content", event.ResponseBody()),
+				attribute.Bool("http.response.body.truncated", event.ResponseBodyTruncated()),
+			)
+		}
 		// by this point, we've already extracted headers based on HTTP_HEADERS list
 		// so we can safely add the headers to the event
 		attrs = append(attrs, headerToAttributes(false, event.Response().Header)...)
@@ -198,6 +276,272 @@ func (handler *otelHandler) resolveHTTPAttributes(event *assemblers.HttpEvent) (
 		)
 	}
 
+	for key, duration := range transferDurations(event) {
+		attrs = append(attrs, attribute.Int64(key, duration))
+	}
+	attrs = append(attrs, tcpStatsAttributes(event)...)
+
+	// the request or response was evicted before its counterpart was seen
+	if event.UnmatchedReason() != "" {
+		attrs = append(attrs, attribute.String("http.unmatched_reason", event.UnmatchedReason()))
+		if event.Request() != nil && event.Response() == nil {
+			attrs = append(attrs, attribute.String("error", "HTTP response not seen"))
+		}
+	}
+
+	return attrs
+}
+
+func (handler *otelHandler) createGrpcSpan(event *assemblers.GrpcEvent, startTime, endTime time.Time, incomingAttrs []attribute.KeyValue) {
+	// OTel names gRPC spans as $package.$service/$method
+	spanName := strings.TrimPrefix(event.Path(), "/")
+	if spanName == "" {
+		spanName = "gRPC"
+	}
+
+	// gRPC clients propagate trace context in request metadata, which is sent as HTTP/2 headers
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(event.RequestHeader()))
+	attrs := append(incomingAttrs, handler.resolveGrpcAttributes(event)...)
+	handler.createSpan(ctx, event, spanName, startTime, endTime, attrs)
+}
+
+func (handler *otelHandler) resolveGrpcAttributes(event *assemblers.GrpcEvent) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.RPCSystemGRPC,
+		semconv.RPCService(event.Service()),
+		semconv.RPCMethod(event.Method()),
+		attribute.Int("rpc.grpc.request.message_count", event.RequestMessages().Count),
+		attribute.Int64("rpc.grpc.request.compressed_size", event.RequestMessages().CompressedSize),
+		attribute.Int("rpc.grpc.response.message_count", event.ResponseMessages().Count),
+		attribute.Int64("rpc.grpc.response.compressed_size", event.ResponseMessages().CompressedSize),
+	}
+	if size := event.RequestMessages().UncompressedSize; size >= 0 {
+		attrs = append(attrs, attribute.Int64("rpc.grpc.request.uncompressed_size", size))
+	}
+	if size := event.ResponseMessages().UncompressedSize; size >= 0 {
+		attrs = append(attrs, attribute.Int64("rpc.grpc.response.uncompressed_size", size))
+	}
+
+	// by this point, we've already extracted headers based on HTTP_HEADERS list
+	// so we can safely add the headers to the event
+	attrs = append(attrs, headerToAttributes(true, event.RequestHeader())...)
+	attrs = append(attrs, headerToAttributes(false, event.ResponseHeader())...)
+
+	if event.StatusCode() >= 0 {
+		attrs = append(attrs, semconv.RPCGRPCStatusCodeKey.Int(event.StatusCode()))
+		if event.StatusMessage() != "" {
+			attrs = append(attrs, attribute.String("rpc.grpc.status_message", event.StatusMessage()))
+		}
+		if event.StatusCode() != 0 {
+			attrs = append(attrs, attribute.String("error", "gRPC error"))
+		}
+	} else {
+		attrs = append(attrs, attribute.String("rpc.grpc.status_code.missing", "no grpc-status on this event"))
+	}
+	return append(attrs, tcpStatsAttributes(event)...)
+}
+
+func (handler *otelHandler) createRedisSpan(event *assemblers.RedisEvent, startTime, endTime time.Time, incomingAttrs []attribute.KeyValue) {
+	attrs := append(incomingAttrs, handler.resolveRedisAttributes(event)...)
+	handler.createSpan(context.Background(), event, event.Command(), startTime, endTime, attrs)
+}
+
+func (handler *otelHandler) resolveRedisAttributes(event *assemblers.RedisEvent) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemRedis,
+		semconv.DBOperation(event.Command()),
+		attribute.Int("db.redis.key_count", event.KeyCount()),
+		attribute.String("db.redis.reply_type", event.ReplyType()),
+	}
+	if event.ErrorMessage() != "" {
+		attrs = append(attrs,
+			attribute.String("db.redis.error_message", event.ErrorMessage()),
+			attribute.String("error", "Redis error"),
+		)
+	}
+	return attrs
+}
+
+func (handler *otelHandler) createSqlSpan(event *assemblers.SqlEvent, startTime, endTime time.Time, incomingAttrs []attribute.KeyValue) {
+	attrs := append(incomingAttrs, handler.resolveSqlAttributes(event)...)
+	spanName := event.Operation()
+	if spanName == "" {
+		spanName = event.DbSystem()
+	}
+	handler.createSpan(context.Background(), event, spanName, startTime, endTime, attrs)
+}
+
+func (handler *otelHandler) resolveSqlAttributes(event *assemblers.SqlEvent) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemKey.String(event.DbSystem()),
+		semconv.DBStatement(event.Statement()),
+		semconv.DBOperation(event.Operation()),
+	}
+	if event.RowsAffected() >= 0 {
+		attrs = append(attrs, attribute.Int64("db.rows_affected", event.RowsAffected()))
+	}
+	if event.ErrorCode() != "" || event.ErrorMessage() != "" {
+		attrs = append(attrs,
+			attribute.String("db.response.status_code", event.ErrorCode()),
+			attribute.String("db.error_message", event.ErrorMessage()),
+			attribute.String("error", "Database error"),
+		)
+	}
+	return attrs
+}
+
+func (handler *otelHandler) createKafkaSpan(event *assemblers.KafkaEvent, startTime, endTime time.Time, incomingAttrs []attribute.KeyValue) {
+	attrs := append(incomingAttrs, handler.resolveKafkaAttributes(event)...)
+	handler.createSpan(context.Background(), event, event.ApiName(), startTime, endTime, attrs)
+}
+
+func (handler *otelHandler) resolveKafkaAttributes(event *assemblers.KafkaEvent) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		attribute.Int("messaging.kafka.api_key", int(event.ApiKey())),
+		attribute.String("messaging.kafka.api_name", event.ApiName()),
+		attribute.Int("messaging.kafka.api_version", int(event.ApiVersion())),
+		attribute.Int("messaging.kafka.correlation_id", int(event.CorrelationId())),
+	}
+	if event.Operation() != "" {
+		attrs = append(attrs, semconv.MessagingOperationKey.String(event.Operation()))
+	}
+	if event.ClientId() != "" {
+		attrs = append(attrs, semconv.MessagingClientID(event.ClientId()))
+	}
+	if len(event.Topics()) == 1 {
+		attrs = append(attrs, semconv.MessagingDestinationName(event.Topics()[0]))
+	}
+	if len(event.Topics()) > 0 {
+		attrs = append(attrs, attribute.StringSlice("messaging.kafka.topics", event.Topics()))
+	}
+	if len(event.TopicIds()) > 0 {
+		attrs = append(attrs, attribute.StringSlice("messaging.kafka.topic_ids", event.TopicIds()))
+	}
+	if event.ConsumerGroup() != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(event.ConsumerGroup()))
+	}
+	if len(event.ErrorCodes()) > 0 {
+		errorCodes := make([]int, len(event.ErrorCodes()))
+		for i, errorCode := range event.ErrorCodes() {
+			errorCodes[i] = int(errorCode)
+		}
+		attrs = append(attrs,
+			attribute.IntSlice("messaging.kafka.error_codes", errorCodes),
+			attribute.String("error", "Kafka error"),
+		)
+	}
+	return attrs
+}
+
+func (handler *otelHandler) createDnsSpan(event *assemblers.DnsEvent, startTime, endTime time.Time, incomingAttrs []attribute.KeyValue) {
+	attrs := append(incomingAttrs, handler.resolveDnsAttributes(event)...)
+	handler.createSpan(context.Background(), event, "DNS "+event.QueryType(), startTime, endTime, attrs)
+}
+
+func (handler *otelHandler) resolveDnsAttributes(event *assemblers.DnsEvent) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.NetworkTransportUDP,
+		attribute.String("dns.question.name", event.QueryName()),
+		attribute.String("dns.question.type", event.QueryType()),
+	}
+	if event.TimedOut() {
+		return append(attrs,
+			attribute.Bool("dns.timed_out", true),
+			attribute.String("error", "DNS timeout"),
+		)
+	}
+	attrs = append(attrs,
+		attribute.String("dns.response_code", event.ResponseCode()),
+		attribute.Int("dns.answers.count", event.AnswerCount()),
+	)
+	if event.ResponseCode() != "NOERROR" {
+		attrs = append(attrs, attribute.String("error", "DNS error"))
+	}
+	return attrs
+}
+
+func (handler *otelHandler) createConnectionSpan(event *assemblers.ConnectionEvent, startTime, endTime time.Time, incomingAttrs []attribute.KeyValue) {
+	attrs := append(incomingAttrs, handler.resolveConnectionAttributes(event)...)
+	handler.createSpan(context.Background(), event, "TCP connection", startTime, endTime, attrs)
+}
+
+func (handler *otelHandler) resolveConnectionAttributes(event *assemblers.ConnectionEvent) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.NetworkTransportTCP,
+		attribute.String("tcp.connection.end_reason", event.EndReason()),
+		attribute.Int("tcp.connection.request_count", event.RequestCount()),
+		attribute.Int64("tcp.client.bytes", event.ClientBytes()),
+		attribute.Int64("tcp.server.bytes", event.ServerBytes()),
+		attribute.Int("tcp.client.packet_count", event.RequestPacketCount()),
+		attribute.Int("tcp.server.packet_count", event.ResponsePacketCount()),
+	}
+	for key, duration := range handshakeDurations(event) {
+		attrs = append(attrs, attribute.Float64(key, duration))
+	}
+	attrs = append(attrs, tcpStatsAttributes(event)...)
+	if event.EndReason() == "rst" {
+		attrs = append(attrs, attribute.String("error", "TCP connection reset"))
+	}
+	return attrs
+}
+
+func (handler *otelHandler) createConnectionFailureSpan(event *assemblers.ConnectionFailureEvent, startTime, endTime time.Time, incomingAttrs []attribute.KeyValue) {
+	attrs := append(incomingAttrs, handler.resolveConnectionFailureAttributes(event)...)
+	handler.createSpan(context.Background(), event, connectionFailureName(event.Reason()), startTime, endTime, attrs)
+}
+
+func (handler *otelHandler) resolveConnectionFailureAttributes(event *assemblers.ConnectionFailureEvent) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.NetworkTransportTCP,
+		semconv.ServerPort(event.ServerPort()),
+		attribute.String("tcp.failure.reason", event.Reason()),
+		attribute.String("error", connectionFailureName(event.Reason())),
+	}
+	if event.ResetBy() != "" {
+		attrs = append(attrs, attribute.String("tcp.reset_by", event.ResetBy()))
+	}
+	return attrs
+}
+
+func (handler *otelHandler) createTlsSpan(event *assemblers.TlsEvent, startTime, endTime time.Time, incomingAttrs []attribute.KeyValue) {
+	attrs := append(incomingAttrs, handler.resolveTlsAttributes(event)...)
+	handler.createSpan(context.Background(), event, "TLS handshake", startTime, endTime, attrs)
+}
+
+func (handler *otelHandler) resolveTlsAttributes(event *assemblers.TlsEvent) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.NetworkTransportTCP,
+		attribute.String("tls.protocol.name", "tls"),
+		attribute.String("tls.client.offered_version", event.OfferedVersion()),
+		attribute.Bool("tls.established", event.Completed()),
+	}
+	if event.ServerName() != "" {
+		attrs = append(attrs, attribute.String("tls.client.server_name", event.ServerName()))
+	}
+	if len(event.OfferedAlpn()) > 0 {
+		attrs = append(attrs, attribute.StringSlice("tls.client.offered_alpn", event.OfferedAlpn()))
+	}
+	if event.NegotiatedVersion() != "" {
+		attrs = append(attrs,
+			attribute.String("tls.protocol.version", event.NegotiatedVersion()),
+			attribute.String("tls.cipher", event.CipherSuite()),
+		)
+	}
+	if event.NegotiatedAlpn() != "" {
+		attrs = append(attrs, attribute.String("tls.next_protocol", event.NegotiatedAlpn()))
+	}
+	if !event.CertificateNotAfter().IsZero() {
+		attrs = append(attrs, attribute.String("tls.server.not_after", event.CertificateNotAfter().UTC().Format(time.RFC3339)))
+	}
+	if len(event.Alerts()) > 0 {
+		attrs = append(attrs, attribute.StringSlice("tls.alerts", event.AlertNames()))
+	}
+	if event.Completed() {
+		attrs = append(attrs, attribute.Float64("tls.handshake_duration_ms", float64(event.HandshakeDuration())/float64(time.Millisecond)))
+	} else {
+		attrs = append(attrs, attribute.String("error", "TLS handshake failed"))
+	}
 	return attrs
 }
 
@@ -211,6 +555,7 @@ func (handler *otelHandler) getEventStartEndTimestamps(event assemblers.Event) (
 	attrs := []attribute.KeyValue{
 		attribute.String("meta.event_handled_at", time.Now().String()),
 	}
+	requestTimestampField, responseTimestampField := timestampFieldNames(event)
 
 	switch {
 	case event.RequestTimestamp().IsZero() && event.ResponseTimestamp().IsZero(): // no request or response
@@ -222,24 +567,24 @@ func (handler *otelHandler) getEventStartEndTimestamps(event assemblers.Event) (
 		attrs = append(attrs, attribute.String("meta.timestamps_missing", "request"))
 		startTime = event.ResponseTimestamp()
 		endTime = startTime
-		attrs = append(attrs, attribute.String("http.response.timestamp", event.ResponseTimestamp().String()))
+		attrs = append(attrs, attribute.String(responseTimestampField, event.ResponseTimestamp().String()))
 		attrs = append(attrs, attribute.Int64("meta.response.capture_to_handle.latency_ms", time.Since(event.ResponseTimestamp()).Milliseconds()))
 
 	case event.ResponseTimestamp().IsZero(): // have request, no response
 		attrs = append(attrs, attribute.String("meta.timestamps_missing", "response"))
 		startTime = event.RequestTimestamp()
 		endTime = startTime
-		attrs = append(attrs, attribute.String("http.request.timestamp", event.RequestTimestamp().String()))
+		attrs = append(attrs, attribute.String(requestTimestampField, event.RequestTimestamp().String()))
 		attrs = append(attrs, attribute.Int64("meta.request.capture_to_handle.latency_ms", time.Since(event.RequestTimestamp()).Milliseconds()))
 
 	default: // the happiest of paths, we have both request and response
 		startTime = event.RequestTimestamp()
-		endTime = event.ResponseTimestamp()
-		attrs = append(attrs, attribute.String("http.request.timestamp", event.RequestTimestamp().String()))
-		attrs = append(attrs, attribute.String("http.response.timestamp", event.ResponseTimestamp().String()))
+		endTime = event.ResponseLastByteTimestamp()
+		attrs = append(attrs, attribute.String(requestTimestampField, event.RequestTimestamp().String()))
+		attrs = append(attrs, attribute.String(responseTimestampField, event.ResponseTimestamp().String()))
 		attrs = append(attrs, attribute.Int64("meta.request.capture_to_handle.latency_ms", time.Since(event.RequestTimestamp()).Milliseconds()))
 		attrs = append(attrs, attribute.Int64("meta.response.capture_to_handle.latency_ms", time.Since(event.ResponseTimestamp()).Milliseconds()))
-		attrs = append(attrs, attribute.Int64("duration_ms", event.ResponseTimestamp().Sub(event.RequestTimestamp()).Milliseconds()))
+		attrs = append(attrs, attribute.Int64("duration_ms", endTime.Sub(startTime).Milliseconds()))
 
 	}
 	return startTime, endTime, attrs
@@ -29,9 +29,9 @@ func Test_extractContextFromEvent(t *testing.T) {
 
 	// create a test http event
 	now := time.Now()
-	event := createTestHttpEventWithRequestHeader(now, now, &http.Header{
+	event := testEvent{requestTimestamp: now, responseTimestamp: now, requestHeader: &http.Header{
 		"Traceparent": []string{fmt.Sprintf("00-%s-%s-01", traceID, spanID)},
-	})
+	}}.httpEvent()
 
 	// use handler to get context from the HTTP event
 	ctx := handler.getContextFromHTTPEvent(event)
@@ -46,7 +46,7 @@ func Test_extractContextFromEvent(t *testing.T) {
 func TestHeaderToAttributes(t *testing.T) {
 	requestTimestamp := time.Now()
 	responseTimestamp := requestTimestamp.Add(3 * time.Millisecond)
-	event := createTestHttpEvent(requestTimestamp, responseTimestamp)
+	event := testEvent{requestTimestamp: requestTimestamp, responseTimestamp: responseTimestamp}.httpEvent()
 
 	reqAttrs := headerToAttributes(true, event.Request().Header)
 	assert.Contains(t, reqAttrs, attribute.String("http.request.header.user_agent", "teapot-checker/1.0"))
@@ -75,11 +75,12 @@ func TestResolveHTTPAttributes(t *testing.T) {
 	})
 
 	// a "real" HTTP realishEvent
-	realishEvent := createTestHttpEvent(time.Now(), time.Now().Add(3*time.Millisecond))
+	realishEvent := testEvent{requestTimestamp: time.Now(), responseTimestamp: time.Now().Add(3 * time.Millisecond)}.httpEvent()
 
 	t.Run("a realish request", func(t *testing.T) {
 		attrs := defaultHandler.resolveHTTPAttributes(realishEvent)
 
+		assert.Contains(t, attrs, attribute.Int64("meta.seqack", 1234567))
 		assert.Contains(t, attrs, attribute.String("http.request.method", "GET"))
 		assert.Contains(t, attrs, attribute.String("http.method", "GET"))
 		assert.Contains(t, attrs, attribute.String("http.request.header.user_agent", "teapot-checker/1.0"))
@@ -91,6 +92,10 @@ func TestResolveHTTPAttributes(t *testing.T) {
 		assert.Contains(t, attrs, attribute.Int("http.request.body.size", 42))
 		assert.Contains(t, attrs, attribute.Int("http.response_content_length", 84))
 		assert.Contains(t, attrs, attribute.Int("http.response.body.size", 84))
+		assert.Contains(t, attrs, attribute.String("http.response.transfer_encoding", "chunked"))
+		assert.Contains(t, attrs, attribute.String("http.response.content_encoding", "gzip"))
+		assert.Contains(t, attrs, attribute.String("http.route", "/check"))
+		assert.NotContains(t, attrs, attribute.String("url.path", "/check"))
 		assert.Contains(t, attrs, attribute.String("http.response.header.content_type", "text/plain; charset=utf-8"))
 		assert.Contains(t, attrs, attribute.String("http.response.header.x_custom_header", "tea-party"))
 	})
@@ -110,4 +115,236 @@ func TestResolveHTTPAttributes(t *testing.T) {
 		assert.Contains(t, attrs, attribute.String("url.path", "/check"))
 		assert.Contains(t, attrs, attribute.String("http.target", "/check"))
 	})
+
+	t.Run("transfer durations", func(t *testing.T) {
+		requestTimestamp := time.Now()
+		event := testEvent{
+			requestTimestamp:          requestTimestamp,
+			requestLastByteTimestamp:  requestTimestamp.Add(5 * time.Millisecond),
+			responseTimestamp:         requestTimestamp.Add(25 * time.Millisecond),
+			responseLastByteTimestamp: requestTimestamp.Add(40 * time.Millisecond),
+		}.httpEvent()
+		attrs := defaultHandler.resolveHTTPAttributes(event)
+
+		assert.Contains(t, attrs, attribute.Int64("http.request.upload_duration_ms", 5))
+		assert.Contains(t, attrs, attribute.Int64("http.response.time_to_first_byte_ms", 20))
+		assert.Contains(t, attrs, attribute.Int64("http.response.download_duration_ms", 15))
+
+		startTime, endTime, timestampAttrs := defaultHandler.getEventStartEndTimestamps(event)
+		assert.Equal(t, requestTimestamp, startTime)
+		assert.Equal(t, requestTimestamp.Add(40*time.Millisecond), endTime)
+		assert.Contains(t, timestampAttrs, attribute.Int64("duration_ms", 40))
+	})
+}
+
+func TestGetEventStartEndTimestampsUsesProtocolNeutralNamesForNonHttpEvents(t *testing.T) {
+	handler := &otelHandler{}
+	requestTimestamp := time.Now()
+	responseTimestamp := requestTimestamp.Add(3 * time.Millisecond)
+
+	_, _, attrs := handler.getEventStartEndTimestamps(testEvent{
+		requestTimestamp:  requestTimestamp,
+		responseTimestamp: responseTimestamp,
+	}.redisEvent())
+
+	assert.Contains(t, attrs, attribute.String("request.timestamp", requestTimestamp.String()))
+	assert.Contains(t, attrs, attribute.String("response.timestamp", responseTimestamp.String()))
+	assert.NotContains(t, attrs, attribute.String("http.request.timestamp", requestTimestamp.String()))
+}
+
+func TestResolveGrpcAttributes(t *testing.T) {
+	handler := NewOtelHandler(
+		config.Config{},
+		nil,
+		nil,
+		"").(*otelHandler)
+	defer handler.Close()
+
+	t.Run("a failed call", func(t *testing.T) {
+		attrs := handler.resolveGrpcAttributes(testEvent{grpcStatusCode: 12}.grpcEvent())
+
+		assert.Contains(t, attrs, attribute.String("rpc.system", "grpc"))
+		assert.Contains(t, attrs, attribute.String("rpc.service", "teapot.v1.TeapotService"))
+		assert.Contains(t, attrs, attribute.String("rpc.method", "Brew"))
+		assert.Contains(t, attrs, attribute.Int("rpc.grpc.status_code", 12))
+		assert.Contains(t, attrs, attribute.String("rpc.grpc.status_message", "I'm a teapot"))
+		assert.Contains(t, attrs, attribute.String("error", "gRPC error"))
+		assert.Contains(t, attrs, attribute.Int("rpc.grpc.request.message_count", 1))
+		assert.Contains(t, attrs, attribute.Int64("rpc.grpc.request.compressed_size", 12))
+		assert.Contains(t, attrs, attribute.Int64("rpc.grpc.request.uncompressed_size", 12))
+		assert.Contains(t, attrs, attribute.Int("rpc.grpc.response.message_count", 3))
+		assert.Contains(t, attrs, attribute.Int64("rpc.grpc.response.compressed_size", 30))
+		assert.NotContains(t, attrs, attribute.Int64("rpc.grpc.response.uncompressed_size", -1))
+		assert.Contains(t, attrs, attribute.String("http.request.header.user_agent", "grpc-go/1.60.0"))
+	})
+
+	t.Run("a call without a status", func(t *testing.T) {
+		attrs := handler.resolveGrpcAttributes(testEvent{grpcStatusCode: -1}.grpcEvent())
+
+		assert.Contains(t, attrs, attribute.String("rpc.grpc.status_code.missing", "no grpc-status on this event"))
+		assert.NotContains(t, attrs, attribute.String("error", "gRPC error"))
+	})
+}
+
+func TestResolveRedisAttributes(t *testing.T) {
+	handler := &otelHandler{}
+
+	attrs := handler.resolveRedisAttributes(testEvent{}.redisEvent())
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation", "MGET"),
+		attribute.Int("db.redis.key_count", 2),
+		attribute.String("db.redis.reply_type", "array"),
+	}, attrs)
+
+	attrs = handler.resolveRedisAttributes(testEvent{
+		errorMessage: "WRONGTYPE Operation against a key holding the wrong kind of value",
+	}.redisEvent())
+	assert.Contains(t, attrs, attribute.String("db.redis.error_message", "WRONGTYPE Operation against a key holding the wrong kind of value"))
+	assert.Contains(t, attrs, attribute.String("error", "Redis error"))
+}
+
+func TestResolveSqlAttributes(t *testing.T) {
+	handler := &otelHandler{}
+
+	attrs := handler.resolveSqlAttributes(testEvent{}.sqlEvent())
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", "SELECT * FROM users WHERE id = ?"),
+		attribute.String("db.operation", "SELECT"),
+		attribute.Int64("db.rows_affected", 1),
+	}, attrs)
+
+	attrs = handler.resolveSqlAttributes(testEvent{
+		errorCode:    "42P01",
+		errorMessage: `relation "users" does not exist`,
+	}.sqlEvent())
+	assert.Contains(t, attrs, attribute.String("db.response.status_code", "42P01"))
+	assert.Contains(t, attrs, attribute.String("db.error_message", `relation "users" does not exist`))
+	assert.Contains(t, attrs, attribute.String("error", "Database error"))
+}
+
+func TestResolveKafkaAttributes(t *testing.T) {
+	handler := &otelHandler{}
+
+	attrs := handler.resolveKafkaAttributes(testEvent{}.kafkaEvent())
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("messaging.system", "kafka"),
+		attribute.Int("messaging.kafka.api_key", 0),
+		attribute.String("messaging.kafka.api_name", "Produce"),
+		attribute.Int("messaging.kafka.api_version", 9),
+		attribute.Int("messaging.kafka.correlation_id", 7),
+		attribute.String("messaging.operation", "publish"),
+		attribute.String("messaging.client_id", "orders-service"),
+		attribute.String("messaging.destination.name", "orders"),
+		attribute.StringSlice("messaging.kafka.topics", []string{"orders"}),
+		attribute.StringSlice("messaging.kafka.topic_ids", []string{"AAAAAAAAAAAAAAAAAAAAAQ"}),
+	}, attrs)
+
+	attrs = handler.resolveKafkaAttributes(testEvent{kafkaErrorCodes: []int16{3}}.kafkaEvent())
+	assert.Contains(t, attrs, attribute.IntSlice("messaging.kafka.error_codes", []int{3}))
+	assert.Contains(t, attrs, attribute.String("error", "Kafka error"))
+}
+
+func TestResolveDnsAttributes(t *testing.T) {
+	handler := &otelHandler{}
+
+	attrs := handler.resolveDnsAttributes(testEvent{}.dnsEvent())
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("network.transport", "udp"),
+		attribute.String("dns.question.name", "api.example.com"),
+		attribute.String("dns.question.type", "A"),
+		attribute.String("dns.response_code", "NOERROR"),
+		attribute.Int("dns.answers.count", 2),
+	}, attrs)
+
+	attrs = handler.resolveDnsAttributes(testEvent{dnsResponseCode: "SERVFAIL"}.dnsEvent())
+	assert.Contains(t, attrs, attribute.String("error", "DNS error"))
+
+	attrs = handler.resolveDnsAttributes(testEvent{requestTimestamp: time.Now(), dnsTimedOut: true}.dnsEvent())
+	assert.Contains(t, attrs, attribute.Bool("dns.timed_out", true))
+	assert.Contains(t, attrs, attribute.String("error", "DNS timeout"))
+	assert.NotContains(t, attrs, attribute.String("dns.response_code", ""))
+}
+
+func TestResolveConnectionAttributes(t *testing.T) {
+	handler := &otelHandler{}
+
+	attrs := handler.resolveConnectionAttributes(testEvent{
+		synToSynAck: 1500 * time.Microsecond,
+		synAckToAck: 500 * time.Microsecond,
+		endReason:   "fin",
+	}.connectionEvent())
+	assert.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("network.transport", "tcp"),
+		attribute.String("tcp.connection.end_reason", "fin"),
+		attribute.Int("tcp.connection.request_count", 2),
+		attribute.Int64("tcp.client.bytes", 1200),
+		attribute.Int64("tcp.server.bytes", 3400),
+		attribute.Int("tcp.client.packet_count", testRequestPacketCount),
+		attribute.Int("tcp.server.packet_count", testResponsePacketCount),
+		attribute.Float64("tcp.handshake.syn_to_syn_ack_ms", 1.5),
+		attribute.Float64("tcp.handshake.syn_ack_to_ack_ms", 0.5),
+		attribute.Float64("tcp.handshake.rtt_ms", 2),
+		attribute.Int("tcp.retransmissions", 3),
+		attribute.Int("tcp.out_of_order", 0),
+		attribute.Int("tcp.duplicate_acks", 0),
+		attribute.Int("tcp.zero_windows", 0),
+		attribute.Int("tcp.rtt.sample_count", 2),
+		attribute.Float64("tcp.rtt.min_ms", 1),
+		attribute.Float64("tcp.rtt.mean_ms", 2),
+		attribute.Float64("tcp.rtt.max_ms", 3),
+	}, attrs)
+
+	// connections opened before the agent started don't have a handshake
+	attrs = handler.resolveConnectionAttributes(testEvent{synToSynAck: -1, synAckToAck: -1, endReason: "rst"}.connectionEvent())
+	assert.Contains(t, attrs, attribute.String("error", "TCP connection reset"))
+	assert.NotContains(t, attrs, attribute.Float64("tcp.handshake.rtt_ms", -1))
+	assert.Len(t, attrs, 16)
+}
+
+func TestResolveTlsAttributes(t *testing.T) {
+	handler := &otelHandler{}
+
+	attrs := handler.resolveTlsAttributes(testEvent{tlsVersion: 0x0304, handshakeDuration: 2500 * time.Microsecond}.tlsEvent())
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("network.transport", "tcp"),
+		attribute.String("tls.protocol.name", "tls"),
+		attribute.String("tls.client.offered_version", "1.3"),
+		attribute.Bool("tls.established", true),
+		attribute.String("tls.client.server_name", "api.example.com"),
+		attribute.StringSlice("tls.client.offered_alpn", []string{"h2", "http/1.1"}),
+		attribute.String("tls.protocol.version", "1.3"),
+		attribute.String("tls.cipher", "TLS_AES_128_GCM_SHA256"),
+		attribute.String("tls.next_protocol", "h2"),
+		attribute.String("tls.server.not_after", "2030-01-02T03:04:05Z"),
+		attribute.Float64("tls.handshake_duration_ms", 2.5),
+	}, attrs)
+
+	// the server rejected the ClientHello
+	attrs = handler.resolveTlsAttributes(testEvent{handshakeDuration: -1, tlsAlerts: []uint8{40}}.tlsEvent())
+	assert.Contains(t, attrs, attribute.Bool("tls.established", false))
+	assert.Contains(t, attrs, attribute.StringSlice("tls.alerts", []string{"handshake_failure"}))
+	assert.Contains(t, attrs, attribute.String("error", "TLS handshake failed"))
+	assert.NotContains(t, attrs, attribute.String("tls.cipher", ""))
+}
+
+func TestResolveConnectionFailureAttributes(t *testing.T) {
+	handler := &otelHandler{}
+
+	attrs := handler.resolveConnectionFailureAttributes(testEvent{
+		failureReason: "connection_refused",
+		resetBy:       "server",
+	}.connectionFailureEvent())
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("network.transport", "tcp"),
+		attribute.Int("server.port", 8080),
+		attribute.String("tcp.failure.reason", "connection_refused"),
+		attribute.String("error", "TCP connection refused"),
+		attribute.String("tcp.reset_by", "server"),
+	}, attrs)
+
+	attrs = handler.resolveConnectionFailureAttributes(testEvent{failureReason: "syn_timeout"}.connectionFailureEvent())
+	assert.Contains(t, attrs, attribute.String("error", "TCP SYN timeout"))
+	assert.NotContains(t, attrs, attribute.String("tcp.reset_by", ""))
 }
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+
+	"github.com/honeycombio/honeycomb-network-agent/assemblers"
+	"github.com/honeycombio/honeycomb-network-agent/config"
+	"github.com/honeycombio/honeycomb-network-agent/utils"
+)
+
+// prometheusOverflowLabel replaces all label values once the maximum number of series is reached
+const prometheusOverflowLabel = "overflow"
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "hny_network_agent",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests between services, by the source workload, destination service and response status class.",
+	}, []string{"source", "destination", "status_class"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "hny_network_agent",
+		Name:      "http_request_duration_seconds",
+		Help:      "Time from the start of HTTP requests between services to the end of their responses.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"source", "destination"})
+
+	sendErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "hny_network_agent",
+		Name:      "handler_send_errors_total",
+		Help:      "Errors sending events or spans, by event handler.",
+	}, []string{"handler"})
+
+	droppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "hny_network_agent",
+		Name:      "handler_events_dropped_total",
+		Help:      "Events dropped because an event handler's queue was full, by event handler.",
+	}, []string{"handler"})
+)
+
+// RegisterMetrics registers the Prometheus metrics recorded by the event handlers
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	return errors.Join(
+		registerer.Register(httpRequests),
+		registerer.Register(httpRequestDuration),
+		registerer.Register(sendErrors),
+		registerer.Register(droppedEvents),
+	)
+}
+
+// countLibhoneySendErrors counts the libhoney responses that failed until the responses channel is closed
+func countLibhoneySendErrors(responses chan transmission.Response) {
+	for response := range responses {
+		if response.Err != nil || response.StatusCode/100 != 2 {
+			sendErrors.WithLabelValues("libhoney").Inc()
+		}
+	}
+}
+
+// prometheusEventHandler is an event handler that records Prometheus metrics for the HTTP requests between services.
+// It's added alongside the configured handlers when Prometheus metrics are enabled.
+type prometheusEventHandler struct {
+	config     config.Config
+	k8sClient  *utils.CachedK8sClient
+	eventsChan chan assemblers.Event
+	series     *seriesLimiter[[3]string]
+}
+
+var _ EventHandler = (*prometheusEventHandler)(nil)
+
+// newPrometheusEventHandler creates a new event handler that records Prometheus metrics for events
+func newPrometheusEventHandler(config config.Config, k8sClient *utils.CachedK8sClient, eventsChan chan assemblers.Event) *prometheusEventHandler {
+	return &prometheusEventHandler{
+		config:     config,
+		k8sClient:  k8sClient,
+		eventsChan: eventsChan,
+		series:     newSeriesLimiter[[3]string](config.PrometheusMaxSeries),
+	}
+}
+
+// Start starts the event handler and begins handling events from the events channel
+// When the context is cancelled or the events channel is closed, the event handler will stop handling events
+func (handler *prometheusEventHandler) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-handler.eventsChan:
+			if !ok {
+				return
+			}
+			handler.handleEvent(event)
+			releaseEvent(event)
+		}
+	}
+}
+
+// Close does nothing, as metrics are read when they're scraped
+func (handler *prometheusEventHandler) Close() {}
+
+// handleEvent records the request and its duration if the event is an HTTP request
+func (handler *prometheusEventHandler) handleEvent(event assemblers.Event) {
+	httpEvent, ok := event.(*assemblers.HttpEvent)
+	if !ok || httpEvent.Request() == nil {
+		return
+	}
+	statusClass := "none"
+	if httpEvent.Response() != nil {
+		statusClass = fmt.Sprintf("%dxx", httpEvent.Response().StatusCode/100)
+	}
+	labels := [3]string{
+		workloadName(handler.k8sClient, handler.config.AgentPodIP, event.SrcIp()),
+		serviceName(handler.k8sClient, handler.config.AgentPodIP, event.DstIp()),
+		statusClass,
+	}
+	if !handler.series.allow(labels) {
+		log.Debug().Strs("labels", labels[:]).Msg("Too many HTTP request series, recording as overflow")
+		labels = [3]string{prometheusOverflowLabel, prometheusOverflowLabel, prometheusOverflowLabel}
+	}
+
+	httpRequests.WithLabelValues(labels[:]...).Inc()
+	if httpEvent.Response() != nil {
+		duration := event.ResponseLastByteTimestamp().Sub(event.RequestTimestamp())
+		httpRequestDuration.WithLabelValues(labels[0], labels[1]).Observe(duration.Seconds())
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/honeycombio/honeycomb-network-agent/assemblers"
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+func Test_prometheusEventHandler_recordsHttpRequests(t *testing.T) {
+	handler := newPrometheusEventHandler(config.Config{PrometheusMaxSeries: 1}, nil, nil)
+	requestsBefore := testutil.ToFloat64(httpRequests.WithLabelValues("1.2.3.4", "5.6.7.8", "4xx"))
+	overflowBefore := testutil.ToFloat64(httpRequests.WithLabelValues("overflow", "overflow", "overflow"))
+
+	requestTimestamp := time.Now()
+	for i := 0; i < 2; i++ {
+		handler.handleEvent(testEvent{
+			requestTimestamp:          requestTimestamp,
+			responseTimestamp:         requestTimestamp.Add(time.Millisecond),
+			responseLastByteTimestamp: requestTimestamp.Add(5 * time.Millisecond),
+		}.httpEvent())
+	}
+	// a request without a response is a new series, which there isn't room for
+	handler.handleEvent(assemblers.NewHttpEvent("c->s:1->2", 0, "1.2.3.4", "5.6.7.8",
+		&http.Request{Method: "GET", RequestURI: "/check"}, assemblers.HttpMessageInfo{MessageInfo: assemblers.MessageInfo{Timestamp: requestTimestamp, PacketCount: 1}},
+		nil, assemblers.HttpMessageInfo{}))
+	// other events aren't HTTP requests
+	handler.handleEvent(testEvent{requestTimestamp: requestTimestamp, responseTimestamp: requestTimestamp}.dnsEvent())
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(httpRequests.WithLabelValues("1.2.3.4", "5.6.7.8", "4xx"))-requestsBefore)
+	assert.Equal(t, float64(1), testutil.ToFloat64(httpRequests.WithLabelValues("overflow", "overflow", "overflow"))-overflowBefore)
+}
+
+func Test_countLibhoneySendErrors(t *testing.T) {
+	errorsBefore := testutil.ToFloat64(sendErrors.WithLabelValues("libhoney"))
+	responses := make(chan transmission.Response, 3)
+	responses <- transmission.Response{StatusCode: http.StatusAccepted}
+	responses <- transmission.Response{StatusCode: http.StatusUnauthorized}
+	responses <- transmission.Response{Err: errors.New("connection refused")}
+	close(responses)
+
+	countLibhoneySendErrors(responses)
+	assert.Equal(t, float64(2), testutil.ToFloat64(sendErrors.WithLabelValues("libhoney"))-errorsBefore)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+
+	"github.com/honeycombio/honeycomb-network-agent/assemblers"
+	"github.com/honeycombio/honeycomb-network-agent/utils"
+)
+
+// redOverflowAttributes are recorded instead of a request's attributes once the maximum number of series is reached
+var redOverflowAttributes = attribute.NewSet(attribute.Bool("otel.metric.overflow", true))
+
+// redMetrics records the rate, errors and duration (RED) of HTTP requests as OpenTelemetry metrics,
+// grouped by source workload, destination service, method, route and status class.
+//
+// The number of distinct attribute sets is bounded so a flood of new routes or workloads can't use unbounded memory
+// in the agent or the metrics backend. Once the maximum is reached, requests with new attribute sets are recorded
+// in a single overflow series.
+type redMetrics struct {
+	k8sClient *utils.CachedK8sClient
+	agentIP   string
+	routes    *routeTemplater
+	requests  metric.Int64Counter
+	errors    metric.Int64Counter
+	duration  metric.Float64Histogram
+	series    *seriesLimiter[attribute.Distinct]
+}
+
+// newRedMetrics creates the RED metric instruments with the meter
+func newRedMetrics(meter metric.Meter, k8sClient *utils.CachedK8sClient, agentIP string, routes *routeTemplater, maxSeries int) (*redMetrics, error) {
+	requests, err := meter.Int64Counter("network.requests",
+		metric.WithDescription("The number of HTTP requests"),
+		metric.WithUnit("{request}"))
+	if err != nil {
+		return nil, err
+	}
+	errors, err := meter.Int64Counter("network.request.errors",
+		metric.WithDescription("The number of HTTP requests with a 4xx or 5xx response"),
+		metric.WithUnit("{request}"))
+	if err != nil {
+		return nil, err
+	}
+	duration, err := meter.Float64Histogram("network.request.duration",
+		metric.WithDescription("The time from the start of an HTTP request to the end of its response"),
+		metric.WithUnit("ms"))
+	if err != nil {
+		return nil, err
+	}
+	return &redMetrics{
+		k8sClient: k8sClient,
+		agentIP:   agentIP,
+		routes:    routes,
+		requests:  requests,
+		errors:    errors,
+		duration:  duration,
+		series:    newSeriesLimiter[attribute.Distinct](maxSeries),
+	}, nil
+}
+
+// record records the HTTP event's request, and whether it was an error and its duration if it had a response
+func (m *redMetrics) record(event *assemblers.HttpEvent) {
+	if m == nil || event.Request() == nil {
+		return
+	}
+	attributes := m.bound(m.attributes(event))
+	options := metric.WithAttributeSet(attributes)
+
+	ctx := context.Background()
+	m.requests.Add(ctx, 1, options)
+	if event.Response() == nil {
+		return
+	}
+	if event.Response().StatusCode >= 400 {
+		m.errors.Add(ctx, 1, options)
+	}
+	duration := event.ResponseLastByteTimestamp().Sub(event.RequestTimestamp())
+	m.duration.Record(ctx, float64(duration.Microseconds())/1000, options)
+}
+
+// attributes returns the attributes the HTTP event's request is recorded with
+func (m *redMetrics) attributes(event *assemblers.HttpEvent) attribute.Set {
+	attrs := []attribute.KeyValue{
+		attribute.String("source.workload", workloadName(m.k8sClient, m.agentIP, event.SrcIp())),
+		attribute.String("destination.service", serviceName(m.k8sClient, m.agentIP, event.DstIp())),
+		semconv.HTTPRequestMethodKey.String(event.Request().Method),
+	}
+	if url, err := url.ParseRequestURI(event.Request().RequestURI); err == nil {
+		attrs = append(attrs, semconv.HTTPRoute(m.routes.route(url.Path)))
+	}
+	if event.Response() != nil {
+		attrs = append(attrs, attribute.String("http.response.status_class", fmt.Sprintf("%dxx", event.Response().StatusCode/100)))
+	}
+	return attribute.NewSet(attrs...)
+}
+
+// bound returns the attributes if they're already recorded or there's room for another series,
+// or the overflow attributes if there isn't
+func (m *redMetrics) bound(attributes attribute.Set) attribute.Set {
+	if !m.series.allow(attributes.Equivalent()) {
+		return redOverflowAttributes
+	}
+	return attributes
+}
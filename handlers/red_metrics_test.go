@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/honeycombio/honeycomb-network-agent/utils"
+)
+
+func TestRedMetricsRecordsRequests(t *testing.T) {
+	isController := true
+	srcPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "checkout-7d9f8-x2k4p",
+			Namespace:       "unit-tests",
+			Labels:          map[string]string{"pod-template-hash": "7d9f8"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "checkout-7d9f8", Controller: &isController}},
+		},
+		Status: v1.PodStatus{PodIP: "1.2.3.4"},
+	}
+	destService := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "teapot", Namespace: "unit-tests"},
+		Spec:       v1.ServiceSpec{ClusterIP: "5.6.7.8"},
+	}
+	k8sClient := utils.NewCachedK8sClient(fake.NewSimpleClientset(srcPod, destService))
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	k8sClient.Start(ctx)
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	metrics, err := newRedMetrics(provider.Meter("test"), k8sClient, "", nil, 10)
+	require.NoError(t, err)
+
+	requestTimestamp := time.Now()
+	for _, duration := range []time.Duration{5 * time.Millisecond, 15 * time.Millisecond} {
+		metrics.record(testEvent{
+			requestTimestamp:          requestTimestamp,
+			responseTimestamp:         requestTimestamp.Add(time.Millisecond),
+			responseLastByteTimestamp: requestTimestamp.Add(duration),
+		}.httpEvent())
+	}
+
+	var collected metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &collected))
+	require.Len(t, collected.ScopeMetrics, 1)
+	recorded := map[string]metricdata.Aggregation{}
+	for _, m := range collected.ScopeMetrics[0].Metrics {
+		recorded[m.Name] = m.Data
+	}
+
+	expectedAttributes := attribute.NewSet(
+		attribute.String("source.workload", "checkout"),
+		attribute.String("destination.service", "teapot"),
+		attribute.String("http.request.method", "GET"),
+		attribute.String("http.route", "/check"),
+		attribute.String("http.response.status_class", "4xx"),
+	)
+	for _, name := range []string{"network.requests", "network.request.errors"} {
+		sum := recorded[name].(metricdata.Sum[int64])
+		require.Len(t, sum.DataPoints, 1, name)
+		assert.Equal(t, int64(2), sum.DataPoints[0].Value, name)
+		assert.Equal(t, expectedAttributes, sum.DataPoints[0].Attributes, name)
+	}
+	histogram := recorded["network.request.duration"].(metricdata.Histogram[float64])
+	require.Len(t, histogram.DataPoints, 1)
+	assert.Equal(t, uint64(2), histogram.DataPoints[0].Count)
+	assert.Equal(t, float64(20), histogram.DataPoints[0].Sum)
+	assert.Equal(t, expectedAttributes, histogram.DataPoints[0].Attributes)
+}
+
+func TestRedMetricsBoundsSeries(t *testing.T) {
+	metrics := &redMetrics{series: newSeriesLimiter[attribute.Distinct](2)}
+	first := attribute.NewSet(attribute.String("http.route", "/users/{id}"))
+	second := attribute.NewSet(attribute.String("http.route", "/orders/{id}"))
+	third := attribute.NewSet(attribute.String("http.route", "/carts/{id}"))
+
+	assert.Equal(t, first, metrics.bound(first))
+	assert.Equal(t, second, metrics.bound(second))
+	assert.Equal(t, redOverflowAttributes, metrics.bound(third))
+	// series that are already recorded keep their attributes
+	assert.Equal(t, first, metrics.bound(first))
+}
+
+func TestPodWorkloadName(t *testing.T) {
+	isController := true
+	testCases := []struct {
+		name     string
+		pod      *v1.Pod
+		expected string
+	}{
+		{
+			name: "deployment",
+			pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "checkout-7d9f8-x2k4p",
+				Labels:          map[string]string{"pod-template-hash": "7d9f8"},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "checkout-7d9f8", Controller: &isController}},
+			}},
+			expected: "checkout",
+		},
+		{
+			name: "stateful set",
+			pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "postgres-0",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet", Name: "postgres", Controller: &isController}},
+			}},
+			expected: "postgres",
+		},
+		{
+			name:     "no controller",
+			pod:      &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "debug"}},
+			expected: "debug",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, podWorkloadName(tc.pod))
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// rotatingFileWriter appends to a file, rotating it once it reaches a maximum size.
+//
+// Rotated files are renamed with a numbered suffix, eg events.jsonl.1 is the most recent,
+// and only the configured number of them are kept.
+type rotatingFileWriter struct {
+	path string
+	// the size in bytes a file can reach before it's rotated, 0 to never rotate
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	mutex      sync.Mutex
+}
+
+// newRotatingFileWriter opens the file at the given path for appending, creating it if needed
+func newRotatingFileWriter(path string, maxSize int64, maxBackups int) (*rotatingFileWriter, error) {
+	writer := &rotatingFileWriter{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := writer.open(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (writer *rotatingFileWriter) open() error {
+	file, err := os.OpenFile(writer.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	writer.file = file
+	writer.size = info.Size()
+	return nil
+}
+
+// Write writes the data to the file, rotating it first if the data would take it over the maximum size.
+// Data is never split across files, so a file only grows past the maximum size if a single write is bigger.
+func (writer *rotatingFileWriter) Write(data []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.file == nil {
+		return 0, fs.ErrClosed
+	}
+	if writer.maxSize > 0 && writer.size > 0 && writer.size+int64(len(data)) > writer.maxSize {
+		if err := writer.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := writer.file.Write(data)
+	writer.size += int64(n)
+	return n, err
+}
+
+// rotate closes the current file, shifts the existing backups along and opens a new file
+func (writer *rotatingFileWriter) rotate() error {
+	if err := writer.file.Close(); err != nil {
+		return err
+	}
+	writer.file = nil
+	if writer.maxBackups > 0 {
+		// the oldest backup is overwritten by the rename
+		for i := writer.maxBackups - 1; i > 0; i-- {
+			if err := os.Rename(writer.backupPath(i), writer.backupPath(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.Rename(writer.path, writer.backupPath(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(writer.path); err != nil {
+		return err
+	}
+	return writer.open()
+}
+
+func (writer *rotatingFileWriter) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", writer.path, n)
+}
+
+// Close closes the current file
+func (writer *rotatingFileWriter) Close() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.file == nil {
+		return nil
+	}
+	err := writer.file.Close()
+	writer.file = nil
+	return err
+}
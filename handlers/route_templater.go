@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+)
+
+// routePattern replaces path segments that match a regular expression with a placeholder, eg "{id}"
+type routePattern struct {
+	placeholder string
+	regexp      *regexp.Regexp
+}
+
+// builtinRoutePatterns replace the kinds of path segments that are almost always identifiers.
+// Numeric IDs are checked before hashes so that long numbers aren't mistaken for hex.
+var builtinRoutePatterns = []routePattern{
+	{"{uuid}", regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)},
+	{"{id}", regexp.MustCompile(`^[0-9]+$`)},
+	{"{hash}", regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)},
+}
+
+// routeTemplater collapses URL paths into low cardinality routes, eg "/users/8241/orders/99" becomes
+// "/users/{id}/orders/{id}", so requests to the same endpoint can be grouped together.
+//
+// Paths are matched against the routes in an OpenAPI spec first if one is given. Otherwise, each path segment
+// is replaced by the placeholder of the first configured pattern it matches, then the built-in patterns.
+// A nil routeTemplater only uses the built-in patterns.
+type routeTemplater struct {
+	// routes from the OpenAPI spec split into segments, ordered so that more specific routes are matched first
+	openAPIRoutes [][]string
+	patterns      []routePattern
+}
+
+// newRouteTemplaterFromConfig returns a routeTemplater using the configured patterns and OpenAPI spec,
+// exiting if they can't be loaded
+func newRouteTemplaterFromConfig(config config.Config) *routeTemplater {
+	templater, err := newRouteTemplater(config.HTTPRoutePatterns, config.HTTPRouteOpenAPISpec)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to setup HTTP route templating")
+	}
+	return templater
+}
+
+// newRouteTemplater returns a routeTemplater using patterns in the form "placeholder=regexp",
+// eg "locale=[a-z]{2}-[a-z]{2}", and the OpenAPI spec at the given path if it isn't empty
+func newRouteTemplater(patterns []string, openAPISpecPath string) (*routeTemplater, error) {
+	templater := &routeTemplater{}
+	for _, pattern := range patterns {
+		placeholder, expression, found := strings.Cut(pattern, "=")
+		if !found || placeholder == "" {
+			return nil, fmt.Errorf("invalid route pattern %q, expected placeholder=regexp", pattern)
+		}
+		// patterns match whole segments
+		compiled, err := regexp.Compile("^(?:" + expression + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid route pattern %q: %w", pattern, err)
+		}
+		templater.patterns = append(templater.patterns, routePattern{"{" + placeholder + "}", compiled})
+	}
+	if openAPISpecPath != "" {
+		routes, err := loadOpenAPIRoutes(openAPISpecPath)
+		if err != nil {
+			return nil, err
+		}
+		templater.openAPIRoutes = routes
+		log.Info().Str("path", openAPISpecPath).Int("routes", len(routes)).Msg("Loaded HTTP routes from OpenAPI spec")
+	}
+	return templater, nil
+}
+
+// route returns the route for the URL path
+func (templater *routeTemplater) route(path string) string {
+	segments := strings.Split(path, "/")
+	if templater != nil {
+		for _, route := range templater.openAPIRoutes {
+			if matchesOpenAPIRoute(route, segments) {
+				return strings.Join(route, "/")
+			}
+		}
+	}
+	templated := make([]string, len(segments))
+	for i, segment := range segments {
+		templated[i] = templater.templateSegment(segment)
+	}
+	return strings.Join(templated, "/")
+}
+
+// templateSegment returns the placeholder of the first pattern that matches the path segment,
+// or the segment if none match
+func (templater *routeTemplater) templateSegment(segment string) string {
+	if segment == "" {
+		return segment
+	}
+	if templater != nil {
+		for _, pattern := range templater.patterns {
+			if pattern.regexp.MatchString(segment) {
+				return pattern.placeholder
+			}
+		}
+	}
+	for _, pattern := range builtinRoutePatterns {
+		if pattern.regexp.MatchString(segment) {
+			return pattern.placeholder
+		}
+	}
+	return segment
+}
+
+// openAPISpec holds the parts of an OpenAPI 3 or Swagger 2 spec that describe the routes it serves
+type openAPISpec struct {
+	Paths map[string]any `json:"paths"`
+	// Swagger 2 routes are relative to the base path
+	BasePath string `json:"basePath"`
+	// OpenAPI 3 routes are relative to the path of each server's URL
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+}
+
+// loadOpenAPIRoutes reads the routes from the OpenAPI spec in the JSON or YAML file at the given path
+func loadOpenAPIRoutes(path string) ([][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenAPI spec: %w", err)
+	}
+	var spec openAPISpec
+	// YAML is a superset of JSON, so this reads either
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI spec: %w", err)
+	}
+
+	basePaths := []string{spec.BasePath}
+	for _, server := range spec.Servers {
+		// server URLs can be relative, and can have variables which we can't resolve
+		if serverURL, err := url.Parse(server.URL); err == nil && !strings.Contains(server.URL, "{") {
+			basePaths = append(basePaths, serverURL.Path)
+		}
+	}
+	var routes [][]string
+	for _, basePath := range basePaths {
+		basePath = strings.TrimSuffix(basePath, "/")
+		for route := range spec.Paths {
+			segments := strings.Split(basePath+route, "/")
+			if !slices.ContainsFunc(routes, func(existing []string) bool { return slices.Equal(existing, segments) }) {
+				routes = append(routes, segments)
+			}
+		}
+	}
+	slices.SortFunc(routes, compareOpenAPIRoutes)
+	return routes, nil
+}
+
+// compareOpenAPIRoutes orders routes so that literal segments are matched before parameters,
+// eg "/users/me" is matched before "/users/{userId}" as the spec requires
+func compareOpenAPIRoutes(a []string, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		aParam, bParam := isOpenAPIParameter(a[i]), isOpenAPIParameter(b[i])
+		if aParam != bParam {
+			if aParam {
+				return 1
+			}
+			return -1
+		}
+		if a[i] != b[i] {
+			return strings.Compare(a[i], b[i])
+		}
+	}
+	return len(a) - len(b)
+}
+
+// matchesOpenAPIRoute returns true if the path segments match the route's segments,
+// where a parameter matches any non-empty segment
+func matchesOpenAPIRoute(route []string, segments []string) bool {
+	if len(route) != len(segments) {
+		return false
+	}
+	for i, segment := range route {
+		if isOpenAPIParameter(segment) {
+			if segments[i] == "" {
+				return false
+			}
+		} else if segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isOpenAPIParameter(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
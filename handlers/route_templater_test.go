@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteTemplaterReplacesIdentifiers(t *testing.T) {
+	templater, err := newRouteTemplater([]string{"locale=[a-z]{2}-[a-z]{2}", "sku=SKU-[0-9]+"}, "")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/", "/"},
+		{"/users/8241/orders/99", "/users/{id}/orders/{id}"},
+		{"/sessions/0b7c6a4e-3f1d-4c2b-9a8e-5d6f7a8b9c0d", "/sessions/{uuid}"},
+		{"/blobs/9e107d9d372bb6826bd81d3542a419d6/", "/blobs/{hash}/"},
+		{"/en-gb/products/SKU-1234", "/{locale}/products/{sku}"},
+		// short hex words and numbers inside other words aren't IDs
+		{"/v2/cafe/beef42", "/v2/cafe/beef42"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, templater.route(tc.path), tc.path)
+	}
+
+	// handlers created without a templater still replace the built-in patterns
+	var builtin *routeTemplater
+	assert.Equal(t, "/users/{id}", builtin.route("/users/42"))
+	assert.Equal(t, "/en-gb", builtin.route("/en-gb"))
+}
+
+func TestRouteTemplaterRejectsInvalidPatterns(t *testing.T) {
+	_, err := newRouteTemplater([]string{"[a-z]+"}, "")
+	assert.Error(t, err)
+
+	_, err = newRouteTemplater([]string{"name=[a-z"}, "")
+	assert.Error(t, err)
+
+	_, err = newRouteTemplater(nil, filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestRouteTemplaterMatchesOpenAPIRoutes(t *testing.T) {
+	testCases := []struct {
+		name string
+		file string
+		spec string
+	}{
+		{
+			name: "OpenAPI 3 YAML",
+			file: "openapi.yaml",
+			spec: `
+openapi: 3.0.0
+servers:
+  - url: https://api.example.com/api
+paths:
+  /users/{userId}:
+    get: {}
+  /users/me:
+    get: {}
+  /users/{userId}/orders/{orderId}:
+    get: {}
+`,
+		},
+		{
+			name: "Swagger 2 JSON",
+			file: "swagger.json",
+			spec: `{
+				"swagger": "2.0",
+				"basePath": "/api",
+				"paths": {
+					"/users/{userId}": {"get": {}},
+					"/users/me": {"get": {}},
+					"/users/{userId}/orders/{orderId}": {"get": {}}
+				}
+			}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.file)
+			require.NoError(t, os.WriteFile(path, []byte(tc.spec), 0o600))
+			templater, err := newRouteTemplater(nil, path)
+			require.NoError(t, err)
+
+			assert.Equal(t, "/api/users/{userId}", templater.route("/api/users/alice"))
+			assert.Equal(t, "/api/users/me", templater.route("/api/users/me"))
+			assert.Equal(t, "/api/users/{userId}/orders/{orderId}", templater.route("/api/users/alice/orders/X-17"))
+			// paths that aren't in the spec fall back to the patterns
+			assert.Equal(t, "/api/accounts/{id}", templater.route("/api/accounts/17"))
+			assert.Equal(t, "/api/users/", templater.route("/api/users/"))
+		})
+	}
+}
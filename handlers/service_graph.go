@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/libhoney-go"
+	"github.com/rs/zerolog/log"
+
+	"github.com/honeycombio/honeycomb-network-agent/assemblers"
+	"github.com/honeycombio/honeycomb-network-agent/config"
+	"github.com/honeycombio/honeycomb-network-agent/utils"
+)
+
+// serviceGraphOverflow replaces an edge's client and server once the maximum number of edges is reached
+const serviceGraphOverflow = "overflow"
+
+// latencySketchGamma is the ratio between the bounds of a latency sketch's buckets,
+// so quantiles are accurate to within 1%
+const latencySketchGamma = 1.02
+
+// ServiceGraph aggregates the calls between services seen in events into a service dependency graph.
+//
+// Each edge is a client workload calling a server service over a protocol, eg "checkout" calling "postgres"
+// over "postgresql", with the number of calls, how many of them failed, and their p50 and p99 latency.
+// Edges are aggregated over a window of ServiceGraphInterval, after which they're sent as one event per edge
+// and a new window starts.
+type ServiceGraph struct {
+	config    config.Config
+	k8sClient *utils.CachedK8sClient
+	// the client edges are sent with, nil if they're only served by the debug service
+	client *libhoney.Client
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	edges       map[serviceGraphEdgeKey]*serviceGraphEdge
+	// the last complete window, served by the debug service once there is one
+	previous *ServiceGraphSnapshot
+}
+
+// serviceGraphEdgeKey identifies an edge in the service graph
+type serviceGraphEdgeKey struct {
+	client   string
+	server   string
+	protocol string
+}
+
+// serviceGraphEdge is the calls made along an edge during the current window
+type serviceGraphEdge struct {
+	calls   int64
+	errors  int64
+	latency latencySketch
+}
+
+// ServiceGraphSnapshot is the service graph's edges over a window
+type ServiceGraphSnapshot struct {
+	WindowStart time.Time              `json:"window_start"`
+	WindowEnd   time.Time              `json:"window_end"`
+	Edges       []ServiceGraphEdgeStat `json:"edges"`
+}
+
+// ServiceGraphEdgeStat is the calls made along an edge over a window
+type ServiceGraphEdgeStat struct {
+	Client   string `json:"client"`
+	Server   string `json:"server"`
+	Protocol string `json:"protocol"`
+	Calls    int64  `json:"calls"`
+	Errors   int64  `json:"errors"`
+	// latencies are left out if none of the calls had a response
+	P50Ms *float64 `json:"p50_ms,omitempty"`
+	P99Ms *float64 `json:"p99_ms,omitempty"`
+}
+
+// NewServiceGraph creates a service graph that's sent to Honeycomb if an API key is configured
+func NewServiceGraph(config config.Config, k8sClient *utils.CachedK8sClient) *ServiceGraph {
+	graph := &ServiceGraph{
+		config:      config,
+		k8sClient:   k8sClient,
+		windowStart: time.Now(),
+		edges:       map[serviceGraphEdgeKey]*serviceGraphEdge{},
+	}
+	if config.APIKey == "" {
+		log.Info().Msg("No API key, the service graph is only served by the debug service")
+		return graph
+	}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:  config.APIKey,
+		Dataset: config.ServiceGraphDataset,
+		APIHost: config.Endpoint,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create service graph client")
+	}
+	graph.client = client
+	return graph
+}
+
+// record adds the call in the event to its edge.
+// Events that aren't a call between services, like TCP connections and TLS handshakes, are ignored.
+func (graph *ServiceGraph) record(event assemblers.Event) {
+	protocol, failed, ok := serviceGraphCall(event)
+	if !ok {
+		return
+	}
+	key := serviceGraphEdgeKey{
+		client:   workloadName(graph.k8sClient, graph.config.AgentPodIP, event.SrcIp()),
+		server:   serviceName(graph.k8sClient, graph.config.AgentPodIP, event.DstIp()),
+		protocol: protocol,
+	}
+
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+	edge, found := graph.edges[key]
+	if !found {
+		if len(graph.edges) >= graph.config.ServiceGraphMaxEdges {
+			log.Debug().Str("client", key.client).Str("server", key.server).Msg("Too many service graph edges, recording as overflow")
+			key = serviceGraphEdgeKey{client: serviceGraphOverflow, server: serviceGraphOverflow, protocol: protocol}
+			edge = graph.edges[key]
+		}
+		if edge == nil {
+			edge = &serviceGraphEdge{latency: latencySketch{buckets: map[int]int64{}}}
+			graph.edges[key] = edge
+		}
+	}
+	edge.calls++
+	if failed {
+		edge.errors++
+	}
+	if !event.RequestTimestamp().IsZero() && !event.ResponseTimestamp().IsZero() {
+		duration := event.ResponseLastByteTimestamp().Sub(event.RequestTimestamp())
+		edge.latency.add(float64(duration.Microseconds()) / 1000)
+	}
+}
+
+// serviceGraphCall returns the protocol of the call in the event and whether it failed,
+// using the same conditions as the error field on libhoney events.
+// Returns false if the event isn't a call between services.
+func serviceGraphCall(event assemblers.Event) (protocol string, failed bool, ok bool) {
+	switch event := event.(type) {
+	case *assemblers.HttpEvent:
+		if event.Request() == nil {
+			return "", false, false
+		}
+		return "http", event.Response() == nil || event.Response().StatusCode >= 400, true
+	case *assemblers.GrpcEvent:
+		return "grpc", event.StatusCode() > 0, true
+	case *assemblers.RedisEvent:
+		return "redis", event.ErrorMessage() != "", true
+	case *assemblers.SqlEvent:
+		return event.DbSystem(), event.ErrorCode() != "" || event.ErrorMessage() != "", true
+	case *assemblers.KafkaEvent:
+		return "kafka", len(event.ErrorCodes()) > 0, true
+	case *assemblers.DnsEvent:
+		return "dns", event.TimedOut() || event.ResponseCode() != "NOERROR", true
+	default:
+		return "", false, false
+	}
+}
+
+// snapshot returns the edges in the current window, sorted by client, server and protocol
+func (graph *ServiceGraph) snapshot(windowEnd time.Time) *ServiceGraphSnapshot {
+	snapshot := &ServiceGraphSnapshot{
+		WindowStart: graph.windowStart,
+		WindowEnd:   windowEnd,
+		Edges:       make([]ServiceGraphEdgeStat, 0, len(graph.edges)),
+	}
+	for key, edge := range graph.edges {
+		stat := ServiceGraphEdgeStat{
+			Client:   key.client,
+			Server:   key.server,
+			Protocol: key.protocol,
+			Calls:    edge.calls,
+			Errors:   edge.errors,
+		}
+		if p50, ok := edge.latency.quantile(0.5); ok {
+			stat.P50Ms = &p50
+		}
+		if p99, ok := edge.latency.quantile(0.99); ok {
+			stat.P99Ms = &p99
+		}
+		snapshot.Edges = append(snapshot.Edges, stat)
+	}
+	slices.SortFunc(snapshot.Edges, func(a, b ServiceGraphEdgeStat) int {
+		if a.Client != b.Client {
+			return cmp.Compare(a.Client, b.Client)
+		}
+		if a.Server != b.Server {
+			return cmp.Compare(a.Server, b.Server)
+		}
+		return cmp.Compare(a.Protocol, b.Protocol)
+	})
+	return snapshot
+}
+
+// Current returns the last complete window of the service graph, or the current window if none has completed yet
+func (graph *ServiceGraph) Current() *ServiceGraphSnapshot {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+	if graph.previous != nil {
+		return graph.previous
+	}
+	return graph.snapshot(time.Now())
+}
+
+// flush ends the current window, sending its edges, and starts a new one
+func (graph *ServiceGraph) flush() {
+	graph.mutex.Lock()
+	now := time.Now()
+	snapshot := graph.snapshot(now)
+	graph.previous = snapshot
+	graph.windowStart = now
+	graph.edges = map[serviceGraphEdgeKey]*serviceGraphEdge{}
+	graph.mutex.Unlock()
+
+	if graph.client == nil {
+		return
+	}
+	for _, stat := range snapshot.Edges {
+		ev := graph.client.NewEvent()
+		ev.Timestamp = snapshot.WindowEnd
+		ev.AddField("name", "service_graph_edge")
+		ev.AddField("meta.service_graph.window_start", snapshot.WindowStart)
+		ev.AddField("meta.service_graph.window_duration_ms", snapshot.WindowEnd.Sub(snapshot.WindowStart).Milliseconds())
+		ev.AddField("client", stat.Client)
+		ev.AddField("server", stat.Server)
+		ev.AddField("protocol", stat.Protocol)
+		ev.AddField("calls", stat.Calls)
+		ev.AddField("errors", stat.Errors)
+		if stat.P50Ms != nil {
+			ev.AddField("latency.p50_ms", *stat.P50Ms)
+		}
+		if stat.P99Ms != nil {
+			ev.AddField("latency.p99_ms", *stat.P99Ms)
+		}
+		ev.Send()
+	}
+}
+
+// close sends the edges in the current window and flushes any pending events
+func (graph *ServiceGraph) close() {
+	graph.flush()
+	if graph.client != nil {
+		graph.client.Close()
+	}
+}
+
+// ServeJSON serves the service graph as JSON
+func (graph *ServiceGraph) ServeJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(graph.Current()); err != nil {
+		log.Debug().Err(err).Msg("Failed to write service graph")
+	}
+}
+
+// ServeDOT serves the service graph in the Graphviz DOT language, eg to render it with `dot -Tsvg`
+func (graph *ServiceGraph) ServeDOT(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/vnd.graphviz")
+	if _, err := w.Write([]byte(graph.Current().DOT())); err != nil {
+		log.Debug().Err(err).Msg("Failed to write service graph")
+	}
+}
+
+// DOT returns the snapshot in the Graphviz DOT language, with an arrow from each client to the servers it calls
+func (snapshot *ServiceGraphSnapshot) DOT() string {
+	var dot strings.Builder
+	dot.WriteString("digraph service_graph {\n")
+	for _, edge := range snapshot.Edges {
+		label := fmt.Sprintf("%s\n%d calls, %d errors", edge.Protocol, edge.Calls, edge.Errors)
+		if edge.P50Ms != nil && edge.P99Ms != nil {
+			label += fmt.Sprintf("\np50 %.1fms, p99 %.1fms", *edge.P50Ms, *edge.P99Ms)
+		}
+		fmt.Fprintf(&dot, "  %s -> %s [label=%s];\n", dotQuote(edge.Client), dotQuote(edge.Server), dotQuote(label))
+	}
+	dot.WriteString("}\n")
+	return dot.String()
+}
+
+// dotQuote returns the string as a quoted DOT ID, escaping quotes and newlines
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// latencySketch counts latencies in buckets whose bounds grow by latencySketchGamma,
+// so quantiles can be estimated within a fixed relative error using memory that grows with the range
+// of latencies rather than the number of calls
+type latencySketch struct {
+	// counts keyed by bucket index, where bucket i holds latencies in (gamma^(i-1), gamma^i]
+	buckets map[int]int64
+	// latencies too small to be bucketed, eg when the response was seen before the request finished
+	zeros int64
+	count int64
+}
+
+// minSketchLatencyMs is the smallest latency given its own bucket
+const minSketchLatencyMs = 0.001
+
+func (sketch *latencySketch) add(latencyMs float64) {
+	sketch.count++
+	if latencyMs < minSketchLatencyMs {
+		sketch.zeros++
+		return
+	}
+	sketch.buckets[int(math.Ceil(math.Log(latencyMs)/math.Log(latencySketchGamma)))]++
+}
+
+// quantile returns the estimated latency at the quantile, or false if there are no latencies
+func (sketch *latencySketch) quantile(q float64) (float64, bool) {
+	if sketch.count == 0 {
+		return 0, false
+	}
+	// the nearest rank of the latency at the quantile, counting from zero
+	rank := max(int64(math.Ceil(q*float64(sketch.count)))-1, 0)
+	if rank < sketch.zeros {
+		return 0, true
+	}
+	seen := sketch.zeros
+	indexes := make([]int, 0, len(sketch.buckets))
+	for index := range sketch.buckets {
+		indexes = append(indexes, index)
+	}
+	slices.Sort(indexes)
+	for _, index := range indexes {
+		seen += sketch.buckets[index]
+		if seen > rank {
+			// the middle of the bucket, which is within gamma-1 of any latency in it
+			return 2 * math.Pow(latencySketchGamma, float64(index)) / (latencySketchGamma + 1), true
+		}
+	}
+	return 0, false
+}
+
+// serviceGraphEventHandler is an event handler that records events in the service graph.
+// It's added alongside the configured handlers when the service graph is enabled, and sends the graph's edges
+// every interval.
+type serviceGraphEventHandler struct {
+	graph      *ServiceGraph
+	eventsChan chan assemblers.Event
+}
+
+var _ EventHandler = (*serviceGraphEventHandler)(nil)
+
+// newServiceGraphEventHandler creates a new event handler that records events in the service graph
+func newServiceGraphEventHandler(graph *ServiceGraph, eventsChan chan assemblers.Event) *serviceGraphEventHandler {
+	return &serviceGraphEventHandler{
+		graph:      graph,
+		eventsChan: eventsChan,
+	}
+}
+
+// Start starts the event handler and begins handling events from the events channel, sending the service graph
+// every interval. When the context is cancelled or the events channel is closed, the event handler will stop handling events
+func (handler *serviceGraphEventHandler) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(handler.graph.config.ServiceGraphInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			handler.graph.flush()
+		case event, ok := <-handler.eventsChan:
+			if !ok {
+				return
+			}
+			handler.handleEvent(event)
+			releaseEvent(event)
+		}
+	}
+}
+
+// Close sends the edges recorded since the service graph was last sent
+func (handler *serviceGraphEventHandler) Close() {
+	handler.graph.close()
+}
+
+func (handler *serviceGraphEventHandler) handleEvent(event assemblers.Event) {
+	handler.graph.record(event)
+}
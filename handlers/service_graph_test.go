@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/honeycombio/honeycomb-network-agent/config"
+	"github.com/honeycombio/honeycomb-network-agent/utils"
+)
+
+func TestServiceGraphRecordsEdges(t *testing.T) {
+	isController := true
+	srcPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "checkout-7d9f8-x2k4p",
+			Namespace:       "unit-tests",
+			Labels:          map[string]string{"pod-template-hash": "7d9f8"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "checkout-7d9f8", Controller: &isController}},
+		},
+		Status: v1.PodStatus{PodIP: "1.2.3.4"},
+	}
+	destService := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "teapot", Namespace: "unit-tests"},
+		Spec:       v1.ServiceSpec{ClusterIP: "5.6.7.8"},
+	}
+	k8sClient := utils.NewCachedK8sClient(fake.NewSimpleClientset(srcPod, destService))
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	k8sClient.Start(ctx)
+
+	graph := NewServiceGraph(config.Config{ServiceGraphMaxEdges: 10}, k8sClient)
+	now := time.Now()
+	for _, duration := range []time.Duration{5 * time.Millisecond, 15 * time.Millisecond} {
+		graph.record(testEvent{
+			requestTimestamp:          now,
+			responseTimestamp:         now.Add(time.Millisecond),
+			responseLastByteTimestamp: now.Add(duration),
+		}.httpEvent())
+	}
+	graph.record(testEvent{requestTimestamp: now, responseTimestamp: now.Add(2 * time.Millisecond)}.sqlEvent())
+	graph.record(testEvent{
+		requestTimestamp:  now,
+		responseTimestamp: now.Add(2 * time.Millisecond),
+		errorCode:         "23505",
+		errorMessage:      "duplicate key",
+	}.sqlEvent())
+	graph.record(testEvent{
+		requestTimestamp:  now,
+		responseTimestamp: now.Add(time.Second),
+		synToSynAck:       time.Millisecond,
+		synAckToAck:       time.Millisecond,
+		endReason:         "fin",
+	}.connectionEvent())
+
+	edges := graph.Current().Edges
+	require.Len(t, edges, 2)
+	assert.Equal(t, "checkout", edges[0].Client)
+	assert.Equal(t, "teapot", edges[0].Server)
+	assert.Equal(t, "http", edges[0].Protocol)
+	// the test response is a 418
+	assert.Equal(t, int64(2), edges[0].Calls)
+	assert.Equal(t, int64(2), edges[0].Errors)
+	assert.InEpsilon(t, 5, *edges[0].P50Ms, 0.01)
+	assert.InEpsilon(t, 15, *edges[0].P99Ms, 0.01)
+
+	assert.Equal(t, "postgresql", edges[1].Protocol)
+	assert.Equal(t, int64(2), edges[1].Calls)
+	assert.Equal(t, int64(1), edges[1].Errors)
+	assert.InEpsilon(t, 2, *edges[1].P50Ms, 0.01)
+
+	// once the window ends, the debug service serves it until the next one ends
+	graph.flush()
+	graph.record(testEvent{requestTimestamp: now, responseTimestamp: now}.redisEvent())
+	assert.Equal(t, edges, graph.Current().Edges)
+	graph.flush()
+	require.Len(t, graph.Current().Edges, 1)
+	assert.Equal(t, "redis", graph.Current().Edges[0].Protocol)
+}
+
+func TestServiceGraphBoundsEdges(t *testing.T) {
+	graph := NewServiceGraph(config.Config{ServiceGraphMaxEdges: 1}, nil)
+	now := time.Now()
+	graph.record(testEvent{requestTimestamp: now, responseTimestamp: now}.redisEvent())
+	graph.record(testEvent{requestTimestamp: now, responseTimestamp: now, dnsResponseCode: "NXDOMAIN"}.dnsEvent())
+	graph.record(testEvent{requestTimestamp: now, responseTimestamp: now}.dnsEvent())
+	// calls along edges that are already recorded keep their edge
+	graph.record(testEvent{requestTimestamp: now, responseTimestamp: now, errorMessage: "ERR"}.redisEvent())
+
+	edges := graph.Current().Edges
+	require.Len(t, edges, 2)
+	assert.Equal(t, ServiceGraphEdgeStat{Client: "1.2.3.4", Server: "5.6.7.8", Protocol: "redis", Calls: 2, Errors: 1, P50Ms: edges[0].P50Ms, P99Ms: edges[0].P99Ms}, edges[0])
+	assert.Equal(t, ServiceGraphEdgeStat{Client: "overflow", Server: "overflow", Protocol: "dns", Calls: 2, Errors: 1, P50Ms: edges[1].P50Ms, P99Ms: edges[1].P99Ms}, edges[1])
+}
+
+func TestServiceGraphServesJSONAndDOT(t *testing.T) {
+	graph := NewServiceGraph(config.Config{ServiceGraphMaxEdges: 10}, nil)
+	now := time.Now()
+	graph.record(testEvent{requestTimestamp: now, responseTimestamp: now.Add(4 * time.Millisecond)}.grpcEvent())
+
+	recorder := httptest.NewRecorder()
+	graph.ServeJSON(recorder, httptest.NewRequest("GET", "/debug/servicegraph", nil))
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var snapshot ServiceGraphSnapshot
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &snapshot))
+	require.Len(t, snapshot.Edges, 1)
+	assert.Equal(t, "grpc", snapshot.Edges[0].Protocol)
+	assert.Equal(t, int64(1), snapshot.Edges[0].Calls)
+	assert.Equal(t, int64(0), snapshot.Edges[0].Errors)
+
+	recorder = httptest.NewRecorder()
+	graph.ServeDOT(recorder, httptest.NewRequest("GET", "/debug/servicegraph.dot", nil))
+	assert.Equal(t, "digraph service_graph {\n"+
+		`  "1.2.3.4" -> "5.6.7.8" [label="grpc\n1 calls, 0 errors\np50 4.0ms, p99 4.0ms"];`+"\n"+
+		"}\n", recorder.Body.String())
+}
+
+func TestLatencySketchQuantiles(t *testing.T) {
+	sketch := latencySketch{buckets: map[int]int64{}}
+	_, ok := sketch.quantile(0.5)
+	assert.False(t, ok)
+
+	for i := 1; i <= 1000; i++ {
+		sketch.add(float64(i))
+	}
+	p50, _ := sketch.quantile(0.5)
+	p99, _ := sketch.quantile(0.99)
+	assert.InEpsilon(t, 500, p50, 0.01)
+	assert.InEpsilon(t, 990, p99, 0.01)
+
+	sketch.add(0)
+	p0, _ := sketch.quantile(0)
+	assert.Equal(t, float64(0), p0)
+}
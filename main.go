@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
@@ -12,6 +14,9 @@ import (
 	"github.com/honeycombio/honeycomb-network-agent/debug"
 	"github.com/honeycombio/honeycomb-network-agent/handlers"
 	"github.com/honeycombio/honeycomb-network-agent/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"k8s.io/client-go/kubernetes"
@@ -41,13 +46,14 @@ func main() {
 		log.Fatal().Err(err).Msg("Config validation failed")
 	}
 
+	var debugService *debug.DebugService
 	if config.Debug {
 		log.Info().
 			Str("debug_address", config.DebugAddress).
 			Msg("Debug service enabled")
 		// enable debug service
-		debug := &debug.DebugService{Config: config}
-		debug.Start()
+		debugService = &debug.DebugService{Config: config}
+		debugService.Start()
 	}
 
 	// setup context and cancel func used to signal shutdown
@@ -65,14 +71,42 @@ func main() {
 
 	// create event handler that sends events to backend (eg Honeycomb)
 	// TODO: move version outside of main package so it can be used directly in the eventHandler
-	eventHandler := handlers.NewEventHandler(config, cachedK8sClient, eventsChannel, Version)
+	var serviceGraph *handlers.ServiceGraph
+	if config.ServiceGraph {
+		serviceGraph = handlers.NewServiceGraph(config, cachedK8sClient)
+		if debugService != nil {
+			debugService.HandleFunc("/debug/servicegraph", serviceGraph.ServeJSON)
+			debugService.HandleFunc("/debug/servicegraph.dot", serviceGraph.ServeDOT)
+		}
+	}
+	eventHandler := handlers.NewEventHandler(config, cachedK8sClient, eventsChannel, Version, serviceGraph)
+	// closed once the event handler stops, which it also does after handling every event once the events channel is closed
+	eventHandlerStopped := make(chan struct{})
 	wgServices.Add(1)
-	go eventHandler.Start(ctx, &wgServices)
+	go func() {
+		defer close(eventHandlerStopped)
+		eventHandler.Start(ctx, &wgServices)
+	}()
 
 	// create assembler that does packet capture and analysis
 	assembler := assemblers.NewTcpAssembler(config, eventsChannel)
+
+	if config.PrometheusMetrics {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+		if err := errors.Join(handlers.RegisterMetrics(registry), assembler.RegisterMetrics(registry)); err != nil {
+			log.Fatal().Err(err).Msg("Failed to register Prometheus metrics")
+		}
+		servePrometheusMetrics(config, registry)
+	}
+	// the assembler is stopped first, so it can send the events of connections still open
+	// while the event handler is still handling them
+	captureCtx, stopCapture := context.WithCancel(ctx)
 	wgServices.Add(1)
-	go assembler.Start(ctx, &wgServices)
+	go assembler.Start(captureCtx, &wgServices)
 
 	// channel to signal when agent process is ready to exit
 	shutdownNow := make(chan bool, 1)
@@ -82,9 +116,19 @@ func main() {
 		signals := make(chan os.Signal, 1)
 		// subscribe signals channel to interrupts: Interrupt (Ctrl+C), SIGINT (default kill), SIGTERM (k8s pod shutdown)
 		signal.Notify(signals, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-		<-signals // wait for shutdown signals
-
-		log.Info().Msg("Agent is stopping. Cleaning up...")
+		select {
+		case <-signals: // wait for shutdown signals
+			log.Info().Msg("Agent is stopping. Cleaning up...")
+			stopCapture()
+		case <-assembler.Done(): // or for the packet source to run out of packets (eg end of a capture file)
+			log.Info().Msg("Packet capture finished")
+		}
+
+		// the assembler closes the events channel once it has sent the events of every open connection,
+		// and the event handler stops once it has handled them
+		<-assembler.Done()
+		log.Info().Msg("Waiting for remaining events to be handled")
+		<-eventHandlerStopped
 
 		done()               // notify services to stop
 		wgServices.Wait()    // wait for all coordinated services to stop
@@ -106,18 +150,38 @@ func setupLogging(c config.Config) {
 	}
 	zerolog.SetGlobalLevel(level)
 
-	// enable pretty printing
+	// enable pretty printing, on stderr so it doesn't mix with events written to stdout
 	if level == zerolog.DebugLevel {
-		log.Logger = log.Output(zerolog.NewConsoleWriter())
+		log.Logger = log.Output(zerolog.NewConsoleWriter(func(w *zerolog.ConsoleWriter) { w.Out = os.Stderr }))
 	}
 }
 
+// servePrometheusMetrics serves the metrics in the registry on the /metrics endpoint for Prometheus to scrape
+func servePrometheusMetrics(config config.Config, registry *prometheus.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry}))
+	log.Info().
+		Str("prometheus_address", config.PrometheusAddress).
+		Msg("Serving Prometheus metrics")
+	go func() {
+		err := http.ListenAndServe(config.PrometheusAddress, mux)
+		log.Error().
+			Err(err).
+			Msg("Prometheus metrics server error")
+	}()
+}
+
 // setupK8s gets the k8s cluster config, creates a k8s clientset then creates and starts
 // cached k8s client that caches k8s objects
 func setupK8s(ctx context.Context, config config.Config) *utils.CachedK8sClient {
 	// get the k8s in-cluster config
 	k8sConfig, err := rest.InClusterConfig()
 	if err != nil {
+		// replaying a capture file doesn't need a cluster, events just won't have k8s metadata
+		if config.PacketSource == "file" {
+			log.Warn().Err(err).Msg("Failed to get kubernetes cluster config, continuing without kubernetes metadata")
+			return nil
+		}
 		log.Fatal().Err(err).Msg("Failed to get kubernetes cluster config")
 	}
 
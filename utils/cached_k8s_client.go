@@ -154,7 +154,8 @@ func (c *CachedK8sClient) GetK8sAttrsForDestinationIP(agentIP string, ip string)
 func (client *CachedK8sClient) getK8sAttrsForIp(agentIP string, ip string, prefix string) map[string]string {
 	k8sAttrs := map[string]string{}
 
-	if ip == "" {
+	// client is nil when running without a kubernetes cluster, eg replaying a capture file
+	if client == nil || ip == "" {
 		return k8sAttrs
 	}
 
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // LookupEnvOrBool returns a bool parsed from the environment variable with the given key
@@ -17,6 +18,17 @@ func LookupEnvOrBool(key string, def bool) bool {
 	return def
 }
 
+// LookupEnvOrInt returns an int parsed from the environment variable with the given key
+// or the default value if the environment variable is not set or cannot be parsed as an int
+func LookupEnvOrInt(key string, def int) int {
+	if env := os.Getenv(key); env != "" {
+		if i, err := strconv.Atoi(env); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
 // LookupEnvOrString returns a string from the environment variable with the given key
 // or the default value if the environment variable is not set
 func LookupEnvOrString(key string, def string) string {
@@ -59,3 +71,14 @@ func LookupEnvAsStringSlice(key string) ([]string, bool) {
 	}
 	return values, true
 }
+
+// LookupEnvOrDuration returns a duration parsed from the environment variable with the given key (eg "30s")
+// or the default value if the environment variable is not set or cannot be parsed as a duration
+func LookupEnvOrDuration(key string, def time.Duration) time.Duration {
+	if env := os.Getenv(key); env != "" {
+		if d, err := time.ParseDuration(env); err == nil {
+			return d
+		}
+	}
+	return def
+}